@@ -0,0 +1,135 @@
+package main
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/migrations"
+	"GolandRestApi/pkg/service"
+	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// usage is printed when the command line is invalid.
+const usage = `Usage: migrate <command> [flags]
+
+Commands:
+  up [-baseline N]       apply the pending migrations; -baseline records the migrations up to N as
+                         applied without running them, to adopt a database created before migrations existed
+  down [-steps N]        revert the last N applied migrations (default 1)
+  status                 list the migrations and whether they are applied
+  create [-dir D] NAME   write the empty scripts of a new migration in D (default pkg/migrations/sql)
+
+The database is configured with the same environment variables as the server (DB_HOST, DB_PORT, DB_USER,
+DB_PASSWORD, DB_NAME).
+`
+
+// main is the entry point of the migrate command, which manages the schema migrations of the MySQL database
+// embedded in the binaries. It runs one of the up, down, status and create commands and exits with status 1
+// on error and 2 on an invalid command line.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+
+	command, args := os.Args[1], os.Args[2:]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+
+	var err error
+	switch command {
+	case "up":
+		baseline := flags.Int("baseline", 0, "version of the schema of an unversioned database")
+		_ = flags.Parse(args)
+		err = withMigrator(logger, func(migrator *migrations.Migrator) error {
+			applied, err := migrator.Up(*baseline)
+			fmt.Printf("%d migration(s) applied\n", applied)
+			return err
+		})
+	case "down":
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		_ = flags.Parse(args)
+		err = withMigrator(logger, func(migrator *migrations.Migrator) error {
+			reverted, err := migrator.Down(*steps)
+			fmt.Printf("%d migration(s) reverted\n", reverted)
+			return err
+		})
+	case "status":
+		_ = flags.Parse(args)
+		err = withMigrator(logger, printStatus)
+	case "create":
+		dir := flags.String("dir", "pkg/migrations/sql", "directory of the migration scripts")
+		_ = flags.Parse(args)
+		if flags.NArg() != 1 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		var upPath, downPath string
+		upPath, downPath, err = migrations.Create(*dir, flags.Arg(0))
+		if err == nil {
+			fmt.Printf("Created %s\nCreated %s\nRebuild the binaries to embed them.\n", upPath, downPath)
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		logger.WithError(err).Errorf("migrate %s failed", command)
+		os.Exit(1)
+	}
+}
+
+// withMigrator connects to the configured database and runs a function with a Migrator for it.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// run: The function to run.
+//
+// Returns the error of the connection or of the function, if any.
+func withMigrator(logger *logrus.Logger, run func(migrator *migrations.Migrator) error) error {
+	cfg := config.NewConfig()
+
+	db, err := service.NewDBConnection(logger, cfg)
+	if err != nil {
+		return err
+	}
+	defer func(db *sql.DB) {
+		_ = db.Close()
+	}(db)
+
+	migrator, err := migrations.NewMigrator(logger, db)
+	if err != nil {
+		return err
+	}
+	return run(migrator)
+}
+
+// printStatus prints the state of every migration as a table.
+//
+// migrator: The Migrator of the database.
+//
+// Returns an error if the status cannot be read.
+func printStatus(migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(table, "%03d\t%s\t%s\t%s\n", status.Version, status.Name, status.State, appliedAt)
+	}
+	return table.Flush()
+}
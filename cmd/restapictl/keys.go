@@ -0,0 +1,120 @@
+package main
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/service"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+)
+
+// keyRotation is the JSON output of the key rotate command.
+type keyRotation struct {
+	Algorithm    string `json:"alg"`
+	KeyID        string `json:"kid"`
+	PreviousID   string `json:"previousKid,omitempty"`
+	File         string `json:"file"`
+	PreviousFile string `json:"previousFile,omitempty"`
+}
+
+// keyRotate parses the flags of the key rotate command.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// flags: The flag set of the command.
+// args: The arguments following the command.
+//
+// Returns the function running the command, which writes a new key for JWT_SIGNING_ALGORITHM in its key file
+// (JWT_SECRET_KEY_FILE for HS256, JWT_PRIVATE_KEY_FILE otherwise) and keeps the previous key in the same file
+// with a .previous extension. The running servers pick the key up when their key files are reloaded.
+func keyRotate(logger *logrus.Logger, flags *flag.FlagSet, args []string) func(cfg *config.Config) (*result, error) {
+	_ = flags.Parse(args)
+
+	return func(cfg *config.Config) (*result, error) {
+		if cfg.JWTKeyID != "" {
+			return nil, fmt.Errorf("JWT_KEY_ID is set to %q: unset it so the new key gets its own kid", cfg.JWTKeyID)
+		}
+		file := cfg.JWTPrivateKeyFile
+		if cfg.JWTSigningAlgorithm == jwt.SigningMethodHS256.Alg() {
+			file = cfg.JWTSecretKeyFile
+		}
+		if file == "" {
+			return nil, errors.New("no key file is configured: the key can only be rotated when it is read from " +
+				"JWT_SECRET_KEY_FILE (HS256) or JWT_PRIVATE_KEY_FILE")
+		}
+
+		rotation := keyRotation{Algorithm: cfg.JWTSigningAlgorithm, File: file}
+		previous, err := os.ReadFile(file)
+		if err == nil {
+			keys, err := service.LoadKeyring(logger, cfg)
+			if err != nil {
+				return nil, err
+			}
+			rotation.PreviousID = keys.Active().ID
+			rotation.PreviousFile = file + ".previous"
+			if err = writeFileAtomic(rotation.PreviousFile, previous); err != nil {
+				return nil, err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		content, err := service.GenerateSigningKey(cfg.JWTSigningAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		if err = writeFileAtomic(file, content); err != nil {
+			return nil, err
+		}
+		keys, err := service.LoadKeyring(logger, cfg)
+		if err != nil {
+			return nil, err
+		}
+		rotation.KeyID = keys.Active().ID
+
+		out := &result{value: rotation, rows: [][]string{{"Signing key rotated"}, {"alg:", rotation.Algorithm},
+			{"kid:", rotation.KeyID}, {"file:", rotation.File}}}
+		if rotation.PreviousID != "" {
+			out.rows = append(out.rows, []string{"previous kid:", rotation.PreviousID},
+				[]string{"previous file:", rotation.PreviousFile})
+		}
+		out.rows = append(out.rows, []string{""},
+			[]string{"Send SIGHUP to the servers or call POST /admin/keys/reload to start signing with the new key."},
+			[]string{"Add the previous file to JWT_VERIFICATION_KEY_FILES before restarting a server, so the tokens " +
+				"it signed stay valid until they expire."})
+		return out, nil
+	}
+}
+
+// writeFileAtomic replaces the content of a file readable only by its owner. The content is written to a
+// temporary file of the same directory first and renamed over the file, so a server reloading its keys never
+// reads a partially written key.
+//
+// path: The path of the file.
+// content: The new content of the file.
+//
+// Returns an error if the file cannot be written.
+func writeFileAtomic(path string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(temp.Name())
+	}()
+
+	if _, err = temp.Write(content); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(temp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
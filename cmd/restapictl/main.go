@@ -0,0 +1,140 @@
+package main
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+)
+
+// usage is printed when the command line is invalid.
+const usage = `Usage: restapictl [-output table|json] <command> <subcommand> [flags]
+
+Commands:
+  user create -username U -email E [-role R] [-country C] [-phone P]
+                                   create a user with the role R (default user); the password is read from stdin
+  user delete -username U          delete a user for good, revoking its sessions and access tokens; unlike
+                                   the admin endpoint, it cannot be restored
+  user reset-password -username U  replace the password of a user, read from stdin, and revoke its sessions
+                                   and access tokens
+  user unlock -username U          clear the failed logins and the lockout of a user
+  user reset-mfa -username U       remove the two-factor authentication of a user who lost its authenticator
+                                   and its recovery codes
+  role list [-username U]          list the roles, or the roles of a user
+  role assign -username U -role R  give a role to a user
+  role unassign -username U -role R
+                                   take a role from a user
+  session list -username U         list the active sessions of a user
+  token revoke -username U [-session S]
+                                   revoke the sessions and access tokens of a user, or of one of its sessions
+  key rotate                       generate a new JWT signing key in the configured key file, keeping the
+                                   previous key next to it with a .previous extension
+
+The commands work straight against the MySQL database, which is configured with the same environment variables
+as the server (DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME); key rotate uses the JWT_* variables.
+`
+
+// main is the entry point of the restapictl command, which administers the users, roles, sessions and signing
+// keys of the API without going through the HTTP server. It exits with status 1 on error and 2 on an invalid
+// command line.
+func main() {
+	global := flag.NewFlagSet("restapictl", flag.ExitOnError)
+	global.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	format := global.String("output", outputTable, "output format, table or json")
+	_ = global.Parse(os.Args[1:])
+	if global.NArg() < 2 || (*format != outputTable && *format != outputJSON) {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	// The stores log every operation at the info level, only warnings and errors are relevant here
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(logrus.WarnLevel)
+
+	command := global.Arg(0) + " " + global.Arg(1)
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	args := global.Args()[2:]
+
+	var run func(cfg *config.Config) (*result, error)
+	switch command {
+	case "user create":
+		run = userCreate(logger, flags, args)
+	case "user delete":
+		run = userDelete(logger, flags, args)
+	case "user reset-password":
+		run = userResetPassword(logger, flags, args)
+	case "user unlock":
+		run = userUnlock(logger, flags, args)
+	case "user reset-mfa":
+		run = userResetMFA(logger, flags, args)
+	case "role list":
+		run = roleList(logger, flags, args)
+	case "role assign":
+		run = roleAssign(logger, flags, args, true)
+	case "role unassign":
+		run = roleAssign(logger, flags, args, false)
+	case "session list":
+		run = sessionList(logger, flags, args)
+	case "token revoke":
+		run = tokenRevoke(logger, flags, args)
+	case "key rotate":
+		run = keyRotate(logger, flags, args)
+	default:
+		global.Usage()
+		os.Exit(2)
+	}
+
+	out, err := run(config.NewConfig())
+	if err == nil {
+		err = out.print(os.Stdout, *format)
+	}
+	if err != nil {
+		logger.WithError(err).Errorf("restapictl %s failed", command)
+		os.Exit(1)
+	}
+}
+
+// requireFlags prints the usage and exits with status 2 if one of the given string flags is empty.
+//
+// flags: The parsed flag set of the command.
+// names: The names of the required flags.
+func requireFlags(flags *flag.FlagSet, names ...string) {
+	for _, name := range names {
+		if f := flags.Lookup(name); f == nil || f.Value.String() == "" {
+			fmt.Fprintf(os.Stderr, "restapictl %s: -%s is required\n\n", flags.Name(), name)
+			flags.Usage()
+			os.Exit(2)
+		}
+	}
+}
+
+// withStore connects to the configured MySQL database and runs a function with a store for it.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the database configuration.
+// run: The function to run.
+//
+// Returns the result of the function, or an error if the storage backend is not MySQL or the connection fails.
+func withStore(logger *logrus.Logger, cfg *config.Config, run func(store repository.Store) (*result, error)) (*result, error) {
+	if cfg.StorageBackend != utils.StorageBackendMySQL {
+		return nil, fmt.Errorf("STORAGE_BACKEND is %q: only the mysql backend keeps its data outside the server",
+			cfg.StorageBackend)
+	}
+
+	db, err := service.NewDBConnection(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer func(db *sql.DB) {
+		_ = db.Close()
+	}(db)
+
+	return run(repository.NewMySQLStore(logger, db))
+}
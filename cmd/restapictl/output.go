@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// The output formats selected with the -output flag.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// result is what a command prints: a value for the JSON output and the same data as a table for the table
+// output. A result without header prints its rows as plain lines, e.g. a confirmation message.
+type result struct {
+	value  interface{}
+	header []string
+	rows   [][]string
+}
+
+// message returns a result made of a single message.
+//
+// format: The format of the message, as for fmt.Sprintf.
+// args: The arguments of the format.
+//
+// Returns a pointer to the result.
+func message(format string, args ...interface{}) *result {
+	text := fmt.Sprintf(format, args...)
+	return &result{
+		value: struct {
+			Message string `json:"message"`
+		}{Message: text},
+		rows: [][]string{{text}},
+	}
+}
+
+// print writes the result in the given format.
+//
+// w: The writer to print to, usually the standard output.
+// format: outputTable or outputJSON.
+//
+// Returns an error if the result cannot be written.
+func (r *result) print(w io.Writer, format string) error {
+	if format == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r.value)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if r.header != nil {
+		fmt.Fprintln(table, strings.Join(r.header, "\t"))
+	}
+	for _, row := range r.rows {
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	return table.Flush()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strconv"
+)
+
+// roleList parses the flags of the role list command.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// flags: The flag set of the command.
+// args: The arguments following the command.
+//
+// Returns the function running the command, which lists every role, or the roles of a user if -username is set.
+func roleList(logger *logrus.Logger, flags *flag.FlagSet, args []string) func(cfg *config.Config) (*result, error) {
+	username := flags.String("username", "", "name of the user whose roles are listed")
+	_ = flags.Parse(args)
+
+	return func(cfg *config.Config) (*result, error) {
+		return withStore(logger, cfg, func(store repository.Store) (*result, error) {
+			roles, err := store.ListRoles()
+			if err != nil {
+				return nil, err
+			}
+
+			if *username != "" {
+				userId, err := lookupUser(store, *username)
+				if err != nil {
+					return nil, err
+				}
+				names, err := store.GetUserRolesByUserId(userId)
+				if err != nil {
+					return nil, err
+				}
+				assigned := make(map[string]bool, len(names))
+				for _, name := range names {
+					assigned[name] = true
+				}
+				userRoles := make([]model.Role, 0, len(names))
+				for _, role := range roles {
+					if assigned[role.Name] {
+						userRoles = append(userRoles, role)
+					}
+				}
+				roles = userRoles
+			}
+
+			out := &result{value: roles, header: []string{"ID", "NAME"}}
+			for _, role := range roles {
+				out.rows = append(out.rows, []string{strconv.Itoa(role.ID), role.Name})
+			}
+			return out, nil
+		})
+	}
+}
+
+// roleAssign parses the flags of the role assign and role unassign commands.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// flags: The flag set of the command.
+// args: The arguments following the command.
+// assign: True to give the role to the user, false to take it away.
+//
+// Returns the function running the command. The permissions of a user are embedded in its access tokens, so a
+// new role applies from the next refresh, while unassigning a role also denies the outstanding access tokens of
+// the user to withdraw the permissions immediately; the sessions stay active.
+func roleAssign(logger *logrus.Logger, flags *flag.FlagSet, args []string, assign bool) func(cfg *config.Config) (*result, error) {
+	username := flags.String("username", "", "name of the user")
+	roleName := flags.String("role", "", "name of the role")
+	_ = flags.Parse(args)
+	requireFlags(flags, "username", "role")
+
+	return func(cfg *config.Config) (*result, error) {
+		return withStore(logger, cfg, func(store repository.Store) (*result, error) {
+			userId, err := lookupUser(store, *username)
+			if err != nil {
+				return nil, err
+			}
+			roleId, err := lookupRole(store, *roleName)
+			if err != nil {
+				return nil, err
+			}
+
+			if assign {
+				if err = store.AssignRole(userId, roleId); err != nil {
+					return nil, err
+				}
+				return message("Role %s assigned to %s", *roleName, *username), nil
+			}
+			if err = store.UnassignRole(userId, roleId); err != nil {
+				return nil, err
+			}
+			denied, err := store.DenyUserAccessTokens(userId)
+			if err != nil {
+				return nil, err
+			}
+			return message("Role %s unassigned from %s, %d access token(s) revoked", *roleName, *username, denied), nil
+		})
+	}
+}
+
+// lookupRole retrieves the ID of a role from its name.
+//
+// store: The store holding the roles.
+// name: The name of the role.
+//
+// Returns the ID of the role, or an error naming the role if it does not exist.
+func lookupRole(store repository.RBACStore, name string) (int, error) {
+	roles, err := store.ListRoles()
+	if err != nil {
+		return -1, err
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			return role.ID, nil
+		}
+	}
+	return -1, fmt.Errorf("role %q not found", name)
+}
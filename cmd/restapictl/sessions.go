@@ -0,0 +1,109 @@
+package main
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/repository"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// sessionList parses the flags of the session list command.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// flags: The flag set of the command.
+// args: The arguments following the command.
+//
+// Returns the function running the command, which lists the active sessions of a user, most recently used first.
+func sessionList(logger *logrus.Logger, flags *flag.FlagSet, args []string) func(cfg *config.Config) (*result, error) {
+	username := flags.String("username", "", "name of the user")
+	_ = flags.Parse(args)
+	requireFlags(flags, "username")
+
+	return func(cfg *config.Config) (*result, error) {
+		return withStore(logger, cfg, func(store repository.Store) (*result, error) {
+			userId, err := lookupUser(store, *username)
+			if err != nil {
+				return nil, err
+			}
+			sessions, err := store.ListSessions(userId)
+			if err != nil {
+				return nil, err
+			}
+
+			out := &result{
+				value:  sessions,
+				header: []string{"ID", "DEVICE", "IP", "USER AGENT", "CREATED AT", "LAST USED AT", "EXPIRES AT"},
+			}
+			for _, session := range sessions {
+				out.rows = append(out.rows, []string{
+					session.ID,
+					orDash(session.DeviceLabel),
+					orDash(session.IP),
+					orDash(session.UserAgent),
+					session.CreatedAt.Format(time.RFC3339),
+					session.LastUsedAt.Format(time.RFC3339),
+					session.ExpiresAt.Format(time.RFC3339),
+				})
+			}
+			return out, nil
+		})
+	}
+}
+
+// tokenRevoke parses the flags of the token revoke command.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// flags: The flag set of the command.
+// args: The arguments following the command.
+//
+// Returns the function running the command, which revokes the refresh tokens of every session of a user, or of
+// the session given with -session, and denies their outstanding access tokens.
+func tokenRevoke(logger *logrus.Logger, flags *flag.FlagSet, args []string) func(cfg *config.Config) (*result, error) {
+	username := flags.String("username", "", "name of the user")
+	sessionId := flags.String("session", "", "ID of the session to revoke, every session of the user if empty")
+	_ = flags.Parse(args)
+	requireFlags(flags, "username")
+
+	return func(cfg *config.Config) (*result, error) {
+		return withStore(logger, cfg, func(store repository.Store) (*result, error) {
+			userId, err := lookupUser(store, *username)
+			if err != nil {
+				return nil, err
+			}
+
+			if *sessionId == "" {
+				revoked, denied, err := revokeUser(store, userId)
+				if err != nil {
+					return nil, err
+				}
+				return message("%d session(s) and %d access token(s) of %s revoked", revoked, denied, *username), nil
+			}
+
+			session, err := store.GetSession(*sessionId)
+			if errors.Is(err, repository.ErrNotFound) || (err == nil && session.UserID != userId) {
+				return nil, fmt.Errorf("session %q of user %q not found", *sessionId, *username)
+			} else if err != nil {
+				return nil, err
+			}
+			if err = store.RevokeSession(session.ID); err != nil {
+				return nil, err
+			}
+			denied, err := store.DenySessionAccessTokens(session.ID)
+			if err != nil {
+				return nil, err
+			}
+			return message("Session %s of %s revoked, %d access token(s) revoked", session.ID, *username, denied), nil
+		})
+	}
+}
+
+// orDash returns the value, or "-" if it is empty, to keep the columns of the table output aligned.
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
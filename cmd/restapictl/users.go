@@ -0,0 +1,250 @@
+package main
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+// userCreate parses the flags of the user create command.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// flags: The flag set of the command.
+// args: The arguments following the command.
+//
+// Returns the function running the command, which creates the user with a password read from stdin. The email
+// address of the user is considered verified.
+func userCreate(logger *logrus.Logger, flags *flag.FlagSet, args []string) func(cfg *config.Config) (*result, error) {
+	username := flags.String("username", "", "name of the user")
+	email := flags.String("email", "", "email address of the user")
+	country := flags.String("country", "", "country of the user")
+	phone := flags.String("phone", "", "phone number of the user")
+	roleName := flags.String("role", "user", "role of the user")
+	_ = flags.Parse(args)
+	requireFlags(flags, "username", "email")
+
+	return func(cfg *config.Config) (*result, error) {
+		return withStore(logger, cfg, func(store repository.Store) (*result, error) {
+			userExists, err := store.UserExists(*username, *email)
+			if err != nil {
+				return nil, err
+			} else if userExists {
+				return nil, fmt.Errorf("username %q or email %q already in use", *username, *email)
+			}
+
+			password, err := readPassword()
+			if err != nil {
+				return nil, err
+			}
+			user := model.NewUser(*username, password, "", *email, *country, *phone)
+			user.EmailVerified = true
+			user.HashedPassword, err = service.HashPassword(logger, *user)
+			if err != nil {
+				return nil, err
+			}
+			if err = store.AddUser(*user, *roleName); err != nil {
+				return nil, err
+			}
+
+			userId, err := store.GetUserIdByUserName(*username)
+			if err != nil {
+				return nil, err
+			}
+			return message("Created user %s (id %d) with the role %s", *username, userId, *roleName), nil
+		})
+	}
+}
+
+// userDelete parses the flags of the user delete command.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// flags: The flag set of the command.
+// args: The arguments following the command.
+//
+// Returns the function running the command, which denies the access tokens of the user and deletes it for good
+// together with its roles, sessions and other records, without the retention period of the deletions made
+// through the API.
+func userDelete(logger *logrus.Logger, flags *flag.FlagSet, args []string) func(cfg *config.Config) (*result, error) {
+	username := flags.String("username", "", "name of the user")
+	_ = flags.Parse(args)
+	requireFlags(flags, "username")
+
+	return func(cfg *config.Config) (*result, error) {
+		return withStore(logger, cfg, func(store repository.Store) (*result, error) {
+			userId, err := lookupUser(store, *username)
+			if err != nil {
+				return nil, err
+			}
+
+			// Deny the access tokens first: they stay valid until they expire once the user is gone
+			denied, err := store.DenyUserAccessTokens(userId)
+			if err != nil {
+				return nil, err
+			}
+			if err = store.DeleteUser(userId); err != nil {
+				return nil, err
+			}
+			return message("Deleted user %s, %d access token(s) revoked", *username, denied), nil
+		})
+	}
+}
+
+// userResetPassword parses the flags of the user reset-password command.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// flags: The flag set of the command.
+// args: The arguments following the command.
+//
+// Returns the function running the command, which replaces the password of the user with one read from stdin
+// and logs the user out of every device.
+func userResetPassword(logger *logrus.Logger, flags *flag.FlagSet, args []string) func(cfg *config.Config) (*result, error) {
+	username := flags.String("username", "", "name of the user")
+	_ = flags.Parse(args)
+	requireFlags(flags, "username")
+
+	return func(cfg *config.Config) (*result, error) {
+		return withStore(logger, cfg, func(store repository.Store) (*result, error) {
+			userId, err := lookupUser(store, *username)
+			if err != nil {
+				return nil, err
+			}
+
+			password, err := readPassword()
+			if err != nil {
+				return nil, err
+			}
+			hashedPassword, err := service.HashPassword(logger, model.User{Username: *username, Password: password})
+			if err != nil {
+				return nil, err
+			}
+			if err = store.UpdatePassword(userId, hashedPassword); err != nil {
+				return nil, err
+			}
+
+			revoked, denied, err := revokeUser(store, userId)
+			if err != nil {
+				return nil, err
+			}
+			return message("Password of %s reset, %d session(s) and %d access token(s) revoked",
+				*username, revoked, denied), nil
+		})
+	}
+}
+
+// userUnlock parses the flags of the user unlock command.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// flags: The flag set of the command.
+// args: The arguments following the command.
+//
+// Returns the function running the command, which clears the failed logins and the lockout of the user.
+func userUnlock(logger *logrus.Logger, flags *flag.FlagSet, args []string) func(cfg *config.Config) (*result, error) {
+	username := flags.String("username", "", "name of the user")
+	_ = flags.Parse(args)
+	requireFlags(flags, "username")
+
+	return func(cfg *config.Config) (*result, error) {
+		return withStore(logger, cfg, func(store repository.Store) (*result, error) {
+			userId, err := lookupUser(store, *username)
+			if err != nil {
+				return nil, err
+			}
+			if err = store.UnlockUser(userId); err != nil {
+				return nil, err
+			}
+			return message("Unlocked user %s", *username), nil
+		})
+	}
+}
+
+// userResetMFA parses the flags of the user reset-mfa command.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// flags: The flag set of the command.
+// args: The arguments following the command.
+//
+// Returns the function running the command, which removes the TOTP enrollment and the recovery codes of the
+// user, so it logs in with its password only until it enrolls again.
+func userResetMFA(logger *logrus.Logger, flags *flag.FlagSet, args []string) func(cfg *config.Config) (*result, error) {
+	username := flags.String("username", "", "name of the user")
+	_ = flags.Parse(args)
+	requireFlags(flags, "username")
+
+	return func(cfg *config.Config) (*result, error) {
+		return withStore(logger, cfg, func(store repository.Store) (*result, error) {
+			userId, err := lookupUser(store, *username)
+			if err != nil {
+				return nil, err
+			}
+			err = store.DeleteTOTP(userId)
+			if errors.Is(err, repository.ErrNotFound) {
+				return nil, fmt.Errorf("user %q has no two-factor authentication", *username)
+			} else if err != nil {
+				return nil, err
+			}
+			return message("Removed the two-factor authentication of %s", *username), nil
+		})
+	}
+}
+
+// lookupUser retrieves the ID of a user.
+//
+// store: The store holding the user.
+// username: The name of the user.
+//
+// Returns the ID of the user, or an error naming the user if it does not exist.
+func lookupUser(store repository.UserStore, username string) (int, error) {
+	userId, err := store.GetUserIdByUserName(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return -1, fmt.Errorf("user %q not found", username)
+	}
+	return userId, err
+}
+
+// revokeUser revokes every session of a user and denies its outstanding access tokens.
+//
+// store: The store holding the sessions and access tokens of the user.
+// userId: The ID of the user.
+//
+// Returns the number of revoked sessions and denied access tokens, and an error, if any.
+func revokeUser(store repository.Store, userId int) (int, int, error) {
+	revoked, err := store.RevokeUserSessions(userId, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	denied, err := store.DenyUserAccessTokens(userId)
+	if err != nil {
+		return revoked, 0, err
+	}
+	return revoked, denied, nil
+}
+
+// readPassword reads a password from the first line of the standard input, so it does not end up in the shell
+// history. A prompt is printed when the standard input is a terminal; the password is echoed, pipe it in to
+// keep it off the screen.
+//
+// Returns the password, or an error if it is empty or cannot be read.
+func readPassword() (string, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("error reading the password from stdin: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("the password is empty")
+	}
+	return password, nil
+}
@@ -3,15 +3,22 @@ package main
 import (
 	"GolandRestApi/pkg/api/handlers/admin"
 	"GolandRestApi/pkg/api/handlers/middleware"
+	"GolandRestApi/pkg/api/handlers/oauth"
 	"GolandRestApi/pkg/api/handlers/token"
 	"GolandRestApi/pkg/api/handlers/user"
 	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/migrations"
+	"GolandRestApi/pkg/repository"
 	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
 	"database/sql"
 	"github.com/gorilla/mux"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 )
 
@@ -21,11 +28,17 @@ import (
 
 // TODO: Update the code to use Docker secrets instead of .env
 
+// purgeInterval is the interval between two purges of the expired access tokens, password reset tokens, OAuth
+// authorization codes, federated login states and failed login counters, and of the users deleted for longer than
+// DELETED_USER_RETENTION.
+const purgeInterval = 10 * time.Minute
+
 // main is the entry point of the GoLandRestApi application. It initializes and configures the HTTP server,
-// sets up database connection, and defines the API routes for login and user registration.
+// sets up the storage backend, applies the schema migrations if DB_AUTO_MIGRATE is set, and defines the API
+// routes for login and user registration.
 //
 // The main function uses the gorilla/mux router for handling HTTP requests.
-// It also initializes a logger and database connection based on the provided configuration.
+// It also initializes a logger and the storage backend (MySQL or in-memory) based on the provided configuration.
 // The server listens on the specified port and handles incoming HTTP requests.
 //
 // It logs initialization errors and server start status.
@@ -46,57 +59,422 @@ func main() {
 
 	logger.Info("Http server started on port ", serverPort, ".")
 
-	// DB Initialization
-	var db *sql.DB
-	for i := 0; i < 10; i++ {
-		db, err = service.NewDBConnection(logger, cfg)
-		if err == nil {
-			break
+	// Storage Initialization
+	var store repository.Store
+	switch cfg.StorageBackend {
+	case utils.StorageBackendMemory:
+		logger.Warn("Using the in-memory storage backend, all data is lost when the server stops")
+		store = repository.NewMemoryStore(logger)
+	case utils.StorageBackendMySQL:
+		var db *sql.DB
+		for i := 0; i < 10; i++ {
+			db, err = service.NewDBConnection(logger, cfg)
+			if err == nil {
+				break
+			}
+
+			logger.WithError(err).WithField("attempt", i).Warn("Could not connect to the database")
+			time.Sleep(2 * time.Second)
+		}
+
+		defer func(db *sql.DB) {
+			err := db.Close()
+			if err != nil {
+				logger.WithError(err).Fatal("Could not close db")
+			}
+		}(db)
+
+		// Schema migrations
+		migrator, err := migrations.NewMigrator(logger, db)
+		if err != nil {
+			logger.WithError(err).Fatal("Could not load the schema migrations")
 		}
+		if cfg.DBAutoMigrate {
+			applied, err := migrator.Up(0)
+			if err != nil {
+				logger.WithError(err).Fatal("Could not apply the schema migrations")
+			}
+			logger.WithField("applied", applied).Info("Database schema up to date")
+		} else if pending, err := migrator.Pending(); err != nil {
+			logger.WithError(err).Warn("Could not check the schema migrations")
+		} else if pending > 0 {
+			logger.WithField("pending", pending).Warn("The database schema is out of date, run `migrate up`")
+		}
+
+		store = repository.NewMySQLStore(logger, db)
+	default:
+		logger.Fatalf("Unknown storage backend %q", cfg.StorageBackend)
+	}
+
+	// Signing keys Initialization
+	keys, err := service.LoadKeyring(logger, cfg)
+	if err != nil {
+		logger.WithError(err).Fatal("Could not load the JWT signing key")
+	}
+	if keys.Active().Algorithm == "HS256" {
+		logger.Warn("Signing with HS256: the ID tokens of the OAuth provider cannot be verified by the clients")
+	}
+
+	// Refresh token Initialization
+	if err := service.CheckRefreshTokenHashKey(cfg); err != nil {
+		logger.WithError(err).Fatal("Invalid refresh token hash key")
+	}
+
+	// TOTP Initialization
+	if err := service.CheckTOTPEncryptionKey(cfg); err != nil {
+		logger.WithError(err).Fatal("Invalid TOTP encryption key")
+	}
+
+	// Notifier Initialization
+	notifier, err := service.NewNotifier(logger, cfg)
+	if err != nil {
+		logger.WithError(err).Fatal("Could not initialize the notifier")
+	}
+	// Login lockout Initialization
+	lockoutPolicy, err := service.NewLoginLockoutPolicy(cfg)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid login lockout configuration")
+	}
 
-		logger.WithError(err).WithField("attempt", i).Warn("Could not connect to the database")
-		time.Sleep(2 * time.Second)
+	// User deletion Initialization
+	deletedUserRetention, err := utils.ParseDuration(cfg.DeletedUserRetention)
+	if err != nil || deletedUserRetention < 0 {
+		logger.Fatalf("Invalid deleted user retention %q", cfg.DeletedUserRetention)
 	}
 
-	defer func(db *sql.DB) {
-		err := db.Close()
+	// Federation Initialization
+	identityProviders, err := service.NewIdentityProviders(logger, cfg)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid federation configuration")
+	}
+	if len(identityProviders.Names()) > 0 && cfg.FederationAutoProvision {
+		roles, err := store.ListRoles()
 		if err != nil {
-			logger.WithError(err).Fatal("Could not close db")
+			logger.WithError(err).Fatal("Could not check the federation default role")
+		}
+		found := false
+		for _, role := range roles {
+			found = found || role.Name == cfg.FederationDefaultRole
+		}
+		if !found {
+			logger.Fatalf("Unknown federation default role %q", cfg.FederationDefaultRole)
+		}
+	}
+
+	switch cfg.EmailVerificationMode {
+	case utils.EmailVerificationOff, utils.EmailVerificationRestrict, utils.EmailVerificationBlock:
+	default:
+		logger.Fatalf("Unknown email verification mode %q", cfg.EmailVerificationMode)
+	}
+	switch cfg.RegistrationMode {
+	case utils.RegistrationModeStandard, utils.RegistrationModeNonEnumerating:
+	default:
+		logger.Fatalf("Unknown registration mode %q", cfg.RegistrationMode)
+	}
+
+	// Reload the key files on SIGHUP to rotate the signing key without a restart
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			// Errors are logged by Reload, the current keys stay in use
+			_ = keys.Reload(logger, cfg)
 		}
-	}(db)
+	}()
+
+	// Purge the expired access tokens, which the denylist ignores anyway, the expired password reset tokens, the
+	// forgotten failed login counters, the abandoned WebAuthn challenges and federated login states and the unused
+	// OAuth authorization codes, so the tables do not grow forever, and the users deleted for longer than the
+	// retention period, which can no longer be restored
+	go func() {
+		for range time.Tick(purgeInterval) {
+			// Errors are logged by the store, the next run tries again
+			if purged, err := store.PurgeExpiredAccessTokens(); err == nil {
+				logger.WithField("purged", purged).Debug("Expired access tokens purged")
+			}
+			if purged, err := store.PurgeExpiredPasswordResetTokens(); err == nil {
+				logger.WithField("purged", purged).Debug("Expired password reset tokens purged")
+			}
+			if purged, err := store.PurgeLoginFailures(time.Now().Add(-lockoutPolicy.ResetAfter)); err == nil {
+				logger.WithField("purged", purged).Debug("Forgotten login failures purged")
+			}
+			if purged, err := store.PurgeExpiredWebAuthnChallenges(); err == nil {
+				logger.WithField("purged", purged).Debug("Expired WebAuthn challenges purged")
+			}
+			if purged, err := store.PurgeExpiredOAuthAuthorizationCodes(); err == nil {
+				logger.WithField("purged", purged).Debug("Expired OAuth authorization codes purged")
+			}
+			if purged, err := store.PurgeExpiredFederatedLoginStates(); err == nil {
+				logger.WithField("purged", purged).Debug("Expired federated login states purged")
+			}
+			if purged, err := store.PurgeDeletedUsers(time.Now().Add(-deletedUserRetention)); err == nil {
+				logger.WithField("purged", purged).Debug("Deleted users purged")
+			}
+		}
+	}()
 
 	// Routes
+	// Every route must be declared with access.Public or access.Require, undeclared routes are denied.
+	access := middleware.NewAccessPolicy()
 	r := mux.NewRouter()
-	r.Use(middleware.Authenticate(logger, db, cfg))
+	r.Use(middleware.Authenticate(logger, access, keys, store, store, store, store, cfg))
+	// Well-known routes
+	access.Public(r.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		token.JWKS(logger, keys, w, r)
+	}).Methods("GET"))
+	access.Public(r.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		oauth.Discovery(logger, keys, cfg, w, r)
+	}).Methods("GET"))
+
+	// OAuth provider routes
+	oauthRoutes := r.PathPrefix("/oauth").Subrouter()
+	access.Require(oauthRoutes.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		oauth.Authorize(logger, store, store, cfg, w, r)
+	}).Methods("GET", "POST"))
+	access.Public(oauthRoutes.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		oauth.Token(logger, store, store, store, store, keys, cfg, w, r)
+	}).Methods("POST"))
+	access.Public(oauthRoutes.HandleFunc("/introspect", func(w http.ResponseWriter, r *http.Request) {
+		oauth.Introspect(logger, store, store, store, store, keys, cfg, w, r)
+	}).Methods("POST"))
+	access.Public(oauthRoutes.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		oauth.Revoke(logger, store, store, store, keys, cfg, w, r)
+	}).Methods("POST"))
+
 	mainRoutFormatted := "/api/" + cfg.APIVersion
 	mainRoute := r.PathPrefix(mainRoutFormatted).Subrouter()
 
 	// User routes
 	userRoutes := mainRoute.PathPrefix("/user").Subrouter()
-	userRoutes.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
-		user.LoginUser(logger, db, cfg, w, r)
-	}).Methods("POST")
-	userRoutes.HandleFunc("/logout/{userId}", func(w http.ResponseWriter, r *http.Request) {
-		user.LogoutUser(logger, db, w, r)
-	}).Methods("GET")
-	userRoutes.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
-		user.RegisterUser(logger, db, w, r)
-	}).Methods("POST")
+	access.Public(userRoutes.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		user.LoginUser(logger, store, store, store, store, store, store, lockoutPolicy, keys, cfg, w, r)
+	}).Methods("POST"))
+	access.Public(userRoutes.HandleFunc("/login/mfa", func(w http.ResponseWriter, r *http.Request) {
+		user.LoginMFA(logger, store, store, store, store, store, store, lockoutPolicy, keys, cfg, w, r)
+	}).Methods("POST"))
+	// Declared after /login/mfa, which a provider can therefore not be named after
+	access.Public(userRoutes.HandleFunc("/login/{provider}", func(w http.ResponseWriter, r *http.Request) {
+		user.FederatedLogin(logger, store, identityProviders, cfg, w, r)
+	}).Methods("GET"))
+	access.Public(userRoutes.HandleFunc("/login/{provider}/callback", func(w http.ResponseWriter, r *http.Request) {
+		user.FederatedCallback(logger, store, store, store, store, store, store, identityProviders, keys, notifier, cfg, w, r)
+	}).Methods("GET", "POST"))
+	access.Require(userRoutes.HandleFunc("/logout/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		user.LogoutUser(logger, store, store, store, w, r)
+	}).Methods("GET"))
+	access.Public(userRoutes.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		user.RegisterUser(logger, store, keys, notifier, cfg, w, r)
+	}).Methods("POST"))
+	access.Public(userRoutes.HandleFunc("/verify", func(w http.ResponseWriter, r *http.Request) {
+		user.VerifyEmail(logger, store, keys, cfg, w, r)
+	}).Methods("GET", "POST"))
+	access.Public(userRoutes.HandleFunc("/verify/resend", func(w http.ResponseWriter, r *http.Request) {
+		user.ResendVerification(logger, store, keys, notifier, cfg, w, r)
+	}).Methods("POST"))
+	access.Require(userRoutes.HandleFunc("/password", func(w http.ResponseWriter, r *http.Request) {
+		user.ChangePassword(logger, store, store, store, store, lockoutPolicy, w, r)
+	}).Methods("POST"))
+	access.Public(userRoutes.HandleFunc("/password/forgot", func(w http.ResponseWriter, r *http.Request) {
+		user.ForgotPassword(logger, store, store, notifier, cfg, w, r)
+	}).Methods("POST"))
+	access.Public(userRoutes.HandleFunc("/password/reset", func(w http.ResponseWriter, r *http.Request) {
+		user.ResetPassword(logger, store, store, store, store, w, r)
+	}).Methods("POST"))
+	access.Require(userRoutes.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		user.GetProfile(logger, store, w, r)
+	}).Methods("GET"))
+	access.Require(userRoutes.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		user.UpdateProfile(logger, store, keys, notifier, cfg, w, r)
+	}).Methods("PATCH"))
+	access.Require(userRoutes.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		user.DeleteProfile(logger, store, store, w, r)
+	}).Methods("DELETE"))
+	access.Require(userRoutes.HandleFunc("/mfa/totp", func(w http.ResponseWriter, r *http.Request) {
+		user.GetTOTPStatus(logger, store, w, r)
+	}).Methods("GET"))
+	access.Require(userRoutes.HandleFunc("/mfa/totp", func(w http.ResponseWriter, r *http.Request) {
+		user.EnrollTOTP(logger, store, cfg, w, r)
+	}).Methods("POST"))
+	access.Require(userRoutes.HandleFunc("/mfa/totp", func(w http.ResponseWriter, r *http.Request) {
+		user.DisableTOTP(logger, store, store, cfg, w, r)
+	}).Methods("DELETE"))
+	access.Require(userRoutes.HandleFunc("/mfa/totp/confirm", func(w http.ResponseWriter, r *http.Request) {
+		user.ConfirmTOTP(logger, store, cfg, w, r)
+	}).Methods("POST"))
+	access.Require(userRoutes.HandleFunc("/webauthn/register/begin", func(w http.ResponseWriter, r *http.Request) {
+		user.BeginWebAuthnRegistration(logger, store, store, cfg, w, r)
+	}).Methods("POST"))
+	access.Require(userRoutes.HandleFunc("/webauthn/register/finish", func(w http.ResponseWriter, r *http.Request) {
+		user.FinishWebAuthnRegistration(logger, store, cfg, w, r)
+	}).Methods("POST"))
+	access.Public(userRoutes.HandleFunc("/webauthn/login/begin", func(w http.ResponseWriter, r *http.Request) {
+		user.BeginWebAuthnLogin(logger, store, cfg, w, r)
+	}).Methods("POST"))
+	access.Public(userRoutes.HandleFunc("/webauthn/login/finish", func(w http.ResponseWriter, r *http.Request) {
+		user.FinishWebAuthnLogin(logger, store, store, store, store, store, store, lockoutPolicy, keys, cfg, w, r)
+	}).Methods("POST"))
+	access.Require(userRoutes.HandleFunc("/webauthn/credentials", func(w http.ResponseWriter, r *http.Request) {
+		user.ListWebAuthnCredentials(logger, store, w, r)
+	}).Methods("GET"))
+	access.Require(userRoutes.HandleFunc("/webauthn/credentials/{credentialId}", func(w http.ResponseWriter, r *http.Request) {
+		user.DeleteWebAuthnCredential(logger, store, w, r)
+	}).Methods("DELETE"))
+	access.Require(userRoutes.HandleFunc("/identities", func(w http.ResponseWriter, r *http.Request) {
+		user.ListIdentities(logger, store, w, r)
+	}).Methods("GET"))
+	access.Require(userRoutes.HandleFunc("/identities/{provider}", func(w http.ResponseWriter, r *http.Request) {
+		user.StartIdentityLink(logger, store, identityProviders, cfg, w, r)
+	}).Methods("POST"))
+	access.Require(userRoutes.HandleFunc("/identities/{provider}", func(w http.ResponseWriter, r *http.Request) {
+		user.UnlinkIdentity(logger, store, w, r)
+	}).Methods("DELETE"))
+	access.Require(userRoutes.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		user.ListSessions(logger, store, w, r)
+	}).Methods("GET"))
+	access.Require(userRoutes.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		user.RevokeOtherSessions(logger, store, store, w, r)
+	}).Methods("DELETE"))
+	access.Require(userRoutes.HandleFunc("/sessions/{sessionId}", func(w http.ResponseWriter, r *http.Request) {
+		user.RevokeSession(logger, store, store, w, r)
+	}).Methods("DELETE"))
+	access.RequireSession(userRoutes.HandleFunc("/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		user.CreateAPIKey(logger, store, store, w, r)
+	}).Methods("POST"))
+	access.RequireSession(userRoutes.HandleFunc("/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		user.ListAPIKeys(logger, store, w, r)
+	}).Methods("GET"))
+	access.RequireSession(userRoutes.HandleFunc("/api-keys/{keyId}", func(w http.ResponseWriter, r *http.Request) {
+		user.DeleteAPIKey(logger, store, w, r)
+	}).Methods("DELETE"))
 
 	// Token routes
 	tokenRoutes := mainRoute.PathPrefix("/token").Subrouter()
-	tokenRoutes.HandleFunc("/refresh", func(w http.ResponseWriter, r *http.Request) {
-		token.Refresh(logger, db, cfg, w, r)
-	}).Methods("POST")
+	access.Public(tokenRoutes.HandleFunc("/refresh", func(w http.ResponseWriter, r *http.Request) {
+		token.Refresh(logger, store, store, store, store, keys, cfg, w, r)
+	}).Methods("POST"))
 
 	//// Admin routes
 	adminRoutes := mainRoute.PathPrefix("/admin").Subrouter()
-	adminRoutes.HandleFunc("/addUser", func(w http.ResponseWriter, r *http.Request) {
-		admin.AddUser(logger, db, w, r)
-	}).Methods("POST")
-	adminRoutes.HandleFunc("/removeUser/{userId}", func(w http.ResponseWriter, r *http.Request) {
-		admin.RemoveUser(logger, db, w, r)
-	}).Methods("DELETE")
+	access.Require(adminRoutes.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListUsers(logger, store, w, r)
+	}).Methods("GET"), utils.PermissionUserRead)
+	access.Require(adminRoutes.HandleFunc("/addUser", func(w http.ResponseWriter, r *http.Request) {
+		admin.AddUser(logger, store, w, r)
+	}).Methods("POST"), utils.PermissionUserCreate)
+	access.Require(adminRoutes.HandleFunc("/removeUser/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.RemoveUser(logger, store, store, w, r)
+	}).Methods("DELETE"), utils.PermissionUserDelete)
+	access.Require(adminRoutes.HandleFunc("/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.UpdateUser(logger, store, store, store, store, store, keys, notifier, cfg, w, r)
+	}).Methods("PATCH"), utils.PermissionUserUpdate)
+	access.Require(adminRoutes.HandleFunc("/users/{userId}/changes", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListUserChanges(logger, store, w, r)
+	}).Methods("GET"), utils.PermissionUserRead)
+	access.Require(adminRoutes.HandleFunc("/users/{userId}/restore", func(w http.ResponseWriter, r *http.Request) {
+		admin.RestoreUser(logger, store, store, w, r)
+	}).Methods("POST"), utils.PermissionUserDelete)
+
+	// Admin RBAC routes
+	access.Require(adminRoutes.HandleFunc("/roles", func(w http.ResponseWriter, r *http.Request) {
+		admin.CreateRole(logger, store, w, r)
+	}).Methods("POST"), utils.PermissionRoleWrite)
+	access.Require(adminRoutes.HandleFunc("/roles", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListRoles(logger, store, w, r)
+	}).Methods("GET"), utils.PermissionRoleRead)
+	access.Require(adminRoutes.HandleFunc("/roles/{roleId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.RenameRole(logger, store, w, r)
+	}).Methods("PUT"), utils.PermissionRoleWrite)
+	access.Require(adminRoutes.HandleFunc("/roles/{roleId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.DeleteRole(logger, store, w, r)
+	}).Methods("DELETE"), utils.PermissionRoleWrite)
+	access.Require(adminRoutes.HandleFunc("/roles/{roleId}/permissions", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListRolePermissions(logger, store, w, r)
+	}).Methods("GET"), utils.PermissionRoleRead)
+	access.Require(adminRoutes.HandleFunc("/roles/{roleId}/permissions/{permissionId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.GrantPermission(logger, store, w, r)
+	}).Methods("PUT"), utils.PermissionRoleWrite)
+	access.Require(adminRoutes.HandleFunc("/roles/{roleId}/permissions/{permissionId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.RevokePermission(logger, store, w, r)
+	}).Methods("DELETE"), utils.PermissionRoleWrite)
+	access.Require(adminRoutes.HandleFunc("/permissions", func(w http.ResponseWriter, r *http.Request) {
+		admin.CreatePermission(logger, store, w, r)
+	}).Methods("POST"), utils.PermissionRoleWrite)
+	access.Require(adminRoutes.HandleFunc("/permissions", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListPermissions(logger, store, w, r)
+	}).Methods("GET"), utils.PermissionRoleRead)
+	access.Require(adminRoutes.HandleFunc("/permissions/{permissionId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.RenamePermission(logger, store, w, r)
+	}).Methods("PUT"), utils.PermissionRoleWrite)
+	access.Require(adminRoutes.HandleFunc("/permissions/{permissionId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.DeletePermission(logger, store, w, r)
+	}).Methods("DELETE"), utils.PermissionRoleWrite)
+	access.Require(adminRoutes.HandleFunc("/users/{userId}/roles", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListUserRoles(logger, store, store, w, r)
+	}).Methods("GET"), utils.PermissionUserRead)
+	access.Require(adminRoutes.HandleFunc("/users/{userId}/roles/{roleId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.AssignRole(logger, store, w, r)
+	}).Methods("PUT"), utils.PermissionRoleAssign)
+	access.Require(adminRoutes.HandleFunc("/users/{userId}/roles/{roleId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.UnassignRole(logger, store, w, r)
+	}).Methods("DELETE"), utils.PermissionRoleAssign)
+
+	// Admin session routes
+	access.Require(adminRoutes.HandleFunc("/users/{userId}/sessions", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListUserSessions(logger, store, w, r)
+	}).Methods("GET"), utils.PermissionUserRead)
+	access.Require(adminRoutes.HandleFunc("/users/{userId}/sessions", func(w http.ResponseWriter, r *http.Request) {
+		admin.RevokeUserSessions(logger, store, store, w, r)
+	}).Methods("DELETE"), utils.PermissionSessionRevoke)
+	access.Require(adminRoutes.HandleFunc("/users/{userId}/sessions/{sessionId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.RevokeUserSession(logger, store, store, w, r)
+	}).Methods("DELETE"), utils.PermissionSessionRevoke)
+
+	// Admin API key routes
+	access.RequireSession(adminRoutes.HandleFunc("/users/{userId}/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		admin.CreateUserAPIKey(logger, store, store, store, w, r)
+	}).Methods("POST"), utils.PermissionAPIKeyManage)
+	access.RequireSession(adminRoutes.HandleFunc("/users/{userId}/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListUserAPIKeys(logger, store, w, r)
+	}).Methods("GET"), utils.PermissionAPIKeyManage)
+	access.RequireSession(adminRoutes.HandleFunc("/users/{userId}/api-keys/{keyId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.DeleteUserAPIKey(logger, store, w, r)
+	}).Methods("DELETE"), utils.PermissionAPIKeyManage)
+
+	// Login lockout routes
+	access.Require(adminRoutes.HandleFunc("/lockouts", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListLockouts(logger, store, w, r)
+	}).Methods("GET"), utils.PermissionUserUnlock)
+	access.Require(adminRoutes.HandleFunc("/lockouts/ips/{ip}", func(w http.ResponseWriter, r *http.Request) {
+		admin.UnlockIP(logger, store, w, r)
+	}).Methods("DELETE"), utils.PermissionUserUnlock)
+	access.Require(adminRoutes.HandleFunc("/users/{userId}/unlock", func(w http.ResponseWriter, r *http.Request) {
+		admin.UnlockUser(logger, store, w, r)
+	}).Methods("POST"), utils.PermissionUserUnlock)
+
+	// OAuth client routes
+	access.Require(adminRoutes.HandleFunc("/oauth/clients", func(w http.ResponseWriter, r *http.Request) {
+		admin.CreateOAuthClient(logger, store, w, r)
+	}).Methods("POST"), utils.PermissionOAuthClientManage)
+	access.Require(adminRoutes.HandleFunc("/oauth/clients", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListOAuthClients(logger, store, w, r)
+	}).Methods("GET"), utils.PermissionOAuthClientManage)
+	access.Require(adminRoutes.HandleFunc("/oauth/clients/{clientId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.GetOAuthClient(logger, store, w, r)
+	}).Methods("GET"), utils.PermissionOAuthClientManage)
+	access.Require(adminRoutes.HandleFunc("/oauth/clients/{clientId}", func(w http.ResponseWriter, r *http.Request) {
+		admin.DeleteOAuthClient(logger, store, w, r)
+	}).Methods("DELETE"), utils.PermissionOAuthClientManage)
+
+	// Signing key routes
+	access.Require(adminRoutes.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		admin.ListKeys(logger, keys, w, r)
+	}).Methods("GET"), utils.PermissionKeyManage)
+	access.Require(adminRoutes.HandleFunc("/keys/reload", func(w http.ResponseWriter, r *http.Request) {
+		admin.ReloadKeys(logger, keys, cfg, w, r)
+	}).Methods("POST"), utils.PermissionKeyManage)
+	access.Require(adminRoutes.HandleFunc("/keys/{kid}", func(w http.ResponseWriter, r *http.Request) {
+		admin.RetireKey(logger, keys, w, r)
+	}).Methods("DELETE"), utils.PermissionKeyManage)
 
 	err = http.ListenAndServe(":"+strconv.Itoa(serverPort), r)
 	if err != nil {
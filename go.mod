@@ -4,13 +4,11 @@ go 1.20
 
 require (
 	github.com/go-sql-driver/mysql v1.7.1
+	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/gorilla/mux v1.8.1
 	github.com/joho/godotenv v1.5.1
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/crypto v0.17.0
 )
 
-require (
-	github.com/golang-jwt/jwt/v5 v5.2.0 // indirect
-	golang.org/x/sys v0.15.0 // indirect
-)
\ No newline at end of file
+require golang.org/x/sys v0.15.0 // indirect
@@ -5,7 +5,6 @@ import (
 	"GolandRestApi/pkg/repository"
 	"GolandRestApi/pkg/service"
 	"GolandRestApi/pkg/utils"
-	"database/sql"
 	"encoding/json"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -14,7 +13,7 @@ import (
 // AddUser handles the creation of a new user by an administrator.
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the SQL database connection.
+// users: The UserStore used to check for and create the user.
 // w: The http.ResponseWriter to write the response to.
 // r: The HTTP request containing user and role information in JSON format.
 //
@@ -22,7 +21,8 @@ import (
 // It first validates the request format and checks if the provided username and email are unique.
 // If the user details are valid and unique, the function hashes the password, creates the user with the specified role,
 // and sends a success response. If any error occurs during the process, an appropriate error response is sent.
-func AddUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+// The email address of a user created by an administrator is considered verified.
+func AddUser(logger *logrus.Logger, users repository.UserStore, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	var AddUserDetails struct {
@@ -43,7 +43,7 @@ func AddUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *http.R
 		return
 	}
 
-	userExists, err := repository.UserExists(logger, db, AddUserDetails.User.Username, AddUserDetails.User.Email)
+	userExists, err := users.UserExists(AddUserDetails.User.Username, AddUserDetails.User.Email)
 	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
@@ -80,7 +80,8 @@ func AddUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *http.R
 	}
 
 	AddUserDetails.User.HashedPassword = hashedPassword
-	err = repository.AddUser(logger, db, AddUserDetails.User, AddUserDetails.RoleName)
+	AddUserDetails.User.EmailVerified = true
+	err = users.AddUser(AddUserDetails.User, AddUserDetails.RoleName)
 	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
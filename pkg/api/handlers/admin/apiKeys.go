@@ -0,0 +1,166 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// CreateUserAPIKey handles the creation of an API key for a user by an administrator, e.g. for a service account.
+// The key is returned once in the response: only its hash is stored. It can only grant permissions the user
+// holds, and stops granting those the user loses.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// apiKeys: The APIKeyStore in which the key is stored.
+// rbac: The RBACStore used to resolve the permissions of the user.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID as a path variable and the key in JSON format, e.g. {"name":
+// "deploy", "permissions": ["user:read"], "expires_at": "2030-01-01T00:00:00Z"}. expires_at is optional.
+//
+// Responds with 201 and the key, with the key itself in its key field, 400 if a field is invalid, or 404 if the
+// user does not exist.
+func CreateUserAPIKey(logger *logrus.Logger,
+	users repository.UserStore,
+	apiKeys repository.APIKeyStore,
+	rbac repository.RBACStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/api-keys",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	var request model.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/api-keys",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if _, err := users.GetUserById(userId); errors.Is(err, sql.ErrNoRows) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			"/admin/users/api-keys",
+			"User not found",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/admin/users/api-keys",
+			"Error retrieving the user",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	permissions, err := rbac.GetUserPermissionsByUserId(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/api-keys", "Error retrieving the permissions", err, "")
+		return
+	}
+	if fields := service.ValidateAPIKeyRequest(request, permissions, time.Now()); len(fields) > 0 {
+		service.HttpValidationErrorResponse(logger, w, "/admin/users/api-keys", "Invalid API key", fields, "")
+		return
+	}
+
+	apiKey, key, err := service.IssueAPIKey(logger, apiKeys, userId, request)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/api-keys", "Error creating the API key", err, "")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusCreated, "/admin/users/api-keys", struct {
+		*model.APIKey
+		Key string `json:"key"`
+	}{APIKey: apiKey, Key: key}, "")
+}
+
+// ListUserAPIKeys handles the listing of the API keys of a user by an administrator. The keys themselves are
+// never listed.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// apiKeys: The APIKeyStore holding the keys.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID as a path variable.
+//
+// Responds with 200 and a JSON array of keys, oldest first, or 404 if the user does not exist.
+func ListUserAPIKeys(logger *logrus.Logger, apiKeys repository.APIKeyStore, w http.ResponseWriter, r *http.Request) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/api-keys",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	keys, err := apiKeys.ListAPIKeys(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/api-keys", "Error listing the API keys", err, "")
+		return
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/users/api-keys", keys, "")
+}
+
+// DeleteUserAPIKey handles the revocation of an API key of a user by an administrator. The key is rejected from
+// the next request on.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// apiKeys: The APIKeyStore holding the keys.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID and the key ID as path variables.
+//
+// Responds with 200 on success and 404 if the user has no such key.
+func DeleteUserAPIKey(logger *logrus.Logger, apiKeys repository.APIKeyStore, w http.ResponseWriter, r *http.Request) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/api-keys",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err := apiKeys.DeleteAPIKey(userId, mux.Vars(r)["keyId"]); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/api-keys", "Error revoking the API key", err, "")
+		return
+	}
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/users/api-keys", "API key revoked", "")
+}
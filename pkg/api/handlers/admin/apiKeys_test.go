@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/api/handlers/handlertest"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestCreateUserAPIKeyRejectsAPIKeys(t *testing.T) {
+	s := handlertest.NewServer(t)
+	aliceId := s.AddUser(t, "alice")
+	s.Access.RequireSession(s.Router.HandleFunc("/users/{userId}/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		CreateUserAPIKey(s.Logger, s.Store, s.Store, s.Store, w, r)
+	}).Methods("POST"), utils.PermissionAPIKeyManage)
+	s.Access.Require(s.Router.HandleFunc("/users/{userId}/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		ListUserAPIKeys(s.Logger, s.Store, w, r)
+	}).Methods("GET"), utils.PermissionAPIKeyManage)
+
+	adminId, err := s.Store.GetUserIdByUserName("admin")
+	if err != nil {
+		t.Fatalf("GetUserIdByUserName: %v", err)
+	}
+	_, key, err := service.IssueAPIKey(s.Logger, s.Store, adminId, model.APIKeyRequest{
+		Name:        "leaked",
+		Permissions: []string{utils.PermissionAPIKeyManage},
+	})
+	if err != nil {
+		t.Fatalf("IssueAPIKey: %v", err)
+	}
+	path := "/users/" + strconv.Itoa(aliceId) + "/api-keys"
+
+	// The key is accepted on a route requiring its permission, but cannot create a key
+	r := httptest.NewRequest("GET", path, nil)
+	r.Header.Set(utils.APIKeyHeader, key)
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("listing with an API key = %d %s, want 200", w.Code, w.Body)
+	}
+	r = httptest.NewRequest("POST", path, bytes.NewReader([]byte(`{"name": "backdoor", "permissions": ["read"]}`)))
+	r.Header.Set(utils.APIKeyHeader, key)
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("creation with an API key = %d %s, want 403", w.Code, w.Body)
+	}
+	if keys, err := s.Store.ListAPIKeys(aliceId); err != nil || len(keys) != 0 {
+		t.Errorf("keys of alice = %+v, %v; want none", keys, err)
+	}
+
+	// A session of the same administrator can
+	request := model.APIKeyRequest{Name: "deploy", Permissions: []string{"read"}}
+	if w = s.Serve(t, "POST", path, s.Login(t, "admin").AccessToken, request); w.Code != http.StatusCreated {
+		t.Errorf("creation with an access token = %d %s, want 201", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// ListKeys handles the listing of the JWT signing keys by an administrator.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// keys: The Keyring holding the keys.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request.
+//
+// Responds with 200 and a JSON array with the kid, algorithm and status of every key.
+func ListKeys(logger *logrus.Logger, keys *service.Keyring, w http.ResponseWriter, r *http.Request) {
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/keys", keys.Keys(), "")
+}
+
+// ReloadKeys handles the reloading of the JWT key files by an administrator, the same as sending SIGHUP to the
+// server. If the signing key file was replaced, the new key becomes active and the previous one keeps verifying
+// tokens until they have all expired.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// keys: The Keyring to reload.
+// cfg: A pointer to the config.Config struct which contains the JWT configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request.
+//
+// Responds with 200 and the status of every key, or 500 if a key cannot be loaded; the keyring is then unchanged.
+func ReloadKeys(logger *logrus.Logger,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+
+	err := keys.Reload(logger, cfg)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/admin/keys/reload",
+			"Error reloading the signing keys",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/keys/reload", keys.Keys(), "")
+}
+
+// RetireKey handles the immediate retirement of a verification key by an administrator, e.g. after it was
+// compromised. Tokens signed by the key are rejected from then on.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// keys: The Keyring holding the key.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the kid as a path variable.
+//
+// Responds with 200 on success, 404 if the key does not exist and 409 if it is the active key.
+func RetireKey(logger *logrus.Logger, keys *service.Keyring, w http.ResponseWriter, r *http.Request) {
+	kid := mux.Vars(r)["kid"]
+
+	err := keys.Retire(kid)
+	switch {
+	case errors.Is(err, service.ErrUnknownKey):
+		service.HttpErrorResponse(logger, w, http.StatusNotFound, "/admin/keys", err.Error(), err, utils.LogTypeWarn, "")
+		return
+	case errors.Is(err, service.ErrActiveKey):
+		service.HttpErrorResponse(logger, w, http.StatusConflict, "/admin/keys", err.Error(), err, utils.LogTypeWarn, "")
+		return
+	case err != nil:
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/admin/keys",
+			"Error retiring the signing key",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/keys", "Signing key "+kid+" retired", "")
+}
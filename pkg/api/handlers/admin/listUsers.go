@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// ListUsers handles the listing of the users by an administrator, one page at a time. The users can be filtered
+// by role, country, creation date and a substring of their username or email, and sorted by any of the fields of
+// service.ParseUserQuery. The next page is read by sending back the next_cursor of the response, with the same
+// filters, sort and order.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the users.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the query parameters, e.g. ?role=admin&q=smith&sort=date_created&order=desc.
+//
+// Responds with 200 and {"users": [...], "next_cursor": "..."}, next_cursor being omitted on the last page, or
+// 400 if a parameter is invalid.
+func ListUsers(logger *logrus.Logger, users repository.UserStore, w http.ResponseWriter, r *http.Request) {
+	query, fields := service.ParseUserQuery(r.URL.Query())
+	if len(fields) > 0 {
+		service.HttpValidationErrorResponse(logger, w, "/admin/users", "Invalid user query", fields, "")
+		return
+	}
+
+	// One more user than the page size tells whether there is a next page
+	limit := query.Limit
+	query.Limit++
+	list, err := users.ListUsers(query)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users", "Error listing the users", err, "")
+		return
+	}
+
+	var nextCursor string
+	if len(list) > limit {
+		list = list[:limit]
+		nextCursor = service.NextUserCursor(query, list[limit-1])
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/users", struct {
+		Users      []model.UserSummary `json:"users"`
+		NextCursor string              `json:"next_cursor,omitempty"`
+	}{Users: list, NextCursor: nextCursor}, "")
+}
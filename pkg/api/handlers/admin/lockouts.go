@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+)
+
+// ListLockouts handles the listing of the accounts and client IP addresses locked out after failed logins.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// lockouts: The LockoutStore holding the failed login counters.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request.
+//
+// Responds with 200 and a JSON array of lockouts, accounts first, each sorted by the end of their lock.
+func ListLockouts(logger *logrus.Logger, lockouts repository.LockoutStore, w http.ResponseWriter, r *http.Request) {
+	list, err := lockouts.ListLockouts()
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/lockouts", "Error listing lockouts", err, "")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/lockouts", list, "")
+}
+
+// UnlockUser handles the unlocking of an account by an administrator: its failed login counter and its lock
+// are cleared, so the user can log in again immediately.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// lockouts: The LockoutStore holding the failed login counters.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID as a path variable.
+//
+// Responds with 200 on success, also if the account was not locked, and 404 if the user does not exist.
+func UnlockUser(logger *logrus.Logger, lockouts repository.LockoutStore, w http.ResponseWriter, r *http.Request) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/unlock",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err = lockouts.UnlockUser(userId); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/unlock", "Error unlocking user", err, "")
+		return
+	}
+
+	logger.WithField("userId", userId).Info("User unlocked by an administrator")
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/users/unlock", "User unlocked", "")
+}
+
+// UnlockIP handles the unlocking of a client IP address by an administrator: its failed logins are forgotten.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// lockouts: The LockoutStore holding the failed login counters.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the IP address as a path variable.
+//
+// Responds with 200 on success, 400 if the address is invalid and 404 if no failed login was recorded for it.
+func UnlockIP(logger *logrus.Logger, lockouts repository.LockoutStore, w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(mux.Vars(r)["ip"])
+	if ip == nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/lockouts/ips",
+			"Invalid IP address",
+			nil,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err := lockouts.UnlockIP(ip.String()); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/lockouts/ips", "Error unlocking address", err, "")
+		return
+	}
+
+	logger.WithField("ip", ip.String()).Info("Address unlocked by an administrator")
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/lockouts/ips", "Address unlocked", "")
+}
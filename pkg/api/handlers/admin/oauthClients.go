@@ -0,0 +1,223 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxOAuthClientNameLength is the maximum length of the name of an OAuth client, also the device label of its
+// sessions
+const maxOAuthClientNameLength = 255
+
+// oauthClientRequest is the request body of CreateOAuthClient.
+type oauthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	Public       bool     `json:"public"`
+}
+
+// validate checks the registration of a client and returns the reason every invalid field is rejected. The grant
+// types must be supported; public clients cannot use the client credentials grant; the authorization code grant
+// needs at least one redirect URI, all of them absolute and without fragment; the refresh token grant is only
+// useful with the authorization code grant; scopes cannot contain spaces.
+func (c oauthClientRequest) validate() map[string]string {
+	fields := make(map[string]string)
+
+	name := strings.TrimSpace(c.Name)
+	if name == "" || len(name) > maxOAuthClientNameLength {
+		fields["name"] = "must be between 1 and 255 characters"
+	}
+
+	grantTypes := make(map[string]bool)
+	for _, grantType := range c.GrantTypes {
+		switch grantType {
+		case utils.OAuthGrantAuthorizationCode, utils.OAuthGrantRefreshToken, utils.OAuthGrantClientCredentials:
+			grantTypes[grantType] = true
+		default:
+			fields["grant_types"] = "must be authorization_code, refresh_token or client_credentials"
+		}
+	}
+	switch {
+	case len(grantTypes) == 0 && fields["grant_types"] == "":
+		fields["grant_types"] = "must contain at least one grant type"
+	case c.Public && grantTypes[utils.OAuthGrantClientCredentials]:
+		fields["grant_types"] = "public clients cannot use client_credentials"
+	case grantTypes[utils.OAuthGrantRefreshToken] && !grantTypes[utils.OAuthGrantAuthorizationCode]:
+		fields["grant_types"] = "refresh_token requires authorization_code"
+	}
+
+	if grantTypes[utils.OAuthGrantAuthorizationCode] && len(c.RedirectURIs) == 0 {
+		fields["redirect_uris"] = "must contain at least one URI for authorization_code"
+	}
+	for _, redirectURI := range c.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || !parsed.IsAbs() || parsed.Host == "" || parsed.Fragment != "" ||
+			strings.ContainsAny(redirectURI, " #") {
+			fields["redirect_uris"] = "must be absolute URIs without fragment"
+		}
+	}
+
+	for _, scope := range c.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\r\n\"\\") {
+			fields["scopes"] = "must not be empty nor contain spaces, quotes or backslashes"
+		}
+	}
+	return fields
+}
+
+// CreateOAuthClient handles the registration of an OAuth client by an administrator. A confidential client gets
+// a secret, returned once in the response: only its hash is stored. A public client, e.g. a single-page or mobile
+// application, has none and must use PKCE.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// clients: The OAuthStore in which the client is registered.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the client in JSON format, e.g. {"name": "Wiki", "redirect_uris":
+// ["https://wiki.example.com/callback"], "scopes": ["openid", "profile"], "grant_types": ["authorization_code",
+// "refresh_token"], "public": false}.
+//
+// Responds with 201 and the client, with its client_secret if it is confidential, or 400 if a field is invalid.
+func CreateOAuthClient(logger *logrus.Logger, clients repository.OAuthStore, w http.ResponseWriter, r *http.Request) {
+	var details oauthClientRequest
+	err := json.NewDecoder(r.Body).Decode(&details)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/oauth/clients",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if fields := details.validate(); len(fields) > 0 {
+		service.HttpValidationErrorResponse(logger, w, "/admin/oauth/clients", "Invalid OAuth client", fields, "")
+		return
+	}
+
+	clientId, err := service.NewOAuthClientId()
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/admin/oauth/clients",
+			"Error generating the client ID",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	client := model.OAuthClient{
+		ID:           clientId,
+		Name:         strings.TrimSpace(details.Name),
+		RedirectURIs: nonNil(details.RedirectURIs),
+		Scopes:       strings.Fields(service.NormalizeOAuthScope(strings.Join(details.Scopes, " "))),
+		GrantTypes:   details.GrantTypes,
+	}
+	var secret string
+	if !details.Public {
+		secret, client.SecretHash, err = service.NewOAuthClientSecret()
+		if err != nil {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusInternalServerError,
+				"/admin/oauth/clients",
+				"Error generating the client secret",
+				err,
+				utils.LogTypeError,
+				"")
+			return
+		}
+	}
+
+	if err = clients.CreateOAuthClient(client); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/oauth/clients", "Error registering the client", err, "")
+		return
+	}
+
+	registered, err := clients.GetOAuthClient(client.ID)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/oauth/clients", "Error retrieving the client", err, "")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusCreated, "/admin/oauth/clients", struct {
+		*model.OAuthClient
+		ClientSecret string `json:"client_secret,omitempty"`
+	}{OAuthClient: registered, ClientSecret: secret}, "")
+}
+
+// ListOAuthClients handles the listing of the OAuth clients by an administrator. Secrets are never listed.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// clients: The OAuthStore holding the clients.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request.
+//
+// Responds with 200 and a JSON array of clients.
+func ListOAuthClients(logger *logrus.Logger, clients repository.OAuthStore, w http.ResponseWriter, r *http.Request) {
+	list, err := clients.ListOAuthClients()
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/oauth/clients", "Error listing the clients", err, "")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/oauth/clients", list, "")
+}
+
+// GetOAuthClient handles the retrieval of an OAuth client by an administrator.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// clients: The OAuthStore holding the client.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the client ID as a path variable.
+//
+// Responds with 200 and the client, or 404 if it does not exist.
+func GetOAuthClient(logger *logrus.Logger, clients repository.OAuthStore, w http.ResponseWriter, r *http.Request) {
+	client, err := clients.GetOAuthClient(mux.Vars(r)["clientId"])
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/oauth/clients", "Error retrieving the client", err, "")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/oauth/clients", client, "")
+}
+
+// DeleteOAuthClient handles the removal of an OAuth client by an administrator. Its authorization codes are
+// deleted and the sessions of its users revoked; the access tokens already issued stay valid until they expire.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// clients: The OAuthStore holding the client.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the client ID as a path variable.
+//
+// Responds with 200 on success, or 404 if the client does not exist.
+func DeleteOAuthClient(logger *logrus.Logger, clients repository.OAuthStore, w http.ResponseWriter, r *http.Request) {
+	if err := clients.DeleteOAuthClient(mux.Vars(r)["clientId"]); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/oauth/clients", "Error removing the client", err, "")
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/oauth/clients", "OAuth client successfully removed", "")
+}
+
+// nonNil returns values, or an empty slice if it is nil, so it is encoded as an empty JSON array.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
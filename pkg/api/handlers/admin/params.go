@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// pathId reads a numeric ID from the path variables of the request.
+//
+// r: The HTTP request whose path variables are read.
+// name: The name of the path variable, e.g. "roleId".
+//
+// Returns the parsed ID, or an error if the variable is missing or not a number.
+func pathId(r *http.Request, name string) (int, error) {
+	value, ok := mux.Vars(r)[name]
+	if !ok {
+		return 0, errors.New(name + " is required")
+	}
+	return strconv.Atoi(value)
+}
+
+// nameRequest is the request body of the endpoints that create or rename a role or a permission.
+type nameRequest struct {
+	Name string `json:"name"`
+}
+
+// valid reports whether the requested name is not blank.
+func (n nameRequest) valid() bool {
+	return strings.TrimSpace(n.Name) != ""
+}
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// CreatePermission handles the creation of a new permission by an administrator.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore in which the permission is created.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the permission name in JSON format, e.g. {"name": "user:delete"}.
+//
+// Responds with 201 and the created permission, 400 if the name is missing and 409 if the name is already in use.
+func CreatePermission(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	var details nameRequest
+	err := json.NewDecoder(r.Body).Decode(&details)
+	if err != nil || !details.valid() {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/permissions",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	permission, err := rbac.CreatePermission(details.Name)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/permissions", "Error creating permission", err, "")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusCreated, "/admin/permissions", permission, "")
+}
+
+// ListPermissions handles the listing of every permission by an administrator.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the permissions.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request.
+//
+// Responds with 200 and a JSON array of permissions.
+func ListPermissions(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	permissions, err := rbac.ListPermissions()
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/permissions", "Error listing permissions", err, "")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/permissions", permissions, "")
+}
+
+// RenamePermission handles the renaming of a permission by an administrator.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the permission.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the permission ID as a path variable and the new name in JSON format.
+//
+// Responds with 200 on success, 404 if the permission does not exist and 409 if the name is already in use.
+func RenamePermission(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	permissionId, err := pathId(r, "permissionId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/permissions",
+			"Invalid permission ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	var details nameRequest
+	err = json.NewDecoder(r.Body).Decode(&details)
+	if err != nil || !details.valid() {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/permissions",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err := rbac.RenamePermission(permissionId, details.Name); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/permissions", "Error renaming permission", err, "")
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/permissions", "Permission successfully renamed", "")
+}
+
+// DeletePermission handles the removal of a permission by an administrator. A permission that is still
+// granted to a role cannot be removed.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the permission.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the permission ID as a path variable.
+//
+// Responds with 200 on success, 404 if the permission does not exist and 409 if it is still granted to a role.
+func DeletePermission(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	permissionId, err := pathId(r, "permissionId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/permissions",
+			"Invalid permission ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err := rbac.DeletePermission(permissionId); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/permissions", "Error deleting permission", err, "")
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/permissions", "Permission successfully removed", "")
+}
@@ -1,11 +1,14 @@
 package admin
 
 import (
+	"GolandRestApi/pkg/api/handlers/middleware"
+	"GolandRestApi/pkg/model"
 	"GolandRestApi/pkg/repository"
 	"GolandRestApi/pkg/service"
 	"GolandRestApi/pkg/utils"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -15,20 +18,28 @@ import (
 // RemoveUser handles the removal of a user by an administrator.
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the SQL database connection.
+// users: The UserStore used to look up and delete the user.
+// denylist: The DenylistStore to which the outstanding access tokens of the user are added.
 // w: The http.ResponseWriter to write the response to.
 // r: The HTTP request containing the user ID as a path variable.
 //
 // This function retrieves the user ID from the request, validates it, and attempts to delete the user from the database.
 // It performs the following steps:
-// 1. Parse the user ID from the path variable.
+// 1. Parse the user ID from the path variable, which cannot be the ID of the administrator.
 // 2. Retrieve the username associated with the user ID from the database.
 // 3. Check if the user exists; if not, return a not found response.
-// 4. Attempt to delete the user from the database.
-// 5. Send a success response if the user is successfully removed or an error response if any issues occur.
+// 4. Deny the outstanding access tokens of the user, so the user is logged out of every device at once.
+// 5. Attempt to delete the user from the database.
+// 6. Send a success response if the user is successfully removed or an error response if any issues occur.
 //
-// Note: This function deletes records from multiple database tables (USERS, USER_AUTH, USER_ROLE) associated with the user.
-func RemoveUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+// Note: The user is only marked as deleted and its sessions are removed. Its roles and other records are kept, so
+// RestoreUser can undo the removal until the user is purged, DELETED_USER_RETENTION after its removal. The denied
+// access tokens are kept until they expire.
+func RemoveUser(logger *logrus.Logger,
+	users repository.UserStore,
+	denylist repository.DenylistStore,
+	w http.ResponseWriter,
+	r *http.Request) {
 	vars := mux.Vars(r)
 	userIdStr, ok := vars["userId"]
 	if !ok {
@@ -55,8 +66,44 @@ func RemoveUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *htt
 		return
 	}
 
-	username, err := repository.GetUserNameByUserId(logger, db, userId)
+	claims, ok := middleware.ClaimsFromRequest(r)
+	if !ok {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/admin/removeUser",
+			"Authorization token required",
+			nil,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+	actorId, err := claims.UserId()
 	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/admin/removeUser",
+			"Invalid token",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	if userId == actorId {
+		// Like disabling it, so the last administrator cannot lock everyone out
+		service.HttpValidationErrorResponse(logger,
+			w,
+			"/admin/removeUser",
+			"Invalid user removal",
+			map[string]string{"userId": "cannot delete your own account"},
+			claims.Username)
+		return
+	}
+
+	username, err := users.GetUserNameByUserId(userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
@@ -79,7 +126,30 @@ func RemoveUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *htt
 		return
 	}
 
-	if err := repository.DeleteUser(logger, db, userId); err != nil {
+	if _, err := denylist.DenyUserAccessTokens(userId); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/admin/removeUser",
+			"Error denying the access tokens of the user",
+			err,
+			utils.LogTypeError,
+			username)
+		return
+	}
+
+	if err := users.SoftDeleteUser(userId, actorId); errors.Is(err, sql.ErrNoRows) {
+		// Deleted by another request since it was looked up
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			"/admin/removeUser",
+			"User not found",
+			err,
+			utils.LogTypeWarn,
+			username)
+		return
+	} else if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
@@ -112,6 +182,105 @@ func RemoveUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *htt
 		return
 	}
 
-	logger.WithField("userId", userId).Info("User removed successfully")
+	logger.WithFields(logrus.Fields{
+		"username": claims.Username,
+		"userId":   userId,
+	}).Info("User removed successfully")
 	return
 }
+
+// RestoreUser handles the restoration by an administrator of a user removed through RemoveUser or deleted by its
+// own user, before it is purged. The user is active again, with the roles and other records it had, and has to
+// log in again: its sessions were removed with it.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// rbac: The RBACStore holding the roles of the user.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID as a path variable.
+//
+// Responds with 200 and the restored user with its roles, with 404 if the user does not exist, e.g. because it
+// was purged, and with 409 if the user is not deleted.
+func RestoreUser(logger *logrus.Logger,
+	users repository.UserStore,
+	rbac repository.RBACStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/restore",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	claims, ok := middleware.ClaimsFromRequest(r)
+	if !ok {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/admin/users/restore",
+			"Authorization token required",
+			nil,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+	actorId, err := claims.UserId()
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/admin/users/restore",
+			"Invalid token",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	user, err := users.RestoreUser(userId, actorId)
+	if errors.Is(err, sql.ErrNoRows) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			"/admin/users/restore",
+			"User not found",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/restore", "Error restoring the user", err,
+			claims.Username)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"username": claims.Username,
+		"userId":   userId,
+	}).Info("User restored by an administrator")
+
+	userRoles, err := rbac.GetUserRolesByUserId(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			"/admin/users/restore",
+			"User restored, but its roles could not be retrieved",
+			err,
+			claims.Username)
+		return
+	}
+	if userRoles == nil {
+		userRoles = []string{}
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/users/restore", model.UserSummary{
+		Profile: user.Profile(),
+		Roles:   userRoles,
+	}, claims.Username)
+}
@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/api/handlers/handlertest"
+	"GolandRestApi/pkg/utils"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// newRemoveUserServer returns a test server serving the removal of the users, with a regular user, alice.
+func newRemoveUserServer(t *testing.T) (*handlertest.Server, int) {
+	t.Helper()
+	s := handlertest.NewServer(t)
+	aliceId := s.AddUser(t, "alice")
+	s.Access.Require(s.Router.HandleFunc("/removeUser/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		RemoveUser(s.Logger, s.Store, s.Store, w, r)
+	}).Methods("DELETE"), utils.PermissionUserDelete)
+	return s, aliceId
+}
+
+func TestRemoveUser(t *testing.T) {
+	s, aliceId := newRemoveUserServer(t)
+	admin := s.Login(t, "admin").AccessToken
+	alice := s.Login(t, "alice").AccessToken
+
+	if code := s.Do(t, "DELETE", "/removeUser/"+strconv.Itoa(aliceId), admin); code != http.StatusOK {
+		t.Fatalf("removal of alice = %d, want 200", code)
+	}
+	if code := s.Do(t, "GET", "/me", alice); code != http.StatusUnauthorized {
+		t.Errorf("access token of the removed user = %d, want 401", code)
+	}
+	if code := s.Do(t, "DELETE", "/removeUser/"+strconv.Itoa(aliceId), admin); code != http.StatusNotFound {
+		t.Errorf("second removal of alice = %d, want 404", code)
+	}
+}
+
+func TestRemoveUserRejectsOwnAccount(t *testing.T) {
+	s, _ := newRemoveUserServer(t)
+	admin := s.Login(t, "admin")
+
+	w := s.Serve(t, "DELETE", "/removeUser/1", admin.AccessToken, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("removal of the own account = %d, want 400", w.Code)
+	}
+	var response struct {
+		Fields map[string]string `json:"fields"`
+	}
+	handlertest.Decode(t, w, &response)
+	if response.Fields["userId"] == "" {
+		t.Errorf("validation error = %v, want a reason for userId", response.Fields)
+	}
+	if code := s.Do(t, "GET", "/me", admin.AccessToken); code != http.StatusOK {
+		t.Errorf("access token of the administrator = %d, want 200", code)
+	}
+}
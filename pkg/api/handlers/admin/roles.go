@@ -0,0 +1,258 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// CreateRole handles the creation of a new role by an administrator.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore in which the role is created.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the role name in JSON format, e.g. {"name": "editor"}.
+//
+// Responds with 201 and the created role, 400 if the name is missing and 409 if the name is already in use.
+func CreateRole(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	var details nameRequest
+	err := json.NewDecoder(r.Body).Decode(&details)
+	if err != nil || !details.valid() {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/roles",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	role, err := rbac.CreateRole(details.Name)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/roles", "Error creating role", err, "")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusCreated, "/admin/roles", role, "")
+}
+
+// ListRoles handles the listing of every role by an administrator.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the roles.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request.
+//
+// Responds with 200 and a JSON array of roles.
+func ListRoles(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	roles, err := rbac.ListRoles()
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/roles", "Error listing roles", err, "")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/roles", roles, "")
+}
+
+// RenameRole handles the renaming of a role by an administrator.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the role.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the role ID as a path variable and the new name in JSON format.
+//
+// Responds with 200 on success, 404 if the role does not exist and 409 if the name is already in use.
+func RenameRole(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	roleId, err := pathId(r, "roleId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/roles",
+			"Invalid role ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	var details nameRequest
+	err = json.NewDecoder(r.Body).Decode(&details)
+	if err != nil || !details.valid() {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/roles",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err := rbac.RenameRole(roleId, details.Name); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/roles", "Error renaming role", err, "")
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/roles", "Role successfully renamed", "")
+}
+
+// DeleteRole handles the removal of a role by an administrator. The permissions granted to the role are
+// revoked with it, but a role that is still assigned to a user cannot be removed.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the role.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the role ID as a path variable.
+//
+// Responds with 200 on success, 404 if the role does not exist and 409 if it is still assigned to a user.
+func DeleteRole(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	roleId, err := pathId(r, "roleId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/roles",
+			"Invalid role ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err := rbac.DeleteRole(roleId); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/roles", "Error deleting role", err, "")
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/roles", "Role successfully removed", "")
+}
+
+// ListRolePermissions handles the listing of the permissions granted to a role.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the role.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the role ID as a path variable.
+//
+// Responds with 200 and a JSON array of permission names, or 404 if the role does not exist.
+func ListRolePermissions(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	roleId, err := pathId(r, "roleId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/roles/permissions",
+			"Invalid role ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	permissions, err := rbac.GetPermissionsByRoleId(roleId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			"/admin/roles/permissions",
+			"Error retrieving the permissions of the role",
+			err,
+			"")
+		return
+	}
+
+	if permissions == nil {
+		permissions = []string{}
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/roles/permissions", permissions, "")
+}
+
+// GrantPermission handles granting a permission to a role.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the role and the permission.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the role ID and the permission ID as path variables.
+//
+// Responds with 200 on success, 404 if the role or the permission does not exist and 409 if the permission
+// is already granted.
+func GrantPermission(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	roleId, permissionId, err := rolePermissionIds(r)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/roles/permissions",
+			"Invalid role or permission ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err := rbac.GrantPermission(roleId, permissionId); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/roles/permissions", "Error granting permission", err, "")
+		return
+	}
+
+	service.HttpMessageResponse(logger,
+		w,
+		http.StatusOK,
+		"/admin/roles/permissions",
+		"Permission successfully granted",
+		"")
+}
+
+// RevokePermission handles revoking a permission from a role.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the role and the permission.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the role ID and the permission ID as path variables.
+//
+// Responds with 200 on success and 404 if the role, the permission or the grant does not exist.
+func RevokePermission(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	roleId, permissionId, err := rolePermissionIds(r)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/roles/permissions",
+			"Invalid role or permission ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err := rbac.RevokePermission(roleId, permissionId); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/roles/permissions", "Error revoking permission", err, "")
+		return
+	}
+
+	service.HttpMessageResponse(logger,
+		w,
+		http.StatusOK,
+		"/admin/roles/permissions",
+		"Permission successfully revoked",
+		"")
+}
+
+// rolePermissionIds reads the roleId and permissionId path variables of the request.
+func rolePermissionIds(r *http.Request) (int, int, error) {
+	roleId, err := pathId(r, "roleId")
+	if err != nil {
+		return 0, 0, err
+	}
+	permissionId, err := pathId(r, "permissionId")
+	if err != nil {
+		return 0, 0, err
+	}
+	return roleId, permissionId, nil
+}
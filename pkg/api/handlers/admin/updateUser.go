@@ -0,0 +1,261 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/api/handlers/middleware"
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// UpdateUser handles the partial update of a user by an administrator, keeping its ID. The email, country and
+// phone can be changed, the roles replaced as a whole, the account disabled or enabled, and the user required to
+// choose a new password before logging in with a password again. The changes are applied in a single transaction
+// and every changed field is recorded with the administrator who made it, see ListUserChanges.
+//
+// Disabling the account or requiring a password reset revokes every session of the user with its access tokens.
+// A required reset also sends the user a password reset token, and a new email address a token to verify it.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// rbac: The RBACStore holding the roles.
+// tokens: The TokenStore holding the sessions of the user.
+// denylist: The DenylistStore to which the access tokens of the user are added.
+// resets: The PasswordResetStore in which the hash of a password reset token is stored.
+// keys: The Keyring holding the key used to sign the email verification token.
+// notifier: The Notifier delivering the password reset and email verification tokens.
+// cfg: A pointer to the config.Config struct which contains the password reset and email verification
+// configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID as a path variable and, in JSON format, the fields to change and the
+// version of the user they were read from, e.g. {"country": "Spain", "roles": ["user", "auditor"],
+// "status": "disabled", "force_password_reset": true, "version": 3}.
+//
+// Responds with 200 and the updated user with its roles. Responds with 400 if the request has unknown fields, no
+// version or invalid fields, listed in the response, with 404 if the user does not exist, and with 409 if the user
+// has changed since that version or the email is used by another user.
+func UpdateUser(logger *logrus.Logger,
+	users repository.UserStore,
+	rbac repository.RBACStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	resets repository.PasswordResetStore,
+	keys *service.Keyring,
+	notifier service.Notifier,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	claims, ok := middleware.ClaimsFromRequest(r)
+	if !ok {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/admin/users",
+			"Authorization token required",
+			nil,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+	actorId, err := claims.UserId()
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/admin/users",
+			"Invalid token",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	var request struct {
+		model.AdminUserUpdate
+		Version *int `json:"version"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users",
+			"Invalid request format, only email, country, phone, roles, status and force_password_reset can be "+
+				"changed",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	roles, err := rbac.ListRoles()
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users", "Error listing the roles", err, claims.Username)
+		return
+	}
+	invalid := service.ValidateAdminUserUpdate(request.AdminUserUpdate, roles)
+	if request.Version == nil {
+		invalid["version"] = "is required, send the version of the user being changed"
+	}
+	disabling := request.Status != nil && *request.Status == utils.UserStatusDisabled
+	if disabling && userId == actorId {
+		invalid["status"] = "cannot disable your own account"
+	}
+	if len(invalid) > 0 {
+		service.HttpValidationErrorResponse(logger, w, "/admin/users", "Invalid user update", invalid, claims.Username)
+		return
+	}
+
+	user, err := users.UpdateUser(userId, *request.Version, request.AdminUserUpdate, actorId)
+	if errors.Is(err, sql.ErrNoRows) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			"/admin/users",
+			"User not found",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users", "Error updating the user", err, claims.Username)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"username": claims.Username,
+		"userId":   userId,
+		"version":  user.Version,
+	}).Info("User updated by an administrator")
+
+	// A disabled user, or one whose password may be known to someone else, is logged out of every device
+	if disabling || request.ForcePasswordReset {
+		if _, err = service.RevokeUserSessions(tokens, denylist, userId, ""); err != nil {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusInternalServerError,
+				"/admin/users",
+				"User updated, but the sessions could not be revoked",
+				err,
+				utils.LogTypeError,
+				claims.Username)
+			return
+		}
+	}
+
+	// The tokens are sent on a best-effort basis: the user can ask for new ones
+	if request.ForcePasswordReset {
+		sendRequiredPasswordReset(logger, resets, notifier, cfg, user)
+	}
+	if !user.EmailVerified && request.Email != nil {
+		_ = service.SendEmailVerification(logger, cfg, keys, notifier, user)
+	}
+
+	userRoles, err := rbac.GetUserRolesByUserId(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			"/admin/users",
+			"User updated, but its roles could not be retrieved",
+			err,
+			claims.Username)
+		return
+	}
+	if userRoles == nil {
+		userRoles = []string{}
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/users", model.UserSummary{
+		Profile: user.Profile(),
+		Roles:   userRoles,
+	}, claims.Username)
+}
+
+// ListUserChanges handles the listing of the changes made to a user by the administrators through UpdateUser.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user and its changes.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID as a path variable.
+//
+// Responds with 200 and a JSON array of changes, oldest first, or 404 if the user does not exist.
+func ListUserChanges(logger *logrus.Logger, users repository.UserStore, w http.ResponseWriter, r *http.Request) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/changes",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	changes, err := users.ListUserChanges(userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			"/admin/users/changes",
+			"User not found",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	} else if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/changes", "Error listing the changes", err, "")
+		return
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/users/changes", changes, "")
+}
+
+// sendRequiredPasswordReset sends a user whose password reset is required by an administrator a password reset
+// token, valid for PASSWORD_RESET_TOKEN_VALIDITY. Errors are logged only: the update of the user is already
+// stored, and the user can ask for another token with the forgotten password form.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// resets: The PasswordResetStore in which the hash of the token is stored.
+// notifier: The Notifier delivering the token.
+// cfg: A pointer to the config.Config struct which contains the password reset configuration.
+// user: The user whose password is reset.
+func sendRequiredPasswordReset(logger *logrus.Logger,
+	resets repository.PasswordResetStore,
+	notifier service.Notifier,
+	cfg *config.Config,
+	user *model.User) {
+	validity, err := utils.ParseDuration(cfg.PasswordResetTokenValidity)
+	if err != nil {
+		logger.WithError(err).Error("Invalid password reset token validity")
+		return
+	}
+	token, err := service.IssuePasswordResetToken(resets, user.ID, validity)
+	if err == nil {
+		err = notifier.Notify(service.PasswordResetNotification(cfg, user, token, validity, true))
+	}
+	if err != nil {
+		logger.WithError(err).WithField("username", user.Username).Error("Error sending the password reset token")
+		return
+	}
+	logger.WithField("username", user.Username).Info("Password reset token sent")
+}
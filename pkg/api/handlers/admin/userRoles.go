@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// ListUserRoles handles the listing of the roles assigned to a user.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore used to check that the user exists.
+// rbac: The RBACStore holding the role assignments.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID as a path variable.
+//
+// Responds with 200 and a JSON array of role names, or 404 if the user does not exist.
+func ListUserRoles(logger *logrus.Logger,
+	users repository.UserStore,
+	rbac repository.RBACStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/roles",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	username, err := users.GetUserNameByUserId(userId)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			"/admin/users/roles",
+			"User not found",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	roles, err := rbac.GetUserRolesByUserId(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			"/admin/users/roles",
+			"Error retrieving the roles of the user",
+			err,
+			username)
+		return
+	}
+
+	if roles == nil {
+		roles = []string{}
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/users/roles", roles, username)
+}
+
+// AssignRole handles assigning a role to a user.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the role assignments.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID and the role ID as path variables.
+//
+// Responds with 200 on success, 404 if the user or the role does not exist and 409 if the role is already assigned.
+func AssignRole(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	userId, roleId, err := userRoleIds(r)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/roles",
+			"Invalid user or role ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err := rbac.AssignRole(userId, roleId); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/roles", "Error assigning role", err, "")
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/users/roles", "Role successfully assigned", "")
+}
+
+// UnassignRole handles removing a role from a user.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// rbac: The RBACStore holding the role assignments.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID and the role ID as path variables.
+//
+// Responds with 200 on success and 404 if the user, the role or the assignment does not exist.
+func UnassignRole(logger *logrus.Logger, rbac repository.RBACStore, w http.ResponseWriter, r *http.Request) {
+	userId, roleId, err := userRoleIds(r)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/roles",
+			"Invalid user or role ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	if err := rbac.UnassignRole(userId, roleId); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/roles", "Error unassigning role", err, "")
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/users/roles", "Role successfully unassigned", "")
+}
+
+// userRoleIds reads the userId and roleId path variables of the request.
+func userRoleIds(r *http.Request) (int, int, error) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		return 0, 0, err
+	}
+	roleId, err := pathId(r, "roleId")
+	if err != nil {
+		return 0, 0, err
+	}
+	return userId, roleId, nil
+}
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/api/handlers/middleware"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// ListUserSessions handles the listing of the active sessions of a user by an administrator.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// tokens: The TokenStore holding the sessions.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID as a path variable.
+//
+// Responds with 200 and a JSON array of sessions, most recently used first, or 404 if the user does not exist.
+func ListUserSessions(logger *logrus.Logger, tokens repository.TokenStore, w http.ResponseWriter, r *http.Request) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/sessions",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	sessions, err := tokens.ListSessions(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/sessions", "Error listing sessions", err, "")
+		return
+	}
+
+	if claims, ok := middleware.ClaimsFromRequest(r); ok {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == claims.SessionId
+		}
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/users/sessions", sessions, "")
+}
+
+// RevokeUserSession handles the revocation of one session of a user by an administrator, denying its access
+// tokens.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// tokens: The TokenStore holding the sessions.
+// denylist: The DenylistStore to which the access tokens of the session are added.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID and the session ID as path variables.
+//
+// Responds with 200 on success and 404 if the user has no such session.
+func RevokeUserSession(logger *logrus.Logger,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/sessions",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	sessionId := mux.Vars(r)["sessionId"]
+	session, err := tokens.GetSession(sessionId)
+	if err == nil && session.UserID != userId {
+		err = &repository.NotFoundError{Entity: "session", Key: sessionId}
+	}
+	if err == nil {
+		err = service.RevokeSession(tokens, denylist, sessionId)
+	}
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/sessions", "Error revoking session", err, "")
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/admin/users/sessions", "Session revoked", "")
+}
+
+// RevokeUserSessions handles the revocation of every session of a user by an administrator, logging the user out
+// of all devices and denying the access tokens of the revoked sessions. When administrators target themselves,
+// the session of their access token is kept.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// tokens: The TokenStore holding the sessions.
+// denylist: The DenylistStore to which the access tokens of the revoked sessions are added.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the user ID as a path variable.
+//
+// Responds with 200 and the number of revoked sessions, e.g. {"revoked": 2}, or 404 if the user does not exist.
+func RevokeUserSessions(logger *logrus.Logger,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	userId, err := pathId(r, "userId")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/admin/users/sessions",
+			"Invalid User ID format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	// Session IDs are unique, so keeping the session of the caller has no effect on other users
+	exceptSessionId := ""
+	if claims, ok := middleware.ClaimsFromRequest(r); ok {
+		exceptSessionId = claims.SessionId
+	}
+
+	revoked, err := service.RevokeUserSessions(tokens, denylist, userId, exceptSessionId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/admin/users/sessions", "Error revoking sessions", err, "")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/admin/users/sessions", map[string]int{"revoked": revoked}, "")
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"GolandRestApi/pkg/api/handlers/handlertest"
+	"GolandRestApi/pkg/utils"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// newSessionServer returns a test server serving the admin session routes, with a regular user, alice.
+func newSessionServer(t *testing.T) (*handlertest.Server, int) {
+	t.Helper()
+	s := handlertest.NewServer(t)
+	aliceId := s.AddUser(t, "alice")
+
+	s.Access.Require(s.Router.HandleFunc("/users/{userId}/sessions", func(w http.ResponseWriter, r *http.Request) {
+		RevokeUserSessions(s.Logger, s.Store, s.Store, w, r)
+	}).Methods("DELETE"), utils.PermissionSessionRevoke)
+	s.Access.Require(s.Router.HandleFunc("/users/{userId}/sessions/{sessionId}", func(w http.ResponseWriter, r *http.Request) {
+		RevokeUserSession(s.Logger, s.Store, s.Store, w, r)
+	}).Methods("DELETE"), utils.PermissionSessionRevoke)
+	return s, aliceId
+}
+
+func TestRevokeUserSessionDeniesItsAccessTokens(t *testing.T) {
+	s, aliceId := newSessionServer(t)
+	admin := s.Login(t, "admin").AccessToken
+	phone := s.Login(t, "alice")
+	laptop := s.Login(t, "alice").AccessToken
+
+	path := "/users/" + strconv.Itoa(aliceId) + "/sessions/" + phone.SessionId
+	if code := s.Do(t, "DELETE", path, admin); code != http.StatusOK {
+		t.Fatalf("DELETE %s = %d, want 200", path, code)
+	}
+	if code := s.Do(t, "GET", "/me", phone.AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("access token of the revoked session = %d, want 401", code)
+	}
+	if code := s.Do(t, "GET", "/me", laptop); code != http.StatusOK {
+		t.Errorf("access token of the other session = %d, want 200", code)
+	}
+}
+
+func TestRevokeUserSessionsDeniesTheirAccessTokens(t *testing.T) {
+	s, aliceId := newSessionServer(t)
+	admin := s.Login(t, "admin").AccessToken
+	phone := s.Login(t, "alice").AccessToken
+	laptop := s.Login(t, "alice").AccessToken
+
+	path := "/users/" + strconv.Itoa(aliceId) + "/sessions"
+	if code := s.Do(t, "DELETE", path, admin); code != http.StatusOK {
+		t.Fatalf("DELETE %s = %d, want 200", path, code)
+	}
+	for name, token := range map[string]string{"phone": phone, "laptop": laptop} {
+		if code := s.Do(t, "GET", "/me", token); code != http.StatusUnauthorized {
+			t.Errorf("access token of the revoked %s session = %d, want 401", name, code)
+		}
+	}
+	if code := s.Do(t, "GET", "/me", admin); code != http.StatusOK {
+		t.Errorf("access token of the administrator = %d, want 200", code)
+	}
+}
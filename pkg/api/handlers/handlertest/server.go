@@ -0,0 +1,194 @@
+// Package handlertest provides the test server the handler tests declare their routes on: a router behind the
+// Authenticate middleware, with an in-memory store and an HMAC keyring.
+package handlertest
+
+import (
+	"GolandRestApi/pkg/api/handlers/middleware"
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Server is a router behind the Authenticate middleware, with an in-memory store seeded with the admin user. Tests
+// declare their routes on Router with Access, and can change Config before the first request.
+type Server struct {
+	Logger *logrus.Logger
+	Store  *repository.MemoryStore
+	Keys   *service.Keyring
+	Config *config.Config
+	Access *middleware.AccessPolicy
+	Router *mux.Router
+}
+
+// NewServer creates a test server. It serves /me, a route answering 200 to any valid access token, to try the
+// tokens.
+//
+// t: The testing.T of the calling test.
+//
+// Returns the server.
+func NewServer(t *testing.T) *Server {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	key, err := service.NewHMACSigningKey("test", "a test secret of at least thirty-two characters")
+	if err != nil {
+		t.Fatalf("NewHMACSigningKey: %v", err)
+	}
+	s := &Server{
+		Logger: logger,
+		Store:  repository.NewMemoryStore(logger),
+		Keys:   service.NewKeyring(key, time.Hour),
+		Config: &config.Config{
+			APIVersion:              "v1",
+			JWTIssuer:               "test",
+			JWTAudience:             "test",
+			JWTExpirationTime:       "15m",
+			JWTRefreshTokenValidity: "7d",
+			RefreshTokenHashKey:     "a test hash key of at least thirty-two characters",
+		},
+		Access: middleware.NewAccessPolicy(),
+		Router: mux.NewRouter(),
+	}
+
+	s.Router.Use(middleware.Authenticate(logger, s.Access, s.Keys, s.Store, s.Store, s.Store, s.Store, s.Config))
+	s.Access.Require(s.Router.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}).Methods("GET"))
+	return s
+}
+
+// AddUser adds a user with the user role and a verified email address.
+//
+// t: The testing.T of the calling test.
+// username: The username, also the local part of the email address.
+//
+// Returns the ID of the user.
+func (s *Server) AddUser(t *testing.T, username string) int {
+	t.Helper()
+	user := model.User{Username: username, Email: username + "@example.com", EmailVerified: true}
+	if err := s.Store.AddUser(user, utils.UserRole); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	userId, err := s.Store.GetUserIdByUserName(username)
+	if err != nil {
+		t.Fatalf("GetUserIdByUserName: %v", err)
+	}
+	return userId
+}
+
+// LockoutPolicy configures the login lockouts, an account being locked after 5 failed logins and an address after
+// 20, and returns the policy to pass to the login handlers.
+//
+// t: The testing.T of the calling test.
+//
+// Returns the policy.
+func (s *Server) LockoutPolicy(t *testing.T) *service.LoginLockoutPolicy {
+	t.Helper()
+	s.Config.LoginLockoutAccountThreshold = 5
+	s.Config.LoginLockoutIPThreshold = 20
+	s.Config.LoginLockoutDuration = "1m"
+	s.Config.LoginLockoutMaxDuration = "1h"
+	s.Config.LoginFailureReset = "15m"
+	policy, err := service.NewLoginLockoutPolicy(s.Config)
+	if err != nil {
+		t.Fatalf("NewLoginLockoutPolicy: %v", err)
+	}
+	return policy
+}
+
+// Login starts a session of a user as the login handlers do: its tokens are issued, the session is stored and the
+// access token recorded, so it can be denied.
+//
+// t: The testing.T of the calling test.
+// username: The username of the user.
+//
+// Returns the tokens of the session.
+func (s *Server) Login(t *testing.T, username string) *service.TokenPair {
+	t.Helper()
+	user, err := s.Store.GetUserByUserName(username)
+	if err != nil {
+		t.Fatalf("GetUserByUserName: %v", err)
+	}
+
+	pair, err := service.HandleTokensCreation(s.Logger, s.Config, s.Keys, s.Store, user, "")
+	if err != nil {
+		t.Fatalf("HandleTokensCreation: %v", err)
+	}
+	err = s.Store.CreateSession(model.Session{
+		ID:        pair.SessionId,
+		UserID:    user.ID,
+		ExpiresAt: pair.RefreshExpiresAt,
+		TokenHash: pair.RefreshTokenHash,
+	})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err = s.Store.RecordAccessToken(pair.AccessTokenRecord(user.ID)); err != nil {
+		t.Fatalf("RecordAccessToken: %v", err)
+	}
+	return pair
+}
+
+// Serve sends a request to the router.
+//
+// t: The testing.T of the calling test.
+// method: The method of the request.
+// path: The path of the request, with its query.
+// accessToken: The Bearer access token of the request, or empty for none.
+// body: The value encoded in JSON as the body of the request, or nil for none.
+//
+// Returns the recorded response.
+func (s *Server) Serve(t *testing.T, method string, path string, accessToken string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			t.Fatalf("encoding the request: %v", err)
+		}
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	if accessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	return w
+}
+
+// Do sends a request without a body and returns the status code of the response.
+//
+// t: The testing.T of the calling test.
+// method: The method of the request.
+// path: The path of the request, with its query.
+// accessToken: The Bearer access token of the request, or empty for none.
+//
+// Returns the status code.
+func (s *Server) Do(t *testing.T, method string, path string, accessToken string) int {
+	t.Helper()
+	return s.Serve(t, method, path, accessToken, nil).Code
+}
+
+// Decode decodes the JSON body of a response.
+//
+// t: The testing.T of the calling test.
+// w: The recorded response.
+// value: A pointer to the value to decode into.
+func Decode(t *testing.T, w *httptest.ResponseRecorder, value interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(w.Body).Decode(value); err != nil {
+		t.Fatalf("decoding the response %q: %v", w.Body, err)
+	}
+}
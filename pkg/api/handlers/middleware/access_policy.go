@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"GolandRestApi/pkg/service"
+	"github.com/gorilla/mux"
+	"sync"
+)
+
+// routePolicy describes who may call a route.
+type routePolicy struct {
+	public      bool
+	permissions []string
+	sessionOnly bool
+}
+
+// AccessPolicy records, for every mux route, whether it is public or which permissions its caller must hold.
+// Routes are declared when they are registered, e.g.:
+//
+//	access.Public(userRoutes.HandleFunc("/login", handler).Methods("POST"))
+//	access.Require(adminRoutes.HandleFunc("/removeUser/{userId}", handler).Methods("DELETE"), "user:delete")
+//
+// Routes that were never declared are denied by the Authenticate middleware.
+type AccessPolicy struct {
+	mu       sync.RWMutex
+	policies map[*mux.Route]routePolicy
+}
+
+// NewAccessPolicy creates an empty AccessPolicy.
+//
+// Returns a pointer to the AccessPolicy.
+func NewAccessPolicy() *AccessPolicy {
+	return &AccessPolicy{policies: make(map[*mux.Route]routePolicy)}
+}
+
+// Public declares a route that can be called without a token.
+//
+// route: The route to declare.
+//
+// Returns the route, so the declaration can wrap the route registration.
+func (a *AccessPolicy) Public(route *mux.Route) *mux.Route {
+	a.set(route, routePolicy{public: true})
+	return route
+}
+
+// Require declares a route that needs a valid token whose user holds every one of the given permissions.
+// Calling Require without permissions declares a route that only needs a valid token.
+//
+// route: The route to declare.
+// permissions: The permissions the caller must hold, e.g. "user:delete".
+//
+// Returns the route, so the declaration can wrap the route registration.
+func (a *AccessPolicy) Require(route *mux.Route, permissions ...string) *mux.Route {
+	a.set(route, routePolicy{permissions: permissions})
+	return route
+}
+
+// RequireSession declares a route like Require, which only accepts access tokens: API keys are rejected even if
+// they grant the permissions. It protects the routes managing the API keys, so a key cannot create keys outliving
+// it, for its owner or for another user.
+//
+// route: The route to declare.
+// permissions: The permissions the caller must hold, e.g. "apikey:manage".
+//
+// Returns the route, so the declaration can wrap the route registration.
+func (a *AccessPolicy) RequireSession(route *mux.Route, permissions ...string) *mux.Route {
+	a.set(route, routePolicy{permissions: permissions, sessionOnly: true})
+	return route
+}
+
+// set stores the policy of a route.
+func (a *AccessPolicy) set(route *mux.Route, policy routePolicy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policies[route] = policy
+}
+
+// lookup returns the policy of a route and whether the route was declared.
+func (a *AccessPolicy) lookup(route *mux.Route) (routePolicy, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	policy, ok := a.policies[route]
+	return policy, ok
+}
+
+// missingPermission returns the first required permission that the claims do not grant, or "" if every
+// required permission is granted.
+func (p routePolicy) missingPermission(claims *service.Claims) string {
+	for _, required := range p.permissions {
+		if !claims.HasPermission(required) {
+			return required
+		}
+	}
+	return ""
+}
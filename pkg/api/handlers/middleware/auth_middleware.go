@@ -2,138 +2,395 @@ package middleware
 
 import (
 	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
 	"GolandRestApi/pkg/repository"
 	"GolandRestApi/pkg/service"
 	"GolandRestApi/pkg/utils"
 	"database/sql"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// Authenticate is a middleware function that enforces token-based authentication for all incoming requests
-// except for specific endpoints like /login, /register, and /refreshToken. It verifies the validity of the access token
-// provided in the Authorization header and ensures that it is correctly formatted as a Bearer token.
+// Authenticate is a middleware function that enforces the access policy declared on each route. Public routes
+// are served without checks. Every other route requires a valid Bearer access token in the Authorization header,
+// and the permissions claim of the token must contain every permission the route requires. The decision is made
+// from the verified claims; the only lookups are whether the jti of the token is on the denylist, i.e. whether the
+// token was revoked by a logout before it expired, and whether the account of the user is still active, so a
+// disabled or deleted user cannot use the tokens issued before. The verified claims are then available to the
+// handlers through ClaimsFromRequest.
+// Machine-to-machine clients can send an API key in the X-API-Key header instead of the Authorization header. A
+// key is only accepted on routes requiring at least one permission, so it cannot manage the account of its owner,
+// and never on the routes declared with RequireSession, so it cannot manage API keys. It grants the permissions
+// it was created with that its owner still holds. Its claims have the api_key type,
+// the key ID as jti and no session.
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
+// access: The AccessPolicy in which every route was declared as public or protected.
+// keys: The Keyring holding the keys used to verify the access tokens.
+// denylist: The DenylistStore holding the revoked access tokens.
+// apiKeys: The APIKeyStore holding the API keys.
+// users: The UserStore holding the users of the access tokens and the owners of the API keys.
+// rbac: The RBACStore used to resolve the current permissions of the owners of the API keys.
 // cfg: A pointer to the config.Config struct that contains the API version and other configuration details.
 //
-// Returns a http.Handler that performs authentication checks before passing control to the next handler.
-func Authenticate(logger *logrus.Logger, db *sql.DB, cfg *config.Config) func(http.Handler) http.Handler {
+// Returns a http.Handler that performs authentication and authorization checks before passing control to the
+// next handler. Denied tokens, and the tokens of deleted users, are rejected with 401 like invalid ones, and the
+// tokens of disabled users with 403. Undeclared routes are denied with 403, and a caller lacking a permission gets
+// 403 with the missing permission named in the error message.
+func Authenticate(logger *logrus.Logger,
+	access *AccessPolicy,
+	keys *service.Keyring,
+	denylist repository.DenylistStore,
+	apiKeys repository.APIKeyStore,
+	users repository.UserStore,
+	rbac repository.RBACStore,
+	cfg *config.Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == "/api/"+cfg.APIVersion+"/user/login" ||
-				r.URL.Path == "/api/"+cfg.APIVersion+"/user/register" ||
-				r.URL.Path == "/api/"+cfg.APIVersion+"/token/refresh" {
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			// Extract token from the Authorization header
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
+			policy, declared := access.lookup(mux.CurrentRoute(r))
+			if !declared {
 				service.HttpErrorResponse(logger,
 					w,
-					http.StatusUnauthorized,
-					"/api/"+cfg.APIVersion,
-					"Authorization header is required",
+					http.StatusForbidden,
+					r.URL.Path,
+					"Access denied",
 					nil,
-					utils.LogTypeWarn,
-					"not able to get the username")
+					utils.LogTypeError,
+					"route without access policy")
 				return
 			}
 
-			bearerToken := strings.Split(authHeader, " ")
-			if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
-				service.HttpErrorResponse(logger,
-					w,
-					http.StatusUnauthorized,
-					"/api/"+cfg.APIVersion,
-					"Invalid token format",
-					nil,
-					utils.LogTypeWarn,
-					"not able to get the username")
+			if policy.public {
+				next.ServeHTTP(w, r)
 				return
 			}
 
-			tokenString := bearerToken[1]
-
-			// Verify token
-			username, _, err := service.ExtractClaimsFromToken(logger, tokenString)
-			if err != nil {
-				service.HttpErrorResponse(logger,
-					w,
-					http.StatusUnauthorized,
-					"/api/"+cfg.APIVersion,
-					"Invalid token",
-					nil,
-					utils.LogTypeWarn,
-					"not able to get the username")
-				return
+			var claims *service.Claims
+			var ok bool
+			if r.Header.Get(utils.APIKeyHeader) != "" {
+				claims, ok = authenticateAPIKey(logger, policy, apiKeys, users, rbac, cfg, w, r)
+			} else {
+				claims, ok = authenticateBearer(logger, keys, denylist, users, cfg, w, r)
 			}
-
-			err = service.VerifyToken(logger, cfg, username, tokenString)
-			if err != nil {
-				service.HttpErrorResponse(logger,
-					w,
-					http.StatusUnauthorized,
-					"/api/"+cfg.APIVersion,
-					"Invalid token",
-					nil,
-					utils.LogTypeWarn,
-					username)
+			if !ok {
 				return
 			}
 
-			// Admin routes
-			if r.URL.Path == "/api/"+cfg.APIVersion+"/admin/addUser" ||
-				r.URL.Path == "/api/"+cfg.APIVersion+"/admin/removeUser/{userId}" {
-				//TODO: check if the user has the role admin assign to him
-				userId, err := repository.GetUserIdByUserName(logger, db, username)
-				if err != nil {
-					service.HttpErrorResponse(logger,
-						w,
-						http.StatusInternalServerError,
-						"/api/"+cfg.APIVersion,
-						"Server error getting userId",
-						err,
-						utils.LogTypeError,
-						username)
-					return
-				}
-
-				userRoles, err := repository.GetUserRolesByUserId(logger, db, userId)
-				if err != nil {
-					service.HttpErrorResponse(logger,
-						w,
-						http.StatusInternalServerError,
-						"/api/"+cfg.APIVersion,
-						"Server error getting user roles",
-						err,
-						utils.LogTypeError,
-						username)
-					return
-				}
-
-				for _, role := range userRoles {
-					if role == utils.AdminRole {
-						next.ServeHTTP(w, r)
-						return
-					}
-				}
-
+			if missing := policy.missingPermission(claims); missing != "" {
 				service.HttpErrorResponse(logger,
 					w,
 					http.StatusForbidden,
-					"/api/"+cfg.APIVersion,
-					"Access denied",
+					r.URL.Path,
+					"Access denied: missing permission "+missing,
 					nil,
 					utils.LogTypeWarn,
-					username)
+					claims.Username)
 				return
 			}
 
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, withClaims(r, claims))
 		})
 	}
 }
+
+// authenticateBearer verifies the Bearer access token of the Authorization header and checks that it was not
+// revoked and that its user is active. It answers the request itself when the token is missing, invalid or denied,
+// or when its user is deleted or disabled.
+//
+// Returns the verified claims and true, or nil and false if the request was answered.
+func authenticateBearer(logger *logrus.Logger,
+	keys *service.Keyring,
+	denylist repository.DenylistStore,
+	users repository.UserStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) (*service.Claims, bool) {
+	// Extract token from the Authorization header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/api/"+cfg.APIVersion,
+			"Authorization header is required",
+			nil,
+			utils.LogTypeWarn,
+			"not able to get the username")
+		return nil, false
+	}
+
+	bearerToken := strings.Split(authHeader, " ")
+	if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/api/"+cfg.APIVersion,
+			"Invalid token format",
+			nil,
+			utils.LogTypeWarn,
+			"not able to get the username")
+		return nil, false
+	}
+
+	tokenString := bearerToken[1]
+
+	// Verify token
+	claims, err := service.VerifyToken(logger, cfg, keys, tokenString, utils.AccessToken)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/api/"+cfg.APIVersion,
+			"Invalid token",
+			nil,
+			utils.LogTypeWarn,
+			"not able to get the username")
+		return nil, false
+	}
+
+	denied, err := denylist.IsAccessTokenDenied(claims.ID)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/api/"+cfg.APIVersion,
+			"Server error checking the token",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return nil, false
+	}
+	if denied {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/api/"+cfg.APIVersion,
+			"Invalid token",
+			nil,
+			utils.LogTypeWarn,
+			claims.Username)
+		return nil, false
+	}
+
+	userId, err := claims.UserId()
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/api/"+cfg.APIVersion,
+			"Invalid token",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return nil, false
+	}
+	user, err := users.GetUserById(userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/api/"+cfg.APIVersion,
+			"Server error checking the token",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return nil, false
+	}
+	if err != nil {
+		// The user was deleted after the token was issued
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/api/"+cfg.APIVersion,
+			"Invalid token",
+			nil,
+			utils.LogTypeWarn,
+			claims.Username)
+		return nil, false
+	}
+	if !user.Active() {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/api/"+cfg.APIVersion,
+			"Account disabled, contact an administrator",
+			nil,
+			utils.LogTypeInfo,
+			claims.Username)
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// authenticateAPIKey checks the API key of the X-API-Key header and builds the claims of the request from the key
+// and its owner. It answers the request itself when the key is invalid, expired, not accepted on the route or owned
+// by a disabled user, and records the use of the key at most once per service.APIKeyTouchInterval.
+//
+// Returns the claims and true, or nil and false if the request was answered.
+func authenticateAPIKey(logger *logrus.Logger,
+	policy routePolicy,
+	apiKeys repository.APIKeyStore,
+	users repository.UserStore,
+	rbac repository.RBACStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) (*service.Claims, bool) {
+	if r.Header.Get("Authorization") != "" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/api/"+cfg.APIVersion,
+			"Send either an API key or an Authorization header, not both",
+			nil,
+			utils.LogTypeWarn,
+			"not able to get the username")
+		return nil, false
+	}
+
+	apiKey := r.Header.Get(utils.APIKeyHeader)
+	keyId, err := service.ParseAPIKey(apiKey)
+	var key *model.APIKey
+	if err == nil {
+		key, err = apiKeys.GetAPIKey(keyId)
+	}
+	if err != nil && !errors.Is(err, service.ErrMalformedAPIKey) && !errors.Is(err, repository.ErrNotFound) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/api/"+cfg.APIVersion,
+			"Server error checking the API key",
+			err,
+			utils.LogTypeError,
+			"not able to get the username")
+		return nil, false
+	}
+	if err != nil || !service.APIKeyMatches(apiKey, key.KeyHash) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/api/"+cfg.APIVersion,
+			"Invalid API key",
+			nil,
+			utils.LogTypeWarn,
+			"not able to get the username")
+		return nil, false
+	}
+
+	now := time.Now()
+	user, err := users.GetUserById(key.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/api/"+cfg.APIVersion,
+			"Invalid API key",
+			nil,
+			utils.LogTypeWarn,
+			"not able to get the username")
+		return nil, false
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/api/"+cfg.APIVersion,
+			"Server error checking the API key",
+			err,
+			utils.LogTypeError,
+			"not able to get the username")
+		return nil, false
+	}
+	if key.Expired(now) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/api/"+cfg.APIVersion,
+			"API key expired",
+			nil,
+			utils.LogTypeInfo,
+			user.Username)
+		return nil, false
+	}
+	if len(policy.permissions) == 0 {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			r.URL.Path,
+			"Access denied: API keys are only accepted on routes requiring a permission, log in instead",
+			nil,
+			utils.LogTypeWarn,
+			user.Username)
+		return nil, false
+	}
+	if policy.sessionOnly {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			r.URL.Path,
+			"Access denied: API keys are not accepted on this route, log in instead",
+			nil,
+			utils.LogTypeWarn,
+			user.Username)
+		return nil, false
+	}
+	if !user.Active() {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/api/"+cfg.APIVersion,
+			"Account disabled, contact an administrator",
+			nil,
+			utils.LogTypeInfo,
+			user.Username)
+		return nil, false
+	}
+	if cfg.EmailVerificationMode == utils.EmailVerificationBlock && !user.EmailVerified {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/api/"+cfg.APIVersion,
+			"Email address not verified, follow the link sent to it or ask for a new one",
+			nil,
+			utils.LogTypeInfo,
+			user.Username)
+		return nil, false
+	}
+
+	ownerPermissions, err := rbac.GetUserPermissionsByUserId(user.ID)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/api/"+cfg.APIVersion,
+			"Server error checking the API key",
+			err,
+			utils.LogTypeError,
+			user.Username)
+		return nil, false
+	}
+	if cfg.EmailVerificationMode == utils.EmailVerificationRestrict && !user.EmailVerified {
+		ownerPermissions = nil
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= service.APIKeyTouchInterval {
+		if err := apiKeys.TouchAPIKey(key.ID, now); err != nil {
+			logger.WithError(err).WithField("username", user.Username).Warn("Error recording the use of an API key")
+		}
+	}
+
+	return &service.Claims{
+		Username:    user.Username,
+		TokenType:   utils.APIKeyToken,
+		Permissions: service.APIKeyPermissions(key.Permissions, ownerPermissions),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.Itoa(user.ID),
+			ID:      key.ID,
+		},
+	}, true
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"GolandRestApi/pkg/service"
+	"context"
+	"net/http"
+)
+
+// contextKey is the type of the keys stored by this package in the request context.
+type contextKey int
+
+const claimsKey contextKey = iota
+
+// withClaims returns a copy of the request carrying the verified claims of its access token.
+func withClaims(r *http.Request, claims *service.Claims) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claimsKey, claims))
+}
+
+// ClaimsFromRequest returns the verified access-token claims stored by the Authenticate middleware.
+//
+// r: The HTTP request being served.
+//
+// Returns the claims and true, or nil and false on public routes.
+func ClaimsFromRequest(r *http.Request) (*service.Claims, bool) {
+	claims, ok := r.Context().Value(claimsKey).(*service.Claims)
+	return claims, ok
+}
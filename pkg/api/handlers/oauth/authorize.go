@@ -0,0 +1,201 @@
+package oauth
+
+import (
+	"GolandRestApi/pkg/api/handlers/middleware"
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+)
+
+// Authorize handles the authorization endpoint of the OAuth provider, for the authorization code grant with PKCE.
+// The user authenticates to it with the Bearer access token of the API, so it is meant to be called by the login
+// page of the API once the user logged in, with the parameters of the authorization request of the client:
+// client_id, redirect_uri, response_type=code, scope, state, nonce, code_challenge and
+// code_challenge_method=S256. PKCE is required from every client. The redirect URI must be one the client was
+// registered with, compared as a string, and the scope must only contain scopes it was registered with. No consent
+// is asked: the clients are registered by the administrators. With EMAIL_VERIFICATION_MODE set to block, users
+// whose email address is not verified are denied.
+//
+// Since the request carries an Authorization header, the response is not a redirection but a JSON body
+// {"redirect_to": "..."} holding the redirect URI with the code and the state, or with the error and the state,
+// to which the page then sends the browser.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// clients: The OAuthStore holding the clients and the authorization codes.
+// users: The UserStore used to look up the user.
+// cfg: A pointer to the config.Config struct which contains the OAuth configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, with the parameters in the query string or in a form-encoded body.
+//
+// Responds with 200 and the redirect_to URI, or with 400 and an OAuth error if the client or the redirect URI is
+// invalid, since the user must then not be redirected to it.
+func Authorize(logger *logrus.Logger,
+	clients repository.OAuthStore,
+	users repository.UserStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+
+	claims, _ := middleware.ClaimsFromRequest(r)
+	userId, err := claims.UserId()
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusUnauthorized, "/oauth/authorize", errAccessDenied,
+			"Invalid token", err, utils.LogTypeWarn, claims.Username)
+		return
+	}
+
+	if err = r.ParseForm(); err != nil {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/authorize", errInvalidRequest,
+			"Invalid request format", err, utils.LogTypeWarn, claims.Username)
+		return
+	}
+
+	clientId := r.Form.Get("client_id")
+	client, err := clients.GetOAuthClient(clientId)
+	if errors.Is(err, repository.ErrNotFound) || clientId == "" {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/authorize", errInvalidRequest,
+			"Unknown client", err, utils.LogTypeWarn, claims.Username)
+		return
+	} else if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/authorize", errServerError,
+			"Server error retrieving the client from DB", err, utils.LogTypeError, claims.Username)
+		return
+	}
+
+	redirectURI := r.Form.Get("redirect_uri")
+	if !client.AllowsRedirectURI(redirectURI) {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/authorize", errInvalidRequest,
+			"Redirect URI not registered for the client", nil, utils.LogTypeWarn, claims.Username)
+		return
+	}
+
+	// From here on, errors are sent back to the client through the redirect URI
+	state := r.Form.Get("state")
+	redirectError := func(code string, description string) {
+		logger.WithFields(logrus.Fields{
+			"endpoint": "/oauth/authorize",
+			"username": claims.Username,
+			"clientId": client.ID,
+			"error":    code,
+		}).Info(description)
+		redirect(logger, w, redirectURI, url.Values{"error": {code}, "error_description": {description}}, state,
+			claims.Username)
+	}
+
+	if r.Form.Get("response_type") != "code" {
+		redirectError(errUnsupportedResponseType, "Only the code response type is supported")
+		return
+	}
+	if !client.AllowsGrant(utils.OAuthGrantAuthorizationCode) {
+		redirectError(errUnauthorizedClient, "The client is not allowed to use the authorization code grant")
+		return
+	}
+
+	scope := service.NormalizeOAuthScope(r.Form.Get("scope"))
+	if !client.AllowsScope(scope) {
+		redirectError(errInvalidScope, "Scope not allowed for the client")
+		return
+	}
+
+	codeChallenge := r.Form.Get("code_challenge")
+	if r.Form.Get("code_challenge_method") != service.PKCEMethodS256 || !service.ValidPKCEChallenge(codeChallenge) {
+		redirectError(errInvalidRequest, "A code_challenge with the S256 code_challenge_method is required")
+		return
+	}
+
+	user, err := users.GetUserById(userId)
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusUnauthorized, "/oauth/authorize", errAccessDenied,
+			"Invalid token", err, utils.LogTypeWarn, claims.Username)
+		return
+	}
+	if !user.Active() {
+		redirectError(errAccessDenied, "Account disabled")
+		return
+	}
+	if cfg.EmailVerificationMode == utils.EmailVerificationBlock && !user.EmailVerified {
+		redirectError(errAccessDenied, "Email address not verified")
+		return
+	}
+
+	expiresAt, err := service.OAuthCodeExpiry(cfg)
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/authorize", errServerError,
+			"Invalid authorization code validity", err, utils.LogTypeError, claims.Username)
+		return
+	}
+
+	code, codeHash, err := service.NewOAuthAuthorizationCode()
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/authorize", errServerError,
+			"Server error generating the authorization code", err, utils.LogTypeError, claims.Username)
+		return
+	}
+
+	err = clients.CreateOAuthAuthorizationCode(model.OAuthAuthorizationCode{
+		CodeHash:      codeHash,
+		ClientID:      client.ID,
+		UserID:        user.ID,
+		RedirectURI:   redirectURI,
+		Scope:         scope,
+		CodeChallenge: codeChallenge,
+		Nonce:         r.Form.Get("nonce"),
+		ExpiresAt:     expiresAt,
+	})
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/authorize", errServerError,
+			"Server error storing the authorization code", err, utils.LogTypeError, claims.Username)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"username": user.Username,
+		"clientId": client.ID,
+		"scope":    scope,
+	}).Info("OAuth authorization code issued")
+	redirect(logger, w, redirectURI, url.Values{"code": {code}}, state, user.Username)
+}
+
+// redirect responds with the redirect URI of the client to which the browser must be sent, holding the given
+// parameters and the state of the authorization request, if any, added to the query of the URI.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// w: The http.ResponseWriter to write the response to.
+// redirectURI: The registered redirect URI.
+// params: The parameters of the response, the code or the error.
+// state: The state sent by the client, possibly empty.
+// username: The username of the user (used for logging purposes).
+func redirect(logger *logrus.Logger,
+	w http.ResponseWriter,
+	redirectURI string,
+	params url.Values,
+	state string,
+	username string) {
+
+	// The registered redirect URIs are validated as absolute URIs
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/authorize", errServerError,
+			"Invalid redirect URI", err, utils.LogTypeError, username)
+		return
+	}
+
+	query := target.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	target.RawQuery = query.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/oauth/authorize",
+		map[string]string{"redirect_to": target.String()}, username)
+}
@@ -0,0 +1,141 @@
+package oauth
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+)
+
+// Error codes of the OAuth 2.0 error responses (RFC 6749, section 5.2 and 4.1.2.1).
+const (
+	errInvalidRequest          = "invalid_request"
+	errInvalidClient           = "invalid_client"
+	errInvalidGrant            = "invalid_grant"
+	errUnauthorizedClient      = "unauthorized_client"
+	errUnsupportedGrantType    = "unsupported_grant_type"
+	errUnsupportedResponseType = "unsupported_response_type"
+	errInvalidScope            = "invalid_scope"
+	errAccessDenied            = "access_denied"
+	errServerError             = "server_error"
+)
+
+// errClientAuthentication is returned by authenticateClient for an unknown client or a wrong secret.
+var errClientAuthentication = errors.New("client authentication failed")
+
+// authenticateClient identifies the OAuth client calling the token, introspection or revocation endpoint. A
+// confidential client authenticates with HTTP Basic authentication (client_secret_basic) or with the client_id
+// and client_secret form parameters (client_secret_post); a public client only sends its client_id. The form must
+// have been parsed.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// clients: The OAuthStore holding the registered clients.
+// r: The HTTP request sent by the client.
+//
+// Returns the authenticated client, errClientAuthentication if the client is unknown or its secret is wrong, or
+// any other error returned by the store.
+func authenticateClient(logger *logrus.Logger,
+	clients repository.OAuthStore,
+	r *http.Request) (*model.OAuthClient, error) {
+
+	clientId, secret, basic := r.BasicAuth()
+	if basic {
+		// The credentials are form-encoded before being put in the header (RFC 6749, section 2.3.1)
+		var err error
+		if clientId, err = url.QueryUnescape(clientId); err != nil {
+			return nil, errClientAuthentication
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, errClientAuthentication
+		}
+	} else {
+		clientId = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	if clientId == "" {
+		return nil, errClientAuthentication
+	}
+
+	client, err := clients.GetOAuthClient(clientId)
+	if errors.Is(err, repository.ErrNotFound) {
+		logger.WithField("clientId", clientId).Warn("Unknown OAuth client")
+		return nil, errClientAuthentication
+	} else if err != nil {
+		return nil, err
+	}
+
+	if client.Confidential() != (secret != "") ||
+		(client.Confidential() && !service.OAuthClientSecretMatches(secret, client.SecretHash)) {
+		logger.WithField("clientId", clientId).Warn("OAuth client authentication failed")
+		return nil, errClientAuthentication
+	}
+	return client, nil
+}
+
+// clientAuthenticationFailed responds to a request whose client could not be authenticated: 401 with the
+// invalid_client error, or 500 if the store failed.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// w: The http.ResponseWriter to write the response to.
+// endpoint: The endpoint handling the request.
+// err: The error returned by authenticateClient.
+func clientAuthenticationFailed(logger *logrus.Logger, w http.ResponseWriter, endpoint string, err error) {
+	if errors.Is(err, errClientAuthentication) {
+		oauthErrorResponse(logger, w, http.StatusUnauthorized, endpoint, errInvalidClient,
+			"Client authentication failed", nil, utils.LogTypeWarn, "")
+		return
+	}
+	oauthErrorResponse(logger, w, http.StatusInternalServerError, endpoint, errServerError,
+		"Server error retrieving the client from DB", err, utils.LogTypeError, "")
+}
+
+// oauthErrorResponse sends an OAuth 2.0 error response, whose JSON body is of the form
+// {"error": code, "error_description": description}, and logs it like service.HttpErrorResponse. The invalid_client
+// error carries a WWW-Authenticate header asking for HTTP Basic authentication.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// w: The http.ResponseWriter to write the error response to.
+// statusCode: The HTTP status code to set in the response.
+// endpoint: The endpoint where the error occurred.
+// code: The OAuth error code, e.g. errInvalidGrant.
+// description: The human-readable description of the error.
+// err: The error object or nil if there's no specific error.
+// logType: The type of log message (e.g., utils.LogTypeInfo, utils.LogTypeWarn, utils.LogTypeError).
+// username: The username or client ID associated with the request (used for logging purposes).
+func oauthErrorResponse(logger *logrus.Logger,
+	w http.ResponseWriter,
+	statusCode int,
+	endpoint,
+	code,
+	description string,
+	err error,
+	logType,
+	username string) {
+
+	fields := logrus.Fields{
+		"endpoint": endpoint,
+		"username": username,
+		"error":    code,
+	}
+	switch logType {
+	case utils.LogTypeInfo:
+		logger.WithFields(fields).Info(description)
+	case utils.LogTypeWarn:
+		logger.WithFields(fields).Warn(description)
+	case utils.LogTypeError:
+		logger.WithError(err).WithFields(fields).Error(description)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if code == errInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
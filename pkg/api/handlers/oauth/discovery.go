@@ -0,0 +1,22 @@
+package oauth
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/service"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// Discovery publishes the OpenID Connect discovery document of the OAuth provider, so the clients can find its
+// endpoints, its keys and what it supports from /.well-known/openid-configuration.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// keys: The Keyring holding the signing key.
+// cfg: A pointer to the config.Config struct which contains the OAuth issuer.
+// w: The HTTP response writer to send the response.
+// r: The HTTP request to process.
+func Discovery(logger *logrus.Logger, keys *service.Keyring, cfg *config.Config, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/.well-known/openid-configuration",
+		service.NewOIDCDiscovery(cfg, keys), "")
+}
@@ -0,0 +1,194 @@
+package oauth
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// introspectionResponse is the response of the introspection endpoint (RFC 7662, section 2.2). Only active is
+// set for a token that is not active.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+}
+
+// Introspect handles the introspection endpoint of the OAuth provider (RFC 7662), with which a resource server
+// checks a token sent to it. Only confidential clients can introspect tokens. An access token issued to any
+// client is described as long as it is valid and was not revoked with its session; a refresh token only to the
+// client it was issued to. Tokens issued by the API outside of OAuth are never active.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// clients: The OAuthStore holding the clients.
+// users: The UserStore used to look up the username of a refresh token.
+// tokens: The TokenStore holding the sessions.
+// denylist: The DenylistStore holding the revoked access tokens.
+// keys: The Keyring holding the verification keys.
+// cfg: A pointer to the config.Config struct which contains the OAuth configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, with the token form parameter.
+//
+// Responds with 200 and the description of the token, {"active": false} if it is not active, or 401 if the client
+// cannot be authenticated.
+func Introspect(logger *logrus.Logger,
+	clients repository.OAuthStore,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/introspect", errInvalidRequest,
+			"Invalid request format", err, utils.LogTypeWarn, "")
+		return
+	}
+
+	client, err := authenticateClient(logger, clients, r)
+	if err == nil && !client.Confidential() {
+		err = errClientAuthentication
+	}
+	if err != nil {
+		clientAuthenticationFailed(logger, w, "/oauth/introspect", err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	var response introspectionResponse
+	if _, parseErr := service.ParseRefreshToken(token); parseErr == nil {
+		response, err = introspectRefreshToken(users, tokens, cfg, client, token)
+	} else {
+		response, err = introspectAccessToken(logger, denylist, keys, cfg, token)
+	}
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/introspect", errServerError,
+			"Server error introspecting the token", err, utils.LogTypeError, client.ID)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/oauth/introspect", response, client.ID)
+}
+
+// introspectAccessToken describes an OAuth access token, active if its signature and claims are valid and it is
+// not on the denylist.
+//
+// Returns the description, or an error returned by the store.
+func introspectAccessToken(logger *logrus.Logger,
+	denylist repository.DenylistStore,
+	keys *service.Keyring,
+	cfg *config.Config,
+	token string) (introspectionResponse, error) {
+
+	claims, err := service.VerifyOAuthAccessToken(logger, cfg, keys, token)
+	if err != nil {
+		return introspectionResponse{}, nil
+	}
+
+	// The tokens of the client credentials grant have no session and are not recorded
+	if claims.SessionId != "" {
+		denied, err := denylist.IsAccessTokenDenied(claims.ID)
+		if err != nil {
+			return introspectionResponse{}, err
+		} else if denied {
+			return introspectionResponse{}, nil
+		}
+	}
+
+	return introspectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientId,
+		Username:  claims.Username,
+		TokenType: "Bearer",
+		Exp:       claims.ExpiresAt.Unix(),
+		Iat:       claims.IssuedAt.Unix(),
+		Sub:       claims.Subject,
+		Aud:       claims.ClientId,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+	}, nil
+}
+
+// introspectRefreshToken describes a refresh token, active if it is the current token of an active session of the
+// calling client.
+//
+// Returns the description, or an error returned by the store.
+func introspectRefreshToken(users repository.UserStore,
+	tokens repository.TokenStore,
+	cfg *config.Config,
+	client *model.OAuthClient,
+	token string) (introspectionResponse, error) {
+
+	session, matches, err := findRefreshTokenSession(tokens, cfg, client, token)
+	if err != nil || !matches || !session.Active(time.Now()) {
+		return introspectionResponse{}, err
+	}
+
+	username, err := users.GetUserNameByUserId(session.UserID)
+	if err != nil {
+		return introspectionResponse{}, nil
+	}
+
+	return introspectionResponse{
+		Active:    true,
+		Scope:     session.Scope,
+		ClientID:  session.ClientID,
+		Username:  username,
+		TokenType: "refresh_token",
+		Exp:       session.ExpiresAt.Unix(),
+		Iat:       session.CreatedAt.Unix(),
+		Sub:       strconv.Itoa(session.UserID),
+		Aud:       session.ClientID,
+		Iss:       cfg.OAuthIssuerURL,
+	}, nil
+}
+
+// findRefreshTokenSession finds the session of a refresh token issued to a client.
+//
+// tokens: The TokenStore holding the sessions.
+// cfg: A pointer to the config.Config struct which contains the refresh token hash key.
+// client: The client presenting the token.
+// token: The refresh token, well-formed.
+//
+// Returns the session, nil if it does not exist or does not belong to the client, whether the token is the
+// current token of the session, and an error returned by the store.
+func findRefreshTokenSession(tokens repository.TokenStore,
+	cfg *config.Config,
+	client *model.OAuthClient,
+	token string) (*model.Session, bool, error) {
+
+	sessionId, err := service.ParseRefreshToken(token)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	session, err := tokens.GetSession(sessionId)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if session.ClientID != client.ID {
+		return nil, false, nil
+	}
+	return session, service.RefreshTokenMatches(service.HashRefreshToken(cfg, token), session.TokenHash), nil
+}
@@ -0,0 +1,96 @@
+package oauth
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// Revoke handles the revocation endpoint of the OAuth provider (RFC 7009), with which a client logs its user out.
+// Revoking a refresh token, or an access token of a session, revokes the whole session: its refresh token cannot
+// be used anymore and its access tokens are denied. A client can only revoke its own tokens. A refresh token
+// revokes its session if it is the current token of the session or one that was rotated, so knowing a session ID
+// is not enough. The response does not tell whether a token was revoked: invalid tokens are ignored.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// clients: The OAuthStore holding the clients.
+// tokens: The TokenStore holding the sessions.
+// denylist: The DenylistStore in which the access tokens of the session are denied.
+// keys: The Keyring holding the verification keys.
+// cfg: A pointer to the config.Config struct which contains the OAuth configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, with the token form parameter.
+//
+// Responds with 200 and an empty body, or with 401 if the client cannot be authenticated.
+func Revoke(logger *logrus.Logger,
+	clients repository.OAuthStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/revoke", errInvalidRequest,
+			"Invalid request format", err, utils.LogTypeWarn, "")
+		return
+	}
+
+	client, err := authenticateClient(logger, clients, r)
+	if err != nil {
+		clientAuthenticationFailed(logger, w, "/oauth/revoke", err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	var sessionId string
+	if _, parseErr := service.ParseRefreshToken(token); parseErr == nil {
+		session, matches, err := findRefreshTokenSession(tokens, cfg, client, token)
+		if err != nil {
+			oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/revoke", errServerError,
+				"Server error retrieving the session from DB", err, utils.LogTypeError, client.ID)
+			return
+		}
+		if session != nil && !matches {
+			matches, err = tokens.IsRotatedRefreshToken(session.ID, service.HashRefreshToken(cfg, token))
+			if err != nil {
+				oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/revoke", errServerError,
+					"Server error retrieving the session from DB", err, utils.LogTypeError, client.ID)
+				return
+			}
+		}
+		if matches {
+			sessionId = session.ID
+		}
+	} else if claims, err := service.VerifyOAuthAccessToken(logger, cfg, keys, token); err == nil &&
+		claims.ClientId == client.ID {
+		sessionId = claims.SessionId
+	}
+
+	if sessionId != "" {
+		err = tokens.RevokeSession(sessionId)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/revoke", errServerError,
+				"Server error revoking the session", err, utils.LogTypeError, client.ID)
+			return
+		}
+		_, err = denylist.DenySessionAccessTokens(sessionId)
+		if err != nil {
+			oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/revoke", errServerError,
+				"Server error denying the access tokens of the session", err, utils.LogTypeError, client.ID)
+			return
+		}
+		logger.WithFields(logrus.Fields{
+			"clientId":  client.ID,
+			"sessionId": sessionId,
+		}).Info("OAuth session revoked")
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,355 @@
+package oauth
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// tokenResponse is the successful response of the token endpoint (RFC 6749, section 5.1), with the ID token of
+// OpenID Connect.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// Token handles the token endpoint of the OAuth provider. The request is form-encoded and the client
+// authenticates as described by authenticateClient. Three grants are supported, each only for the clients
+// registered with it:
+//   - authorization_code exchanges a code issued by Authorize, with the same redirect_uri and the code_verifier
+//     answering its PKCE challenge, for an access token, a refresh token and, with the openid scope, an ID token.
+//     It starts a session of the user belonging to the client, listed with the other sessions of the user.
+//   - refresh_token rotates the refresh token of such a session, with the same reuse detection as the refresh
+//     tokens of the API. A narrower scope can be requested for the new access token.
+//   - client_credentials issues an access token to a confidential client acting on its own behalf, without user,
+//     session nor refresh token. It cannot be revoked before it expires.
+//
+// The refresh token is only returned to clients registered with the refresh_token grant.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// clients: The OAuthStore holding the clients and the authorization codes.
+// users: The UserStore used to look up the users.
+// tokens: The TokenStore holding the sessions.
+// denylist: The DenylistStore in which the access tokens of the users are recorded.
+// keys: The Keyring holding the signing key.
+// cfg: A pointer to the config.Config struct which contains the JWT and OAuth configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request.
+//
+// Responds with 200 and the tokens, or with an OAuth error: 401 if the client cannot be authenticated, 400
+// otherwise. Responses are not cacheable.
+func Token(logger *logrus.Logger,
+	clients repository.OAuthStore,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidRequest,
+			"Invalid request format", err, utils.LogTypeWarn, "")
+		return
+	}
+
+	client, err := authenticateClient(logger, clients, r)
+	if err != nil {
+		clientAuthenticationFailed(logger, w, "/oauth/token", err)
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	switch grantType {
+	case utils.OAuthGrantAuthorizationCode, utils.OAuthGrantRefreshToken, utils.OAuthGrantClientCredentials:
+	default:
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errUnsupportedGrantType,
+			"Unsupported grant type", nil, utils.LogTypeInfo, client.ID)
+		return
+	}
+	if !client.AllowsGrant(grantType) {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errUnauthorizedClient,
+			"The client is not allowed to use this grant type", nil, utils.LogTypeWarn, client.ID)
+		return
+	}
+
+	switch grantType {
+	case utils.OAuthGrantAuthorizationCode:
+		exchangeAuthorizationCode(logger, clients, users, tokens, denylist, keys, cfg, w, r, client)
+	case utils.OAuthGrantRefreshToken:
+		refreshTokens(logger, users, tokens, denylist, keys, cfg, w, r, client)
+	case utils.OAuthGrantClientCredentials:
+		issueClientCredentialsToken(logger, keys, cfg, w, r, client)
+	}
+}
+
+// exchangeAuthorizationCode serves the authorization_code grant of Token.
+func exchangeAuthorizationCode(logger *logrus.Logger,
+	clients repository.OAuthStore,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request,
+	client *model.OAuthClient) {
+
+	code, err := clients.ConsumeOAuthAuthorizationCode(service.HashOAuthSecret(r.PostForm.Get("code")))
+	if errors.Is(err, repository.ErrNotFound) {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidGrant,
+			"Invalid or expired authorization code", nil, utils.LogTypeWarn, client.ID)
+		return
+	} else if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/token", errServerError,
+			"Server error retrieving the authorization code from DB", err, utils.LogTypeError, client.ID)
+		return
+	}
+
+	// The code is consumed in any case, so a code sent with a wrong verifier cannot be tried again
+	if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") ||
+		!service.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidGrant,
+			"Invalid or expired authorization code", nil, utils.LogTypeWarn, client.ID)
+		return
+	}
+
+	user, ok := grantingUser(logger, users, cfg, w, code.UserID, client)
+	if !ok {
+		return
+	}
+
+	oauthTokens, err := service.HandleOAuthTokensCreation(logger, cfg, keys, client, user, code.Scope, code.Nonce, "")
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/token", errServerError,
+			"Server error handling the tokens", err, utils.LogTypeError, user.Username)
+		return
+	}
+
+	// The token endpoint is called by the client, so its user agent and address do not describe the device of
+	// the user; the session is labelled with the name of the client instead
+	err = tokens.CreateSession(model.Session{
+		ID:          oauthTokens.SessionId,
+		UserID:      user.ID,
+		DeviceLabel: client.Name,
+		ExpiresAt:   oauthTokens.RefreshExpiresAt,
+		ClientID:    client.ID,
+		Scope:       oauthTokens.Scope,
+		TokenHash:   oauthTokens.RefreshTokenHash,
+	})
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/token", errServerError,
+			"Server error creating the session", err, utils.LogTypeError, user.Username)
+		return
+	}
+
+	writeTokens(logger, denylist, w, client, user, oauthTokens)
+}
+
+// refreshTokens serves the refresh_token grant of Token.
+func refreshTokens(logger *logrus.Logger,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request,
+	client *model.OAuthClient) {
+
+	refreshToken := r.PostForm.Get("refresh_token")
+	sessionId, err := service.ParseRefreshToken(refreshToken)
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidGrant,
+			"Invalid refresh token", err, utils.LogTypeWarn, client.ID)
+		return
+	}
+
+	session, err := tokens.GetSession(sessionId)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/token", errServerError,
+			"Server error retrieving the session from DB", err, utils.LogTypeError, client.ID)
+		return
+	}
+
+	// The token must belong to an active session of the client
+	if err != nil || !session.Active(time.Now()) || session.ClientID != client.ID {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidGrant,
+			"Invalid refresh token", err, utils.LogTypeWarn, client.ID)
+		return
+	}
+
+	user, ok := grantingUser(logger, users, cfg, w, session.UserID, client)
+	if !ok {
+		return
+	}
+
+	tokenHash := service.HashRefreshToken(cfg, refreshToken)
+	if !service.RefreshTokenMatches(tokenHash, session.TokenHash) {
+		rotated, err := tokens.IsRotatedRefreshToken(session.ID, tokenHash)
+		if err != nil {
+			oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/token", errServerError,
+				"Server error retrieving the session from DB", err, utils.LogTypeError, user.Username)
+			return
+		}
+		if rotated {
+			service.RevokeReusedSession(logger, tokens, denylist, session, user.Username, r)
+		}
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidGrant,
+			"Invalid refresh token", nil, utils.LogTypeWarn, user.Username)
+		return
+	}
+
+	// The new access token can be limited to a part of the scope of the session, which stays unchanged
+	scope := session.Scope
+	if requested := service.NormalizeOAuthScope(r.PostForm.Get("scope")); requested != "" {
+		if !service.OAuthScopeIncludes(session.Scope, requested) {
+			oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidScope,
+				"The scope exceeds the scope originally granted", nil, utils.LogTypeWarn, user.Username)
+			return
+		}
+		scope = requested
+	}
+
+	oauthTokens, err := service.HandleOAuthTokensCreation(logger, cfg, keys, client, user, scope, "", session.ID)
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/token", errServerError,
+			"Server error handling the tokens", err, utils.LogTypeError, user.Username)
+		return
+	}
+
+	err = tokens.RotateRefreshToken(session.ID, tokenHash, oauthTokens.RefreshTokenHash, oauthTokens.RefreshExpiresAt)
+	if err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			// Another request rotated the same token in the meantime
+			service.RevokeReusedSession(logger, tokens, denylist, session, user.Username, r)
+		}
+		if errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrNotFound) {
+			oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidGrant,
+				"Invalid refresh token", err, utils.LogTypeWarn, user.Username)
+			return
+		}
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/token", errServerError,
+			"Server error storing the refresh token", err, utils.LogTypeError, user.Username)
+		return
+	}
+
+	writeTokens(logger, denylist, w, client, user, oauthTokens)
+}
+
+// issueClientCredentialsToken serves the client_credentials grant of Token. Only confidential clients can use it,
+// and the openid scope cannot be requested since there is no user.
+func issueClientCredentialsToken(logger *logrus.Logger,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request,
+	client *model.OAuthClient) {
+
+	if !client.Confidential() {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errUnauthorizedClient,
+			"Public clients cannot use the client credentials grant", nil, utils.LogTypeWarn, client.ID)
+		return
+	}
+
+	scope := service.NormalizeOAuthScope(r.PostForm.Get("scope"))
+	if !client.AllowsScope(scope) || service.HasOAuthScope(scope, utils.OAuthScopeOpenID) {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidScope,
+			"Scope not allowed for the client", nil, utils.LogTypeWarn, client.ID)
+		return
+	}
+
+	accessToken, expiresAt, err := service.NewOAuthClientCredentialsToken(logger, cfg, keys, client, scope)
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/token", errServerError,
+			"Server error handling the tokens", err, utils.LogTypeError, client.ID)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/oauth/token", tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(expiresAt).Round(time.Second).Seconds()),
+		Scope:       scope,
+	}, client.ID)
+}
+
+// grantingUser looks up the user who authorized a client, and checks that tokens can still be issued to it.
+// Disabled users are rejected, and so are, with EMAIL_VERIFICATION_MODE set to block, users whose email address is
+// not verified.
+//
+// Returns the user and true, or false if an error response was written.
+func grantingUser(logger *logrus.Logger,
+	users repository.UserStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	userId int,
+	client *model.OAuthClient) (*model.User, bool) {
+
+	user, err := users.GetUserById(userId)
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidGrant,
+			"The user of the grant does not exist anymore", err, utils.LogTypeWarn, client.ID)
+		return nil, false
+	}
+	if !user.Active() {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidGrant,
+			"Account disabled", nil, utils.LogTypeInfo, user.Username)
+		return nil, false
+	}
+	if cfg.EmailVerificationMode == utils.EmailVerificationBlock && !user.EmailVerified {
+		oauthErrorResponse(logger, w, http.StatusBadRequest, "/oauth/token", errInvalidGrant,
+			"Email address not verified", nil, utils.LogTypeInfo, user.Username)
+		return nil, false
+	}
+	return user, true
+}
+
+// writeTokens records the access token issued to a user in the denylist store, so it is denied with its session,
+// and responds with the tokens. The refresh token is left out for clients not registered with the refresh_token
+// grant.
+func writeTokens(logger *logrus.Logger,
+	denylist repository.DenylistStore,
+	w http.ResponseWriter,
+	client *model.OAuthClient,
+	user *model.User,
+	oauthTokens *service.OAuthTokens) {
+
+	err := denylist.RecordAccessToken(oauthTokens.AccessTokenRecord(user.ID))
+	if err != nil {
+		oauthErrorResponse(logger, w, http.StatusInternalServerError, "/oauth/token", errServerError,
+			"Server error recording the access token", err, utils.LogTypeError, user.Username)
+		return
+	}
+
+	response := tokenResponse{
+		AccessToken: oauthTokens.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   oauthTokens.ExpiresIn(),
+		Scope:       oauthTokens.Scope,
+		IDToken:     oauthTokens.IDToken,
+	}
+	if client.AllowsGrant(utils.OAuthGrantRefreshToken) {
+		response.RefreshToken = oauthTokens.RefreshToken
+	}
+
+	logger.WithFields(logrus.Fields{
+		"username":  user.Username,
+		"clientId":  client.ID,
+		"sessionId": oauthTokens.SessionId,
+	}).Info("OAuth tokens issued")
+	w.Header().Set("Cache-Control", "no-store")
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/oauth/token", response, user.Username)
+}
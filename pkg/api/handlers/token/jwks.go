@@ -0,0 +1,20 @@
+package token
+
+import (
+	"GolandRestApi/pkg/service"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// JWKS publishes the public keys used to verify the tokens issued by the API, as a JSON Web Key Set.
+// Other services can fetch it from /.well-known/jwks.json and verify tokens without sharing any secret.
+// HS256 keys are never published, so the set is empty when the API signs with a shared secret.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// keys: The Keyring holding the verification keys.
+// w: The HTTP response writer to send the response.
+// r: The HTTP request to process.
+func JWKS(logger *logrus.Logger, keys *service.Keyring, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/.well-known/jwks.json", keys.JWKS(), "")
+}
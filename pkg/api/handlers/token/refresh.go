@@ -5,27 +5,47 @@ import (
 	"GolandRestApi/pkg/repository"
 	"GolandRestApi/pkg/service"
 	"GolandRestApi/pkg/utils"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"time"
 )
 
 // Refresh handles the refresh of access tokens for an authenticated user.
-// It receives a refresh token in the request, verifies its authenticity and validity,
-// and generates a new access token and refresh token pair if the provided token is valid.
+// It receives an opaque refresh token in the request, finds its session and compares the keyed hash of the
+// token with the stored one in constant time, and generates a new access token and refresh token pair if the
+// provided token is the current token of an active session.
+// Refresh tokens are single use: the new refresh token replaces the used one in its session. If a refresh token
+// that was already rotated is presented again, the whole session is revoked and a security event is logged, so
+// both the attacker and the legitimate client have to log in again on that device. The refresh tokens issued to
+// OAuth clients are rejected, so they cannot be exchanged for the access tokens of the API, and so are the tokens
+// of disabled users. The sessions of a deleted user are removed with it.
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the SQL database connection.
-// cfg: A pointer to the config.Config struct which contains JWT configuration.
+// users: The UserStore used to look up the user of the session.
+// tokens: The TokenStore holding the sessions and the hashes of their refresh tokens.
+// denylist: The DenylistStore in which the new access token is recorded.
+// rbac: The RBACStore used to embed the current roles and permissions of the user in the new access token.
+// keys: The Keyring holding the key used to sign the access token.
+// cfg: A pointer to the config.Config struct which contains JWT configuration and the refresh token hash key.
 // w: The HTTP response writer to send the response.
 // r: The HTTP request to process.
 //
-// If the refresh token is valid and matches the one stored in the database for the user,
+// If the refresh token is the current token of its session,
 // it returns a new access token and refresh token in the response along with a status code 200 (OK).
 // If any errors occur during token verification, generation, or storage, it returns an appropriate
-// HTTP error response with the corresponding status code and error message.
-func Refresh(logger *logrus.Logger, db *sql.DB, cfg *config.Config, w http.ResponseWriter, r *http.Request) {
+// HTTP error response with the corresponding status code and error message. With EMAIL_VERIFICATION_MODE set to
+// block, it responds with 403 if the email address of the user is not verified, e.g. after the user changed it.
+func Refresh(logger *logrus.Logger,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	rbac repository.RBACStore,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	var refreshDetails struct {
@@ -45,47 +65,79 @@ func Refresh(logger *logrus.Logger, db *sql.DB, cfg *config.Config, w http.Respo
 		return
 	}
 
-	userName, _, err := service.ExtractClaimsFromToken(logger, refreshDetails.RefreshToken)
+	sessionId, err := service.ParseRefreshToken(refreshDetails.RefreshToken)
 	if err != nil {
-		http.Error(w, "Invalid token", http.StatusBadRequest)
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusBadRequest,
 			"/refresh",
 			"Invalid token",
 			err,
+			utils.LogTypeWarn,
+			"not able to get the username")
+		return
+	}
+
+	session, err := tokens.GetSession(sessionId)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/refresh",
+			"Server error retrieving the session from DB",
+			err,
 			utils.LogTypeError,
 			"not able to get the username")
 		return
 	}
 
-	err = service.VerifyToken(logger, cfg, userName, refreshDetails.RefreshToken)
-	if err != nil {
+	// The token must belong to an active session, not to a session of an OAuth client: those are refreshed at the
+	// token endpoint and limited to their scope
+	if err != nil || !session.Active(time.Now()) || session.ClientID != "" {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusBadRequest,
 			"/refresh",
 			"Invalid token",
 			err,
-			utils.LogTypeError,
-			userName)
+			utils.LogTypeWarn,
+			"not able to get the username")
 		return
 	}
 
-	dbRefreshToken, err := repository.RetrieveRefreshTokenFromDB(logger, db, userName)
+	userName, err := users.GetUserNameByUserId(session.UserID)
 	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
-			http.StatusInternalServerError,
+			http.StatusBadRequest,
 			"/refresh",
-			"Server error retrieving refreshToken from DB",
+			"Invalid token",
 			err,
-			utils.LogTypeError,
-			userName)
+			utils.LogTypeWarn,
+			"not able to get the username")
 		return
 	}
 
-	if dbRefreshToken != refreshDetails.RefreshToken {
+	tokenHash := service.HashRefreshToken(cfg, refreshDetails.RefreshToken)
+	if !service.RefreshTokenMatches(tokenHash, session.TokenHash) {
+		// A token of the session that is not its current token was already rotated: it was stolen, or the
+		// legitimate client is replaying it. Either way the whole session is revoked. Any other value is only
+		// rejected, so knowing a session ID is not enough to revoke the session.
+		rotated, err := tokens.IsRotatedRefreshToken(session.ID, tokenHash)
+		if err != nil {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusInternalServerError,
+				"/refresh",
+				"Server error retrieving the session from DB",
+				err,
+				utils.LogTypeError,
+				userName)
+			return
+		}
+		if rotated {
+			service.RevokeReusedSession(logger, tokens, denylist, session, userName, r)
+		}
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusBadRequest,
@@ -97,8 +149,45 @@ func Refresh(logger *logrus.Logger, db *sql.DB, cfg *config.Config, w http.Respo
 		return
 	}
 
-	var newAccessToken, newRefreshToken string
-	newAccessToken, newRefreshToken, err = service.HandleTokensCreation(logger, db, cfg, userName)
+	user, err := users.GetUserByUserName(userName)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/refresh",
+			"Invalid token",
+			err,
+			utils.LogTypeWarn,
+			userName)
+		return
+	}
+
+	if !user.Active() {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/refresh",
+			"Account disabled, contact an administrator",
+			nil,
+			utils.LogTypeInfo,
+			userName)
+		return
+	}
+
+	if cfg.EmailVerificationMode == utils.EmailVerificationBlock && !user.EmailVerified {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/refresh",
+			"Email address not verified, follow the link sent to it or ask for a new one",
+			nil,
+			utils.LogTypeInfo,
+			userName)
+		return
+	}
+
+	var tokenPair *service.TokenPair
+	tokenPair, err = service.HandleTokensCreation(logger, cfg, keys, rbac, user, session.ID)
 	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
@@ -111,10 +200,23 @@ func Refresh(logger *logrus.Logger, db *sql.DB, cfg *config.Config, w http.Respo
 		return
 	}
 
-	var result bool
-	result, err = repository.StoreRefreshTokenInDB(logger, db, newRefreshToken, userName)
+	err = tokens.RotateRefreshToken(session.ID, tokenHash, tokenPair.RefreshTokenHash, tokenPair.RefreshExpiresAt)
 	if err != nil {
-		http.Error(w, "Server error storing refreshToken", http.StatusInternalServerError)
+		if errors.Is(err, repository.ErrConflict) {
+			// Another request rotated the same token in the meantime
+			service.RevokeReusedSession(logger, tokens, denylist, session, userName, r)
+		}
+		if errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrNotFound) {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusBadRequest,
+				"/refresh",
+				"Invalid token",
+				err,
+				utils.LogTypeWarn,
+				userName)
+			return
+		}
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
@@ -124,14 +226,17 @@ func Refresh(logger *logrus.Logger, db *sql.DB, cfg *config.Config, w http.Respo
 			utils.LogTypeError,
 			userName)
 		return
-	} else if !result {
+	}
+
+	err = denylist.RecordAccessToken(tokenPair.AccessTokenRecord(user.ID))
+	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
 			"/refresh",
-			"Server failed to storing refreshToken",
-			nil,
-			utils.LogTypeWarn,
+			"Server error recording the access token",
+			err,
+			utils.LogTypeError,
 			userName)
 		return
 	}
@@ -140,8 +245,8 @@ func Refresh(logger *logrus.Logger, db *sql.DB, cfg *config.Config, w http.Respo
 		AccessToken  string `json:"access_token"`
 		RefreshToken string `json:"refresh_token"`
 	}{
-		AccessToken:  newAccessToken,
-		RefreshToken: newRefreshToken,
+		AccessToken:  tokenPair.AccessToken,
+		RefreshToken: tokenPair.RefreshToken,
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,59 @@
+package token
+
+import (
+	"GolandRestApi/pkg/api/handlers/handlertest"
+	"net/http"
+	"testing"
+)
+
+// newRefreshServer returns a test server serving the refresh route.
+func newRefreshServer(t *testing.T) *handlertest.Server {
+	t.Helper()
+	s := handlertest.NewServer(t)
+	s.Access.Public(s.Router.HandleFunc("/refresh", func(w http.ResponseWriter, r *http.Request) {
+		Refresh(s.Logger, s.Store, s.Store, s.Store, s.Store, s.Keys, s.Config, w, r)
+	}).Methods("POST"))
+	return s
+}
+
+// tokens holds the tokens written by the refresh handler.
+type tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refresh presents the refresh token and returns the status code and the tokens of the response.
+func refresh(t *testing.T, s *handlertest.Server, refreshToken string) (int, tokens) {
+	t.Helper()
+	w := s.Serve(t, "POST", "/refresh", "", map[string]string{"refreshToken": refreshToken})
+	var response tokens
+	if w.Code == http.StatusOK {
+		handlertest.Decode(t, w, &response)
+	}
+	return w.Code, response
+}
+
+func TestRefreshTokenReuseDeniesTheAccessTokensOfTheSession(t *testing.T) {
+	s := newRefreshServer(t)
+	first := s.Login(t, "admin")
+
+	code, second := refresh(t, s, first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("first refresh = %d, want 200", code)
+	}
+	if code = s.Do(t, "GET", "/me", second.AccessToken); code != http.StatusOK {
+		t.Fatalf("access token of the refresh = %d, want 200", code)
+	}
+
+	if code, _ = refresh(t, s, first.RefreshToken); code != http.StatusBadRequest {
+		t.Fatalf("refresh with the rotated token = %d, want 400", code)
+	}
+	for name, token := range map[string]string{"login": first.AccessToken, "refresh": second.AccessToken} {
+		if code = s.Do(t, "GET", "/me", token); code != http.StatusUnauthorized {
+			t.Errorf("access token of the %s after the reuse = %d, want 401", name, code)
+		}
+	}
+	if code, _ = refresh(t, s, second.RefreshToken); code != http.StatusBadRequest {
+		t.Errorf("refresh with the current token after the reuse = %d, want 400", code)
+	}
+}
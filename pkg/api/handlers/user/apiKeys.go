@@ -0,0 +1,121 @@
+package user
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// CreateAPIKey handles the creation of an API key by the authenticated user, for a machine-to-machine client such
+// as a service account. The key is returned once in the response: only its hash is stored. It can only grant
+// permissions the user holds, and stops granting those the user loses.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// apiKeys: The APIKeyStore in which the key is stored.
+// rbac: The RBACStore used to resolve the permissions of the user.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the key in JSON format, e.g. {"name": "deploy", "permissions": ["user:read"],
+// "expires_at": "2030-01-01T00:00:00Z"}, authenticated with an access token. expires_at is optional.
+//
+// Responds with 201 and the key, with the key itself in its key field, or 400 if a field is invalid.
+func CreateAPIKey(logger *logrus.Logger,
+	apiKeys repository.APIKeyStore,
+	rbac repository.RBACStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	claims, userId, ok := requestOwner(logger, w, r, "/user/api-keys")
+	if !ok {
+		return
+	}
+
+	var request model.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/api-keys",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	permissions, err := rbac.GetUserPermissionsByUserId(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			"/user/api-keys",
+			"Error retrieving the permissions",
+			err,
+			claims.Username)
+		return
+	}
+	if fields := service.ValidateAPIKeyRequest(request, permissions, time.Now()); len(fields) > 0 {
+		service.HttpValidationErrorResponse(logger, w, "/user/api-keys", "Invalid API key", fields, claims.Username)
+		return
+	}
+
+	apiKey, key, err := service.IssueAPIKey(logger, apiKeys, userId, request)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/api-keys", "Error creating the API key", err, claims.Username)
+		return
+	}
+
+	logger.WithField("username", claims.Username).Info("API key created")
+	service.HttpJSONResponse(logger, w, http.StatusCreated, "/user/api-keys", struct {
+		*model.APIKey
+		Key string `json:"key"`
+	}{APIKey: apiKey, Key: key}, claims.Username)
+}
+
+// ListAPIKeys handles the listing of the API keys of the authenticated user. The keys themselves are never listed.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// apiKeys: The APIKeyStore holding the keys.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token.
+//
+// Responds with 200 and a JSON array of keys, oldest first, including the expired ones.
+func ListAPIKeys(logger *logrus.Logger, apiKeys repository.APIKeyStore, w http.ResponseWriter, r *http.Request) {
+	claims, userId, ok := requestOwner(logger, w, r, "/user/api-keys")
+	if !ok {
+		return
+	}
+
+	keys, err := apiKeys.ListAPIKeys(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/api-keys", "Error listing the API keys", err, claims.Username)
+		return
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/api-keys", keys, claims.Username)
+}
+
+// DeleteAPIKey handles the revocation of an API key of the authenticated user. The key is rejected from the next
+// request on.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// apiKeys: The APIKeyStore holding the keys.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the key ID as a path variable, authenticated with an access token.
+//
+// Responds with 200 on success and 404 if the user has no such key.
+func DeleteAPIKey(logger *logrus.Logger, apiKeys repository.APIKeyStore, w http.ResponseWriter, r *http.Request) {
+	claims, userId, ok := requestOwner(logger, w, r, "/user/api-keys")
+	if !ok {
+		return
+	}
+
+	if err := apiKeys.DeleteAPIKey(userId, mux.Vars(r)["keyId"]); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/api-keys", "Error revoking the API key", err, claims.Username)
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/user/api-keys", "API key revoked", claims.Username)
+}
@@ -0,0 +1,678 @@
+package user
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxUsernameLength is the length of the username column
+	maxUsernameLength = 255
+
+	// federatedLoginFailedMessage is the response to every rejected login through an upstream provider, whether
+	// the provider refused it or the account is locked.
+	federatedLoginFailedMessage = "Login refused by the identity provider, or the account is temporarily locked"
+)
+
+// FederatedLogin handles the start of a login through an upstream identity provider. A single-use state is
+// stored, valid for FEDERATION_STATE_VALIDITY, with the nonce the ID token must carry and a PKCE code verifier,
+// and the user is redirected to the authorization endpoint of the provider, which sends it back to
+// /user/login/{provider}/callback.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// federation: The FederationStore in which the state is stored.
+// providers: The IdentityProviders registry.
+// cfg: A pointer to the config.Config struct which contains the federation configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the name of the provider as a path variable.
+//
+// Responds with 302 to the provider, 404 if no such provider is configured, or 502 if the provider cannot be
+// reached.
+func FederatedLogin(logger *logrus.Logger,
+	federation repository.FederationStore,
+	providers *service.IdentityProviders,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+
+	provider, ok := lookupIdentityProvider(logger, providers, w, r, "/user/login/federated", "")
+	if !ok {
+		return
+	}
+	authorizationURL, ok := newFederatedLoginState(logger, federation, provider, cfg, w, r, 0,
+		"/user/login/federated", "")
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, authorizationURL, http.StatusFound)
+}
+
+// FederatedCallback handles the return of the user from an upstream identity provider. The state is used up, the
+// code exchanged for the ID token of the user, whose signature, issuer, audience and nonce are checked, and:
+//   - if the login was started by StartIdentityLink, the identity is linked to the user who started it;
+//   - if the identity is linked to a user, a new session is started for it with an access token and a refresh
+//     token, like a login with a password;
+//   - otherwise, if FEDERATION_AUTO_PROVISION is set, a user is created with FEDERATION_DEFAULT_ROLE and a random
+//     password, the identity linked to it and a session started. Its username is the preferred_username of the
+//     provider, or the local part of its email address, or the name of the provider followed by a hash of the
+//     subject, the first one not taken. Its email address is verified if the provider says so; if not, a
+//     verification is sent like on registration. No user is provisioned for an email address already used by an
+//     account: its owner must log in and link the identity.
+//
+// As the provider authenticated the user, no TOTP code is asked. A locked account is rejected even so.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user accounts.
+// tokens: The TokenStore in which the session started by the login is stored.
+// denylist: The DenylistStore in which the access token is recorded, so logging out can deny it.
+// rbac: The RBACStore used to embed the roles and permissions of the user in the access token.
+// lockouts: The LockoutStore holding the failed login counters.
+// federation: The FederationStore holding the states and the identities.
+// providers: The IdentityProviders registry.
+// keys: The Keyring holding the key used to sign the tokens.
+// notifier: The Notifier delivering the email verification of a provisioned user.
+// cfg: A pointer to the config.Config struct which contains JWT and federation configuration details.
+// w: The http.ResponseWriter to write the HTTP response.
+// r: The http.Request containing the name of the provider as a path variable and the code and state query or
+// form parameters sent by the provider, or its error parameter; an optional device parameter labels the session.
+//
+// Responds with a JSON object containing the access token and refresh token, 201 and the identity for a link, 400
+// if the state is invalid or expired, 401 if the provider refused the login or the account is locked, 403 if the
+// identity is not linked and provisioning is disabled, 409 if the identity or the email address is already used
+// by another account, and 502 if the provider cannot be reached. With EMAIL_VERIFICATION_MODE set to block, it
+// responds with 403 to a user whose email address is not verified.
+func FederatedCallback(logger *logrus.Logger,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	rbac repository.RBACStore,
+	lockouts repository.LockoutStore,
+	federation repository.FederationStore,
+	providers *service.IdentityProviders,
+	keys *service.Keyring,
+	notifier service.Notifier,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	provider, ok := lookupIdentityProvider(logger, providers, w, r, "/user/login/federated/callback", "")
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil || len(r.Form.Get("device")) > maxDeviceLabelLength {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/login/federated/callback",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	state, err := federation.ConsumeFederatedLoginState(service.HashFederationState(r.Form.Get("state")),
+		provider.Name())
+	if errors.Is(err, repository.ErrNotFound) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/login/federated/callback",
+			"Invalid or expired state, start the login again",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/login/federated/callback",
+			"Server error checking the state",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	if upstreamError := r.Form.Get("error"); upstreamError != "" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/user/login/federated/callback",
+			federatedLoginFailedMessage,
+			errors.New(upstreamError+" "+r.Form.Get("error_description")),
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), r.Form.Get("code"), state.CodeVerifier, state.Nonce)
+	if errors.Is(err, service.ErrUpstreamLogin) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/user/login/federated/callback",
+			federatedLoginFailedMessage,
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadGateway,
+			"/user/login/federated/callback",
+			"The identity provider cannot be reached",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	if state.UserID != 0 {
+		linkIdentity(logger, federation, w, provider.Name(), state.UserID, identity)
+		return
+	}
+
+	user, ok := federatedUser(logger, users, federation, keys, notifier, cfg, w, provider.Name(), identity)
+	if !ok {
+		return
+	}
+
+	if user.Locked(time.Now()) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			"/user/login/federated/callback",
+			federatedLoginFailedMessage,
+			nil,
+			utils.LogTypeWarn,
+			user.Username)
+		return
+	}
+	if !accountUsable(logger, w, user, false, "/user/login/federated/callback") {
+		return
+	}
+	if cfg.EmailVerificationMode == utils.EmailVerificationBlock && !user.EmailVerified {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/user/login/federated/callback",
+			"Email address not verified, follow the link sent to it or ask for a new one",
+			nil,
+			utils.LogTypeInfo,
+			user.Username)
+		return
+	}
+
+	if !clearLoginFailures(logger, lockouts, w, user, "/user/login/federated/callback") {
+		return
+	}
+	if startSession(logger, tokens, denylist, rbac, keys, cfg, w, r, user, r.Form.Get("device"),
+		"/user/login/federated/callback") {
+		logger.WithFields(logrus.Fields{
+			"username": user.Username,
+			"provider": provider.Name(),
+		}).Info("User logged in through an identity provider")
+	}
+}
+
+// StartIdentityLink handles the start of the linking of an upstream identity to the account of the authenticated
+// user. Like FederatedLogin, a single-use state is stored, bound to the user; the identity is linked by
+// /user/login/{provider}/callback once the user logged in at the provider.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// federation: The FederationStore in which the state is stored.
+// providers: The IdentityProviders registry.
+// cfg: A pointer to the config.Config struct which contains the federation configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the name of the provider as a path variable, authenticated with an access token.
+//
+// Responds with 200 and {"redirect_to": "<URL of the provider>"}, 404 if no such provider is configured, or 502
+// if the provider cannot be reached.
+func StartIdentityLink(logger *logrus.Logger,
+	federation repository.FederationStore,
+	providers *service.IdentityProviders,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/identities")
+	if !ok {
+		return
+	}
+	provider, ok := lookupIdentityProvider(logger, providers, w, r, "/user/identities", claims.Username)
+	if !ok {
+		return
+	}
+	authorizationURL, ok := newFederatedLoginState(logger, federation, provider, cfg, w, r, userId,
+		"/user/identities", claims.Username)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/identities",
+		map[string]string{"redirect_to": authorizationURL}, claims.Username)
+}
+
+// ListIdentities handles the listing of the upstream identities linked to the account of the authenticated user.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// federation: The FederationStore holding the identities.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token.
+//
+// Responds with 200 and a JSON array of identities, ordered by provider.
+func ListIdentities(logger *logrus.Logger, federation repository.FederationStore, w http.ResponseWriter, r *http.Request) {
+	claims, userId, ok := requestOwner(logger, w, r, "/user/identities")
+	if !ok {
+		return
+	}
+
+	identities, err := federation.ListExternalIdentities(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/identities", "Error listing the identities", err,
+			claims.Username)
+		return
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/identities", identities, claims.Username)
+}
+
+// UnlinkIdentity handles the unlinking of an upstream identity from the account of the authenticated user. The
+// identity cannot be used to log in anymore; the sessions it started are left untouched. A provisioned user has a
+// random password: it can set one with /user/password/forgot before unlinking its last identity.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// federation: The FederationStore holding the identities.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the name of the provider as a path variable, authenticated with an access token.
+//
+// Responds with 200 on success and 404 if the user has no identity at the provider.
+func UnlinkIdentity(logger *logrus.Logger, federation repository.FederationStore, w http.ResponseWriter, r *http.Request) {
+	claims, userId, ok := requestOwner(logger, w, r, "/user/identities")
+	if !ok {
+		return
+	}
+
+	if err := federation.DeleteExternalIdentity(userId, mux.Vars(r)["provider"]); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/identities", "Error unlinking the identity",
+			err, claims.Username)
+		return
+	}
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/user/identities", "Identity unlinked",
+		claims.Username)
+}
+
+// lookupIdentityProvider finds the provider named by the provider path variable of the request.
+//
+// Returns the provider and true, or false if a 404 response was written.
+func lookupIdentityProvider(logger *logrus.Logger,
+	providers *service.IdentityProviders,
+	w http.ResponseWriter,
+	r *http.Request,
+	endpoint string,
+	username string) (service.IdentityProvider, bool) {
+	provider, ok := providers.Lookup(mux.Vars(r)["provider"])
+	if !ok {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			endpoint,
+			"Identity provider not found",
+			nil,
+			utils.LogTypeInfo,
+			username)
+		return nil, false
+	}
+	return provider, true
+}
+
+// newFederatedLoginState generates and stores the state of a login through an upstream provider, with its nonce
+// and PKCE code verifier, and builds the URL of the provider the user is sent to.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// federation: The FederationStore in which the state is stored.
+// provider: The provider.
+// cfg: A pointer to the config.Config struct which contains the federation configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request starting the login.
+// userId: The ID of the user linking an identity, or 0 for a login.
+// endpoint: The endpoint starting the login.
+// username: The username of the request, possibly empty.
+//
+// Returns the authorization URL and true, or false if an error response was written.
+func newFederatedLoginState(logger *logrus.Logger,
+	federation repository.FederationStore,
+	provider service.IdentityProvider,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request,
+	userId int,
+	endpoint string,
+	username string) (string, bool) {
+	state, stateHash, err := service.NewFederationState()
+	var nonce, verifier, challenge string
+	if err == nil {
+		nonce, err = service.NewFederationNonce()
+	}
+	if err == nil {
+		verifier, challenge, err = service.NewPKCEVerifier()
+	}
+	var expiresAt time.Time
+	if err == nil {
+		expiresAt, err = service.FederationStateExpiry(cfg)
+	}
+	if err == nil {
+		err = federation.CreateFederatedLoginState(model.FederatedLoginState{
+			StateHash:    stateHash,
+			Provider:     provider.Name(),
+			UserID:       userId,
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			ExpiresAt:    expiresAt,
+		})
+	}
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, endpoint, "Error generating the state", err, username)
+		return "", false
+	}
+
+	authorizationURL, err := provider.AuthorizationURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadGateway,
+			endpoint,
+			"The identity provider cannot be reached",
+			err,
+			utils.LogTypeError,
+			username)
+		return "", false
+	}
+	return authorizationURL, true
+}
+
+// linkIdentity links an upstream identity to the user who started the link, and responds with 201 and the
+// identity, 404 if the user no longer exists, or 409 if the identity is linked to an account or the user already
+// has one at the provider.
+func linkIdentity(logger *logrus.Logger,
+	federation repository.FederationStore,
+	w http.ResponseWriter,
+	providerName string,
+	userId int,
+	identity *service.UpstreamIdentity) {
+	linked := model.ExternalIdentity{
+		Provider:  providerName,
+		Subject:   identity.Subject,
+		UserID:    userId,
+		Email:     identity.Email,
+		CreatedAt: time.Now(),
+	}
+	if err := federation.CreateExternalIdentity(linked); err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/login/federated/callback", "Error linking the identity",
+			err, "")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"userId":   userId,
+		"provider": providerName,
+	}).Info("Identity linked")
+	service.HttpJSONResponse(logger, w, http.StatusCreated, "/user/login/federated/callback", linked, "")
+}
+
+// federatedUser finds the user an upstream identity is linked to, or provisions one if FEDERATION_AUTO_PROVISION
+// is set.
+//
+// Returns the user and true, or false if an error response was written.
+func federatedUser(logger *logrus.Logger,
+	users repository.UserStore,
+	federation repository.FederationStore,
+	keys *service.Keyring,
+	notifier service.Notifier,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	providerName string,
+	identity *service.UpstreamIdentity) (*model.User, bool) {
+
+	linked, err := federation.GetExternalIdentity(providerName, identity.Subject)
+	if err == nil {
+		user, err := users.GetUserById(linked.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The identity is kept with its deleted user, which an administrator can restore
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusForbidden,
+				"/user/login/federated/callback",
+				"Account deleted, contact an administrator",
+				nil,
+				utils.LogTypeInfo,
+				"")
+			return nil, false
+		} else if err != nil {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusInternalServerError,
+				"/user/login/federated/callback",
+				"Server error retrieving the user",
+				err,
+				utils.LogTypeError,
+				"")
+			return nil, false
+		}
+		return user, true
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/login/federated/callback",
+			"Server error retrieving the identity",
+			err,
+			utils.LogTypeError,
+			"")
+		return nil, false
+	}
+
+	if !cfg.FederationAutoProvision {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/user/login/federated/callback",
+			"No account is linked to this identity, log in and link it first",
+			nil,
+			utils.LogTypeInfo,
+			"")
+		return nil, false
+	}
+	return provisionUser(logger, users, federation, keys, notifier, cfg, w, providerName, identity)
+}
+
+// provisionUser creates the user of an upstream identity that is not linked to any account, with
+// FEDERATION_DEFAULT_ROLE, and links the identity to it.
+//
+// Returns the user and true, or false if an error response was written.
+func provisionUser(logger *logrus.Logger,
+	users repository.UserStore,
+	federation repository.FederationStore,
+	keys *service.Keyring,
+	notifier service.Notifier,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	providerName string,
+	identity *service.UpstreamIdentity) (*model.User, bool) {
+
+	if identity.Email != "" {
+		// The address of a deleted user stays in use until it is purged
+		exists, err := users.UserExists("", identity.Email)
+		if err == nil && exists {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusConflict,
+				"/user/login/federated/callback",
+				"An account already uses this email address, log in and link the identity to it",
+				nil,
+				utils.LogTypeInfo,
+				"")
+			return nil, false
+		} else if err != nil {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusInternalServerError,
+				"/user/login/federated/callback",
+				"Server error checking the email address",
+				err,
+				utils.LogTypeError,
+				"")
+			return nil, false
+		}
+	}
+
+	username, err := availableUsername(users, providerName, identity)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/login/federated/callback",
+			"Server error checking the username",
+			err,
+			utils.LogTypeError,
+			"")
+		return nil, false
+	} else if username == "" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusConflict,
+			"/user/login/federated/callback",
+			"No username is available for this identity",
+			nil,
+			utils.LogTypeWarn,
+			"")
+		return nil, false
+	}
+
+	// The password is never told to anyone: the user logs in through the provider, or sets one with
+	// /user/password/forgot
+	random := make([]byte, 32)
+	if _, err = rand.Read(random); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/login/federated/callback",
+			"Error generating the password",
+			err,
+			utils.LogTypeError,
+			username)
+		return nil, false
+	}
+	newUser := model.NewUser(username, base64.RawURLEncoding.EncodeToString(random), "", identity.Email, "", "")
+	newUser.HashedPassword, err = service.HashPassword(logger, *newUser)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/login/federated/callback",
+			"Error hashing password",
+			err,
+			utils.LogTypeError,
+			username)
+		return nil, false
+	}
+	newUser.EmailVerified = identity.Email != "" && identity.EmailVerified
+
+	if err = users.AddUser(*newUser, cfg.FederationDefaultRole); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/login/federated/callback",
+			"Error adding user",
+			err,
+			utils.LogTypeError,
+			username)
+		return nil, false
+	}
+	user, err := users.GetUserByUserName(username)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/login/federated/callback",
+			"Server error retrieving the user",
+			err,
+			utils.LogTypeError,
+			username)
+		return nil, false
+	}
+
+	err = federation.CreateExternalIdentity(model.ExternalIdentity{
+		Provider:  providerName,
+		Subject:   identity.Subject,
+		UserID:    user.ID,
+		Email:     identity.Email,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		// A concurrent callback linked the identity first: the user created here is not needed
+		if deleteErr := users.DeleteUser(user.ID); deleteErr != nil {
+			logger.WithError(deleteErr).WithField("username", username).Error("Error removing the unlinked user")
+		}
+		service.HttpRepositoryErrorResponse(logger, w, "/user/login/federated/callback", "Error linking the identity",
+			err, username)
+		return nil, false
+	}
+
+	if user.Email != "" && !user.EmailVerified {
+		// The verification is sent on a best-effort basis, errors are logged by SendEmailVerification
+		_ = service.SendEmailVerification(logger, cfg, keys, notifier, user)
+	}
+	logger.WithFields(logrus.Fields{
+		"username": username,
+		"provider": providerName,
+	}).Info("User provisioned from an identity provider")
+	return user, true
+}
+
+// availableUsername picks the username of a provisioned user: the preferred_username of the provider, the local
+// part of its email address, or the name of the provider followed by a hash of the subject, the first one not
+// taken, even by a deleted user.
+//
+// Returns the username, empty if all of them are taken, or an error returned by the store.
+func availableUsername(users repository.UserStore, providerName string, identity *service.UpstreamIdentity) (string, error) {
+	sum := sha256.Sum256([]byte(identity.Subject))
+	candidates := []string{
+		identity.PreferredUsername,
+		strings.SplitN(identity.Email, "@", 2)[0],
+		providerName + "-" + hex.EncodeToString(sum[:6]),
+	}
+	for _, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "" || len(candidate) > maxUsernameLength {
+			continue
+		}
+		exists, err := users.UserExists(candidate, "")
+		if err != nil {
+			return "", err
+		} else if !exists {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,234 @@
+package user
+
+import (
+	"GolandRestApi/pkg/api/handlers/handlertest"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/service/oidctest"
+	"GolandRestApi/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// recordingNotifier keeps the notifications instead of delivering them.
+type recordingNotifier struct {
+	mu            sync.Mutex
+	notifications []service.Notification
+}
+
+func (n *recordingNotifier) Notify(notification service.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+// federationServer is a test server also serving the routes of the logins through the mock issuer, named corp.
+type federationServer struct {
+	*handlertest.Server
+	issuer   *oidctest.Issuer
+	notifier *recordingNotifier
+}
+
+func newFederationServer(t *testing.T, autoProvision bool) *federationServer {
+	t.Helper()
+	issuer, err := oidctest.New("api", "client secret")
+	if err != nil {
+		t.Fatalf("oidctest.New: %v", err)
+	}
+	t.Cleanup(issuer.Close)
+	issuer.Subject = "248289761001"
+	issuer.Email = "jane@example.com"
+	issuer.EmailVerified = true
+	issuer.PreferredUsername = "jane"
+
+	s := &federationServer{Server: handlertest.NewServer(t), issuer: issuer, notifier: &recordingNotifier{}}
+	s.Config.FederationProviders = append(s.Config.FederationProviders,
+		issuer.Provider("corp", "http://localhost:8080/login/corp/callback"))
+	s.Config.FederationAutoProvision = autoProvision
+	s.Config.FederationDefaultRole = utils.UserRole
+	s.Config.FederationStateValidity = "10m"
+	s.Config.EmailVerificationTokenValidity = "24h"
+	providers, err := service.NewIdentityProviders(s.Logger, s.Config)
+	if err != nil {
+		t.Fatalf("NewIdentityProviders: %v", err)
+	}
+
+	s.Access.Public(s.Router.HandleFunc("/login/{provider}", func(w http.ResponseWriter, r *http.Request) {
+		FederatedLogin(s.Logger, s.Store, providers, s.Config, w, r)
+	}).Methods("GET"))
+	s.Access.Public(s.Router.HandleFunc("/login/{provider}/callback", func(w http.ResponseWriter, r *http.Request) {
+		FederatedCallback(s.Logger, s.Store, s.Store, s.Store, s.Store, s.Store, s.Store, providers, s.Keys, s.notifier,
+			s.Config, w, r)
+	}).Methods("GET", "POST"))
+	s.Access.Require(s.Router.HandleFunc("/identities/{provider}", func(w http.ResponseWriter, r *http.Request) {
+		StartIdentityLink(s.Logger, s.Store, providers, s.Config, w, r)
+	}).Methods("POST"))
+	return s
+}
+
+// authorize follows the authorization URL to the issuer and returns the query of the callback it redirects to.
+func (s *federationServer) authorize(t *testing.T, authorizationURL string) url.Values {
+	t.Helper()
+	code, state, err := s.issuer.Authorize(authorizationURL)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	return url.Values{"code": {code}, "state": {state}}
+}
+
+// startLogin starts a login through the issuer and returns the query of its callback.
+func (s *federationServer) startLogin(t *testing.T) url.Values {
+	t.Helper()
+	w := s.Serve(t, "GET", "/login/corp", "", nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("GET /login/corp = %d, want 302", w.Code)
+	}
+	return s.authorize(t, w.Header().Get("Location"))
+}
+
+// callback sends the query to the callback and returns the response.
+func (s *federationServer) callback(t *testing.T, query url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	return s.Serve(t, "GET", "/login/corp/callback?"+query.Encode(), "", nil)
+}
+
+// loggedIn decodes the response of a successful login and returns the claims of its access token.
+func (s *federationServer) loggedIn(t *testing.T, w *httptest.ResponseRecorder) *service.Claims {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback = %d %s, want 200", w.Code, w.Body)
+	}
+	var response struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	handlertest.Decode(t, w, &response)
+	if response.RefreshToken == "" {
+		t.Error("login response without a refresh token")
+	}
+	claims, err := service.VerifyToken(s.Logger, s.Config, s.Keys, response.AccessToken, utils.AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	return claims
+}
+
+func TestFederatedLoginProvisionsUser(t *testing.T) {
+	s := newFederationServer(t, true)
+
+	claims := s.loggedIn(t, s.callback(t, s.startLogin(t)))
+	if claims.Username != "jane" || len(claims.Roles) != 1 || claims.Roles[0] != utils.UserRole {
+		t.Errorf("provisioned user = %s %v, want jane with the role %s", claims.Username, claims.Roles, utils.UserRole)
+	}
+	user, err := s.Store.GetUserByUserName("jane")
+	if err != nil {
+		t.Fatalf("GetUserByUserName: %v", err)
+	}
+	if user.Email != "jane@example.com" || !user.EmailVerified {
+		t.Errorf("provisioned user = %s %t, want the verified address of the provider", user.Email, user.EmailVerified)
+	}
+	if len(s.notifier.notifications) != 0 {
+		t.Errorf("notifications = %v, want no verification of an address verified by the provider",
+			s.notifier.notifications)
+	}
+
+	// The next login finds the linked user instead of provisioning another one
+	if again := s.loggedIn(t, s.callback(t, s.startLogin(t))); again.Subject != claims.Subject {
+		t.Errorf("user of the second login = %s, want %s", again.Subject, claims.Subject)
+	}
+	identities, err := s.Store.ListExternalIdentities(user.ID)
+	if err != nil || len(identities) != 1 || identities[0].Provider != "corp" || identities[0].Subject != "248289761001" {
+		t.Errorf("identities = %+v, %v; want the identity at corp", identities, err)
+	}
+}
+
+func TestFederatedLoginProvisionsUnverifiedUser(t *testing.T) {
+	s := newFederationServer(t, true)
+	s.issuer.EmailVerified = false
+	s.issuer.PreferredUsername = "admin"
+
+	// The preferred username is taken, so the local part of the address is used
+	if claims := s.loggedIn(t, s.callback(t, s.startLogin(t))); claims.Username != "jane" {
+		t.Errorf("provisioned user = %s, want jane", claims.Username)
+	}
+	user, err := s.Store.GetUserByUserName("jane")
+	if err != nil {
+		t.Fatalf("GetUserByUserName: %v", err)
+	}
+	if user.EmailVerified {
+		t.Error("address not verified by the provider marked as verified")
+	}
+	if len(s.notifier.notifications) != 1 || s.notifier.notifications[0].To != "jane@example.com" {
+		t.Errorf("notifications = %v, want a verification sent to jane@example.com", s.notifier.notifications)
+	}
+}
+
+func TestFederatedLoginDoesNotProvision(t *testing.T) {
+	s := newFederationServer(t, false)
+	if w := s.callback(t, s.startLogin(t)); w.Code != http.StatusForbidden {
+		t.Errorf("callback of an identity not linked = %d, want 403", w.Code)
+	}
+
+	// The owner of an account must log in and link the identity, which cannot be provisioned for its address
+	s.Config.FederationAutoProvision = true
+	s.issuer.Email = "admin@example.com"
+	if w := s.callback(t, s.startLogin(t)); w.Code != http.StatusConflict {
+		t.Errorf("callback of an identity with the address of an account = %d, want 409", w.Code)
+	}
+	if exists, err := s.Store.UserExists("jane", ""); err != nil || exists {
+		t.Errorf("UserExists(jane) = %t, %v; want no user provisioned", exists, err)
+	}
+}
+
+func TestIdentityLink(t *testing.T) {
+	s := newFederationServer(t, false)
+	s.issuer.Email = "admin@example.com"
+	accessToken := s.Login(t, "admin").AccessToken
+
+	w := s.Serve(t, "POST", "/identities/corp", accessToken, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /identities/corp = %d, want 200", w.Code)
+	}
+	var link struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+	handlertest.Decode(t, w, &link)
+	if w = s.callback(t, s.authorize(t, link.RedirectTo)); w.Code != http.StatusCreated {
+		t.Fatalf("callback of the link = %d %s, want 201", w.Code, w.Body)
+	}
+
+	if claims := s.loggedIn(t, s.callback(t, s.startLogin(t))); claims.Username != "admin" {
+		t.Errorf("user of the linked identity = %s, want admin", claims.Username)
+	}
+
+	// The identity is linked to one account only
+	w = s.Serve(t, "POST", "/identities/corp", accessToken, nil)
+	handlertest.Decode(t, w, &link)
+	if w = s.callback(t, s.authorize(t, link.RedirectTo)); w.Code != http.StatusConflict {
+		t.Errorf("second link of the identity = %d, want 409", w.Code)
+	}
+}
+
+func TestFederatedCallbackRejectsState(t *testing.T) {
+	s := newFederationServer(t, true)
+	query := s.startLogin(t)
+	s.loggedIn(t, s.callback(t, query))
+
+	if w := s.callback(t, query); w.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback = %d, want 400", w.Code)
+	}
+	query = s.startLogin(t)
+	query.Set("state", "unknown")
+	if w := s.callback(t, query); w.Code != http.StatusBadRequest {
+		t.Errorf("callback with an unknown state = %d, want 400", w.Code)
+	}
+
+	// An ID token replayed from another login does not carry its nonce
+	s.issuer.Nonce = "a nonce of another login"
+	if w := s.callback(t, s.startLogin(t)); w.Code != http.StatusUnauthorized {
+		t.Errorf("callback with an ID token of another login = %d, want 401", w.Code)
+	}
+}
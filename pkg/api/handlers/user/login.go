@@ -10,31 +10,84 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/sirupsen/logrus"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDeviceLabelLength is the maximum length of the device label of a session
+	maxDeviceLabelLength = 255
+
+	// maxUserAgentLength is the length at which the user agent of a session is truncated
+	maxUserAgentLength = 512
+
+	// loginFailedMessage is the response to every rejected login, whether the username is unknown, the password
+	// is wrong or the account is locked, so the response does not tell which accounts exist or are locked.
+	loginFailedMessage = "Invalid username or password, or the account is temporarily locked"
+
+	// mfaFailedMessage is the response to every rejected second factor, whether the code is wrong or the account
+	// is locked.
+	mfaFailedMessage = "Invalid authentication code, or the account is temporarily locked"
 )
 
 // LoginUser handles user authentication by verifying the provided username and password.
-// Upon successful authentication, it generates and returns an access token and a refresh token.
+// Upon successful authentication, it starts a new session for the device and returns an access token and a
+// refresh token bound to it. Sessions started on other devices are left untouched.
+// Failed logins are counted per account and per client IP address, which are locked out according to the
+// lockout policy. A locked account is rejected like a wrong password, even with the right one, and a locked
+// address with 429 and a Retry-After header, whatever the username. The password of an unknown username is
+// compared with a dummy hash, so the response time does not tell whether the username exists either.
+// If the user enabled two-factor authentication, no session is started: the login responds with a short-lived
+// MFA challenge token instead, exchanged with a code at /user/login/mfa. The failed logins of the account are then
+// only cleared once the second factor is checked.
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the sql.DB instance representing the database connection.
+// users: The UserStore used to look up the user.
+// tokens: The TokenStore in which the session started by the login is stored.
+// denylist: The DenylistStore in which the access token is recorded, so logging out can deny it.
+// rbac: The RBACStore used to embed the roles and permissions of the user in the access token.
+// lockouts: The LockoutStore holding the failed login counters.
+// totps: The TOTPStore telling whether the user enabled two-factor authentication.
+// policy: The LoginLockoutPolicy deciding when accounts and addresses are locked.
+// keys: The Keyring holding the key used to sign the tokens.
 // cfg: A pointer to the config.Config struct which contains JWT configuration details.
 // w: The http.ResponseWriter to write the HTTP response.
-// r: The http.Request representing the HTTP request with user login details in JSON format.
+// r: The http.Request representing the HTTP request with user login details in JSON format, e.g.
+// {"username": "admin", "password": "admin", "device": "Work laptop"}; the device label is optional.
 //
-// Responds with a JSON object containing the access token and refresh token upon successful login.
-// If login details are invalid, it returns an error response with an appropriate HTTP status code.
-func LoginUser(logger *logrus.Logger, db *sql.DB, cfg *config.Config, w http.ResponseWriter, r *http.Request) {
+// Responds with a JSON object containing the access token and refresh token upon successful login, or
+// {"mfa_required": true, "mfa_token": "..."} if a second factor is needed.
+// If login details are invalid, it returns an error response with an appropriate HTTP status code. With
+// EMAIL_VERIFICATION_MODE set to block, it responds with 403 to a user whose email address is not verified; the
+// password is checked first, so the response does not tell whether an account is verified. It also responds with
+// 403, after the password check, to a user whose account is disabled or who must reset its password.
+func LoginUser(logger *logrus.Logger,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	rbac repository.RBACStore,
+	lockouts repository.LockoutStore,
+	totps repository.TOTPStore,
+	policy *service.LoginLockoutPolicy,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
+	ip := service.ClientIP(r)
 
 	var loginDetails struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Device   string `json:"device"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&loginDetails)
-	if err != nil {
+	if err != nil || len(loginDetails.Device) > maxDeviceLabelLength {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusBadRequest,
@@ -46,18 +99,17 @@ func LoginUser(logger *logrus.Logger, db *sql.DB, cfg *config.Config, w http.Res
 		return
 	}
 
+	if ipLocked(logger, lockouts, w, ip, loginDetails.Username, "/login") {
+		return
+	}
+
 	var newUser *model.User
-	newUser, err = repository.GetUserByUserName(logger, db, loginDetails.Username)
+	newUser, err = users.GetUserByUserName(loginDetails.Username)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			service.HttpErrorResponse(logger,
-				w,
-				http.StatusUnauthorized,
-				"/login",
-				"Invalid username or password",
-				nil,
-				utils.LogTypeWarn,
-				loginDetails.Username)
+			// Compare the password anyway, so an unknown username answers as slowly as a wrong password
+			service.CheckDummyPasswordHash(loginDetails.Password)
+			loginFailed(logger, lockouts, policy, w, 0, loginDetails.Username, ip)
 			return
 		}
 
@@ -74,62 +126,487 @@ func LoginUser(logger *logrus.Logger, db *sql.DB, cfg *config.Config, w http.Res
 
 	newUser.Username = loginDetails.Username
 	err = service.CheckPasswordHash(logger, newUser, loginDetails.Password)
+	if newUser.Locked(time.Now()) {
+		// The password is checked anyway, so a locked account answers as slowly as any other. The attempt only
+		// counts against the address: the lockout of the account does not grow while it is locked.
+		logger.WithField("username", loginDetails.Username).Warn("Login attempt on a locked account")
+		loginFailed(logger, lockouts, policy, w, 0, loginDetails.Username, ip)
+		return
+	}
 	if err != nil {
+		loginFailed(logger, lockouts, policy, w, newUser.ID, loginDetails.Username, ip)
+		return
+	}
+	if !accountUsable(logger, w, newUser, true, "/login") {
+		return
+	}
+
+	totp, err := totps.GetTOTP(newUser.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/login",
+			"Server error checking the two-factor authentication",
+			err,
+			utils.LogTypeError,
+			loginDetails.Username)
+		return
+	}
+	mfaRequired := err == nil && totp.Enabled
+
+	// With a second factor, the failed logins are kept until it is checked, so the counter cannot be reset by
+	// the password alone between guesses of the code
+	if !mfaRequired && !clearLoginFailures(logger, lockouts, w, newUser, "/login") {
+		return
+	}
+
+	if cfg.EmailVerificationMode == utils.EmailVerificationBlock && !newUser.EmailVerified {
 		service.HttpErrorResponse(logger,
 			w,
-			http.StatusUnauthorized,
+			http.StatusForbidden,
 			"/login",
-			"Invalid username or password",
+			"Email address not verified, follow the link sent to it or ask for a new one",
 			nil,
-			utils.LogTypeWarn,
+			utils.LogTypeInfo,
 			loginDetails.Username)
 		return
 	}
 
-	var accessToken, refreshToken string
-	accessToken, refreshToken, err = service.HandleTokensCreation(logger, db, cfg, loginDetails.Username)
+	if mfaRequired {
+		mfaToken, err := service.NewMFAToken(logger, cfg, keys, newUser)
+		if err != nil {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusInternalServerError,
+				"/login",
+				"Server error handling the tokens",
+				err,
+				utils.LogTypeError,
+				loginDetails.Username)
+			return
+		}
+		logger.WithField("username", loginDetails.Username).Info("Password checked, second factor required")
+		service.HttpJSONResponse(logger, w, http.StatusOK, "/login", map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		}, loginDetails.Username)
+		return
+	}
+
+	if startSession(logger, tokens, denylist, rbac, keys, cfg, w, r, newUser, loginDetails.Device, "/login") {
+		logger.WithField("username", loginDetails.Username).Info("User logged in with success")
+	}
+}
+
+// LoginMFA handles the second step of the login of a user who enabled two-factor authentication: the MFA
+// challenge token returned by LoginUser is exchanged, with a code of the authenticator app or a recovery code,
+// for an access token and a refresh token bound to a new session. A TOTP code can only be used once and a
+// recovery code is used up. Wrong codes count as failed logins of the account and of the client IP address,
+// which are locked out according to the lockout policy like on /user/login.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore used to look up the user.
+// tokens: The TokenStore in which the session started by the login is stored.
+// denylist: The DenylistStore in which the access token is recorded, so logging out can deny it.
+// rbac: The RBACStore used to embed the roles and permissions of the user in the access token.
+// lockouts: The LockoutStore holding the failed login counters.
+// totps: The TOTPStore holding the TOTP secret and the recovery codes of the user.
+// policy: The LoginLockoutPolicy deciding when accounts and addresses are locked.
+// keys: The Keyring holding the keys used to verify the challenge and to sign the tokens.
+// cfg: A pointer to the config.Config struct which contains JWT and TOTP configuration details.
+// w: The http.ResponseWriter to write the HTTP response.
+// r: The http.Request with the challenge and the code in JSON format, e.g.
+// {"mfa_token": "...", "code": "123456", "device": "Work laptop"}; the device label is optional.
+//
+// Responds with a JSON object containing the access token and refresh token, 401 if the challenge is invalid or
+// expired or if the code is wrong, 403 if the account was disabled or a password reset required since the password
+// was checked, and 429 if the client IP address is locked.
+func LoginMFA(logger *logrus.Logger,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	rbac repository.RBACStore,
+	lockouts repository.LockoutStore,
+	totps repository.TOTPStore,
+	policy *service.LoginLockoutPolicy,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json")
+	ip := service.ClientIP(r)
+
+	var request struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+		Device   string `json:"device"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || len(request.Device) > maxDeviceLabelLength {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/login/mfa",
+			"Invalid request format",
+			err,
+			utils.LogTypeError,
+			"not able to get the username")
+		return
+	}
+
+	claims, err := service.VerifyToken(logger, cfg, keys, request.MFAToken, utils.MFAToken)
+	var userId int
+	if err == nil {
+		userId, err = claims.UserId()
+	}
 	if err != nil {
+		mfaTokenRejected(logger, w, err, "")
+		return
+	}
+
+	user, err := users.GetUserById(userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The user was deleted since the password was checked
+		mfaTokenRejected(logger, w, err, claims.Username)
+		return
+	} else if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
-			"/login",
-			"Server error handling the tokens",
+			"/login/mfa",
+			"Server error retrieving the user",
 			err,
 			utils.LogTypeError,
-			loginDetails.Username)
+			claims.Username)
+		return
+	}
+
+	totp, err := totps.GetTOTP(userId)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && !totp.Enabled) {
+		// Two-factor authentication was disabled since the password was checked
+		mfaTokenRejected(logger, w, err, user.Username)
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/login/mfa",
+			"Server error checking the two-factor authentication",
+			err,
+			utils.LogTypeError,
+			user.Username)
+		return
+	}
+
+	if ipLocked(logger, lockouts, w, ip, user.Username, "/login/mfa") {
 		return
 	}
 
-	var result bool
-	result, err = repository.StoreRefreshTokenInDB(logger, db, refreshToken, loginDetails.Username)
+	if user.Locked(time.Now()) {
+		// Like on /login, a locked account is rejected and only the address is counted. The code is not checked, so
+		// a valid one is not used up by a login that is refused anyway
+		logger.WithField("username", user.Username).Warn("Second factor given for a locked account")
+		mfaFailed(logger, lockouts, policy, w, 0, user.Username, ip)
+		return
+	}
+
+	valid, err := checkSecondFactor(totps, cfg, totp, request.Code)
 	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
-			"/login",
-			"Server error storing refreshToken",
+			"/login/mfa",
+			"Server error checking the authentication code",
 			err,
 			utils.LogTypeError,
-			loginDetails.Username)
+			user.Username)
+		return
+	}
+	if !valid {
+		mfaFailed(logger, lockouts, policy, w, user.ID, user.Username, ip)
 		return
-	} else if !result {
+	}
+	// The account may have been changed by an administrator since the password was checked
+	if !accountUsable(logger, w, user, true, "/login/mfa") {
+		return
+	}
+
+	if !clearLoginFailures(logger, lockouts, w, user, "/login/mfa") {
+		return
+	}
+	if startSession(logger, tokens, denylist, rbac, keys, cfg, w, r, user, request.Device, "/login/mfa") {
+		logger.WithField("username", user.Username).Info("User logged in with a second factor")
+	}
+}
+
+// truncate shortens a string to at most maxLength bytes, dropping a multi-byte character cut in half.
+func truncate(value string, maxLength int) string {
+	if len(value) > maxLength {
+		return strings.ToValidUTF8(value[:maxLength], "")
+	}
+	return value
+}
+
+// loginFailed records a failed login and responds with 401 and loginFailedMessage.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// lockouts: The LockoutStore holding the failed login counters.
+// policy: The LoginLockoutPolicy deciding when accounts and addresses are locked.
+// w: The http.ResponseWriter to write the response to.
+// userId: The ID of the account the failure counts against, or 0 to only count it against the address.
+// username: The username of the login.
+// ip: The client IP address.
+func loginFailed(logger *logrus.Logger,
+	lockouts repository.LockoutStore,
+	policy *service.LoginLockoutPolicy,
+	w http.ResponseWriter,
+	userId int,
+	username string,
+	ip string) {
+	if err := policy.RecordLoginFailure(logger, lockouts, userId, username, ip); err != nil {
+		// The login is rejected anyway, a store error must not tell the failure apart
+		logger.WithError(err).WithField("username", username).Error("Error recording the failed login")
+	}
+	service.HttpErrorResponse(logger,
+		w,
+		http.StatusUnauthorized,
+		"/login",
+		loginFailedMessage,
+		nil,
+		utils.LogTypeWarn,
+		username)
+}
+
+// mfaFailed records a failed second factor like a failed login and responds with 401 and mfaFailedMessage.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// lockouts: The LockoutStore holding the failed login counters.
+// policy: The LoginLockoutPolicy deciding when accounts and addresses are locked.
+// w: The http.ResponseWriter to write the response to.
+// userId: The ID of the account the failure counts against, or 0 to only count it against the address.
+// username: The username of the login.
+// ip: The client IP address.
+func mfaFailed(logger *logrus.Logger,
+	lockouts repository.LockoutStore,
+	policy *service.LoginLockoutPolicy,
+	w http.ResponseWriter,
+	userId int,
+	username string,
+	ip string) {
+	if err := policy.RecordLoginFailure(logger, lockouts, userId, username, ip); err != nil {
+		// The code is rejected anyway, a store error must not tell the failure apart
+		logger.WithError(err).WithField("username", username).Error("Error recording the failed second factor")
+	}
+	service.HttpErrorResponse(logger,
+		w,
+		http.StatusUnauthorized,
+		"/login/mfa",
+		mfaFailedMessage,
+		nil,
+		utils.LogTypeWarn,
+		username)
+}
+
+// mfaTokenRejected responds with 401 to a second factor given with an invalid or expired MFA challenge token, or
+// for a user who was deleted or disabled two-factor authentication since the challenge was issued.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// w: The http.ResponseWriter to write the response to.
+// err: The reason of the rejection, logged, possibly nil.
+// username: The username of the challenge, empty if the token is invalid.
+func mfaTokenRejected(logger *logrus.Logger, w http.ResponseWriter, err error, username string) {
+	service.HttpErrorResponse(logger,
+		w,
+		http.StatusUnauthorized,
+		"/login/mfa",
+		"Invalid or expired MFA token, log in again",
+		err,
+		utils.LogTypeWarn,
+		username)
+}
+
+// ipLocked checks whether the client IP address of a login is locked out, and responds with 429 and a
+// Retry-After header if it is.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// lockouts: The LockoutStore holding the failed login counters.
+// w: The http.ResponseWriter to write the response to.
+// ip: The client IP address.
+// username: The username of the login.
+// endpoint: The endpoint handling the login.
+//
+// Returns true if the address is locked or cannot be checked, in which case a response has been written.
+func ipLocked(logger *logrus.Logger,
+	lockouts repository.LockoutStore,
+	w http.ResponseWriter,
+	ip string,
+	username string,
+	endpoint string) bool {
+	lockedUntil, err := lockouts.IPLockedUntil(ip)
+	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
-			"/login",
-			"Server failed to storing refreshToken",
+			endpoint,
+			"Server error checking the login lockout",
+			err,
+			utils.LogTypeError,
+			username)
+		return true
+	}
+	if now := time.Now(); now.Before(lockedUntil) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedUntil.Sub(now).Seconds()))))
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusTooManyRequests,
+			endpoint,
+			"Too many failed logins from this address, try again later",
 			nil,
 			utils.LogTypeWarn,
-			loginDetails.Username)
-		return
+			username)
+		return true
+	}
+	return false
+}
+
+// accountUsable checks that a user whose credentials were accepted can log in: the account must not be disabled
+// by an administrator and, for a login with a password, no password reset must be required by an administrator.
+// Both are checked after the credentials, so they are only revealed to someone who knows them.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// w: The http.ResponseWriter to write the response to.
+// user: The user logging in.
+// password: Whether the user logs in with its password.
+// endpoint: The endpoint handling the login.
+//
+// Returns false if the user cannot log in, in which case a 403 response has been written.
+func accountUsable(logger *logrus.Logger, w http.ResponseWriter, user *model.User, password bool, endpoint string) bool {
+	message := ""
+	switch {
+	case !user.Active():
+		message = "Account disabled, contact an administrator"
+	case password && user.PasswordResetRequired:
+		message = "Password reset required, follow the link sent to your email address or ask for a new one"
+	default:
+		return true
+	}
+	service.HttpErrorResponse(logger, w, http.StatusForbidden, endpoint, message, nil, utils.LogTypeInfo, user.Username)
+	return false
+}
+
+// clearLoginFailures clears the failed logins and the lock of a user who logged in, if it has any.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// lockouts: The LockoutStore holding the failed login counters.
+// w: The http.ResponseWriter to write the response to.
+// user: The user who logged in.
+// endpoint: The endpoint handling the login.
+//
+// Returns false if the failed logins cannot be cleared, in which case a response has been written.
+func clearLoginFailures(logger *logrus.Logger,
+	lockouts repository.LockoutStore,
+	w http.ResponseWriter,
+	user *model.User,
+	endpoint string) bool {
+	if user.FailedLogins == 0 && user.LockedUntil.IsZero() {
+		return true
+	}
+	if err := lockouts.UnlockUser(user.ID); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			endpoint,
+			"Server error resetting the failed logins",
+			err,
+			utils.LogTypeError,
+			user.Username)
+		return false
+	}
+	return true
+}
+
+// startSession starts a new session for a user who logged in, and responds with its access token and refresh
+// token.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// tokens: The TokenStore in which the session is stored.
+// denylist: The DenylistStore in which the access token is recorded, so logging out can deny it.
+// rbac: The RBACStore used to embed the roles and permissions of the user in the access token.
+// keys: The Keyring holding the key used to sign the access token.
+// cfg: A pointer to the config.Config struct which contains JWT configuration details.
+// w: The http.ResponseWriter to write the response to.
+// r: The http.Request of the login, whose user agent and client IP address are stored with the session.
+// user: The user who logged in.
+// device: The device label of the session, possibly empty.
+// endpoint: The endpoint handling the login.
+//
+// Returns true if the session was started and the tokens written, false if an error response was written.
+func startSession(logger *logrus.Logger,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	rbac repository.RBACStore,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request,
+	user *model.User,
+	device string,
+	endpoint string) bool {
+	tokenPair, err := service.HandleTokensCreation(logger, cfg, keys, rbac, user, "")
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			endpoint,
+			"Server error handling the tokens",
+			err,
+			utils.LogTypeError,
+			user.Username)
+		return false
+	}
+
+	err = tokens.CreateSession(model.Session{
+		ID:          tokenPair.SessionId,
+		UserID:      user.ID,
+		DeviceLabel: strings.TrimSpace(device),
+		UserAgent:   truncate(r.UserAgent(), maxUserAgentLength),
+		IP:          service.ClientIP(r),
+		ExpiresAt:   tokenPair.RefreshExpiresAt,
+		TokenHash:   tokenPair.RefreshTokenHash,
+	})
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			endpoint,
+			"Server error creating the session",
+			err,
+			utils.LogTypeError,
+			user.Username)
+		return false
+	}
+
+	err = denylist.RecordAccessToken(tokenPair.AccessTokenRecord(user.ID))
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			endpoint,
+			"Server error recording the access token",
+			err,
+			utils.LogTypeError,
+			user.Username)
+		return false
 	}
 
 	response := struct {
 		AccessToken  string `json:"access_token"`
 		RefreshToken string `json:"refresh_token"`
 	}{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:  tokenPair.AccessToken,
+		RefreshToken: tokenPair.RefreshToken,
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -138,16 +615,13 @@ func LoginUser(logger *logrus.Logger, db *sql.DB, cfg *config.Config, w http.Res
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
-			"/login",
+			endpoint,
 			"Error writing the response",
 			err,
 			utils.LogTypeError,
-			loginDetails.Username)
-		return
+			user.Username)
+		return false
 	}
 
-	logger.WithField("username", loginDetails.Username).Info("User logged in with success")
-	return
+	return true
 }
-
-
@@ -0,0 +1,75 @@
+package user
+
+import (
+	"GolandRestApi/pkg/api/handlers/handlertest"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/service"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newMFAServer returns a test server serving the second step of the logins, with two-factor authentication
+// enabled for the admin user. It also returns the recovery codes of the admin user and an MFA challenge token.
+func newMFAServer(t *testing.T) (*handlertest.Server, []string, string) {
+	t.Helper()
+	s := handlertest.NewServer(t)
+	policy := s.LockoutPolicy(t)
+	s.Config.TOTPEncryptionKey = "a test TOTP key of at least thirty-two characters"
+	s.Config.MFATokenValidity = "5m"
+	s.Access.Public(s.Router.HandleFunc("/login/mfa", func(w http.ResponseWriter, r *http.Request) {
+		LoginMFA(s.Logger, s.Store, s.Store, s.Store, s.Store, s.Store, s.Store, policy, s.Keys, s.Config, w, r)
+	}).Methods("POST"))
+
+	user, err := s.Store.GetUserByUserName("admin")
+	if err != nil {
+		t.Fatalf("GetUserByUserName: %v", err)
+	}
+	secret, err := service.NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("NewTOTPSecret: %v", err)
+	}
+	encrypted, err := service.EncryptTOTPSecret(s.Config, user.ID, secret)
+	if err != nil {
+		t.Fatalf("EncryptTOTPSecret: %v", err)
+	}
+	if err = s.Store.SaveTOTP(model.TOTP{UserID: user.ID, EncryptedSecret: encrypted, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveTOTP: %v", err)
+	}
+	codes, hashes, err := service.NewRecoveryCodes(s.Config)
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes: %v", err)
+	}
+	if err = s.Store.EnableTOTP(user.ID, 0, hashes); err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+
+	mfaToken, err := service.NewMFAToken(s.Logger, s.Config, s.Keys, user)
+	if err != nil {
+		t.Fatalf("NewMFAToken: %v", err)
+	}
+	return s, codes, mfaToken
+}
+
+func TestLoginMFAKeepsTheCodesOfLockedAccounts(t *testing.T) {
+	s, codes, mfaToken := newMFAServer(t)
+	request := map[string]string{"mfa_token": mfaToken, "code": codes[0]}
+
+	if err := s.Store.LockUser(1, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("LockUser: %v", err)
+	}
+	if code := s.Serve(t, "POST", "/login/mfa", "", request).Code; code != http.StatusUnauthorized {
+		t.Fatalf("recovery code of a locked account = %d, want 401", code)
+	}
+
+	// The code was not used up by the refused login
+	if err := s.Store.UnlockUser(1); err != nil {
+		t.Fatalf("UnlockUser: %v", err)
+	}
+	if code := s.Serve(t, "POST", "/login/mfa", "", request).Code; code != http.StatusOK {
+		t.Errorf("recovery code once the account is unlocked = %d, want 200", code)
+	}
+	if code := s.Serve(t, "POST", "/login/mfa", "", request).Code; code != http.StatusUnauthorized {
+		t.Errorf("recovery code used twice = %d, want 401", code)
+	}
+}
@@ -1,10 +1,10 @@
 package user
 
 import (
+	"GolandRestApi/pkg/api/handlers/middleware"
 	"GolandRestApi/pkg/repository"
 	"GolandRestApi/pkg/service"
 	"GolandRestApi/pkg/utils"
-	"database/sql"
 	"encoding/json"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -12,20 +12,29 @@ import (
 	"strconv"
 )
 
-// LogoutUser handles the user logout process, which involves revoking the user's access and refresh tokens.
-// It extracts the user ID from the URL parameters, revokes the user's tokens, and sends a response indicating
-// successful logout.
+// LogoutUser handles the user logout process, which involves revoking the session of the access token, so its
+// refresh token cannot be used anymore, and denying the outstanding access tokens of the session, including the
+// one of the request. It extracts the user ID from the URL parameters, checks that it is the user of the access
+// token, revokes the session, and sends a response indicating successful logout.
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the SQL database connection.
+// users: The UserStore used to look up the user.
+// tokens: The TokenStore holding the session to revoke.
+// denylist: The DenylistStore to which the access tokens of the session are added.
 // w: The HTTP response writer to send the response.
 // r: The HTTP request to process.
 //
 // If the user ID is not provided in the URL or has an invalid format, it returns an appropriate HTTP error response
-// with a status code 400 (Bad Request). If token revocation encounters an error or is not successful, it returns
-// an HTTP error response with a status code 500 (Internal Server Error). Upon successful logout, it sends an HTTP
+// with a status code 400 (Bad Request). If the user ID is not the user of the access token, it returns 403
+// (Forbidden). If the session revocation or the denial of the access tokens encounters an error, it returns an HTTP error response with a status code
+// 500 (Internal Server Error). Upon successful logout, it sends an HTTP
 // response with a status code 200 (OK) indicating that the user has logged out.
-func LogoutUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+func LogoutUser(logger *logrus.Logger,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	w http.ResponseWriter,
+	r *http.Request) {
 	vars := mux.Vars(r)
 	userIdStr, ok := vars["userId"]
 
@@ -54,38 +63,48 @@ func LogoutUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *htt
 		return
 	}
 
-	username, err := repository.GetUserNameByUserId(logger, db, userId)
-	if err != nil {
+	// Only the session of the access token is logged out; the other devices of the user stay logged in
+	claims, ok := middleware.ClaimsFromRequest(r)
+	if !ok || claims.Subject != strconv.Itoa(userId) {
 		service.HttpErrorResponse(logger,
 			w,
-			http.StatusInternalServerError,
+			http.StatusForbidden,
 			"/logout",
-			"Error getting userName by userId",
-			err,
-			utils.LogTypeError,
+			"Access denied: users can only log themselves out",
+			nil,
+			utils.LogTypeWarn,
 			"with userId: "+strconv.Itoa(userId))
 		return
 	}
 
-	success, err := repository.TokenRevocation(logger, db, userId)
+	username, err := users.GetUserNameByUserId(userId)
 	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
 			"/logout",
-			"Error revoking token",
+			"Error getting userName by userId",
 			err,
 			utils.LogTypeError,
-			username)
+			"with userId: "+strconv.Itoa(userId))
 		return
-	} else if !success {
+	}
+
+	err = tokens.RevokeSession(claims.SessionId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/logout", "Error revoking the session", err, username)
+		return
+	}
+
+	_, err = denylist.DenySessionAccessTokens(claims.SessionId)
+	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
 			"/logout",
-			"Failed revoking token",
-			nil,
-			utils.LogTypeWarn,
+			"Error denying the access tokens",
+			err,
+			utils.LogTypeError,
 			username)
 		return
 	}
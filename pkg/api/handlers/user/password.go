@@ -0,0 +1,365 @@
+package user
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// forgotPasswordMessage is the response to every valid forgot-password request, whether an account uses the
+// email address or not, so the endpoint cannot be used to find out which addresses are registered.
+const forgotPasswordMessage = "If an account uses this email address, a password reset token was sent to it"
+
+// ChangePassword handles the change of the password of the authenticated user. The current password is asked
+// again, and every other session of the user is revoked, with its access tokens, so a device logged in with the
+// old password is logged out. The session of the request stays active. A wrong current password counts as a
+// failed login of the account and of the client IP address, so a stolen access token cannot be used to guess the
+// password, and a locked account cannot change its password until the lockout ends.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// tokens: The TokenStore holding the sessions of the user.
+// denylist: The DenylistStore to which the access tokens of the other sessions are added.
+// lockouts: The LockoutStore holding the failed login counters.
+// policy: The LoginLockoutPolicy deciding when accounts and addresses are locked.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token, containing the passwords in JSON format, e.g.
+// {"currentPassword": "old secret", "newPassword": "new secret"}.
+//
+// Responds with 200 and the number of revoked sessions, 400 if the new password is missing or invalid, 403 if the
+// current password is wrong, and 429 if the account is locked.
+func ChangePassword(logger *logrus.Logger,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	lockouts repository.LockoutStore,
+	policy *service.LoginLockoutPolicy,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/password")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/password",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+	if reason := service.ValidatePassword(request.NewPassword); reason != "" {
+		service.HttpValidationErrorResponse(logger,
+			w,
+			"/user/password",
+			"Invalid password",
+			map[string]string{"newPassword": reason},
+			claims.Username)
+		return
+	}
+
+	user, ok := requestUser(logger, users, w, userId, claims.Username, "/user/password")
+	if !ok {
+		return
+	}
+	if now := time.Now(); user.Locked(now) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(user.LockedUntil.Sub(now).Seconds()))))
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusTooManyRequests,
+			"/user/password",
+			"Too many failed logins for this account, try again later",
+			nil,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+	if err := service.CheckPasswordHash(logger, user, request.CurrentPassword); err != nil {
+		recordErr := policy.RecordLoginFailure(logger, lockouts, user.ID, claims.Username, service.ClientIP(r))
+		if recordErr != nil {
+			// The password is rejected anyway
+			logger.WithError(recordErr).WithField("username", claims.Username).Error("Error recording the failed login")
+		}
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/user/password",
+			"Invalid current password",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+	if !clearLoginFailures(logger, lockouts, w, user, "/user/password") {
+		return
+	}
+
+	if !setPassword(logger, users, w, user, request.NewPassword, "/user/password") {
+		return
+	}
+
+	// The session of the request is kept
+	revoked, err := service.RevokeUserSessions(tokens, denylist, userId, claims.SessionId)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/password",
+			"Password changed, but the other sessions could not be revoked",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"username": claims.Username,
+		"revoked":  revoked,
+	}).Info("Password changed with success")
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/password", map[string]interface{}{
+		"message": "Password changed",
+		"revoked": revoked,
+	}, claims.Username)
+}
+
+// ForgotPassword handles the request of a password reset token by a user who forgot its password. If an account
+// uses the email address, a single-use reset token valid for PASSWORD_RESET_TOKEN_VALIDITY is stored hashed and
+// sent to the user through the notifier. The response is the same whether an account uses the address or not.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore used to find the user by email.
+// resets: The PasswordResetStore in which the hash of the token is stored.
+// notifier: The Notifier delivering the token to the user.
+// cfg: A pointer to the config.Config struct which contains the password reset configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the email address in JSON format, e.g. {"email": "user@example.com"}.
+//
+// Responds with 202 once the request is processed and 400 if the request has no email address.
+func ForgotPassword(logger *logrus.Logger,
+	users repository.UserStore,
+	resets repository.PasswordResetStore,
+	notifier service.Notifier,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/password/forgot",
+			"Invalid request format, the email address is required",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	validity, err := utils.ParseDuration(cfg.PasswordResetTokenValidity)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/password/forgot",
+			"Invalid password reset token validity",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	user, err := users.GetUserByEmail(request.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		service.HttpMessageResponse(logger, w, http.StatusAccepted, "/user/password/forgot", forgotPasswordMessage, "")
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/password/forgot",
+			"Error retrieving the user",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	token, err := service.IssuePasswordResetToken(resets, user.ID, validity)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/password/forgot",
+			"Error creating the password reset token",
+			err,
+			utils.LogTypeError,
+			user.Username)
+		return
+	}
+
+	err = notifier.Notify(service.PasswordResetNotification(cfg, user, token, validity, false))
+	if err != nil {
+		// The response does not tell the failure apart, it would reveal that the account exists
+		logger.WithError(err).WithField("username", user.Username).Error("Error sending the password reset token")
+	}
+	service.HttpMessageResponse(logger, w, http.StatusAccepted, "/user/password/forgot", forgotPasswordMessage,
+		user.Username)
+}
+
+// ResetPassword handles the completion of a password reset: the reset token sent by ForgotPassword is used, the
+// password of its user is replaced, and every session of the user is revoked with its access tokens.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// tokens: The TokenStore holding the sessions of the user.
+// denylist: The DenylistStore to which the access tokens of the user are added.
+// resets: The PasswordResetStore holding the reset tokens.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the reset token and the new password in JSON format, e.g.
+// {"token": "<reset token>", "newPassword": "new secret"}.
+//
+// Responds with 200 once the password is reset, and 400 if the new password is invalid or the token is unknown,
+// expired or already used. An invalid password does not use the token.
+func ResetPassword(logger *logrus.Logger,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	resets repository.PasswordResetStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var request struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/password/reset",
+			"Invalid request format, the reset token is required",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+	if reason := service.ValidatePassword(request.NewPassword); reason != "" {
+		service.HttpValidationErrorResponse(logger,
+			w,
+			"/user/password/reset",
+			"Invalid password",
+			map[string]string{"newPassword": reason},
+			"")
+		return
+	}
+
+	resetToken, err := resets.ConsumePasswordResetToken(service.HashPasswordResetToken(request.Token))
+	if errors.Is(err, repository.ErrNotFound) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/password/reset",
+			"Invalid or expired reset token",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/password/reset",
+			"Error using the reset token",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	user, ok := requestUser(logger, users, w, resetToken.UserID, "", "/user/password/reset")
+	if !ok {
+		return
+	}
+	if !setPassword(logger, users, w, user, request.NewPassword, "/user/password/reset") {
+		return
+	}
+
+	// Whoever knew the forgotten password may still be logged in
+	if _, err = service.RevokeUserSessions(tokens, denylist, user.ID, ""); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/password/reset",
+			"Password reset, but the sessions could not be revoked",
+			err,
+			utils.LogTypeError,
+			user.Username)
+		return
+	}
+
+	service.HttpMessageResponse(logger,
+		w,
+		http.StatusOK,
+		"/user/password/reset",
+		"Password reset, log in with the new password",
+		user.Username)
+}
+
+// setPassword hashes a new password with service.HashPassword and stores it.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// w: The http.ResponseWriter to which a 500 response is written if the password cannot be stored.
+// user: The user whose password is changed.
+// password: The new plaintext password.
+// endpoint: The endpoint being served (used for logging purposes).
+//
+// Returns true if the password was stored, or false if an error response was sent.
+func setPassword(logger *logrus.Logger,
+	users repository.UserStore,
+	w http.ResponseWriter,
+	user *model.User,
+	password string,
+	endpoint string) bool {
+	hashedPassword, err := service.HashPassword(logger, model.User{Username: user.Username, Password: password})
+	if err == nil {
+		err = users.UpdatePassword(user.ID, hashedPassword)
+	}
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			endpoint,
+			"Error updating the password",
+			err,
+			utils.LogTypeError,
+			user.Username)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package user
+
+import (
+	"GolandRestApi/pkg/api/handlers/handlertest"
+	"net/http"
+	"testing"
+)
+
+// newPasswordServer returns a test server serving the password change of the authenticated user.
+func newPasswordServer(t *testing.T) *handlertest.Server {
+	t.Helper()
+	s := handlertest.NewServer(t)
+	policy := s.LockoutPolicy(t)
+	s.Access.Require(s.Router.HandleFunc("/password", func(w http.ResponseWriter, r *http.Request) {
+		ChangePassword(s.Logger, s.Store, s.Store, s.Store, s.Store, policy, w, r)
+	}).Methods("POST"))
+	return s
+}
+
+func TestChangePasswordLocksOutGuesses(t *testing.T) {
+	s := newPasswordServer(t)
+	accessToken := s.Login(t, "admin").AccessToken
+	change := func(currentPassword string) int {
+		request := map[string]string{"currentPassword": currentPassword, "newPassword": "a new password"}
+		return s.Serve(t, "POST", "/password", accessToken, request).Code
+	}
+
+	for i := 0; i < s.Config.LoginLockoutAccountThreshold; i++ {
+		if code := change("a guess"); code != http.StatusForbidden {
+			t.Fatalf("guess %d = %d, want 403", i+1, code)
+		}
+	}
+	user, err := s.Store.GetUserByUserName("admin")
+	if err != nil {
+		t.Fatalf("GetUserByUserName: %v", err)
+	}
+	if user.LockedUntil.IsZero() {
+		t.Fatalf("account not locked after %d wrong passwords", s.Config.LoginLockoutAccountThreshold)
+	}
+	if code := change("admin"); code != http.StatusTooManyRequests {
+		t.Errorf("right password while the account is locked = %d, want 429", code)
+	}
+
+	if err = s.Store.UnlockUser(user.ID); err != nil {
+		t.Fatalf("UnlockUser: %v", err)
+	}
+	if code := change("admin"); code != http.StatusOK {
+		t.Errorf("right password once the account is unlocked = %d, want 200", code)
+	}
+}
@@ -0,0 +1,247 @@
+package user
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// GetProfile handles the reading of the profile of the authenticated user, identified by its access token.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token.
+//
+// Responds with 200 and the profile of the user, including its version, and 404 if the user no longer exists.
+func GetProfile(logger *logrus.Logger, users repository.UserStore, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/me")
+	if !ok {
+		return
+	}
+
+	user, ok := requestUser(logger, users, w, userId, claims.Username, "/user/me")
+	if !ok {
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/me", user.Profile(), claims.Username)
+}
+
+// UpdateProfile handles the partial update of the profile of the authenticated user. Only the email, country
+// and phone can be changed; the username and password cannot. A new email address is not verified: a token to
+// verify it is sent to it.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// keys: The Keyring holding the key used to sign the email verification token.
+// notifier: The Notifier delivering the email verification token.
+// cfg: A pointer to the config.Config struct which contains the email verification configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token, containing the fields to change and the version of
+// the profile they were read from in JSON format, e.g. {"phone": "+351 912 345 678", "version": 3}.
+//
+// Responds with 200 and the updated profile. Responds with 400 if the request has unknown fields, no version or
+// invalid fields, listed in the response, and with 409 if the profile has changed since that version or the
+// email is used by another user.
+func UpdateProfile(logger *logrus.Logger,
+	users repository.UserStore,
+	keys *service.Keyring,
+	notifier service.Notifier,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/me")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		model.ProfileUpdate
+		Version *int `json:"version"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/me",
+			"Invalid request format, only email, country and phone can be changed",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	invalid := service.ValidateProfileUpdate(request.ProfileUpdate)
+	if request.Version == nil {
+		invalid["version"] = "is required, send the version of the profile being changed"
+	}
+	if len(invalid) > 0 {
+		service.HttpValidationErrorResponse(logger, w, "/user/me", "Invalid profile", invalid, claims.Username)
+		return
+	}
+
+	user, err := users.UpdateProfile(userId, *request.Version, request.ProfileUpdate)
+	if errors.Is(err, sql.ErrNoRows) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			"/user/me",
+			"User not found",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/me", "Error updating the profile", err, claims.Username)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"username": claims.Username,
+		"version":  user.Version,
+	}).Info("Profile updated with success")
+	if !user.EmailVerified && request.Email != nil {
+		// The verification is sent on a best-effort basis, errors are logged by SendEmailVerification
+		_ = service.SendEmailVerification(logger, cfg, keys, notifier, user)
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/me", user.Profile(), claims.Username)
+}
+
+// DeleteProfile handles the deletion of the account of the authenticated user. The password of the user is
+// asked again, so a stolen access token is not enough to delete the account. The outstanding access tokens of
+// the user are denied, its sessions removed and the user marked as deleted. An administrator can restore the
+// account until it is purged, DELETED_USER_RETENTION later.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// denylist: The DenylistStore to which the access tokens of the user are added.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token, containing the password of the user in JSON
+// format, e.g. {"password": "secret"}.
+//
+// Responds with 200 once the user is deleted, 400 if the request has no password and 403 if the password is
+// wrong.
+func DeleteProfile(logger *logrus.Logger,
+	users repository.UserStore,
+	denylist repository.DenylistStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/me")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Password == "" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/me",
+			"Invalid request format, the password of the user is required",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	user, ok := requestUser(logger, users, w, userId, claims.Username, "/user/me")
+	if !ok {
+		return
+	}
+	if err := service.CheckPasswordHash(logger, user, request.Password); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/user/me",
+			"Invalid password",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	// Deny the access tokens first: they stay valid until they expire once the user is gone
+	if _, err := denylist.DenyUserAccessTokens(userId); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/me",
+			"Error revoking the access tokens of the user",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+	if err := users.SoftDeleteUser(userId, userId); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/me",
+			"Error deleting the user",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/user/me", "User deleted", claims.Username)
+}
+
+// requestUser retrieves the user of the access token of the request.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// w: The http.ResponseWriter to which an error response is written if the user cannot be retrieved.
+// userId: The ID of the user of the access token.
+// username: The username of the access token (used for logging purposes).
+// endpoint: The endpoint being served (used for logging purposes).
+//
+// Returns the user and true, or false if an error response was sent: 404 if the user no longer exists.
+func requestUser(logger *logrus.Logger,
+	users repository.UserStore,
+	w http.ResponseWriter,
+	userId int,
+	username string,
+	endpoint string) (*model.User, bool) {
+	user, err := users.GetUserById(userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			endpoint,
+			"User not found",
+			err,
+			utils.LogTypeWarn,
+			username)
+		return nil, false
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			endpoint,
+			"Error retrieving the user",
+			err,
+			utils.LogTypeError,
+			username)
+		return nil, false
+	}
+	return user, true
+}
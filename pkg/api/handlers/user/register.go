@@ -1,30 +1,53 @@
 package user
 
 import (
+	"GolandRestApi/pkg/config"
 	"GolandRestApi/pkg/model"
 	"GolandRestApi/pkg/repository"
 	"GolandRestApi/pkg/service"
 	"GolandRestApi/pkg/utils"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"net/http"
 )
 
+// registrationAcceptedMessage answers every registration in the non-enumerating mode, whether the account was
+// created or the username or the email address is already in use.
+const registrationAcceptedMessage = "Registration received, follow the instructions sent to your email address"
+
 // RegisterUser is an HTTP handler function for registering a new user.
-// It takes a logrus.Logger instance for logging, a pointer to a sql.DB representing the database connection,
+// It takes a logrus.Logger instance for logging, a repository.UserStore holding the user accounts,
 // a http.ResponseWriter for writing the HTTP response, and an http.Request for processing the HTTP request.
 // This function expects a JSON-encoded User object in the request body and performs the following steps:
 // 1. Deserialize the User object from the request body.
-// 2. Check if a user with the same username or email already exists in the database.
-// 3. If not, hash the user's password and add the user to the database.
-// 4. Respond with appropriate HTTP status codes and messages.
+// 2. Hash the user's password.
+// 3. Check if a user with the same username or email already exists in the database.
+// 4. If not, add the user to the database.
+// 5. Send the user a token to verify its email address.
+// 6. Respond with appropriate HTTP status codes and messages.
+//
+// The user is created even if the verification cannot be sent: a new one can be asked at /user/verify/resend.
+// The password is hashed before the existence check, so a conflict is answered as slowly as a registration.
+// In the non-enumerating registration mode, an email address is required and a conflict is answered with the
+// same 202 response as a registration: it is reported by email instead, to the owner of the address.
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to a sql.DB representing the database connection.
+// users: A repository.UserStore holding the user accounts.
+// keys: The Keyring holding the key used to sign the email verification token.
+// notifier: The Notifier delivering the email verification token.
+// cfg: A pointer to the config.Config struct which contains the email verification configuration.
 // w: An http.ResponseWriter for writing the HTTP response.
 // r: An http.Request containing the HTTP request with a JSON-encoded User object in the request body.
-func RegisterUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *http.Request) {
+func RegisterUser(logger *logrus.Logger,
+	users repository.UserStore,
+	keys *service.Keyring,
+	notifier service.Notifier,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
 	var newUser model.User
 	err := json.NewDecoder(r.Body).Decode(&newUser)
 	if err != nil {
@@ -39,7 +62,33 @@ func RegisterUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *h
 		return
 	}
 
-	userExists, err := repository.UserExists(logger, db, newUser.Username, newUser.Email)
+	nonEnumerating := cfg.RegistrationMode == utils.RegistrationModeNonEnumerating
+	if nonEnumerating && newUser.Email == "" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/register",
+			"An email address is required",
+			nil,
+			utils.LogTypeInfo,
+			newUser.Username)
+		return
+	}
+
+	hashedPassword, err := service.HashPassword(logger, newUser)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/register",
+			"Error hashing password",
+			err,
+			utils.LogTypeError,
+			newUser.Username)
+		return
+	}
+
+	userExists, err := users.UserExists(newUser.Username, newUser.Email)
 	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
@@ -50,6 +99,11 @@ func RegisterUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *h
 			utils.LogTypeError,
 			newUser.Username)
 		return
+	} else if userExists == true && nonEnumerating {
+		reportRegistrationConflict(logger, users, notifier, newUser)
+		service.HttpMessageResponse(logger, w, http.StatusAccepted, "/register", registrationAcceptedMessage,
+			newUser.Username)
+		return
 	} else if userExists == true {
 		service.HttpErrorResponse(logger,
 			w,
@@ -62,29 +116,27 @@ func RegisterUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *h
 		return
 	}
 
-	hashedPassword, err := service.HashPassword(logger, newUser)
+	newUser.HashedPassword = hashedPassword
+	err = users.AddUser(newUser, utils.UserRole)
 	if err != nil {
 		service.HttpErrorResponse(logger,
 			w,
 			http.StatusInternalServerError,
 			"/register",
-			"Error hashing password",
+			"Error adding user",
 			err,
 			utils.LogTypeError,
 			newUser.Username)
 		return
 	}
 
-	newUser.HashedPassword = hashedPassword
-	err = repository.AddUser(logger, db, newUser, utils.UserRole)
-	if err != nil {
-		service.HttpErrorResponse(logger,
-			w,
-			http.StatusInternalServerError,
-			"/register",
-			"Error adding user",
-			err,
-			utils.LogTypeError,
+	// The verification is sent on a best-effort basis, errors are logged by SendEmailVerification
+	if created, err := users.GetUserByUserName(newUser.Username); err == nil {
+		_ = service.SendEmailVerification(logger, cfg, keys, notifier, created)
+	}
+
+	if nonEnumerating {
+		service.HttpMessageResponse(logger, w, http.StatusAccepted, "/register", registrationAcceptedMessage,
 			newUser.Username)
 		return
 	}
@@ -113,3 +165,68 @@ func RegisterUser(logger *logrus.Logger, db *sql.DB, w http.ResponseWriter, r *h
 	logger.WithField("username", newUser.Username).Info("User registered with success")
 	return
 }
+
+// reportRegistrationConflict tells the owner of the email address given to a registration in conflict why no
+// account was created: the address is already used by an account, or the username is taken. Errors are only
+// logged, the response of the registration must not depend on them.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: A repository.UserStore holding the user accounts.
+// notifier: The Notifier delivering the notification.
+// newUser: The user whose registration is in conflict.
+func reportRegistrationConflict(logger *logrus.Logger,
+	users repository.UserStore,
+	notifier service.Notifier,
+	newUser model.User) {
+	var notification service.Notification
+	existing, err := users.GetUserByEmail(newUser.Email)
+	switch {
+	case err == nil:
+		notification = registrationEmailInUseNotification(existing)
+	case errors.Is(err, sql.ErrNoRows):
+		notification = registrationUsernameTakenNotification(newUser)
+	default:
+		logger.WithError(err).WithField("username", newUser.Username).Error("Error looking up the email address")
+		return
+	}
+
+	if err = notifier.Notify(notification); err != nil {
+		logger.WithError(err).WithField("username", newUser.Username).Error("Error reporting the registration conflict")
+		return
+	}
+	logger.WithField("username", newUser.Username).Info("Registration conflict reported by email")
+}
+
+// registrationEmailInUseNotification builds the notification telling the owner of an account that its email
+// address was given to a registration.
+//
+// user: The user owning the email address.
+//
+// Returns the notification.
+func registrationEmailInUseNotification(user *model.User) service.Notification {
+	return service.Notification{
+		To:       user.Email,
+		Username: user.Username,
+		Subject:  "Registration attempt with your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nSomeone tried to register a new account with this email address, which "+
+			"is already used by your account. No account was created. If it was you, log in with the username %s, "+
+			"or reset your password if you forgot it. Otherwise, ignore this message.", user.Username, user.Username),
+	}
+}
+
+// registrationUsernameTakenNotification builds the notification telling the author of a registration that the
+// username it chose is already taken.
+//
+// newUser: The user whose registration is in conflict.
+//
+// Returns the notification.
+func registrationUsernameTakenNotification(newUser model.User) service.Notification {
+	return service.Notification{
+		To:       newUser.Email,
+		Username: newUser.Username,
+		Subject:  "Your registration could not be completed",
+		Body: fmt.Sprintf("Hello,\n\nThe username %s is already taken, so no account was created for this email "+
+			"address. Register again with another username. If you did not register, ignore this message.",
+			newUser.Username),
+	}
+}
@@ -0,0 +1,142 @@
+package user
+
+import (
+	"GolandRestApi/pkg/api/handlers/middleware"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// ListSessions handles the listing of the active sessions of the authenticated user, i.e. the devices on which
+// the user is logged in.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// tokens: The TokenStore holding the sessions.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token.
+//
+// Responds with 200 and a JSON array of sessions, most recently used first; the session of the access token is
+// flagged as current.
+func ListSessions(logger *logrus.Logger, tokens repository.TokenStore, w http.ResponseWriter, r *http.Request) {
+	claims, userId, ok := requestOwner(logger, w, r, "/user/sessions")
+	if !ok {
+		return
+	}
+
+	sessions, err := tokens.ListSessions(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/sessions", "Error listing sessions", err, claims.Username)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionId
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/sessions", sessions, claims.Username)
+}
+
+// RevokeSession handles the revocation of one session of the authenticated user, e.g. a lost device. Neither
+// the refresh token nor the access tokens of the session can be used anymore.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// tokens: The TokenStore holding the sessions.
+// denylist: The DenylistStore to which the access tokens of the session are added.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the session ID as a path variable, authenticated with an access token.
+//
+// Responds with 200 on success and 404 if the user has no such session.
+func RevokeSession(logger *logrus.Logger,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	claims, userId, ok := requestOwner(logger, w, r, "/user/sessions")
+	if !ok {
+		return
+	}
+
+	sessionId := mux.Vars(r)["sessionId"]
+	session, err := tokens.GetSession(sessionId)
+	if err == nil && session.UserID != userId {
+		// Sessions of other users are reported as missing, so their IDs cannot be probed
+		err = &repository.NotFoundError{Entity: "session", Key: sessionId}
+	}
+	if err == nil {
+		err = service.RevokeSession(tokens, denylist, sessionId)
+	}
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/sessions", "Error revoking session", err, claims.Username)
+		return
+	}
+
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/user/sessions", "Session revoked", claims.Username)
+}
+
+// RevokeOtherSessions handles the revocation of every session of the authenticated user except the session of
+// the access token, logging the user out of all the other devices. Their access tokens are denied.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// tokens: The TokenStore holding the sessions.
+// denylist: The DenylistStore to which the access tokens of the revoked sessions are added.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token.
+//
+// Responds with 200 and the number of revoked sessions, e.g. {"revoked": 2}.
+func RevokeOtherSessions(logger *logrus.Logger,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	claims, userId, ok := requestOwner(logger, w, r, "/user/sessions")
+	if !ok {
+		return
+	}
+
+	revoked, err := service.RevokeUserSessions(tokens, denylist, userId, claims.SessionId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/sessions", "Error revoking sessions", err, claims.Username)
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/sessions", map[string]int{"revoked": revoked}, claims.Username)
+}
+
+// requestOwner reads the user of the access token of the request.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// w: The http.ResponseWriter to which a 401 response is written if the request carries no valid claims.
+// r: The HTTP request.
+// endpoint: The endpoint being served (used for logging purposes).
+//
+// Returns the claims, the user ID and true, or false if an error response was sent.
+func requestOwner(logger *logrus.Logger, w http.ResponseWriter, r *http.Request, endpoint string) (*service.Claims, int, bool) {
+	claims, ok := middleware.ClaimsFromRequest(r)
+	if !ok {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			endpoint,
+			"Authorization token required",
+			nil,
+			utils.LogTypeWarn,
+			"")
+		return nil, 0, false
+	}
+
+	userId, err := claims.UserId()
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusUnauthorized,
+			endpoint,
+			"Invalid token",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return nil, 0, false
+	}
+	return claims, userId, true
+}
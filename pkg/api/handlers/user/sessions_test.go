@@ -0,0 +1,58 @@
+package user
+
+import (
+	"GolandRestApi/pkg/api/handlers/handlertest"
+	"net/http"
+	"testing"
+)
+
+// newSessionServer returns a test server serving the session routes of the authenticated user.
+func newSessionServer(t *testing.T) *handlertest.Server {
+	t.Helper()
+	s := handlertest.NewServer(t)
+	s.Access.Require(s.Router.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		ListSessions(s.Logger, s.Store, w, r)
+	}).Methods("GET"))
+	s.Access.Require(s.Router.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		RevokeOtherSessions(s.Logger, s.Store, s.Store, w, r)
+	}).Methods("DELETE"))
+	s.Access.Require(s.Router.HandleFunc("/sessions/{sessionId}", func(w http.ResponseWriter, r *http.Request) {
+		RevokeSession(s.Logger, s.Store, s.Store, w, r)
+	}).Methods("DELETE"))
+	return s
+}
+
+func TestRevokeSessionDeniesItsAccessTokens(t *testing.T) {
+	s := newSessionServer(t)
+	laptop := s.Login(t, "admin").AccessToken
+	phone := s.Login(t, "admin")
+
+	if code := s.Do(t, "DELETE", "/sessions/"+phone.SessionId, laptop); code != http.StatusOK {
+		t.Fatalf("DELETE /sessions/{sessionId} = %d, want 200", code)
+	}
+	if code := s.Do(t, "GET", "/sessions", phone.AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("access token of the revoked session = %d, want 401", code)
+	}
+	if code := s.Do(t, "GET", "/sessions", laptop); code != http.StatusOK {
+		t.Errorf("access token of the other session = %d, want 200", code)
+	}
+}
+
+func TestRevokeOtherSessionsDeniesTheirAccessTokens(t *testing.T) {
+	s := newSessionServer(t)
+	laptop := s.Login(t, "admin").AccessToken
+	phone := s.Login(t, "admin").AccessToken
+	tablet := s.Login(t, "admin").AccessToken
+
+	if code := s.Do(t, "DELETE", "/sessions", laptop); code != http.StatusOK {
+		t.Fatalf("DELETE /sessions = %d, want 200", code)
+	}
+	for name, token := range map[string]string{"phone": phone, "tablet": tablet} {
+		if code := s.Do(t, "GET", "/sessions", token); code != http.StatusUnauthorized {
+			t.Errorf("access token of the revoked %s session = %d, want 401", name, code)
+		}
+	}
+	if code := s.Do(t, "GET", "/sessions", laptop); code != http.StatusOK {
+		t.Errorf("access token of the current session = %d, want 200", code)
+	}
+}
@@ -0,0 +1,414 @@
+package user
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EnrollTOTP handles the enrollment of the authenticated user in TOTP two-factor authentication. A new secret is
+// generated and stored encrypted, pending until it is confirmed with a first code at /user/mfa/totp/confirm.
+// Enrolling again replaces a pending secret.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// totps: The TOTPStore holding the enrollment.
+// cfg: A pointer to the config.Config struct which contains the TOTP configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token.
+//
+// Responds with 201, the secret and its otpauth:// URI to render as a QR code, or 409 if two-factor
+// authentication is already enabled.
+func EnrollTOTP(logger *logrus.Logger,
+	totps repository.TOTPStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/mfa/totp")
+	if !ok {
+		return
+	}
+
+	secret, err := service.NewTOTPSecret()
+	var encrypted string
+	if err == nil {
+		encrypted, err = service.EncryptTOTPSecret(cfg, userId, secret)
+	}
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/mfa/totp",
+			"Error generating the TOTP secret",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+
+	err = totps.SaveTOTP(model.TOTP{UserID: userId, EncryptedSecret: encrypted})
+	if errors.Is(err, repository.ErrConflict) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusConflict,
+			"/user/mfa/totp",
+			"Two-factor authentication is already enabled, disable it first",
+			err,
+			utils.LogTypeInfo,
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			totpStoreErrorStatus(err),
+			"/user/mfa/totp",
+			"Error storing the TOTP secret",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+
+	logger.WithField("username", claims.Username).Info("TOTP enrollment started")
+	service.HttpJSONResponse(logger, w, http.StatusCreated, "/user/mfa/totp", map[string]string{
+		"secret":      secret,
+		"otpauth_uri": service.TOTPURI(cfg, claims.Username, secret),
+	}, claims.Username)
+}
+
+// ConfirmTOTP handles the confirmation of the pending TOTP enrollment of the authenticated user with a first
+// code of its authenticator app. Two-factor authentication is then enabled and one-time recovery codes are
+// generated; they are only shown in this response and stored hashed.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// totps: The TOTPStore holding the enrollment.
+// cfg: A pointer to the config.Config struct which contains the TOTP configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token, containing the code in JSON format, e.g.
+// {"code": "123456"}.
+//
+// Responds with 200 and the recovery codes, 400 if the code is invalid, 404 if no enrollment is pending and 409
+// if two-factor authentication is already enabled.
+func ConfirmTOTP(logger *logrus.Logger,
+	totps repository.TOTPStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/mfa/totp/confirm")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/mfa/totp/confirm",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	totp, err := totps.GetTOTP(userId)
+	if errors.Is(err, repository.ErrNotFound) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			"/user/mfa/totp/confirm",
+			"No pending two-factor enrollment, start one first",
+			err,
+			utils.LogTypeInfo,
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/mfa/totp/confirm",
+			"Error retrieving the two-factor enrollment",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	} else if totp.Enabled {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusConflict,
+			"/user/mfa/totp/confirm",
+			"Two-factor authentication is already enabled",
+			nil,
+			utils.LogTypeInfo,
+			claims.Username)
+		return
+	}
+
+	secret, err := service.DecryptTOTPSecret(cfg, userId, totp.EncryptedSecret)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/mfa/totp/confirm",
+			"Error decrypting the TOTP secret",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+	step, valid := service.ValidateTOTPCode(secret, strings.TrimSpace(request.Code), time.Now())
+	if !valid {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/mfa/totp/confirm",
+			"Invalid authentication code",
+			nil,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	codes, hashes, err := service.NewRecoveryCodes(cfg)
+	if err == nil {
+		err = totps.EnableTOTP(userId, step, hashes)
+	}
+	if errors.Is(err, repository.ErrConflict) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusConflict,
+			"/user/mfa/totp/confirm",
+			"Two-factor authentication is already enabled",
+			err,
+			utils.LogTypeInfo,
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			totpStoreErrorStatus(err),
+			"/user/mfa/totp/confirm",
+			"Error enabling two-factor authentication",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+
+	logger.WithField("username", claims.Username).Info("TOTP enabled")
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/mfa/totp/confirm", map[string]interface{}{
+		"message":        "Two-factor authentication enabled, keep the recovery codes in a safe place",
+		"recovery_codes": codes,
+	}, claims.Username)
+}
+
+// GetTOTPStatus handles the retrieval of the two-factor authentication status of the authenticated user.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// totps: The TOTPStore holding the enrollment.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token.
+//
+// Responds with 200 and whether two-factor authentication is enabled, with the dates of the enrollment and the
+// number of recovery codes left if the user has one.
+func GetTOTPStatus(logger *logrus.Logger,
+	totps repository.TOTPStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/mfa/totp")
+	if !ok {
+		return
+	}
+
+	totp, err := totps.GetTOTP(userId)
+	if errors.Is(err, repository.ErrNotFound) {
+		service.HttpJSONResponse(logger, w, http.StatusOK, "/user/mfa/totp", map[string]bool{"enabled": false},
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/mfa/totp",
+			"Error retrieving the two-factor enrollment",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/mfa/totp", totp, claims.Username)
+}
+
+// DisableTOTP handles the removal of the TOTP enrollment of the authenticated user, pending or enabled, with its
+// recovery codes. The password is asked again and, if two-factor authentication is enabled, a code of the
+// authenticator app or a recovery code, so a stolen access token is not enough to remove it.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// totps: The TOTPStore holding the enrollment.
+// cfg: A pointer to the config.Config struct which contains the TOTP configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token, containing the password and the code in JSON format,
+// e.g. {"password": "secret", "code": "123456"}.
+//
+// Responds with 200, 403 if the password or the code is wrong and 404 if the user has no enrollment.
+func DisableTOTP(logger *logrus.Logger,
+	users repository.UserStore,
+	totps repository.TOTPStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/mfa/totp")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/mfa/totp",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	user, ok := requestUser(logger, users, w, userId, claims.Username, "/user/mfa/totp")
+	if !ok {
+		return
+	}
+	if err := service.CheckPasswordHash(logger, user, request.Password); err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/user/mfa/totp",
+			"Invalid password",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	totp, err := totps.GetTOTP(userId)
+	if err == nil && totp.Enabled {
+		var valid bool
+		valid, err = checkSecondFactor(totps, cfg, totp, request.Code)
+		if err == nil && !valid {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusForbidden,
+				"/user/mfa/totp",
+				"Invalid authentication code",
+				nil,
+				utils.LogTypeWarn,
+				claims.Username)
+			return
+		}
+	}
+	if err == nil {
+		err = totps.DeleteTOTP(userId)
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			"/user/mfa/totp",
+			"Two-factor authentication is not enabled",
+			err,
+			utils.LogTypeInfo,
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/mfa/totp",
+			"Error disabling two-factor authentication",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+
+	logger.WithField("username", claims.Username).Info("TOTP disabled")
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/user/mfa/totp", "Two-factor authentication disabled",
+		claims.Username)
+}
+
+// checkSecondFactor checks a code against the enabled TOTP of a user. A code of 6 digits is checked as a TOTP
+// code, whose time step is recorded so it cannot be replayed; any other code as a recovery code, which is used up.
+//
+// totps: The TOTPStore holding the enrollment and the recovery codes.
+// cfg: A pointer to the config.Config struct which contains the TOTP configuration.
+// totp: The enabled TOTP of the user.
+// code: The code given by the user.
+//
+// Returns whether the code is valid, and an error if it cannot be checked.
+func checkSecondFactor(totps repository.TOTPStore, cfg *config.Config, totp *model.TOTP, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	var err error
+	if isTOTPCode(code) {
+		var secret string
+		if secret, err = service.DecryptTOTPSecret(cfg, totp.UserID, totp.EncryptedSecret); err != nil {
+			return false, err
+		}
+		step, valid := service.ValidateTOTPCode(secret, code, time.Now())
+		if !valid {
+			return false, nil
+		}
+		err = totps.UseTOTPStep(totp.UserID, step)
+	} else {
+		err = totps.UseRecoveryCode(totp.UserID, service.HashRecoveryCode(cfg, code))
+	}
+
+	// A used code, or an enrollment removed in the meantime, is only an invalid code
+	if errors.Is(err, repository.ErrConflict) || errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isTOTPCode reports whether a code has the form of a TOTP code, 6 digits, rather than of a recovery code.
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// totpStoreErrorStatus maps an error of the TOTPStore to an HTTP status: 404 if the user is gone, 500 otherwise.
+func totpStoreErrorStatus(err error) int {
+	if errors.Is(err, repository.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,166 @@
+package user
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// resendVerificationMessage is the response to every valid resend request, whether an unverified account uses
+// the email address or not, so the endpoint cannot be used to find out which addresses are registered.
+const resendVerificationMessage = "If an unverified account uses this email address, a verification was sent to it"
+
+// VerifyEmail handles the verification of the email address of a user with the token sent to it at
+// registration, after an email change or by ResendVerification. The token is read from the token query
+// parameter, so the link sent to the user can point to this endpoint, or from the JSON body of a POST request.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore holding the user.
+// keys: The Keyring holding the keys verifying the token.
+// cfg: A pointer to the config.Config struct which contains the JWT configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, e.g. GET /user/verify?token=<token> or POST /user/verify with {"token": "<token>"}.
+//
+// Responds with 200 once the address is verified, also if it already was, 400 if the token is missing, invalid
+// or expired, and 409 if the email address of the user changed since the token was sent.
+func VerifyEmail(logger *logrus.Logger,
+	users repository.UserStore,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	token := r.URL.Query().Get("token")
+	if token == "" && r.Method == http.MethodPost {
+		var request struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusBadRequest,
+				"/user/verify",
+				"Invalid request format",
+				err,
+				utils.LogTypeWarn,
+				"")
+			return
+		}
+		token = request.Token
+	}
+	if token == "" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/verify",
+			"The verification token is required",
+			nil,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	claims, err := service.VerifyToken(logger, cfg, keys, token, utils.EmailVerificationToken)
+	var userId int
+	if err == nil {
+		userId, err = claims.UserId()
+	}
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/verify",
+			"Invalid or expired verification token",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	err = users.VerifyEmail(userId, claims.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/verify",
+			"Invalid or expired verification token",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpRepositoryErrorResponse(logger, w, "/user/verify", "Error verifying the email address", err,
+			claims.Username)
+		return
+	}
+
+	logger.WithField("username", claims.Username).Info("Email address verified")
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/user/verify", "Email address verified", claims.Username)
+}
+
+// ResendVerification handles the request of a new email verification token, e.g. when the previous one expired.
+// If an account with an unverified address uses the email address, a new token is sent to it. The response is
+// the same whether such an account exists or not.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore used to find the user by email.
+// keys: The Keyring holding the key used to sign the token.
+// notifier: The Notifier delivering the token to the user.
+// cfg: A pointer to the config.Config struct which contains the email verification configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the email address in JSON format, e.g. {"email": "user@example.com"}.
+//
+// Responds with 202 once the request is processed and 400 if the request has no email address.
+func ResendVerification(logger *logrus.Logger,
+	users repository.UserStore,
+	keys *service.Keyring,
+	notifier service.Notifier,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/verify/resend",
+			"Invalid request format, the email address is required",
+			err,
+			utils.LogTypeWarn,
+			"")
+		return
+	}
+
+	user, err := users.GetUserByEmail(request.Email)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && user.EmailVerified) {
+		service.HttpMessageResponse(logger, w, http.StatusAccepted, "/user/verify/resend", resendVerificationMessage, "")
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/verify/resend",
+			"Error retrieving the user",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	// The response does not tell a failure apart, it would reveal that the account exists; errors are logged by
+	// SendEmailVerification
+	_ = service.SendEmailVerification(logger, cfg, keys, notifier, user)
+	service.HttpMessageResponse(logger, w, http.StatusAccepted, "/user/verify/resend", resendVerificationMessage,
+		user.Username)
+}
@@ -0,0 +1,538 @@
+package user
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxCredentialNameLength is the maximum length of the name of a passkey
+	maxCredentialNameLength = 255
+
+	// webAuthnLoginFailedMessage is the response to every rejected passkey login, whether the credential is
+	// unknown, the assertion is invalid or the account is locked.
+	webAuthnLoginFailedMessage = "Invalid passkey, or the account is temporarily locked"
+)
+
+// BeginWebAuthnRegistration handles the start of the registration of a passkey by the authenticated user. A
+// single-use challenge is stored, valid for WEBAUTHN_CHALLENGE_VALIDITY, and the options to pass to
+// navigator.credentials.create() are returned. The passkeys the user already registered are excluded.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore used to look up the user.
+// webauthn: The WebAuthnStore holding the challenges and the credentials.
+// cfg: A pointer to the config.Config struct which contains the WebAuthn configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token.
+//
+// Responds with 200 and the PublicKeyCredentialCreationOptions in JSON form, or 404 if the user no longer exists.
+func BeginWebAuthnRegistration(logger *logrus.Logger,
+	users repository.UserStore,
+	webauthn repository.WebAuthnStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/webauthn/register/begin")
+	if !ok {
+		return
+	}
+
+	user, err := users.GetUserById(userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusNotFound,
+			"/user/webauthn/register/begin",
+			"User not found",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/webauthn/register/begin",
+			"Error retrieving the user",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+
+	credentials, err := webauthn.ListWebAuthnCredentials(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			"/user/webauthn/register/begin",
+			"Error listing the passkeys",
+			err,
+			claims.Username)
+		return
+	}
+
+	challenge, ok := newWebAuthnChallenge(logger, webauthn, cfg, w, userId, utils.WebAuthnRegistration,
+		"/user/webauthn/register/begin", claims.Username)
+	if !ok {
+		return
+	}
+	options, err := service.NewWebAuthnCreationOptions(cfg, user, challenge, credentials)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/webauthn/register/begin",
+			"Error building the registration options",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/webauthn/register/begin", options, claims.Username)
+}
+
+// FinishWebAuthnRegistration handles the end of the registration of a passkey by the authenticated user: the
+// credential created by the authenticator is verified against the challenge issued by BeginWebAuthnRegistration,
+// which is used up, and stored with its public key and signature counter.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// webauthn: The WebAuthnStore holding the challenges and the credentials.
+// cfg: A pointer to the config.Config struct which contains the WebAuthn configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token, containing the name of the passkey and the
+// PublicKeyCredential in JSON form, e.g. {"name": "Work laptop", "credential": {"id": "...", "type":
+// "public-key", "response": {"clientDataJSON": "...", "attestationObject": "..."}}}.
+//
+// Responds with 201 and the registered passkey, 400 if the credential is invalid or does not answer a pending
+// challenge of the user, and 409 if it is already registered.
+func FinishWebAuthnRegistration(logger *logrus.Logger,
+	webauthn repository.WebAuthnStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	claims, userId, ok := requestOwner(logger, w, r, "/user/webauthn/register/finish")
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name       string                               `json:"name"`
+		Credential service.WebAuthnRegistrationResponse `json:"credential"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || len(request.Name) > maxCredentialNameLength {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/webauthn/register/finish",
+			"Invalid request format",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	credential, challengeHash, err := service.VerifyWebAuthnRegistration(cfg, &request.Credential)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/webauthn/register/finish",
+			"Invalid passkey registration",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	}
+
+	challenge, err := webauthn.ConsumeWebAuthnChallenge(challengeHash, utils.WebAuthnRegistration)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && challenge.UserID != userId) {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/webauthn/register/finish",
+			"Invalid or expired challenge, start the registration again",
+			err,
+			utils.LogTypeWarn,
+			claims.Username)
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/webauthn/register/finish",
+			"Error checking the challenge",
+			err,
+			utils.LogTypeError,
+			claims.Username)
+		return
+	}
+
+	credential.UserID = userId
+	credential.Name = strings.TrimSpace(request.Name)
+	credential.CreatedAt = time.Now()
+	if err = webauthn.AddWebAuthnCredential(*credential); err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			"/user/webauthn/register/finish",
+			"Error storing the passkey",
+			err,
+			claims.Username)
+		return
+	}
+
+	logger.WithField("username", claims.Username).Info("Passkey registered")
+	service.HttpJSONResponse(logger, w, http.StatusCreated, "/user/webauthn/register/finish", credential, claims.Username)
+}
+
+// ListWebAuthnCredentials handles the listing of the passkeys of the authenticated user.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// webauthn: The WebAuthnStore holding the credentials.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request, authenticated with an access token.
+//
+// Responds with 200 and a JSON array of passkeys, oldest first.
+func ListWebAuthnCredentials(logger *logrus.Logger,
+	webauthn repository.WebAuthnStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	claims, userId, ok := requestOwner(logger, w, r, "/user/webauthn/credentials")
+	if !ok {
+		return
+	}
+
+	credentials, err := webauthn.ListWebAuthnCredentials(userId)
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			"/user/webauthn/credentials",
+			"Error listing the passkeys",
+			err,
+			claims.Username)
+		return
+	}
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/webauthn/credentials", credentials, claims.Username)
+}
+
+// DeleteWebAuthnCredential handles the removal of a passkey of the authenticated user, e.g. a lost device. The
+// passkey cannot be used to log in anymore; the sessions it started are left untouched.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// webauthn: The WebAuthnStore holding the credentials.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request containing the credential ID as a path variable, authenticated with an access token.
+//
+// Responds with 200 on success and 404 if the user has no such passkey.
+func DeleteWebAuthnCredential(logger *logrus.Logger,
+	webauthn repository.WebAuthnStore,
+	w http.ResponseWriter,
+	r *http.Request) {
+	claims, userId, ok := requestOwner(logger, w, r, "/user/webauthn/credentials")
+	if !ok {
+		return
+	}
+
+	if err := webauthn.DeleteWebAuthnCredential(userId, mux.Vars(r)["credentialId"]); err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			"/user/webauthn/credentials",
+			"Error removing the passkey",
+			err,
+			claims.Username)
+		return
+	}
+	service.HttpMessageResponse(logger, w, http.StatusOK, "/user/webauthn/credentials", "Passkey removed", claims.Username)
+}
+
+// BeginWebAuthnLogin handles the start of a login with a passkey. A single-use challenge is stored, valid for
+// WEBAUTHN_CHALLENGE_VALIDITY, and the options to pass to navigator.credentials.get() are returned. No username
+// is needed: the passkey picked by the user tells who logs in.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// webauthn: The WebAuthnStore holding the challenges.
+// cfg: A pointer to the config.Config struct which contains the WebAuthn configuration.
+// w: The http.ResponseWriter to write the response to.
+// r: The HTTP request.
+//
+// Responds with 200 and the PublicKeyCredentialRequestOptions in JSON form.
+func BeginWebAuthnLogin(logger *logrus.Logger,
+	webauthn repository.WebAuthnStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	challenge, ok := newWebAuthnChallenge(logger, webauthn, cfg, w, 0, utils.WebAuthnLogin,
+		"/user/webauthn/login/begin", "")
+	if !ok {
+		return
+	}
+	options, err := service.NewWebAuthnRequestOptions(cfg, challenge)
+	if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/webauthn/login/begin",
+			"Error building the login options",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	service.HttpJSONResponse(logger, w, http.StatusOK, "/user/webauthn/login/begin", options, "")
+}
+
+// FinishWebAuthnLogin handles the end of a login with a passkey: the assertion signed by the authenticator is
+// verified against the challenge issued by BeginWebAuthnLogin, which is used up, and a new session is started
+// with an access token and a refresh token, like a login with a password. The signature counter of the passkey
+// must increase, so a cloned authenticator is detected. As the authenticator verified the user, with a PIN or a
+// biometric, no TOTP code is asked. Rejected assertions count as failed logins of the account and of the client
+// IP address, which are locked out according to the lockout policy like on /user/login.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// users: The UserStore used to look up the user.
+// tokens: The TokenStore in which the session started by the login is stored.
+// denylist: The DenylistStore in which the access token is recorded, so logging out can deny it.
+// rbac: The RBACStore used to embed the roles and permissions of the user in the access token.
+// lockouts: The LockoutStore holding the failed login counters.
+// webauthn: The WebAuthnStore holding the challenges and the credentials.
+// policy: The LoginLockoutPolicy deciding when accounts and addresses are locked.
+// keys: The Keyring holding the key used to sign the tokens.
+// cfg: A pointer to the config.Config struct which contains JWT and WebAuthn configuration details.
+// w: The http.ResponseWriter to write the HTTP response.
+// r: The http.Request with the PublicKeyCredential in JSON form, e.g. {"credential": {"id": "...", "type":
+// "public-key", "response": {"clientDataJSON": "...", "authenticatorData": "...", "signature": "...",
+// "userHandle": "..."}}, "device": "Work laptop"}; the device label is optional.
+//
+// Responds with a JSON object containing the access token and refresh token, 401 if the assertion is rejected and
+// 429 if the client IP address is locked. With EMAIL_VERIFICATION_MODE set to block, it responds with 403 to a
+// user whose email address is not verified.
+func FinishWebAuthnLogin(logger *logrus.Logger,
+	users repository.UserStore,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	rbac repository.RBACStore,
+	lockouts repository.LockoutStore,
+	webauthn repository.WebAuthnStore,
+	policy *service.LoginLockoutPolicy,
+	keys *service.Keyring,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	r *http.Request) {
+
+	w.Header().Set("Content-Type", "application/json")
+	ip := service.ClientIP(r)
+
+	var request struct {
+		Credential service.WebAuthnLoginResponse `json:"credential"`
+		Device     string                        `json:"device"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || len(request.Device) > maxDeviceLabelLength {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusBadRequest,
+			"/user/webauthn/login/finish",
+			"Invalid request format",
+			err,
+			utils.LogTypeError,
+			"not able to get the username")
+		return
+	}
+
+	if ipLocked(logger, lockouts, w, ip, "", "/user/webauthn/login/finish") {
+		return
+	}
+
+	credential, err := webauthn.GetWebAuthnCredential(request.Credential.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		webAuthnLoginFailed(logger, lockouts, policy, w, 0, "", ip)
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/webauthn/login/finish",
+			"Server error retrieving the passkey",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	user, err := users.GetUserById(credential.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		webAuthnLoginFailed(logger, lockouts, policy, w, 0, "", ip)
+		return
+	} else if err != nil {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusInternalServerError,
+			"/user/webauthn/login/finish",
+			"Server error retrieving the user",
+			err,
+			utils.LogTypeError,
+			"")
+		return
+	}
+
+	signCount, challengeHash, err := service.VerifyWebAuthnLogin(cfg, &request.Credential, credential)
+	if err == nil && request.Credential.Response.UserHandle != "" &&
+		request.Credential.Response.UserHandle != service.WebAuthnUserHandle(user.ID) {
+		err = errors.New("user handle of another user")
+	}
+	if err == nil {
+		_, err = webauthn.ConsumeWebAuthnChallenge(challengeHash, utils.WebAuthnLogin)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			service.HttpErrorResponse(logger,
+				w,
+				http.StatusInternalServerError,
+				"/user/webauthn/login/finish",
+				"Server error checking the challenge",
+				err,
+				utils.LogTypeError,
+				user.Username)
+			return
+		}
+	}
+	if errors.Is(err, service.ErrWebAuthnSignCount) {
+		logger.WithField("username", user.Username).WithField("credentialId", credential.ID).
+			Warn("Passkey signature counter did not increase, the authenticator may be cloned")
+	}
+	if user.Locked(time.Now()) {
+		// Like on /login, a locked account is rejected even with a valid passkey and only the address is counted
+		logger.WithField("username", user.Username).Warn("Passkey login attempt on a locked account")
+		webAuthnLoginFailed(logger, lockouts, policy, w, 0, user.Username, ip)
+		return
+	}
+	if err != nil {
+		logger.WithError(err).WithField("username", user.Username).Warn("Passkey assertion rejected")
+		webAuthnLoginFailed(logger, lockouts, policy, w, user.ID, user.Username, ip)
+		return
+	}
+	if !accountUsable(logger, w, user, false, "/user/webauthn/login/finish") {
+		return
+	}
+
+	if cfg.EmailVerificationMode == utils.EmailVerificationBlock && !user.EmailVerified {
+		service.HttpErrorResponse(logger,
+			w,
+			http.StatusForbidden,
+			"/user/webauthn/login/finish",
+			"Email address not verified, follow the link sent to it or ask for a new one",
+			nil,
+			utils.LogTypeInfo,
+			user.Username)
+		return
+	}
+
+	if err = webauthn.UpdateWebAuthnSignCount(credential.ID, signCount); err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			"/user/webauthn/login/finish",
+			"Server error updating the passkey",
+			err,
+			user.Username)
+		return
+	}
+	if !clearLoginFailures(logger, lockouts, w, user, "/user/webauthn/login/finish") {
+		return
+	}
+	if startSession(logger, tokens, denylist, rbac, keys, cfg, w, r, user, request.Device, "/user/webauthn/login/finish") {
+		logger.WithField("username", user.Username).Info("User logged in with a passkey")
+	}
+}
+
+// newWebAuthnChallenge generates and stores the challenge of a WebAuthn ceremony.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// webauthn: The WebAuthnStore in which the challenge is stored.
+// cfg: A pointer to the config.Config struct which contains the WebAuthn configuration.
+// w: The http.ResponseWriter to write the response to.
+// userId: The ID of the user registering a passkey, or 0 for a login.
+// ceremony: The ceremony, utils.WebAuthnRegistration or utils.WebAuthnLogin.
+// endpoint: The endpoint starting the ceremony.
+// username: The username of the request, possibly empty.
+//
+// Returns the base64url-encoded challenge and true, or false if an error response was written.
+func newWebAuthnChallenge(logger *logrus.Logger,
+	webauthn repository.WebAuthnStore,
+	cfg *config.Config,
+	w http.ResponseWriter,
+	userId int,
+	ceremony string,
+	endpoint string,
+	username string) (string, bool) {
+	challenge, challengeHash, err := service.NewWebAuthnChallenge()
+	var expiresAt time.Time
+	if err == nil {
+		expiresAt, err = service.WebAuthnChallengeExpiry(cfg)
+	}
+	if err == nil {
+		err = webauthn.CreateWebAuthnChallenge(model.WebAuthnChallenge{
+			ChallengeHash: challengeHash,
+			UserID:        userId,
+			Ceremony:      ceremony,
+			ExpiresAt:     expiresAt,
+		})
+	}
+	if err != nil {
+		service.HttpRepositoryErrorResponse(logger,
+			w,
+			endpoint,
+			"Error generating the challenge",
+			err,
+			username)
+		return "", false
+	}
+	return challenge, true
+}
+
+// webAuthnLoginFailed records a rejected passkey login like a failed login and responds with 401 and
+// webAuthnLoginFailedMessage.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// lockouts: The LockoutStore holding the failed login counters.
+// policy: The LoginLockoutPolicy deciding when accounts and addresses are locked.
+// w: The http.ResponseWriter to write the response to.
+// userId: The ID of the account the failure counts against, or 0 to only count it against the address.
+// username: The username of the passkey, empty if it is unknown.
+// ip: The client IP address.
+func webAuthnLoginFailed(logger *logrus.Logger,
+	lockouts repository.LockoutStore,
+	policy *service.LoginLockoutPolicy,
+	w http.ResponseWriter,
+	userId int,
+	username string,
+	ip string) {
+	if err := policy.RecordLoginFailure(logger, lockouts, userId, username, ip); err != nil {
+		// The passkey is rejected anyway, a store error must not tell the failure apart
+		logger.WithError(err).WithField("username", username).Error("Error recording the failed passkey login")
+	}
+	service.HttpErrorResponse(logger,
+		w,
+		http.StatusUnauthorized,
+		"/user/webauthn/login/finish",
+		webAuthnLoginFailedMessage,
+		nil,
+		utils.LogTypeWarn,
+		username)
+}
@@ -0,0 +1,136 @@
+package user
+
+import (
+	"GolandRestApi/pkg/api/handlers/handlertest"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/service/webauthntest"
+	"net/http"
+	"testing"
+)
+
+// newWebAuthnServer returns a test server serving the passkey routes, for the origin http://localhost:8080.
+func newWebAuthnServer(t *testing.T) *handlertest.Server {
+	t.Helper()
+	s := handlertest.NewServer(t)
+	s.Config.WebAuthnRPID = "localhost"
+	s.Config.WebAuthnRPName = "GolandRestApi"
+	s.Config.WebAuthnOrigins = "http://localhost:8080"
+	s.Config.WebAuthnChallengeValidity = "5m"
+	policy := s.LockoutPolicy(t)
+
+	s.Access.Require(s.Router.HandleFunc("/webauthn/register/begin", func(w http.ResponseWriter, r *http.Request) {
+		BeginWebAuthnRegistration(s.Logger, s.Store, s.Store, s.Config, w, r)
+	}).Methods("POST"))
+	s.Access.Require(s.Router.HandleFunc("/webauthn/register/finish", func(w http.ResponseWriter, r *http.Request) {
+		FinishWebAuthnRegistration(s.Logger, s.Store, s.Config, w, r)
+	}).Methods("POST"))
+	s.Access.Public(s.Router.HandleFunc("/webauthn/login/begin", func(w http.ResponseWriter, r *http.Request) {
+		BeginWebAuthnLogin(s.Logger, s.Store, s.Config, w, r)
+	}).Methods("POST"))
+	s.Access.Public(s.Router.HandleFunc("/webauthn/login/finish", func(w http.ResponseWriter, r *http.Request) {
+		FinishWebAuthnLogin(s.Logger, s.Store, s.Store, s.Store, s.Store, s.Store, s.Store, policy, s.Keys, s.Config, w, r)
+	}).Methods("POST"))
+	return s
+}
+
+// registerPasskey registers a passkey of the authenticator for the user of the access token.
+func registerPasskey(t *testing.T, s *handlertest.Server, accessToken string, authenticator *webauthntest.Authenticator) {
+	t.Helper()
+	w := s.Serve(t, "POST", "/webauthn/register/begin", accessToken, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("register/begin = %d, want 200", w.Code)
+	}
+	var options service.WebAuthnCreationOptions
+	handlertest.Decode(t, w, &options)
+	credential, err := authenticator.Create(&options)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	request := map[string]interface{}{"name": "Work laptop", "credential": credential}
+	if code := s.Serve(t, "POST", "/webauthn/register/finish", accessToken, request).Code; code != http.StatusCreated {
+		t.Fatalf("register/finish = %d, want 201", code)
+	}
+}
+
+// beginLogin starts a passkey login and returns its options.
+func beginLogin(t *testing.T, s *handlertest.Server) *service.WebAuthnRequestOptions {
+	t.Helper()
+	w := s.Serve(t, "POST", "/webauthn/login/begin", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login/begin = %d, want 200", w.Code)
+	}
+	var options service.WebAuthnRequestOptions
+	handlertest.Decode(t, w, &options)
+	return &options
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	s := newWebAuthnServer(t)
+	accessToken := s.Login(t, "admin").AccessToken
+	authenticator, err := webauthntest.New("http://localhost:8080")
+	if err != nil {
+		t.Fatalf("webauthntest.New: %v", err)
+	}
+	authenticator.SignCount = 1
+	registerPasskey(t, s, accessToken, authenticator)
+
+	assertion, err := authenticator.Get(beginLogin(t, s))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	request := map[string]interface{}{"credential": assertion}
+	w := s.Serve(t, "POST", "/webauthn/login/finish", "", request)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login/finish = %d, want 200", w.Code)
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	handlertest.Decode(t, w, &tokens)
+	if code := s.Do(t, "GET", "/me", tokens.AccessToken); code != http.StatusOK {
+		t.Errorf("access token of the passkey login = %d, want 200", code)
+	}
+
+	// The challenge was used up, and the signature counter stored
+	if code := s.Serve(t, "POST", "/webauthn/login/finish", "", request).Code; code != http.StatusUnauthorized {
+		t.Errorf("replayed assertion = %d, want 401", code)
+	}
+}
+
+func TestWebAuthnRejectsChallengesNotIssued(t *testing.T) {
+	s := newWebAuthnServer(t)
+	accessToken := s.Login(t, "admin").AccessToken
+	authenticator, err := webauthntest.New("http://localhost:8080")
+	if err != nil {
+		t.Fatalf("webauthntest.New: %v", err)
+	}
+	authenticator.SignCount = 1
+
+	w := s.Serve(t, "POST", "/webauthn/register/begin", accessToken, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("register/begin = %d, want 200", w.Code)
+	}
+	var options service.WebAuthnCreationOptions
+	handlertest.Decode(t, w, &options)
+	options.Challenge, _, _ = service.NewWebAuthnChallenge()
+	credential, err := authenticator.Create(&options)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	request := map[string]interface{}{"name": "Forged", "credential": credential}
+	if code := s.Serve(t, "POST", "/webauthn/register/finish", accessToken, request).Code; code != http.StatusBadRequest {
+		t.Errorf("registration answering a challenge not issued = %d, want 400", code)
+	}
+
+	registerPasskey(t, s, accessToken, authenticator)
+	loginOptions := beginLogin(t, s)
+	loginOptions.Challenge, _, _ = service.NewWebAuthnChallenge()
+	assertion, err := authenticator.Get(loginOptions)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	request = map[string]interface{}{"credential": assertion}
+	if code := s.Serve(t, "POST", "/webauthn/login/finish", "", request).Code; code != http.StatusUnauthorized {
+		t.Errorf("login answering a challenge not issued = %d, want 401", code)
+	}
+}
@@ -6,6 +6,9 @@ type Config struct {
 	ServerPort int
 	APIVersion string
 
+	// Storage Configuration
+	StorageBackend string
+
 	// Database Configuration
 	DBHost     string
 	DBPort     int
@@ -13,11 +16,87 @@ type Config struct {
 	DBPassword string
 	DBName     string
 
+	// DBAutoMigrate applies the pending schema migrations when the server starts
+	DBAutoMigrate bool
+
 	// Logging Configuration
 	LogDir string
 
 	// JWT Configuration
+	JWTSigningAlgorithm     string
 	JWTSecretKey            string
+	JWTSecretKeyFile        string
+	JWTPrivateKeyFile       string
+	JWTVerificationKeyFiles string
+	JWTKeyID                string
+	JWTIssuer               string
+	JWTAudience             string
 	JWTExpirationTime       string
 	JWTRefreshTokenValidity string
+	RefreshTokenHashKey     string
+
+	// Password Reset Configuration
+	PasswordResetTokenValidity string
+	PasswordResetURL           string
+
+	// Login Lockout Configuration
+	LoginLockoutAccountThreshold int
+	LoginLockoutIPThreshold      int
+	LoginLockoutDuration         string
+	LoginLockoutMaxDuration      string
+	LoginFailureReset            string
+
+	// User Deletion Configuration
+	DeletedUserRetention string
+
+	// Registration Configuration
+	RegistrationMode string
+
+	// Email Verification Configuration
+	EmailVerificationMode          string
+	EmailVerificationTokenValidity string
+	EmailVerificationURL           string
+
+	// TOTP Configuration
+	TOTPIssuer        string
+	TOTPEncryptionKey string
+	MFATokenValidity  string
+
+	// WebAuthn Configuration
+	WebAuthnRPID              string
+	WebAuthnRPName            string
+	WebAuthnOrigins           string
+	WebAuthnChallengeValidity string
+
+	// OAuth Configuration
+	OAuthIssuerURL    string
+	OAuthCodeValidity string
+
+	// Federation Configuration
+	FederationProviders     []UpstreamProvider
+	FederationAutoProvision bool
+	FederationDefaultRole   string
+	FederationStateValidity string
+
+	// Notifier Configuration
+	Notifier     string
+	NotifierFile string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+}
+
+// UpstreamProvider is the configuration of an upstream OpenID Connect identity provider the users can log in with,
+// read from the FEDERATION_<NAME>_* environment variables of a name listed in FEDERATION_PROVIDERS.
+type UpstreamProvider struct {
+	// Name identifies the provider in the routes, e.g. /user/login/{name}
+	Name string
+
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       string
 }
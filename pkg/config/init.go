@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // NewConfig creates a new configuration instance for the application based on environment variables.
@@ -23,18 +24,95 @@ func NewConfig() *Config {
 		log.Fatalf("SERVER_PORT environment variable is not set or invalid")
 	}
 
+	dbAutoMigrate, err := strconv.ParseBool(getEnv("DB_AUTO_MIGRATE", "false"))
+	if err != nil {
+		log.Fatalf("DB_AUTO_MIGRATE environment variable is invalid")
+	}
+
+	lockoutAccountThreshold, err := strconv.Atoi(getEnv("LOGIN_LOCKOUT_ACCOUNT_THRESHOLD", "5"))
+	if err != nil {
+		log.Fatalf("LOGIN_LOCKOUT_ACCOUNT_THRESHOLD environment variable is invalid")
+	}
+
+	lockoutIPThreshold, err := strconv.Atoi(getEnv("LOGIN_LOCKOUT_IP_THRESHOLD", "20"))
+	if err != nil {
+		log.Fatalf("LOGIN_LOCKOUT_IP_THRESHOLD environment variable is invalid")
+	}
+
+	federationAutoProvision, err := strconv.ParseBool(getEnv("FEDERATION_AUTO_PROVISION", "true"))
+	if err != nil {
+		log.Fatalf("FEDERATION_AUTO_PROVISION environment variable is invalid")
+	}
+
+	smtpPort, err := strconv.Atoi(getEnv("SMTP_PORT", "25"))
+	if err != nil {
+		log.Fatalf("SMTP_PORT environment variable is invalid")
+	}
+
 	return &Config{
 		ServerPort:              serverPort,
 		APIVersion:              getEnv("API_VERSION", "v1"),
+		StorageBackend:          getEnv("STORAGE_BACKEND", "mysql"),
 		DBHost:                  getEnv("DB_HOST", "localhost"),
 		DBPort:                  dbPort,
 		DBUser:                  getEnv("DB_USER", "defaultUser"),
 		DBPassword:              getEnv("DB_PASSWORD", ""),
 		DBName:                  getEnv("DB_NAME", "RestApi"),
+		DBAutoMigrate:           dbAutoMigrate,
 		LogDir:                  getEnv("LOG_DIR", "/var/log/restapi/"),
-		JWTSecretKey:            getEnv("JWT_SECRET_KEY", "defaultSecret"),
+		JWTSigningAlgorithm:     getEnv("JWT_SIGNING_ALGORITHM", "HS256"),
+		JWTSecretKey:            getEnv("JWT_SECRET_KEY", ""),
+		JWTSecretKeyFile:        getEnv("JWT_SECRET_KEY_FILE", ""),
+		JWTPrivateKeyFile:       getEnv("JWT_PRIVATE_KEY_FILE", ""),
+		JWTVerificationKeyFiles: getEnv("JWT_VERIFICATION_KEY_FILES", ""),
+		JWTKeyID:                getEnv("JWT_KEY_ID", ""),
+		JWTIssuer:               getEnv("JWT_ISSUER", "GolandRestApi"),
+		JWTAudience:             getEnv("JWT_AUDIENCE", "GolandRestApi"),
 		JWTExpirationTime:       getEnv("JWT_EXPIRATION_TIME", "15m"),
 		JWTRefreshTokenValidity: getEnv("JWT_REFRESH_TOKEN_VALIDITY", "7d"),
+		RefreshTokenHashKey:     getEnv("REFRESH_TOKEN_HASH_KEY", ""),
+
+		PasswordResetTokenValidity: getEnv("PASSWORD_RESET_TOKEN_VALIDITY", "30m"),
+		PasswordResetURL:           getEnv("PASSWORD_RESET_URL", ""),
+
+		LoginLockoutAccountThreshold: lockoutAccountThreshold,
+		LoginLockoutIPThreshold:      lockoutIPThreshold,
+		LoginLockoutDuration:         getEnv("LOGIN_LOCKOUT_DURATION", "1m"),
+		LoginLockoutMaxDuration:      getEnv("LOGIN_LOCKOUT_MAX_DURATION", "1h"),
+		LoginFailureReset:            getEnv("LOGIN_FAILURE_RESET", "24h"),
+
+		DeletedUserRetention: getEnv("DELETED_USER_RETENTION", "30d"),
+
+		RegistrationMode: getEnv("REGISTRATION_MODE", "standard"),
+
+		EmailVerificationMode:          getEnv("EMAIL_VERIFICATION_MODE", "off"),
+		EmailVerificationTokenValidity: getEnv("EMAIL_VERIFICATION_TOKEN_VALIDITY", "24h"),
+		EmailVerificationURL:           getEnv("EMAIL_VERIFICATION_URL", ""),
+
+		TOTPIssuer:        getEnv("TOTP_ISSUER", "GolandRestApi"),
+		TOTPEncryptionKey: getEnv("TOTP_ENCRYPTION_KEY", ""),
+		MFATokenValidity:  getEnv("MFA_TOKEN_VALIDITY", "5m"),
+
+		WebAuthnRPID:              getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:            getEnv("WEBAUTHN_RP_NAME", "GolandRestApi"),
+		WebAuthnOrigins:           getEnv("WEBAUTHN_ORIGINS", "http://localhost:8080"),
+		WebAuthnChallengeValidity: getEnv("WEBAUTHN_CHALLENGE_VALIDITY", "5m"),
+
+		OAuthIssuerURL:    getEnv("OAUTH_ISSUER_URL", "http://localhost:8080"),
+		OAuthCodeValidity: getEnv("OAUTH_CODE_VALIDITY", "1m"),
+
+		FederationProviders:     upstreamProviders(getEnv("FEDERATION_PROVIDERS", "")),
+		FederationAutoProvision: federationAutoProvision,
+		FederationDefaultRole:   getEnv("FEDERATION_DEFAULT_ROLE", "user"),
+		FederationStateValidity: getEnv("FEDERATION_STATE_VALIDITY", "10m"),
+
+		Notifier:     getEnv("NOTIFIER", "log"),
+		NotifierFile: getEnv("NOTIFIER_FILE", "notifications.log"),
+		SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:     smtpPort,
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "no-reply@localhost"),
 	}
 }
 
@@ -51,3 +129,30 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// upstreamProviders reads the configuration of the upstream identity providers. Every name of the comma-separated
+// list has its own FEDERATION_<NAME>_ISSUER_URL, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL and _SCOPES variables,
+// where <NAME> is the name in upper case with hyphens replaced by underscores.
+//
+// names: The value of FEDERATION_PROVIDERS, e.g. "corp,partner".
+//
+// Returns the configuration of every provider, in the order of the list.
+func upstreamProviders(names string) []UpstreamProvider {
+	var providers []UpstreamProvider
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "FEDERATION_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		providers = append(providers, UpstreamProvider{
+			Name:         name,
+			IssuerURL:    getEnv(prefix+"ISSUER_URL", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", ""),
+			Scopes:       getEnv(prefix+"SCOPES", "openid profile email"),
+		})
+	}
+	return providers
+}
@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// namePattern matches the characters allowed in a migration name.
+var namePattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Create writes the empty up and down scripts of a new migration, numbered after the last migration of the
+// directory. The binaries must be rebuilt to embed them.
+//
+// dir: The directory of the migration scripts, usually pkg/migrations/sql.
+// name: The description of the migration, e.g. "add user locale"; it is lowercased and its words are joined
+// with underscores.
+//
+// Returns the paths of the up and down scripts, or an error if the name is empty, the directory holds invalid
+// scripts or the files cannot be written.
+func Create(dir string, name string) (string, string, error) {
+	name = strings.Trim(namePattern.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", "", errors.New("the migration name must contain letters or digits")
+	}
+
+	existing, err := Load(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+	version := 1
+	if len(existing) > 0 {
+		version = existing[len(existing)-1].Version + 1
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%03d_%s", version, name))
+	upPath, downPath := base+".up.sql", base+".down.sql"
+	header := "# " + strings.ReplaceAll(name, "_", " ") + "\n\n"
+	if err := writeNewFile(upPath, header); err != nil {
+		return "", "", err
+	}
+	if err := writeNewFile(downPath, "# Reverts "+strings.TrimPrefix(header, "# ")); err != nil {
+		return "", "", err
+	}
+	return upPath, downPath, nil
+}
+
+// writeNewFile writes a file that must not exist yet.
+func writeNewFile(path string, content string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
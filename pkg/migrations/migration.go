@@ -0,0 +1,175 @@
+// Package migrations holds the versioned SQL schema of the MySQL storage backend and applies it to a database.
+// Every migration is a pair of scripts in the sql directory, embedded in the binaries: NNN_name.up.sql applies
+// the change and NNN_name.down.sql reverts it. Applied migrations are recorded in the schema_migrations table
+// with a checksum of their up script, so a script modified after it was applied is detected.
+package migrations
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// files holds the migration scripts of the application.
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+// scriptDir is the directory of the scripts in files.
+const scriptDir = "sql"
+
+// fileNamePattern matches the names of the migration scripts, e.g. 007_access_token_denylist.up.sql.
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Embedded returns the migrations embedded in the binary, in order.
+//
+// Returns the migrations, or an error if a script is misnamed or a version has no up script.
+func Embedded() ([]Migration, error) {
+	scripts, err := fs.Sub(files, scriptDir)
+	if err != nil {
+		return nil, err
+	}
+	return Load(scripts)
+}
+
+// Load reads the migration scripts at the root of a file system. Files that are not .sql files are ignored.
+//
+// fsys: The file system holding the scripts, e.g. os.DirFS("pkg/migrations/sql").
+//
+// Returns the migrations sorted by version, or an error if a script is misnamed, two versions share a number
+// with different names, or a version has no up script.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q, expected NNN_name.up.sql or NNN_name.down.sql",
+				entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %03d has two names, %q and %q", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %03d_%s has no up script", migration.Version, migration.Name)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// splitStatements splits a script into its statements, separated by semicolons. Semicolons inside quoted
+// strings, quoted identifiers and comments (#, -- and /* */) do not end a statement, and statements made only
+// of comments are dropped.
+//
+// script: The SQL script.
+//
+// Returns the statements, without their trailing semicolon.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	hasCode := false
+
+	flush := func() {
+		if hasCode {
+			statements = append(statements, strings.TrimSpace(current.String()))
+		}
+		current.Reset()
+		hasCode = false
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// Copy the quoted string up to its closing quote, skipping escaped characters
+			end := i + 1
+			for end < len(script) && script[end] != c {
+				if script[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			if end >= len(script) {
+				end = len(script) - 1
+			}
+			current.WriteString(script[i : end+1])
+			hasCode = true
+			i = end
+		case c == '#' || (c == '-' && strings.HasPrefix(script[i:], "-- ")):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			i += end - 1
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				hasCode = true
+			}
+		}
+	}
+	flush()
+	return statements
+}
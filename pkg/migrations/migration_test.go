@@ -0,0 +1,116 @@
+package migrations
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			name:   "statements",
+			script: "CREATE TABLE A (id INT);\nCREATE TABLE B (id INT);\n",
+			want:   []string{"CREATE TABLE A (id INT)", "CREATE TABLE B (id INT)"},
+		},
+		{
+			name:   "trailing statement without terminator",
+			script: "DELETE FROM A;\nDELETE FROM B\n",
+			want:   []string{"DELETE FROM A", "DELETE FROM B"},
+		},
+		{
+			name:   "empty script",
+			script: " \n\t\n",
+			want:   nil,
+		},
+		{
+			name:   "empty statements",
+			script: "DELETE FROM A;;\n;",
+			want:   []string{"DELETE FROM A"},
+		},
+		{
+			name:   "semicolons in quotes",
+			script: `INSERT INTO A VALUES ('a;b', "c;d");` + "\nSELECT `e;f` FROM A;",
+			want:   []string{`INSERT INTO A VALUES ('a;b', "c;d")`, "SELECT `e;f` FROM A"},
+		},
+		{
+			name:   "escaped quotes",
+			script: `INSERT INTO A VALUES ('it\'s; fine', "say \"hi;\"", 'back\\');SELECT 1;`,
+			want:   []string{`INSERT INTO A VALUES ('it\'s; fine', "say \"hi;\"", 'back\\')`, "SELECT 1"},
+		},
+		{
+			name:   "doubled quotes",
+			script: "INSERT INTO A VALUES ('it''s; fine');SELECT 1;",
+			want:   []string{"INSERT INTO A VALUES ('it''s; fine')", "SELECT 1"},
+		},
+		{
+			name:   "backslash in a quoted identifier",
+			script: "SELECT `a\\` FROM A;SELECT 1;",
+			want:   []string{"SELECT `a\\` FROM A", "SELECT 1"},
+		},
+		{
+			name: "line comments",
+			script: "# Creates A; and B\nCREATE TABLE A (id INT); -- the first; table\n" +
+				"-- Then B;\nCREATE TABLE B (id INT);",
+			want: []string{"CREATE TABLE A (id INT)", "CREATE TABLE B (id INT)"},
+		},
+		{
+			name:   "block comments",
+			script: "/* Creates A;\n and B; */\nCREATE TABLE A (id /* key; */ INT);\nCREATE TABLE B (id INT);",
+			want:   []string{"CREATE TABLE A (id  INT)", "CREATE TABLE B (id INT)"},
+		},
+		{
+			name:   "statement made only of comments",
+			script: "DELETE FROM A;\n# Nothing else;\n/* to do; */",
+			want:   []string{"DELETE FROM A"},
+		},
+		{
+			name:   "comment without newline at the end",
+			script: "DELETE FROM A; -- done",
+			want:   []string{"DELETE FROM A"},
+		},
+		{
+			name:   "double dash without a space",
+			script: "UPDATE A SET n = n--1;",
+			want:   []string{"UPDATE A SET n = n--1"},
+		},
+		{
+			name:   "unterminated quote",
+			script: "SELECT 'a;b",
+			want:   []string{"SELECT 'a;b"},
+		},
+		{
+			name:   "unterminated block comment",
+			script: "SELECT 1; /* to do;",
+			want:   []string{"SELECT 1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := splitStatements(test.script); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", test.script, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedScriptsSplit(t *testing.T) {
+	migrations, err := Embedded()
+	if err != nil {
+		t.Fatalf("Embedded: %v", err)
+	}
+	for _, migration := range migrations {
+		if statements := splitStatements(migration.Up); len(statements) == 0 {
+			t.Errorf("migration %03d_%s: the up script has no statement", migration.Version, migration.Name)
+		}
+		for _, statement := range append(splitStatements(migration.Up), splitStatements(migration.Down)...) {
+			if statement == "" {
+				t.Errorf("migration %03d_%s: empty statement", migration.Version, migration.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+// lockName is the name of the MySQL advisory lock held while migrations run, so two servers starting at the
+// same time do not apply the same migration twice.
+const lockName = "schema_migrations"
+
+// lockTimeout is the number of seconds to wait for the lock held by another process.
+const lockTimeout = 60
+
+// States of a migration reported by Status.
+const (
+	StatePending  = "pending"
+	StateApplied  = "applied"
+	StateModified = "modified"
+	StateMissing  = "missing"
+)
+
+var (
+	// ErrUnversionedDatabase is returned for a database created before migrations existed, i.e. holding the
+	// tables of the application but no schema_migrations table. It must be adopted with a baseline.
+	ErrUnversionedDatabase = errors.New("the database was created before migrations existed, " +
+		"run `migrate up -baseline <version>` with the version matching its schema")
+
+	// ErrChecksumMismatch is returned when the up script of an applied migration was modified since.
+	ErrChecksumMismatch = errors.New("migration modified after it was applied")
+
+	// ErrUnknownMigration is returned when the database has a migration applied that this binary does not know,
+	// e.g. after downgrading the application.
+	ErrUnknownMigration = errors.New("migration applied to the database is unknown to this version")
+)
+
+// MigrationStatus is the state of a migration in a database.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// appliedMigration is a row of the schema_migrations table.
+type appliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrator applies and reverts migrations on a MySQL database.
+type Migrator struct {
+	logger     *logrus.Logger
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator creates a Migrator for the migrations embedded in the binary.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// db: The database to migrate.
+//
+// Returns a pointer to the Migrator, or an error if the embedded migrations are invalid.
+func NewMigrator(logger *logrus.Logger, db *sql.DB) (*Migrator, error) {
+	migrations, err := Embedded()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{logger: logger, db: db, migrations: migrations}, nil
+}
+
+// Status reports the state of every migration: applied, pending, modified (applied with a different checksum)
+// or missing (applied but unknown to this binary). It does not change the database.
+//
+// Returns the statuses sorted by version, or ErrUnversionedDatabase if the database must be adopted first.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name, State: StatePending}
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+			status.State = StateApplied
+			if row.Checksum != migration.Checksum {
+				status.State = StateModified
+			}
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, row := range applied {
+		appliedAt := row.AppliedAt
+		statuses = append(statuses, MigrationStatus{Version: row.Version, Name: row.Name, State: StateMissing,
+			AppliedAt: &appliedAt})
+	}
+	sortStatuses(statuses)
+	return statuses, nil
+}
+
+// Up applies the pending migrations in order. Each migration is recorded once its script has run; MySQL
+// commits schema changes immediately, so a failing migration may be left half applied and must be fixed by
+// hand before running Up again.
+//
+// baseline: The version of the schema of a database created before migrations existed, whose migrations are
+// recorded as applied without running them, or 0. It is only accepted while no migration is recorded.
+//
+// Returns the number of migrations applied, or an error if an applied migration was modified or is unknown,
+// the database is unversioned and no baseline was given, or a script fails.
+func (m *Migrator) Up(baseline int) (int, error) {
+	ctx := context.Background()
+	conn, release, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	if baseline > 0 {
+		err = m.recordBaseline(ctx, conn, baseline)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.verify(applied); err != nil {
+		return 0, err
+	}
+	if err := m.createTable(ctx, conn); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := m.run(ctx, conn, migration, migration.Up); err != nil {
+			return count, err
+		}
+
+		_, err := conn.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+			migration.Version, migration.Name, migration.Checksum, time.Now().UTC())
+		if err != nil {
+			m.logger.WithError(err).WithField("version", migration.Version).Error("Error recording the migration")
+			return count, err
+		}
+		m.logger.WithFields(logrus.Fields{
+			"version": migration.Version,
+			"name":    migration.Name,
+		}).Info("Migration applied")
+		count++
+	}
+	return count, nil
+}
+
+// Down reverts the most recently applied migrations, newest first.
+//
+// steps: The number of migrations to revert.
+//
+// Returns the number of migrations reverted, or an error if a migration to revert was modified, is unknown to
+// this binary or has no down script, or a script fails.
+func (m *Migrator) Down(steps int) (int, error) {
+	ctx := context.Background()
+	conn, release, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.verify(applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == "" {
+			return count, fmt.Errorf("migration %03d_%s has no down script", migration.Version, migration.Name)
+		}
+		if err := m.run(ctx, conn, migration, migration.Down); err != nil {
+			return count, err
+		}
+
+		_, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+		if err != nil {
+			m.logger.WithError(err).WithField("version", migration.Version).Error("Error removing the migration")
+			return count, err
+		}
+		m.logger.WithFields(logrus.Fields{
+			"version": migration.Version,
+			"name":    migration.Name,
+		}).Info("Migration reverted")
+		count++
+	}
+	return count, nil
+}
+
+// Pending returns the number of migrations that Up would apply.
+//
+// Returns the number, or an error if the status of the database cannot be read.
+func (m *Migrator) Pending() (int, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if status.State == StatePending {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// run executes the statements of a script one by one.
+//
+// ctx: The context of the migration.
+// conn: The connection holding the migration lock.
+// migration: The migration the script belongs to, for logging.
+// script: The up or down script.
+//
+// Returns an error naming the failing statement, if any.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, script string) error {
+	for i, statement := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			m.logger.WithError(err).WithFields(logrus.Fields{
+				"version":   migration.Version,
+				"name":      migration.Name,
+				"statement": i + 1,
+			}).Error("Error running the migration")
+			return fmt.Errorf("migration %03d_%s, statement %d: %w", migration.Version, migration.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+// verify checks that every applied migration is known to this binary and was not modified since.
+//
+// applied: The applied migrations by version.
+//
+// Returns ErrUnknownMigration or ErrChecksumMismatch wrapped with the version, or nil.
+func (m *Migrator) verify(applied map[int]appliedMigration) error {
+	known := make(map[int]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+	for version, row := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("%03d_%s: %w", version, row.Name, ErrUnknownMigration)
+		}
+		if migration.Checksum != row.Checksum {
+			return fmt.Errorf("%03d_%s: %w", version, row.Name, ErrChecksumMismatch)
+		}
+	}
+	return nil
+}
+
+// recordBaseline records the migrations up to a version as applied without running them.
+//
+// ctx: The context of the migration.
+// conn: The connection holding the migration lock.
+// baseline: The last version already present in the schema.
+//
+// Returns an error if the version is unknown or migrations are already recorded.
+func (m *Migrator) recordBaseline(ctx context.Context, conn *sql.Conn, baseline int) error {
+	known := false
+	for _, migration := range m.migrations {
+		known = known || migration.Version == baseline
+	}
+	if !known {
+		return fmt.Errorf("unknown baseline version %d", baseline)
+	}
+
+	if err := m.createTable(ctx, conn); err != nil {
+		return err
+	}
+	var recorded int
+	err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations").Scan(&recorded)
+	if err != nil {
+		return err
+	}
+	if recorded > 0 {
+		return errors.New("a baseline can only be set on a database without recorded migrations")
+	}
+
+	now := time.Now().UTC()
+	for _, migration := range m.migrations {
+		if migration.Version > baseline {
+			break
+		}
+		_, err := conn.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+			migration.Version, migration.Name, migration.Checksum, now)
+		if err != nil {
+			return err
+		}
+	}
+	m.logger.WithField("version", baseline).Info("Migration baseline recorded")
+	return nil
+}
+
+// applied reads the schema_migrations table.
+//
+// ctx: The context of the migration.
+// conn: The connection to use.
+//
+// Returns the applied migrations by version, an empty map for an empty database, or ErrUnversionedDatabase if
+// the tables of the application exist without a schema_migrations table.
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	applied := make(map[int]appliedMigration)
+
+	versioned, err := tableExists(ctx, conn, "schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if !versioned {
+		unversioned, err := tableExists(ctx, conn, "USERS")
+		if err != nil {
+			return nil, err
+		}
+		if unversioned {
+			return nil, ErrUnversionedDatabase
+		}
+		return applied, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row appliedMigration
+		if err := rows.Scan(&row.Version, &row.Name, &row.Checksum, &row.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[row.Version] = row
+	}
+	return applied, rows.Err()
+}
+
+// createTable creates the schema_migrations table if it does not exist.
+func (m *Migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+                    version INT PRIMARY KEY,
+                    name VARCHAR(255) NOT NULL,
+                    checksum CHAR(64) NOT NULL,
+                    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`)
+	if err != nil {
+		m.logger.WithError(err).Error("Error creating the schema_migrations table")
+	}
+	return err
+}
+
+// lock takes the migration lock on a dedicated connection, since MySQL advisory locks belong to a connection.
+//
+// ctx: The context of the migration.
+//
+// Returns the connection, a function releasing the lock and closing the connection, and an error if the lock
+// cannot be taken within lockTimeout seconds.
+func (m *Migrator) lock(ctx context.Context) (*sql.Conn, func(), error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var locked sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, lockTimeout).Scan(&locked)
+	if err == nil && locked.Int64 != 1 {
+		err = errors.New("timeout waiting for the migration lock held by another process")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	release := func() {
+		if _, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName); err != nil {
+			m.logger.WithError(err).Warn("Error releasing the migration lock")
+		}
+		conn.Close()
+	}
+	return conn, release, nil
+}
+
+// tableExists reports whether a table exists in the current database.
+func tableExists(ctx context.Context, conn *sql.Conn, table string) (bool, error) {
+	var count int
+	err := conn.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?",
+		table).Scan(&count)
+	return count > 0, err
+}
+
+// sortStatuses sorts statuses by version.
+func sortStatuses(statuses []MigrationStatus) {
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+}
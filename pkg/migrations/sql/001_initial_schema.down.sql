@@ -0,0 +1,8 @@
+# Drops every table of the initial schema, and all the data with them.
+
+DROP TABLE USER_ROLE;
+DROP TABLE ROLE_PERMISSION;
+DROP TABLE PERMISSION;
+DROP TABLE ROLE;
+DROP TABLE USER_AUTH;
+DROP TABLE USERS;
@@ -1,8 +1,6 @@
-CREATE DATABASE IF NOT EXISTS RestApi;
+# Schema and default data of the first release: users, their single refresh token, and the role based access
+# control tables with the admin and user roles and the admin account (username: admin, password: admin).
 
-USE RestApi;
-
-# Add the default tables
 CREATE TABLE USERS (
                     id INT AUTO_INCREMENT PRIMARY KEY,
                     username VARCHAR(255) NOT NULL,
@@ -58,4 +56,4 @@ INSERT INTO ROLE_PERMISSION (role_id, permission_id) VALUES (2, 1);
 # Add admin default account
 INSERT INTO USERS (username, hashed_password, email, country, phone) VALUES ('admin', '$2a$10$H7POZPYUzJS15D2/XSq7f.QHsyZBeMetjZa6W8Yffbhz1vhmGLG9C', 'admin@example.com', 'Admin Country', '1234567890');
 
-INSERT INTO USER_ROLE (user_id, role_id) VALUES (1, 1);
\ No newline at end of file
+INSERT INTO USER_ROLE (user_id, role_id) VALUES (1, 1);
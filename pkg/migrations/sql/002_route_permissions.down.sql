@@ -0,0 +1,8 @@
+DELETE rp FROM ROLE_PERMISSION rp JOIN PERMISSION p ON p.id = rp.permission_id
+    WHERE p.name IN ('user:create', 'user:read', 'user:delete', 'role:read', 'role:write', 'role:assign');
+
+DELETE FROM PERMISSION
+    WHERE name IN ('user:create', 'user:read', 'user:delete', 'role:read', 'role:write', 'role:assign');
+
+ALTER TABLE PERMISSION DROP INDEX name;
+ALTER TABLE ROLE DROP INDEX name;
@@ -0,0 +1,12 @@
+# Permissions required by the API routes, all granted to the admin role. Role and permission names become unique.
+
+ALTER TABLE ROLE ADD UNIQUE (name);
+ALTER TABLE PERMISSION ADD UNIQUE (name);
+
+INSERT IGNORE INTO PERMISSION (name) VALUES ('user:create'), ('user:read'), ('user:delete'),
+                                            ('role:read'), ('role:write'), ('role:assign');
+
+INSERT IGNORE INTO ROLE_PERMISSION (role_id, permission_id)
+    SELECT r.id, p.id FROM ROLE r, PERMISSION p
+    WHERE r.name = 'admin' AND p.name IN ('user:create', 'user:read', 'user:delete',
+                                          'role:read', 'role:write', 'role:assign');
@@ -0,0 +1,3 @@
+DELETE rp FROM ROLE_PERMISSION rp JOIN PERMISSION p ON p.id = rp.permission_id WHERE p.name = 'key:manage';
+
+DELETE FROM PERMISSION WHERE name = 'key:manage';
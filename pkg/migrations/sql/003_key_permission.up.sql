@@ -0,0 +1,7 @@
+# Permission to manage the JWT signing keys through the API, granted to the admin role.
+
+INSERT IGNORE INTO PERMISSION (name) VALUES ('key:manage');
+
+INSERT IGNORE INTO ROLE_PERMISSION (role_id, permission_id)
+    SELECT r.id, p.id FROM ROLE r, PERMISSION p
+    WHERE r.name = 'admin' AND p.name = 'key:manage';
@@ -0,0 +1,8 @@
+# The refresh tokens of the token families cannot be stored as before, so every user has to log in again.
+
+DELETE FROM USER_AUTH;
+
+ALTER TABLE USER_AUTH DROP INDEX family_id,
+                      DROP COLUMN family_id,
+                      DROP COLUMN token_id,
+                      ADD COLUMN refresh_token VARCHAR(255);
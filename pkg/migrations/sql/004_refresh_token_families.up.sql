@@ -0,0 +1,9 @@
+# USER_AUTH stores the family and the jti of the current refresh token instead of the token itself.
+# Existing refresh tokens cannot be migrated, so every user has to log in again.
+
+DELETE FROM USER_AUTH;
+
+ALTER TABLE USER_AUTH DROP COLUMN refresh_token,
+                      ADD COLUMN family_id CHAR(32),
+                      ADD COLUMN token_id CHAR(32),
+                      ADD UNIQUE (family_id);
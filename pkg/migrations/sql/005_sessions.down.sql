@@ -0,0 +1,16 @@
+# Sessions cannot be merged back into one token family per user, so every user has to log in again.
+
+DELETE rp FROM ROLE_PERMISSION rp JOIN PERMISSION p ON p.id = rp.permission_id WHERE p.name = 'session:revoke';
+
+DELETE FROM PERMISSION WHERE name = 'session:revoke';
+
+DROP TABLE SESSION;
+
+CREATE TABLE USER_AUTH (
+                    user_id INT,
+                    family_id CHAR(32),
+                    token_id CHAR(32),
+                    PRIMARY KEY (user_id),
+                    UNIQUE (family_id),
+                    FOREIGN KEY (user_id) REFERENCES USERS(id)
+);
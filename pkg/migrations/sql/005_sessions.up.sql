@@ -0,0 +1,25 @@
+# USER_AUTH, which held one refresh token family per user, is replaced by SESSION, which holds one row per login.
+# Existing refresh tokens cannot be migrated, so every user has to log in again.
+
+DROP TABLE USER_AUTH;
+
+CREATE TABLE SESSION (
+                    id CHAR(32) PRIMARY KEY,
+                    user_id INT NOT NULL,
+                    token_id CHAR(32),
+                    device_label VARCHAR(255),
+                    user_agent VARCHAR(512),
+                    ip VARCHAR(45),
+                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    last_used_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    expires_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    revoked_at TIMESTAMP NULL DEFAULT NULL,
+                    INDEX (user_id),
+                    FOREIGN KEY (user_id) REFERENCES USERS(id)
+);
+
+INSERT IGNORE INTO PERMISSION (name) VALUES ('session:revoke');
+
+INSERT IGNORE INTO ROLE_PERMISSION (role_id, permission_id)
+    SELECT r.id, p.id FROM ROLE r, PERMISSION p
+    WHERE r.name = 'admin' AND p.name = 'session:revoke';
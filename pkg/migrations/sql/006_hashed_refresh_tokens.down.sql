@@ -0,0 +1,7 @@
+# Hashed refresh tokens cannot be turned back into refresh JWTs, so every session is revoked.
+
+DROP TABLE ROTATED_REFRESH_TOKEN;
+
+UPDATE SESSION SET token_hash = NULL, revoked_at = CURRENT_TIMESTAMP WHERE token_hash IS NOT NULL;
+
+ALTER TABLE SESSION CHANGE token_hash token_id CHAR(32);
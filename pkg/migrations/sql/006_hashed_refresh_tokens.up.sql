@@ -0,0 +1,16 @@
+# Refresh tokens are opaque random values, and SESSION only holds an HMAC-SHA256 of the current one, keyed with
+# REFRESH_TOKEN_HASH_KEY. The refresh JWTs issued before cannot be hashed after the fact, so every session is
+# revoked and every user has to log in again. ROTATED_REFRESH_TOKEN keeps the hashes of the rotated tokens to
+# detect their reuse.
+
+UPDATE SESSION SET token_id = NULL, revoked_at = CURRENT_TIMESTAMP WHERE token_id IS NOT NULL;
+
+ALTER TABLE SESSION CHANGE token_id token_hash CHAR(64);
+
+CREATE TABLE ROTATED_REFRESH_TOKEN (
+                    token_hash CHAR(64) PRIMARY KEY,
+                    session_id CHAR(32) NOT NULL,
+                    rotated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    INDEX (session_id),
+                    FOREIGN KEY (session_id) REFERENCES SESSION(id) ON DELETE CASCADE
+);
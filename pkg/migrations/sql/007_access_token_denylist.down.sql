@@ -0,0 +1 @@
+DROP TABLE ACCESS_TOKEN;
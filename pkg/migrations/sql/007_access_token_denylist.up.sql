@@ -0,0 +1,13 @@
+# ACCESS_TOKEN records every issued access token until it expires; denied_at is set when the token is revoked
+# before expiring. It has no foreign keys: the denied tokens of a deleted user must stay denied.
+
+CREATE TABLE ACCESS_TOKEN (
+                    jti CHAR(32) PRIMARY KEY,
+                    session_id CHAR(32) NOT NULL,
+                    user_id INT NOT NULL,
+                    expires_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    denied_at TIMESTAMP NULL DEFAULT NULL,
+                    INDEX (session_id),
+                    INDEX (user_id),
+                    INDEX (expires_at)
+);
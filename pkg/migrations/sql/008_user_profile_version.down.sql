@@ -0,0 +1,3 @@
+ALTER TABLE USERS
+                    DROP COLUMN updated_at,
+                    DROP COLUMN version;
@@ -0,0 +1,8 @@
+# The profile of a user (email, country, phone) can be changed through /user/me. Every change increments
+# version, which the clients send back with their changes, so two concurrent edits cannot overwrite each other.
+
+ALTER TABLE USERS
+                    ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+UPDATE USERS SET updated_at = COALESCE(date_created, CURRENT_TIMESTAMP);
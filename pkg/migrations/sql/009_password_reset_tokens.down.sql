@@ -0,0 +1 @@
+DROP TABLE PASSWORD_RESET_TOKEN;
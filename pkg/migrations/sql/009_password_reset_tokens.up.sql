@@ -0,0 +1,12 @@
+# PASSWORD_RESET_TOKEN holds the SHA-256 of the outstanding password reset tokens. A token is deleted when it is
+# used, together with the other tokens of its user, and expires at expires_at.
+
+CREATE TABLE PASSWORD_RESET_TOKEN (
+                    token_hash CHAR(64) PRIMARY KEY,
+                    user_id INT NOT NULL,
+                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    expires_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    INDEX (user_id),
+                    INDEX (expires_at),
+                    FOREIGN KEY (user_id) REFERENCES USERS(id) ON DELETE CASCADE
+);
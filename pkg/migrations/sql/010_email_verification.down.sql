@@ -0,0 +1,3 @@
+ALTER TABLE USERS
+                    DROP COLUMN email_verified_at,
+                    DROP COLUMN email_verified;
@@ -0,0 +1,8 @@
+# A registered user receives a link to verify its email address. Depending on EMAIL_VERIFICATION_MODE, users
+# who did not follow it cannot log in or get no permissions. The existing users are considered verified.
+
+ALTER TABLE USERS
+                    ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+                    ADD COLUMN email_verified_at TIMESTAMP NULL DEFAULT NULL;
+
+UPDATE USERS SET email_verified = TRUE, email_verified_at = CURRENT_TIMESTAMP;
@@ -0,0 +1,10 @@
+DELETE rp FROM ROLE_PERMISSION rp JOIN PERMISSION p ON p.id = rp.permission_id WHERE p.name = 'user:unlock';
+
+DELETE FROM PERMISSION WHERE name = 'user:unlock';
+
+DROP TABLE LOGIN_FAILURE_IP;
+
+ALTER TABLE USERS
+                    DROP COLUMN locked_until,
+                    DROP COLUMN last_failed_login_at,
+                    DROP COLUMN failed_logins;
@@ -0,0 +1,23 @@
+# Failed logins are counted per account, in USERS, and per client IP address, in LOGIN_FAILURE_IP. Past a
+# threshold the account or the address is locked until locked_until, for a window that doubles with every further
+# failure. A counter restarts when its last failure is old enough. Administrators unlock accounts with the
+# user:unlock permission.
+
+ALTER TABLE USERS
+                    ADD COLUMN failed_logins INT NOT NULL DEFAULT 0,
+                    ADD COLUMN last_failed_login_at TIMESTAMP NULL DEFAULT NULL,
+                    ADD COLUMN locked_until TIMESTAMP NULL DEFAULT NULL;
+
+CREATE TABLE LOGIN_FAILURE_IP (
+                    ip VARCHAR(45) PRIMARY KEY,
+                    failed_logins INT NOT NULL DEFAULT 0,
+                    last_failed_login_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    locked_until TIMESTAMP NULL DEFAULT NULL,
+                    INDEX (last_failed_login_at)
+);
+
+INSERT IGNORE INTO PERMISSION (name) VALUES ('user:unlock');
+
+INSERT IGNORE INTO ROLE_PERMISSION (role_id, permission_id)
+    SELECT r.id, p.id FROM ROLE r, PERMISSION p
+    WHERE r.name = 'admin' AND p.name = 'user:unlock';
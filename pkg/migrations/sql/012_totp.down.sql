@@ -0,0 +1,2 @@
+DROP TABLE TOTP_RECOVERY_CODE;
+DROP TABLE USER_TOTP;
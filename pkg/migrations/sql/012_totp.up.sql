@@ -0,0 +1,21 @@
+# USER_TOTP holds the TOTP second factor of the users, at most one per user. The secret is encrypted with
+# TOTP_ENCRYPTION_KEY. An enrollment is pending until it is confirmed with a first code, which enables it.
+# last_used_step is the time step of the last accepted code, so a code cannot be replayed.
+# TOTP_RECOVERY_CODE holds the keyed hashes of the one-time recovery codes of the users; a used code is deleted.
+
+CREATE TABLE USER_TOTP (
+                    user_id INT PRIMARY KEY,
+                    secret VARCHAR(255) NOT NULL,
+                    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    confirmed_at TIMESTAMP NULL DEFAULT NULL,
+                    last_used_step BIGINT NOT NULL DEFAULT 0,
+                    FOREIGN KEY (user_id) REFERENCES USERS(id) ON DELETE CASCADE
+);
+
+CREATE TABLE TOTP_RECOVERY_CODE (
+                    user_id INT NOT NULL,
+                    code_hash CHAR(64) NOT NULL,
+                    PRIMARY KEY (user_id, code_hash),
+                    FOREIGN KEY (user_id) REFERENCES USERS(id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE WEBAUTHN_CHALLENGE;
+DROP TABLE WEBAUTHN_CREDENTIAL;
@@ -0,0 +1,25 @@
+# WEBAUTHN_CREDENTIAL holds the passkeys of the users: the base64url-encoded credential ID, the COSE-encoded public
+# key and the last signature counter reported by the authenticator.
+# WEBAUTHN_CHALLENGE holds the SHA-256 of the outstanding challenges of the registration and login ceremonies. A
+# challenge is deleted when it is used and expires at expires_at; login challenges have no user.
+
+CREATE TABLE WEBAUTHN_CREDENTIAL (
+                    id VARCHAR(1400) CHARACTER SET ascii PRIMARY KEY,
+                    user_id INT NOT NULL,
+                    name VARCHAR(255) NOT NULL DEFAULT '',
+                    public_key BLOB NOT NULL,
+                    sign_count BIGINT NOT NULL DEFAULT 0,
+                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    last_used_at TIMESTAMP NULL DEFAULT NULL,
+                    INDEX (user_id),
+                    FOREIGN KEY (user_id) REFERENCES USERS(id) ON DELETE CASCADE
+);
+
+CREATE TABLE WEBAUTHN_CHALLENGE (
+                    challenge_hash CHAR(64) PRIMARY KEY,
+                    user_id INT NULL DEFAULT NULL,
+                    ceremony VARCHAR(16) NOT NULL,
+                    expires_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    INDEX (expires_at),
+                    FOREIGN KEY (user_id) REFERENCES USERS(id) ON DELETE CASCADE
+);
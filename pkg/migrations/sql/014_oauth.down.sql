@@ -0,0 +1,11 @@
+DELETE rp FROM ROLE_PERMISSION rp JOIN PERMISSION p ON p.id = rp.permission_id WHERE p.name = 'oauth:client';
+
+DELETE FROM PERMISSION WHERE name = 'oauth:client';
+
+ALTER TABLE SESSION
+                    DROP INDEX client_id,
+                    DROP COLUMN scope,
+                    DROP COLUMN client_id;
+
+DROP TABLE OAUTH_AUTHORIZATION_CODE;
+DROP TABLE OAUTH_CLIENT;
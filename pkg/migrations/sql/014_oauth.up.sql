@@ -0,0 +1,40 @@
+# OAUTH_CLIENT holds the applications delegating their login to the API. redirect_uris, scopes and grant_types
+# are space-separated lists; secret_hash is NULL for the public clients.
+# OAUTH_AUTHORIZATION_CODE holds the SHA-256 of the outstanding authorization codes, deleted when they are used.
+# The sessions started through the OAuth token endpoint record their client and scope. Administrators manage the
+# clients with the oauth:client permission.
+
+CREATE TABLE OAUTH_CLIENT (
+                    client_id VARCHAR(64) CHARACTER SET ascii PRIMARY KEY,
+                    secret_hash CHAR(64) NULL DEFAULT NULL,
+                    name VARCHAR(255) NOT NULL,
+                    redirect_uris TEXT NOT NULL,
+                    scopes VARCHAR(1024) NOT NULL DEFAULT '',
+                    grant_types VARCHAR(255) NOT NULL,
+                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE OAUTH_AUTHORIZATION_CODE (
+                    code_hash CHAR(64) PRIMARY KEY,
+                    client_id VARCHAR(64) CHARACTER SET ascii NOT NULL,
+                    user_id INT NOT NULL,
+                    redirect_uri VARCHAR(2048) NOT NULL,
+                    scope VARCHAR(1024) NOT NULL DEFAULT '',
+                    code_challenge VARCHAR(128) NOT NULL,
+                    nonce VARCHAR(255) NOT NULL DEFAULT '',
+                    expires_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    INDEX (expires_at),
+                    FOREIGN KEY (client_id) REFERENCES OAUTH_CLIENT(client_id) ON DELETE CASCADE,
+                    FOREIGN KEY (user_id) REFERENCES USERS(id) ON DELETE CASCADE
+);
+
+ALTER TABLE SESSION
+                    ADD COLUMN client_id VARCHAR(64) CHARACTER SET ascii NULL DEFAULT NULL,
+                    ADD COLUMN scope VARCHAR(1024) NOT NULL DEFAULT '',
+                    ADD INDEX (client_id);
+
+INSERT IGNORE INTO PERMISSION (name) VALUES ('oauth:client');
+
+INSERT IGNORE INTO ROLE_PERMISSION (role_id, permission_id)
+    SELECT r.id, p.id FROM ROLE r, PERMISSION p
+    WHERE r.name = 'admin' AND p.name = 'oauth:client';
@@ -0,0 +1,2 @@
+DROP TABLE FEDERATED_LOGIN_STATE;
+DROP TABLE EXTERNAL_IDENTITY;
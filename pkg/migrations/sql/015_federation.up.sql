@@ -0,0 +1,27 @@
+# EXTERNAL_IDENTITY links the users to their accounts at the upstream OpenID Connect identity providers: a subject
+# of a provider belongs to one user, and a user has at most one identity per provider.
+# FEDERATED_LOGIN_STATE holds the SHA-256 of the state of the outstanding logins through an upstream provider, with
+# their nonce and PKCE code verifier. A state is deleted when it is used and expires at expires_at; only the logins
+# linking an identity to an account have a user.
+
+CREATE TABLE EXTERNAL_IDENTITY (
+                    provider VARCHAR(64) CHARACTER SET ascii NOT NULL,
+                    subject VARCHAR(255) NOT NULL,
+                    user_id INT NOT NULL,
+                    email VARCHAR(255) NOT NULL DEFAULT '',
+                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    PRIMARY KEY (provider, subject),
+                    UNIQUE (user_id, provider),
+                    FOREIGN KEY (user_id) REFERENCES USERS(id) ON DELETE CASCADE
+);
+
+CREATE TABLE FEDERATED_LOGIN_STATE (
+                    state_hash CHAR(64) PRIMARY KEY,
+                    provider VARCHAR(64) CHARACTER SET ascii NOT NULL,
+                    user_id INT NULL DEFAULT NULL,
+                    nonce VARCHAR(64) CHARACTER SET ascii NOT NULL,
+                    code_verifier VARCHAR(128) CHARACTER SET ascii NOT NULL,
+                    expires_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    INDEX (expires_at),
+                    FOREIGN KEY (user_id) REFERENCES USERS(id) ON DELETE CASCADE
+);
@@ -0,0 +1,5 @@
+DELETE rp FROM ROLE_PERMISSION rp JOIN PERMISSION p ON p.id = rp.permission_id WHERE p.name = 'apikey:manage';
+
+DELETE FROM PERMISSION WHERE name = 'apikey:manage';
+
+DROP TABLE API_KEY;
@@ -0,0 +1,23 @@
+# API_KEY holds the API keys of the users, for machine-to-machine clients. Only the SHA-256 of the secret of a key
+# is stored; permissions is the space-separated list of the permissions the key grants, among those of its owner.
+# expires_at is NULL for a key that never expires and last_used_at NULL for a key that was never used.
+# Administrators manage the keys of other users with the apikey:manage permission.
+
+CREATE TABLE API_KEY (
+                    id CHAR(32) CHARACTER SET ascii PRIMARY KEY,
+                    user_id INT NOT NULL,
+                    name VARCHAR(255) NOT NULL,
+                    key_hash CHAR(64) NOT NULL,
+                    permissions TEXT NOT NULL,
+                    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    expires_at TIMESTAMP NULL DEFAULT NULL,
+                    last_used_at TIMESTAMP NULL DEFAULT NULL,
+                    INDEX (user_id),
+                    FOREIGN KEY (user_id) REFERENCES USERS(id) ON DELETE CASCADE
+);
+
+INSERT IGNORE INTO PERMISSION (name) VALUES ('apikey:manage');
+
+INSERT IGNORE INTO ROLE_PERMISSION (role_id, permission_id)
+    SELECT r.id, p.id FROM ROLE r, PERMISSION p
+    WHERE r.name = 'admin' AND p.name = 'apikey:manage';
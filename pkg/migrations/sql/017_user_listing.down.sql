@@ -0,0 +1,7 @@
+DROP INDEX idx_users_date_created ON USERS;
+
+DROP INDEX idx_users_country ON USERS;
+
+DROP INDEX idx_users_email ON USERS;
+
+DROP INDEX idx_users_username ON USERS;
@@ -0,0 +1,10 @@
+# Administrators list the users through GET /admin/users, filtered by country or creation date and sorted by
+# username, email, country or creation date. These indexes serve the filters and the sort orders.
+
+CREATE INDEX idx_users_username ON USERS (username);
+
+CREATE INDEX idx_users_email ON USERS (email);
+
+CREATE INDEX idx_users_country ON USERS (country);
+
+CREATE INDEX idx_users_date_created ON USERS (date_created);
@@ -0,0 +1,9 @@
+DELETE rp FROM ROLE_PERMISSION rp JOIN PERMISSION p ON p.id = rp.permission_id WHERE p.name = 'user:update';
+
+DELETE FROM PERMISSION WHERE name = 'user:update';
+
+DROP TABLE USER_CHANGE;
+
+ALTER TABLE USERS
+                    DROP COLUMN password_reset_required,
+                    DROP COLUMN status;
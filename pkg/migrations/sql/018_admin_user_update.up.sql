@@ -0,0 +1,26 @@
+# Administrators update users through PATCH /admin/users/{id} with the user:update permission. They can disable an
+# account, which can then no longer be used, and require its user to choose a new password before logging in with
+# a password again. Every change of a field is recorded in USER_CHANGE with the administrator who made it; the
+# changes are kept when the administrator is deleted, and deleted with the user they apply to.
+
+ALTER TABLE USERS
+                    ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active',
+                    ADD COLUMN password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE USER_CHANGE (
+                    id INT AUTO_INCREMENT PRIMARY KEY,
+                    user_id INT NOT NULL,
+                    actor_id INT NOT NULL,
+                    field VARCHAR(32) NOT NULL,
+                    old_value TEXT NOT NULL,
+                    new_value TEXT NOT NULL,
+                    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                    INDEX (user_id, changed_at),
+                    FOREIGN KEY (user_id) REFERENCES USERS(id) ON DELETE CASCADE
+);
+
+INSERT IGNORE INTO PERMISSION (name) VALUES ('user:update');
+
+INSERT IGNORE INTO ROLE_PERMISSION (role_id, permission_id)
+    SELECT r.id, p.id FROM ROLE r, PERMISSION p
+    WHERE r.name = 'admin' AND p.name = 'user:update';
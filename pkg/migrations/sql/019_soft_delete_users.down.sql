@@ -0,0 +1,5 @@
+UPDATE USERS SET status = 'disabled' WHERE status = 'deleted';
+
+ALTER TABLE USERS
+                    DROP INDEX idx_users_status_deleted_at,
+                    DROP COLUMN deleted_at;
@@ -0,0 +1,7 @@
+# Deleting a user marks it as deleted instead of removing its rows, so an administrator can restore it through
+# POST /admin/users/{id}/restore. Its sessions are removed at once, and the background purge job removes the
+# deleted users for good once they have been deleted for longer than DELETED_USER_RETENTION.
+
+ALTER TABLE USERS
+                    ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL,
+                    ADD INDEX idx_users_status_deleted_at (status, deleted_at);
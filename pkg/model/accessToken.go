@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// AccessToken records an access token issued by the API, identified by its jti, until it expires. Denied
+// tokens are rejected by the authentication middleware even though their signature and exp claim are valid.
+type AccessToken struct {
+	ID        string    `json:"jti"`
+	SessionID string    `json:"session_id"`
+	UserID    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Denied    bool      `json:"denied"`
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// APIKey is a long-lived credential of a user for machine-to-machine clients, e.g. a service account. The key is
+// made of its public ID and a secret, of which only the hash is stored. A key only grants the Permissions it was
+// created with, and only while its owner still holds them. ExpiresAt is nil for a key that never expires, and
+// LastUsedAt is nil for a key that was never used.
+type APIKey struct {
+	ID          string     `json:"id"`
+	UserID      int        `json:"user_id"`
+	Name        string     `json:"name"`
+	KeyHash     string     `json:"-"`
+	Permissions []string   `json:"permissions"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Expired reports whether the key can no longer be used at the given time.
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// APIKeyRequest is the request body of the endpoints creating an API key. ExpiresAt is nil for a key that never
+// expires.
+type APIKeyRequest struct {
+	Name        string     `json:"name"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
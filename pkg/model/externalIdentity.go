@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// ExternalIdentity links a user to its account at an upstream OpenID Connect identity provider, identified by the
+// sub claim of the provider. A user has at most one identity per provider. Email is the address the provider
+// reported when the identity was linked.
+type ExternalIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FederatedLoginState is the single-use state of a login through an upstream identity provider, from the
+// redirection of the user to the provider until its callback. Only the SHA-256 of the state parameter is stored,
+// with the nonce expected in the ID token and the PKCE code verifier sent with the code. A login to link an
+// identity to an account belongs to the user linking it; other logins have no user.
+type FederatedLoginState struct {
+	StateHash    string
+	Provider     string
+	UserID       int
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
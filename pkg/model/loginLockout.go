@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// LoginLockout is the failed login counter of an account or of a client IP address. Past a threshold of
+// failures, the account or the address cannot log in until LockedUntil. Exactly one of UserID and IP is set.
+type LoginLockout struct {
+	UserID       int       `json:"user_id,omitempty"`
+	Username     string    `json:"username,omitempty"`
+	IP           string    `json:"ip,omitempty"`
+	FailedLogins int       `json:"failed_logins"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+	LockedUntil  time.Time `json:"locked_until"`
+}
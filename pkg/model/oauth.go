@@ -0,0 +1,70 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// OAuthClient is an application registered to delegate the login of its users to the API through OAuth 2.0 and
+// OpenID Connect. A confidential client authenticates with a secret, of which only the hash is stored; a public
+// client, e.g. a single-page or mobile application, has none and relies on PKCE. A client can only use the grant
+// types, redirect URIs and scopes it was registered with.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	SecretHash   string    `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	GrantTypes   []string  `json:"grant_types"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Confidential reports whether the client authenticates with a secret.
+func (c *OAuthClient) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+// AllowsGrant reports whether the client was registered with a grant type.
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+// AllowsRedirectURI reports whether the client was registered with a redirect URI. URIs are compared as strings,
+// without any normalization.
+func (c *OAuthClient) AllowsRedirectURI(redirectURI string) bool {
+	return contains(c.RedirectURIs, redirectURI)
+}
+
+// AllowsScope reports whether the client was registered with every scope of a space-separated scope list.
+func (c *OAuthClient) AllowsScope(scope string) bool {
+	for _, requested := range strings.Fields(scope) {
+		if !contains(c.Scopes, requested) {
+			return false
+		}
+	}
+	return true
+}
+
+// contains reports whether values contains value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthAuthorizationCode is a single-use code issued by the authorization endpoint to a client, for a user, and
+// exchanged at the token endpoint before it expires. Only the hash of the code is stored, with the PKCE challenge
+// the client must answer and the OpenID Connect nonce to copy into the ID token.
+type OAuthAuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	Nonce         string
+	ExpiresAt     time.Time
+}
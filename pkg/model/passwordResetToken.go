@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// PasswordResetToken is a single-use token sent to a user who forgot its password, allowing to set a new one
+// until it expires. Only the SHA-256 of the token is stored.
+type PasswordResetToken struct {
+	TokenHash string    `json:"-"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
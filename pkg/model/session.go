@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// Session is a login of a user on one device. Its refresh tokens form a token family: every refresh rotates
+// the current token of the session, and TokenHash is the keyed hash of the only refresh token that may still
+// be used. The refresh token itself is never stored. LastUsedAt is updated at every refresh. The sessions started
+// through the OAuth token endpoint belong to the OAuth client ClientID and are limited to its Scope.
+type Session struct {
+	ID          string    `json:"id"`
+	UserID      int       `json:"user_id"`
+	DeviceLabel string    `json:"device_label,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	ClientID    string    `json:"client_id,omitempty"`
+	Scope       string    `json:"scope,omitempty"`
+	TokenHash   string    `json:"-"`
+	Revoked     bool      `json:"-"`
+
+	// Current is not stored: the handlers set it on the session of the access token of the caller
+	Current bool `json:"current"`
+}
+
+// Active reports whether the refresh token of the session can still be used at the given time.
+func (s *Session) Active(now time.Time) bool {
+	return !s.Revoked && now.Before(s.ExpiresAt)
+}
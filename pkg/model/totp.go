@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// TOTP is the TOTP second factor (RFC 6238) of a user. It is pending until the user confirms it with a first
+// code, which enables it: from then on, logging in requires a code of the authenticator app, or one of the
+// one-time recovery codes. The secret is stored encrypted and LastUsedStep prevents replaying a code.
+type TOTP struct {
+	UserID            int       `json:"-"`
+	EncryptedSecret   string    `json:"-"`
+	Enabled           bool      `json:"enabled"`
+	CreatedAt         time.Time `json:"created_at"`
+	ConfirmedAt       time.Time `json:"confirmed_at"`
+	LastUsedStep      int64     `json:"-"`
+	RecoveryCodesLeft int       `json:"recovery_codes_left"`
+}
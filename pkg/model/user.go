@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"GolandRestApi/pkg/utils"
+	"time"
+)
 
 type User struct {
 	ID             int       `json:"id"`
@@ -11,6 +14,31 @@ type User struct {
 	Country        string    `json:"country,omitempty"`
 	Phone          string    `json:"phone,omitempty"`
 	DateCreated    time.Time `json:"date_created"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Version        int       `json:"version"`
+	EmailVerified  bool      `json:"-"` // Only set by the server, never from a request
+
+	// Failed login counter of the account, see LoginLockout. LockedUntil is zero if the account was never locked.
+	FailedLogins      int       `json:"-"`
+	LastFailedLoginAt time.Time `json:"-"`
+	LockedUntil       time.Time `json:"-"`
+
+	// Status of the account, one of the utils.UserStatus* values, and whether the user must choose a new password
+	// before logging in with a password again. Both are only set by the administrators. DeletedAt is zero unless
+	// the account is deleted.
+	Status                string    `json:"-"`
+	PasswordResetRequired bool      `json:"-"`
+	DeletedAt             time.Time `json:"-"`
+}
+
+// Active reports whether the account can be used, i.e. it was neither disabled nor deleted.
+func (u *User) Active() bool {
+	return u.Status == utils.UserStatusActive
+}
+
+// Locked reports whether the account cannot log in at the given time because of failed logins.
+func (u *User) Locked(now time.Time) bool {
+	return now.Before(u.LockedUntil)
 }
 
 // NewUser is a constructor for User struct
@@ -23,5 +51,143 @@ func NewUser(username, password, hashedPassword, email, country, phone string) *
 		Country:        country,
 		Phone:          phone,
 		DateCreated:    time.Now(),
+		Status:         utils.UserStatusActive,
+	}
+}
+
+// Profile is the representation of a user returned by the API. Unlike User, it has no password field, so
+// neither the password nor its hash can ever be sent back to a client.
+type Profile struct {
+	ID            int       `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email,omitempty"`
+	Country       string    `json:"country,omitempty"`
+	Phone         string    `json:"phone,omitempty"`
+	DateCreated   time.Time `json:"date_created"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Version       int       `json:"version"`
+	EmailVerified bool      `json:"email_verified"`
+	Status        string    `json:"status"`
+
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	DeletedAt             *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Profile returns the representation of the user returned by the API.
+func (u *User) Profile() Profile {
+	profile := Profile{
+		ID:            u.ID,
+		Username:      u.Username,
+		Email:         u.Email,
+		Country:       u.Country,
+		Phone:         u.Phone,
+		DateCreated:   u.DateCreated,
+		UpdatedAt:     u.UpdatedAt,
+		Version:       u.Version,
+		EmailVerified: u.EmailVerified,
+		Status:        u.Status,
+
+		PasswordResetRequired: u.PasswordResetRequired,
+	}
+	if !u.DeletedAt.IsZero() {
+		deletedAt := u.DeletedAt
+		profile.DeletedAt = &deletedAt
+	}
+	return profile
+}
+
+// ProfileUpdate is a partial update of the profile of a user: only the non-nil fields are changed, and an
+// empty Country or Phone clears the field.
+type ProfileUpdate struct {
+	Email   *string `json:"email"`
+	Country *string `json:"country"`
+	Phone   *string `json:"phone"`
+}
+
+// ApplyProfileUpdate sets the fields of the user changed by a profile update. It does not touch the version.
+// A new email address is not verified, even if the previous one was.
+func (u *User) ApplyProfileUpdate(update ProfileUpdate) {
+	if update.Email != nil {
+		if *update.Email != u.Email {
+			u.EmailVerified = false
+		}
+		u.Email = *update.Email
 	}
+	if update.Country != nil {
+		u.Country = *update.Country
+	}
+	if update.Phone != nil {
+		u.Phone = *update.Phone
+	}
+}
+
+// AdminUserUpdate is a partial update of a user made by an administrator: the profile fields, the complete set
+// of roles, by name, and the status of the account. Only the non-nil fields are changed; an empty Roles removes
+// every role. ForcePasswordReset requires the user to choose a new password before logging in with a password
+// again.
+type AdminUserUpdate struct {
+	ProfileUpdate
+	Roles              *[]string `json:"roles"`
+	Status             *string   `json:"status"`
+	ForcePasswordReset bool      `json:"force_password_reset"`
+}
+
+// ApplyAdminUserUpdate sets the fields of the user changed by an administrator. The roles are not part of User
+// and are left to the caller. It does not touch the version.
+func (u *User) ApplyAdminUserUpdate(update AdminUserUpdate) {
+	u.ApplyProfileUpdate(update.ProfileUpdate)
+	if update.Status != nil {
+		u.Status = *update.Status
+	}
+	if update.ForcePasswordReset {
+		u.PasswordResetRequired = true
+	}
+}
+
+// UserChange records the change of a field of a user made by an administrator, or the deletion of its own
+// account by a user, with its value before and after the change. The roles are recorded as the comma-separated names of the roles, ordered by role ID.
+type UserChange struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	ActorID   int       `json:"actor_id"`
+	Field     string    `json:"field"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// UserSummary is the representation of a user in the user listing of the administrators: its profile and the
+// names of its roles.
+type UserSummary struct {
+	Profile
+	Roles []string `json:"roles"`
+}
+
+// UserQuery selects, sorts and paginates the users listed to the administrators. Empty or zero filters are not
+// applied. Search matches a substring of the username or of the email address, ignoring case, and Country
+// matches the whole country, ignoring case. CreatedFrom is inclusive and CreatedTo exclusive. The deleted users
+// are only listed when Status is utils.UserStatusDeleted.
+type UserQuery struct {
+	Role        string
+	Status      string
+	Country     string
+	Search      string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+
+	// Sort is the field the users are sorted by, one of the utils.UserSort* values; ties are broken by ID
+	Sort       string
+	Descending bool
+
+	// After, when set, only selects the users coming after it in the sort order
+	After *UserCursor
+	Limit int
+}
+
+// UserCursor is a position in a sorted user listing: the value of the sort field and the ID of the last user
+// of the previous page. Value is empty when sorting by ID, and a time formatted with time.RFC3339Nano when
+// sorting by creation date.
+type UserCursor struct {
+	Value string
+	ID    int
 }
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// WebAuthnCredential is a public key credential, or passkey, registered by a user to log in without a password.
+// Its ID is the base64url-encoded credential ID chosen by the authenticator and PublicKey the COSE-encoded public
+// key verifying its assertions. SignCount is the signature counter last reported by the authenticator.
+type WebAuthnCredential struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"-"`
+	Name       string    `json:"name"`
+	PublicKey  []byte    `json:"-"`
+	SignCount  uint32    `json:"sign_count"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// WebAuthnChallenge is a single-use challenge of a WebAuthn registration or login ceremony, valid until it
+// expires. Only the SHA-256 of the challenge is stored. Registration challenges belong to the user registering a
+// credential; login challenges have no user, the credential tells who logs in.
+type WebAuthnChallenge struct {
+	ChallengeHash string
+	UserID        int
+	Ceremony      string
+	ExpiresAt     time.Time
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const apiKeyColumns = "id, user_id, name, key_hash, permissions, created_at, expires_at, last_used_at"
+
+// CreateAPIKey stores a new API key of a user.
+//
+// key: The API key; its ID, UserID, Name, KeyHash and Permissions must be set. A zero CreatedAt is set to the
+// current time.
+//
+// Returns a NotFoundError if the user does not exist, a ConflictError if the ID is already stored, or any other
+// error returned by the database.
+func (s *MySQLStore) CreateAPIKey(key model.APIKey) error {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", key.UserID)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("user", key.UserID)
+	}
+	found, err = s.exists("SELECT COUNT(*) FROM API_KEY WHERE id = ?", key.ID)
+	if err != nil {
+		return err
+	} else if found {
+		return conflict("API key", key.ID, "already stored")
+	}
+
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	var expiresAt sql.NullTime
+	if key.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: key.ExpiresAt.UTC(), Valid: true}
+	}
+	query := "INSERT INTO API_KEY (id, user_id, name, key_hash, permissions, created_at, expires_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?)"
+	_, err = s.db.Exec(query, key.ID, key.UserID, key.Name, key.KeyHash, strings.Join(key.Permissions, " "),
+		key.CreatedAt.UTC(), expiresAt)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", key.UserID).Error("Error storing the API key")
+		return err
+	}
+
+	s.logger.WithField("userId", key.UserID).Info("API key created with success")
+	return nil
+}
+
+// GetAPIKey retrieves an API key by its ID.
+//
+// keyId: The public ID of the key.
+//
+// Returns the key, a NotFoundError if it does not exist, or any other error returned by the database.
+func (s *MySQLStore) GetAPIKey(keyId string) (*model.APIKey, error) {
+	row := s.db.QueryRow("SELECT "+apiKeyColumns+" FROM API_KEY WHERE id = ?", keyId)
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("API key", keyId)
+	} else if err != nil {
+		s.logger.WithError(err).Error("Error retrieving the API key")
+		return nil, err
+	}
+	return key, nil
+}
+
+// ListAPIKeys retrieves the API keys of a user, oldest first, including the expired ones.
+//
+// userId: The ID of the user.
+//
+// Returns the keys, a NotFoundError if the user does not exist, or any other error returned by the database.
+func (s *MySQLStore) ListAPIKeys(userId int) ([]model.APIKey, error) {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", userId)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		return nil, notFound("user", userId)
+	}
+
+	query := "SELECT " + apiKeyColumns + " FROM API_KEY WHERE user_id = ? ORDER BY created_at, id"
+	rows, err := s.db.Query(query, userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error listing the API keys")
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []model.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error scanning an API key")
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// TouchAPIKey records the time an API key was last used.
+//
+// keyId: The public ID of the key.
+// usedAt: The time the key was used.
+//
+// Returns a NotFoundError if the key does not exist, or any other error returned by the database.
+func (s *MySQLStore) TouchAPIKey(keyId string, usedAt time.Time) error {
+	found, err := s.exists("SELECT COUNT(*) FROM API_KEY WHERE id = ?", keyId)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("API key", keyId)
+	}
+
+	_, err = s.db.Exec("UPDATE API_KEY SET last_used_at = ? WHERE id = ?", usedAt.UTC(), keyId)
+	if err != nil {
+		s.logger.WithError(err).Error("Error updating the API key")
+		return err
+	}
+	return nil
+}
+
+// DeleteAPIKey deletes an API key of a user, which can no longer be used from then on.
+//
+// userId: The ID of the user owning the key.
+// keyId: The public ID of the key.
+//
+// Returns a NotFoundError if the user has no such key, or any other error returned by the database.
+func (s *MySQLStore) DeleteAPIKey(userId int, keyId string) error {
+	result, err := s.db.Exec("DELETE FROM API_KEY WHERE id = ? AND user_id = ?", keyId, userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error deleting the API key")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).
+			Error("Error getting the number of rows affected when trying to delete an API key")
+		return err
+	}
+	if rowsAffected == 0 {
+		return notFound("API key", keyId)
+	}
+
+	s.logger.WithField("userId", userId).Info("API key deleted with success")
+	return nil
+}
+
+// scanAPIKey reads an API key from a row selecting apiKeyColumns.
+func scanAPIKey(row rowScanner) (*model.APIKey, error) {
+	var key model.APIKey
+	var permissions string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.KeyHash, &permissions, &key.CreatedAt, &expiresAt,
+		&lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.Permissions = strings.Fields(permissions)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return &key, nil
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// RecordAccessToken stores an issued access token in the ACCESS_TOKEN table. The table has no foreign keys, so
+// the denied tokens of a deleted user stay denied until they expire.
+//
+// token: The access token; its ID, SessionID, UserID and ExpiresAt must be set.
+//
+// Returns a ConflictError if the jti is already recorded, or any other error returned by the database.
+func (s *MySQLStore) RecordAccessToken(token model.AccessToken) error {
+	found, err := s.exists("SELECT 1 FROM ACCESS_TOKEN WHERE jti = ?", token.ID)
+	if err != nil {
+		return err
+	}
+	if found {
+		return conflict("access token", token.ID, "already recorded")
+	}
+
+	query := "INSERT INTO ACCESS_TOKEN (jti, session_id, user_id, expires_at) VALUES (?, ?, ?, ?)"
+	_, err = s.db.Exec(query, token.ID, token.SessionID, token.UserID, token.ExpiresAt)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", token.UserID).Error("Error recording the access token")
+		return err
+	}
+	return nil
+}
+
+// DenySessionAccessTokens adds the outstanding access tokens of a session to the denylist.
+//
+// sessionId: The ID of the session.
+//
+// Returns the number of denied tokens, or an error returned by the database.
+func (s *MySQLStore) DenySessionAccessTokens(sessionId string) (int, error) {
+	query := "UPDATE ACCESS_TOKEN SET denied_at = ? WHERE session_id = ? AND denied_at IS NULL AND expires_at > ?"
+	return s.denyAccessTokens(query, sessionId, logrus.Fields{"sessionId": sessionId})
+}
+
+// DenyUserAccessTokens adds the outstanding access tokens of every session of a user to the denylist.
+//
+// userId: The ID of the user.
+//
+// Returns the number of denied tokens, or an error returned by the database.
+func (s *MySQLStore) DenyUserAccessTokens(userId int) (int, error) {
+	query := "UPDATE ACCESS_TOKEN SET denied_at = ? WHERE user_id = ? AND denied_at IS NULL AND expires_at > ?"
+	return s.denyAccessTokens(query, userId, logrus.Fields{"userId": userId})
+}
+
+// denyAccessTokens runs an UPDATE query denying access tokens, whose parameters are the current time, the
+// owner of the tokens and the current time again.
+//
+// query: The UPDATE query.
+// owner: The session ID or user ID of the tokens.
+// fields: The logging fields identifying the owner.
+//
+// Returns the number of denied tokens, or an error returned by the database.
+func (s *MySQLStore) denyAccessTokens(query string, owner interface{}, fields logrus.Fields) (int, error) {
+	now := time.Now().UTC()
+	result, err := s.db.Exec(query, now, owner, now)
+	if err != nil {
+		s.logger.WithError(err).WithFields(fields).Error("Error denying the access tokens")
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithFields(fields).
+			Error("Error getting the number of rows affected when trying to deny the access tokens")
+		return 0, err
+	}
+
+	s.logger.WithFields(fields).WithField("denied", rowsAffected).Info("Access tokens denied with success")
+	return int(rowsAffected), nil
+}
+
+// IsAccessTokenDenied reports whether an access token is on the denylist. Tokens that were never recorded, e.g.
+// tokens issued before the denylist existed, are not denied.
+//
+// tokenId: The jti of the access token.
+//
+// Returns true if the token is denied and not expired yet, or an error returned by the database.
+func (s *MySQLStore) IsAccessTokenDenied(tokenId string) (bool, error) {
+	query := "SELECT 1 FROM ACCESS_TOKEN WHERE jti = ? AND denied_at IS NOT NULL AND expires_at > ?"
+	return s.exists(query, tokenId, time.Now().UTC())
+}
+
+// PurgeExpiredAccessTokens deletes the access tokens that have expired, denied or not, since they cannot be
+// used anymore.
+//
+// Returns the number of deleted tokens, or an error returned by the database.
+func (s *MySQLStore) PurgeExpiredAccessTokens() (int, error) {
+	result, err := s.db.Exec("DELETE FROM ACCESS_TOKEN WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		s.logger.WithError(err).Error("Error purging the expired access tokens")
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).
+			Error("Error getting the number of rows affected when trying to purge the expired access tokens")
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
@@ -0,0 +1,249 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"database/sql"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const externalIdentityColumns = "provider, subject, user_id, email, created_at"
+
+// CreateExternalIdentity links an identity of an upstream provider to a user.
+//
+// identity: The identity; its Provider, Subject and UserID must be set. CreatedAt defaults to the current time.
+//
+// Returns a NotFoundError if the user does not exist, a ConflictError if the subject is already linked or the
+// user already has an identity at the provider, or any other error returned by the database.
+func (s *MySQLStore) CreateExternalIdentity(identity model.ExternalIdentity) error {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", identity.UserID)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("user", identity.UserID)
+	}
+	found, err = s.exists("SELECT COUNT(*) FROM EXTERNAL_IDENTITY WHERE provider = ? AND subject = ?",
+		identity.Provider, identity.Subject)
+	if err != nil {
+		return err
+	} else if found {
+		return conflict("external identity", identity.Provider, "already linked to a user")
+	}
+	found, err = s.exists("SELECT COUNT(*) FROM EXTERNAL_IDENTITY WHERE user_id = ? AND provider = ?",
+		identity.UserID, identity.Provider)
+	if err != nil {
+		return err
+	} else if found {
+		return conflict("external identity", identity.Provider, "the user already has an identity at this provider")
+	}
+
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now()
+	}
+	query := "INSERT INTO EXTERNAL_IDENTITY (" + externalIdentityColumns + ") VALUES (?, ?, ?, ?, ?)"
+	_, err = s.db.Exec(query, identity.Provider, identity.Subject, identity.UserID, identity.Email,
+		identity.CreatedAt.UTC())
+	if err != nil {
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"provider": identity.Provider,
+			"userId":   identity.UserID,
+		}).Error("Error linking the external identity")
+		return err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"provider": identity.Provider,
+		"userId":   identity.UserID,
+	}).Info("External identity linked with success")
+	return nil
+}
+
+// GetExternalIdentity retrieves the identity of a subject of an upstream provider.
+//
+// provider: The name of the provider.
+// subject: The sub claim of the provider.
+//
+// Returns the identity, a NotFoundError if the subject is not linked, or any other error returned by the database.
+func (s *MySQLStore) GetExternalIdentity(provider string, subject string) (*model.ExternalIdentity, error) {
+	query := "SELECT " + externalIdentityColumns + " FROM EXTERNAL_IDENTITY WHERE provider = ? AND subject = ?"
+	identity, err := scanExternalIdentity(s.db.QueryRow(query, provider, subject))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("external identity", provider)
+	} else if err != nil {
+		s.logger.WithError(err).WithField("provider", provider).Error("Error retrieving the external identity")
+		return nil, err
+	}
+	return identity, nil
+}
+
+// ListExternalIdentities retrieves the identities of a user at the upstream providers, ordered by provider.
+//
+// userId: The ID of the user.
+//
+// Returns the identities, possibly none, a NotFoundError if the user does not exist, or any other error returned
+// by the database.
+func (s *MySQLStore) ListExternalIdentities(userId int) ([]model.ExternalIdentity, error) {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", userId)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		return nil, notFound("user", userId)
+	}
+
+	query := "SELECT " + externalIdentityColumns + " FROM EXTERNAL_IDENTITY WHERE user_id = ? ORDER BY provider"
+	rows, err := s.db.Query(query, userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error listing the external identities")
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []model.ExternalIdentity{}
+	for rows.Next() {
+		identity, err := scanExternalIdentity(rows)
+		if err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error scanning an external identity")
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+	return identities, rows.Err()
+}
+
+// DeleteExternalIdentity unlinks the identity of a user at an upstream provider.
+//
+// userId: The ID of the user.
+// provider: The name of the provider.
+//
+// Returns a NotFoundError if the user has no identity at the provider, or any other error returned by the database.
+func (s *MySQLStore) DeleteExternalIdentity(userId int, provider string) error {
+	result, err := s.db.Exec("DELETE FROM EXTERNAL_IDENTITY WHERE user_id = ? AND provider = ?", userId, provider)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error unlinking the external identity")
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).
+			Error("Error getting the number of rows affected when trying to unlink an external identity")
+		return err
+	}
+	if rowsAffected == 0 {
+		return notFound("external identity", provider)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"provider": provider,
+		"userId":   userId,
+	}).Info("External identity unlinked with success")
+	return nil
+}
+
+// CreateFederatedLoginState stores the hash of the state of a new login through an upstream provider.
+//
+// state: The state; its StateHash, Provider, Nonce, CodeVerifier and ExpiresAt must be set, and its UserID to link
+// an identity. A UserID of 0 stores a state without a user.
+//
+// Returns a NotFoundError if the user does not exist, a ConflictError if the hash is already stored, or any other
+// error returned by the database.
+func (s *MySQLStore) CreateFederatedLoginState(state model.FederatedLoginState) error {
+	userId := sql.NullInt64{Int64: int64(state.UserID), Valid: state.UserID != 0}
+	if userId.Valid {
+		found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", state.UserID)
+		if err != nil {
+			return err
+		} else if !found {
+			return notFound("user", state.UserID)
+		}
+	}
+	found, err := s.exists("SELECT COUNT(*) FROM FEDERATED_LOGIN_STATE WHERE state_hash = ?", state.StateHash)
+	if err != nil {
+		return err
+	} else if found {
+		return conflict("federated login state", state.Provider, "already stored")
+	}
+
+	query := "INSERT INTO FEDERATED_LOGIN_STATE (state_hash, provider, user_id, nonce, code_verifier, expires_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?)"
+	_, err = s.db.Exec(query, state.StateHash, state.Provider, userId, state.Nonce, state.CodeVerifier,
+		state.ExpiresAt.UTC())
+	if err != nil {
+		s.logger.WithError(err).WithField("provider", state.Provider).Error("Error storing the federated login state")
+		return err
+	}
+	return nil
+}
+
+// ConsumeFederatedLoginState uses the state of a login through an upstream provider, deleting it so it can only
+// be used once, even by concurrent requests.
+//
+// stateHash: The hash of the state.
+// provider: The provider the state must have been issued for.
+//
+// Returns the used state, a NotFoundError if no such state exists for the provider or it has expired, or any
+// other error returned by the database.
+func (s *MySQLStore) ConsumeFederatedLoginState(stateHash string, provider string) (*model.FederatedLoginState, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).Error("Error beginning the use of a federated login state")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	state := model.FederatedLoginState{StateHash: stateHash}
+	var userId sql.NullInt64
+	query := "SELECT provider, user_id, nonce, code_verifier, expires_at FROM FEDERATED_LOGIN_STATE " +
+		"WHERE state_hash = ? FOR UPDATE"
+	err = tx.QueryRow(query, stateHash).Scan(&state.Provider, &userId, &state.Nonce, &state.CodeVerifier,
+		&state.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("federated login state", provider)
+	} else if err != nil {
+		s.logger.WithError(err).Error("Error retrieving the federated login state")
+		return nil, err
+	}
+	state.UserID = int(userId.Int64)
+	if state.Provider != provider || !time.Now().Before(state.ExpiresAt) {
+		return nil, notFound("federated login state", provider)
+	}
+
+	if _, err = tx.Exec("DELETE FROM FEDERATED_LOGIN_STATE WHERE state_hash = ?", stateHash); err != nil {
+		s.logger.WithError(err).Error("Error deleting the federated login state")
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).Error("Error committing the use of a federated login state")
+		return nil, err
+	}
+	return &state, nil
+}
+
+// PurgeExpiredFederatedLoginStates deletes the federated login states that have expired.
+//
+// Returns the number of deleted states, or an error returned by the database.
+func (s *MySQLStore) PurgeExpiredFederatedLoginStates() (int, error) {
+	result, err := s.db.Exec("DELETE FROM FEDERATED_LOGIN_STATE WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		s.logger.WithError(err).Error("Error purging the expired federated login states")
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).
+			Error("Error getting the number of rows affected when trying to purge the expired federated login states")
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// scanExternalIdentity reads an external identity from a row selecting externalIdentityColumns.
+func scanExternalIdentity(row rowScanner) (*model.ExternalIdentity, error) {
+	var identity model.ExternalIdentity
+	err := row.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
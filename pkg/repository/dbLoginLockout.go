@@ -0,0 +1,280 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"database/sql"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// RecordUserLoginFailure counts a failed login of a user. The row of the user is locked while the counter is
+// read and written, so concurrent failures are all counted.
+//
+// userId: The ID of the user.
+// since: The time before which a previous failure is forgotten and the counter restarts.
+//
+// Returns the number of consecutive failures of the user, including this one, a NotFoundError if the user does
+// not exist, or any other error returned by the database.
+func (s *MySQLStore) RecordUserLoginFailure(userId int, since time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error beginning the record of a login failure")
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	var lastFailedAt sql.NullTime
+	err = tx.QueryRow("SELECT failed_logins, last_failed_login_at FROM USERS WHERE id = ? FOR UPDATE", userId).
+		Scan(&failures, &lastFailedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, notFound("user", userId)
+	} else if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving the login failures of the user")
+		return 0, err
+	}
+
+	failures = nextFailureCount(failures, lastFailedAt.Time, since)
+	_, err = tx.Exec("UPDATE USERS SET failed_logins = ?, last_failed_login_at = ? WHERE id = ?",
+		failures, time.Now().UTC(), userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error recording the login failure of the user")
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error committing the login failure of the user")
+		return 0, err
+	}
+
+	s.logger.WithFields(logrus.Fields{"userId": userId, "failures": failures}).Info("Login failure recorded")
+	return failures, nil
+}
+
+// LockUser prevents a user from logging in until the given time.
+//
+// userId: The ID of the user.
+// until: The end of the lock.
+//
+// Returns a NotFoundError if the user does not exist, or any other error returned by the database.
+func (s *MySQLStore) LockUser(userId int, until time.Time) error {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", userId)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("user", userId)
+	}
+
+	if _, err = s.db.Exec("UPDATE USERS SET locked_until = ? WHERE id = ?", until.UTC(), userId); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error locking the user")
+		return err
+	}
+
+	s.logger.WithField("userId", userId).Info("User locked")
+	return nil
+}
+
+// UnlockUser clears the failed login counter and the lock of a user, after a successful login or by an
+// administrator.
+//
+// userId: The ID of the user.
+//
+// Returns a NotFoundError if the user does not exist, or any other error returned by the database.
+func (s *MySQLStore) UnlockUser(userId int) error {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", userId)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("user", userId)
+	}
+
+	_, err = s.db.Exec("UPDATE USERS SET failed_logins = 0, last_failed_login_at = NULL, locked_until = NULL "+
+		"WHERE id = ?", userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error unlocking the user")
+		return err
+	}
+
+	s.logger.WithField("userId", userId).Info("User unlocked")
+	return nil
+}
+
+// RecordIPLoginFailure counts a failed login from a client IP address, whatever the username.
+//
+// ip: The client IP address.
+// since: The time before which a previous failure is forgotten and the counter restarts.
+//
+// Returns the number of failures from the address, including this one, or any error returned by the database.
+func (s *MySQLStore) RecordIPLoginFailure(ip string, since time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("ip", ip).Error("Error beginning the record of a login failure")
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// The counter is computed by the upsert itself, so concurrent failures of a new address are all counted.
+	// MySQL assigns the columns from left to right: failed_logins is computed from the previous failure time.
+	now := time.Now().UTC()
+	_, err = tx.Exec("INSERT INTO LOGIN_FAILURE_IP (ip, failed_logins, last_failed_login_at) VALUES (?, 1, ?) "+
+		"ON DUPLICATE KEY UPDATE failed_logins = IF(last_failed_login_at < ?, 1, failed_logins + 1), "+
+		"last_failed_login_at = ?", ip, now, since.UTC(), now)
+	if err != nil {
+		s.logger.WithError(err).WithField("ip", ip).Error("Error recording the login failure of the address")
+		return 0, err
+	}
+	var failures int
+	if err = tx.QueryRow("SELECT failed_logins FROM LOGIN_FAILURE_IP WHERE ip = ?", ip).Scan(&failures); err != nil {
+		s.logger.WithError(err).WithField("ip", ip).Error("Error retrieving the login failures of the address")
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("ip", ip).Error("Error committing the login failure of the address")
+		return 0, err
+	}
+
+	s.logger.WithFields(logrus.Fields{"ip": ip, "failures": failures}).Info("Login failure recorded")
+	return failures, nil
+}
+
+// LockIP prevents any login from a client IP address until the given time.
+//
+// ip: The client IP address.
+// until: The end of the lock.
+//
+// Returns a NotFoundError if no failure was recorded for the address, or any other error returned by the
+// database.
+func (s *MySQLStore) LockIP(ip string, until time.Time) error {
+	found, err := s.exists("SELECT COUNT(*) FROM LOGIN_FAILURE_IP WHERE ip = ?", ip)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("login failure", ip)
+	}
+
+	if _, err = s.db.Exec("UPDATE LOGIN_FAILURE_IP SET locked_until = ? WHERE ip = ?", until.UTC(), ip); err != nil {
+		s.logger.WithError(err).WithField("ip", ip).Error("Error locking the address")
+		return err
+	}
+
+	s.logger.WithField("ip", ip).Info("Address locked")
+	return nil
+}
+
+// IPLockedUntil returns the end of the lock of a client IP address.
+//
+// ip: The client IP address.
+//
+// Returns the end of the lock, which is the zero time or in the past if the address is not locked, or an error
+// returned by the database.
+func (s *MySQLStore) IPLockedUntil(ip string) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRow("SELECT locked_until FROM LOGIN_FAILURE_IP WHERE ip = ?", ip).Scan(&lockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.logger.WithError(err).WithField("ip", ip).Error("Error retrieving the lock of the address")
+		return time.Time{}, err
+	}
+	return lockedUntil.Time, nil
+}
+
+// UnlockIP forgets the failed logins and the lock of a client IP address.
+//
+// ip: The client IP address.
+//
+// Returns a NotFoundError if no failure was recorded for the address, or any other error returned by the
+// database.
+func (s *MySQLStore) UnlockIP(ip string) error {
+	result, err := s.db.Exec("DELETE FROM LOGIN_FAILURE_IP WHERE ip = ?", ip)
+	if err != nil {
+		s.logger.WithError(err).WithField("ip", ip).Error("Error unlocking the address")
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("ip", ip).Error("Error unlocking the address")
+		return err
+	} else if deleted == 0 {
+		return notFound("login failure", ip)
+	}
+
+	s.logger.WithField("ip", ip).Info("Address unlocked")
+	return nil
+}
+
+// ListLockouts lists the accounts and the client IP addresses that are currently locked.
+//
+// Returns the lockouts, accounts first, each sorted by the end of their lock, or an error returned by the
+// database.
+func (s *MySQLStore) ListLockouts() ([]model.LoginLockout, error) {
+	now := time.Now().UTC()
+	lockouts := []model.LoginLockout{}
+
+	rows, err := s.db.Query("SELECT id, username, failed_logins, last_failed_login_at, locked_until FROM USERS "+
+		"WHERE locked_until > ? ORDER BY locked_until", now)
+	if err != nil {
+		s.logger.WithError(err).Error("Error listing the locked users")
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lockout model.LoginLockout
+		var lastFailedAt sql.NullTime
+		err = rows.Scan(&lockout.UserID, &lockout.Username, &lockout.FailedLogins, &lastFailedAt, &lockout.LockedUntil)
+		if err != nil {
+			s.logger.WithError(err).Error("Error reading a locked user")
+			return nil, err
+		}
+		lockout.LastFailedAt = lastFailedAt.Time
+		lockouts = append(lockouts, lockout)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.WithError(err).Error("Error listing the locked users")
+		return nil, err
+	}
+
+	ipRows, err := s.db.Query("SELECT ip, failed_logins, last_failed_login_at, locked_until FROM LOGIN_FAILURE_IP "+
+		"WHERE locked_until > ? ORDER BY locked_until", now)
+	if err != nil {
+		s.logger.WithError(err).Error("Error listing the locked addresses")
+		return nil, err
+	}
+	defer ipRows.Close()
+	for ipRows.Next() {
+		var lockout model.LoginLockout
+		err = ipRows.Scan(&lockout.IP, &lockout.FailedLogins, &lockout.LastFailedAt, &lockout.LockedUntil)
+		if err != nil {
+			s.logger.WithError(err).Error("Error reading a locked address")
+			return nil, err
+		}
+		lockouts = append(lockouts, lockout)
+	}
+	if err = ipRows.Err(); err != nil {
+		s.logger.WithError(err).Error("Error listing the locked addresses")
+		return nil, err
+	}
+	return lockouts, nil
+}
+
+// PurgeLoginFailures deletes the failed login counters of the client IP addresses whose last failure happened
+// before the given time and which are not locked. The counters of the accounts restart by themselves.
+//
+// before: The time before which the counters are forgotten.
+//
+// Returns the number of deleted counters, or an error returned by the database.
+func (s *MySQLStore) PurgeLoginFailures(before time.Time) (int, error) {
+	result, err := s.db.Exec("DELETE FROM LOGIN_FAILURE_IP WHERE last_failed_login_at < ? "+
+		"AND (locked_until IS NULL OR locked_until <= ?)", before.UTC(), time.Now().UTC())
+	if err != nil {
+		s.logger.WithError(err).Error("Error purging the login failures")
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).
+			Error("Error getting the number of rows affected when trying to purge the login failures")
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"database/sql"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const oauthClientColumns = "client_id, secret_hash, name, redirect_uris, scopes, grant_types, created_at"
+
+// CreateOAuthClient registers a new OAuth client. The redirect URIs, scopes and grant types are stored as
+// space-separated lists.
+//
+// client: The client; its ID, Name and GrantTypes must be set, and its SecretHash for a confidential client.
+// CreatedAt defaults to the current time.
+//
+// Returns a ConflictError if the client ID is already registered, or any other error returned by the database.
+func (s *MySQLStore) CreateOAuthClient(client model.OAuthClient) error {
+	found, err := s.exists("SELECT COUNT(*) FROM OAUTH_CLIENT WHERE client_id = ?", client.ID)
+	if err != nil {
+		return err
+	} else if found {
+		return conflict("OAuth client", client.ID, "already registered")
+	}
+
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now()
+	}
+	secretHash := sql.NullString{String: client.SecretHash, Valid: client.SecretHash != ""}
+	query := "INSERT INTO OAUTH_CLIENT (" + oauthClientColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?)"
+	_, err = s.db.Exec(query, client.ID, secretHash, client.Name, strings.Join(client.RedirectURIs, " "),
+		strings.Join(client.Scopes, " "), strings.Join(client.GrantTypes, " "), client.CreatedAt.UTC())
+	if err != nil {
+		s.logger.WithError(err).WithField("clientId", client.ID).Error("Error registering the OAuth client")
+		return err
+	}
+
+	s.logger.WithField("clientId", client.ID).Info("OAuth client registered with success")
+	return nil
+}
+
+// GetOAuthClient retrieves an OAuth client by its ID.
+//
+// clientId: The ID of the client.
+//
+// Returns the client, a NotFoundError if it does not exist, or any other error returned by the database.
+func (s *MySQLStore) GetOAuthClient(clientId string) (*model.OAuthClient, error) {
+	row := s.db.QueryRow("SELECT "+oauthClientColumns+" FROM OAUTH_CLIENT WHERE client_id = ?", clientId)
+	client, err := scanOAuthClient(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("OAuth client", clientId)
+	} else if err != nil {
+		s.logger.WithError(err).WithField("clientId", clientId).Error("Error retrieving the OAuth client")
+		return nil, err
+	}
+	return client, nil
+}
+
+// ListOAuthClients retrieves every OAuth client, oldest first.
+//
+// Returns the clients, or an error returned by the database.
+func (s *MySQLStore) ListOAuthClients() ([]model.OAuthClient, error) {
+	rows, err := s.db.Query("SELECT " + oauthClientColumns + " FROM OAUTH_CLIENT ORDER BY created_at, client_id")
+	if err != nil {
+		s.logger.WithError(err).Error("Error listing the OAuth clients")
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []model.OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			s.logger.WithError(err).Error("Error scanning an OAuth client")
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+	return clients, rows.Err()
+}
+
+// DeleteOAuthClient removes an OAuth client. Its outstanding authorization codes are deleted with it, and the
+// sessions started through it are revoked, so its refresh tokens cannot be used anymore.
+//
+// clientId: The ID of the client.
+//
+// Returns a NotFoundError if the client does not exist, or any other error returned by the database.
+func (s *MySQLStore) DeleteOAuthClient(clientId string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("clientId", clientId).Error("Error starting the transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM OAUTH_CLIENT WHERE client_id = ?", clientId)
+	if err != nil {
+		s.logger.WithError(err).WithField("clientId", clientId).Error("Error removing the OAuth client")
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("clientId", clientId).
+			Error("Error getting the number of rows affected when trying to remove an OAuth client")
+		return err
+	}
+	if rowsAffected == 0 {
+		return notFound("OAuth client", clientId)
+	}
+
+	query := "UPDATE SESSION SET token_hash = NULL, revoked_at = ? WHERE client_id = ? AND token_hash IS NOT NULL"
+	result, err = tx.Exec(query, time.Now().UTC(), clientId)
+	if err != nil {
+		s.logger.WithError(err).WithField("clientId", clientId).Error("Error revoking the sessions of the OAuth client")
+		return err
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("clientId", clientId).
+			Error("Error getting the number of rows affected when trying to revoke the sessions of an OAuth client")
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("clientId", clientId).Error("Error committing the removal of the OAuth client")
+		return err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"clientId": clientId,
+		"revoked":  revoked,
+	}).Info("OAuth client removed with success")
+	return nil
+}
+
+// CreateOAuthAuthorizationCode stores the hash of a new authorization code.
+//
+// code: The code; every field but Scope and Nonce must be set.
+//
+// Returns a NotFoundError if the client or the user does not exist, a ConflictError if the hash is already
+// stored, or any other error returned by the database.
+func (s *MySQLStore) CreateOAuthAuthorizationCode(code model.OAuthAuthorizationCode) error {
+	found, err := s.exists("SELECT COUNT(*) FROM OAUTH_CLIENT WHERE client_id = ?", code.ClientID)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("OAuth client", code.ClientID)
+	}
+	found, err = s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", code.UserID)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("user", code.UserID)
+	}
+	found, err = s.exists("SELECT COUNT(*) FROM OAUTH_AUTHORIZATION_CODE WHERE code_hash = ?", code.CodeHash)
+	if err != nil {
+		return err
+	} else if found {
+		return conflict("OAuth authorization code", code.ClientID, "already stored")
+	}
+
+	query := "INSERT INTO OAUTH_AUTHORIZATION_CODE " +
+		"(code_hash, client_id, user_id, redirect_uri, scope, code_challenge, nonce, expires_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err = s.db.Exec(query, code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scope,
+		code.CodeChallenge, code.Nonce, code.ExpiresAt.UTC())
+	if err != nil {
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"clientId": code.ClientID,
+			"userId":   code.UserID,
+		}).Error("Error storing the OAuth authorization code")
+		return err
+	}
+	return nil
+}
+
+// ConsumeOAuthAuthorizationCode uses an authorization code, deleting it so it can only be used once, even by
+// concurrent requests.
+//
+// codeHash: The hash of the code.
+//
+// Returns the used code, a NotFoundError if no such code exists or it has expired, or any other error returned
+// by the database.
+func (s *MySQLStore) ConsumeOAuthAuthorizationCode(codeHash string) (*model.OAuthAuthorizationCode, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).Error("Error beginning the use of an OAuth authorization code")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	code := model.OAuthAuthorizationCode{CodeHash: codeHash}
+	query := "SELECT client_id, user_id, redirect_uri, scope, code_challenge, nonce, expires_at " +
+		"FROM OAUTH_AUTHORIZATION_CODE WHERE code_hash = ? FOR UPDATE"
+	err = tx.QueryRow(query, codeHash).Scan(&code.ClientID, &code.UserID, &code.RedirectURI, &code.Scope,
+		&code.CodeChallenge, &code.Nonce, &code.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("OAuth authorization code", "")
+	} else if err != nil {
+		s.logger.WithError(err).Error("Error retrieving the OAuth authorization code")
+		return nil, err
+	}
+	if !time.Now().Before(code.ExpiresAt) {
+		return nil, notFound("OAuth authorization code", "")
+	}
+
+	if _, err = tx.Exec("DELETE FROM OAUTH_AUTHORIZATION_CODE WHERE code_hash = ?", codeHash); err != nil {
+		s.logger.WithError(err).Error("Error deleting the OAuth authorization code")
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).Error("Error committing the use of an OAuth authorization code")
+		return nil, err
+	}
+	return &code, nil
+}
+
+// PurgeExpiredOAuthAuthorizationCodes deletes the authorization codes that have expired.
+//
+// Returns the number of deleted codes, or an error returned by the database.
+func (s *MySQLStore) PurgeExpiredOAuthAuthorizationCodes() (int, error) {
+	result, err := s.db.Exec("DELETE FROM OAUTH_AUTHORIZATION_CODE WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		s.logger.WithError(err).Error("Error purging the expired OAuth authorization codes")
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).
+			Error("Error getting the number of rows affected when trying to purge the expired OAuth authorization codes")
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// scanOAuthClient reads an OAuth client from a row selecting oauthClientColumns.
+func scanOAuthClient(row rowScanner) (*model.OAuthClient, error) {
+	var client model.OAuthClient
+	var secretHash sql.NullString
+	var redirectURIs, scopes, grantTypes string
+	err := row.Scan(&client.ID, &secretHash, &client.Name, &redirectURIs, &scopes, &grantTypes, &client.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	client.SecretHash = secretHash.String
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	client.Scopes = strings.Fields(scopes)
+	client.GrantTypes = strings.Fields(grantTypes)
+	return &client, nil
+}
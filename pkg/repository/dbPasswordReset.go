@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// CreatePasswordResetToken stores the hash of a new password reset token of a user. The other tokens of the
+// user stay valid until they are used or expire.
+//
+// token: The reset token; its TokenHash, UserID and ExpiresAt must be set. CreatedAt defaults to the current time.
+//
+// Returns a NotFoundError if the user does not exist, a ConflictError if the hash is already stored, or any
+// other error returned by the database.
+func (s *MySQLStore) CreatePasswordResetToken(token model.PasswordResetToken) error {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", token.UserID)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("user", token.UserID)
+	}
+	found, err = s.exists("SELECT COUNT(*) FROM PASSWORD_RESET_TOKEN WHERE token_hash = ?", token.TokenHash)
+	if err != nil {
+		return err
+	} else if found {
+		return conflict("password reset token", token.UserID, "already stored")
+	}
+
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	query := "INSERT INTO PASSWORD_RESET_TOKEN (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)"
+	_, err = s.db.Exec(query, token.TokenHash, token.UserID, token.CreatedAt.UTC(), token.ExpiresAt.UTC())
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", token.UserID).Error("Error storing the password reset token")
+		return err
+	}
+
+	s.logger.WithField("userId", token.UserID).Info("Password reset token created with success")
+	return nil
+}
+
+// ConsumePasswordResetToken uses a password reset token: the token and every other reset token of its user are
+// deleted, so a token can only be used once, even by concurrent requests.
+//
+// tokenHash: The hash of the reset token.
+//
+// Returns the used token, a NotFoundError if no such token exists or it has expired, or any other error
+// returned by the database.
+func (s *MySQLStore) ConsumePasswordResetToken(tokenHash string) (*model.PasswordResetToken, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).Error("Error beginning the use of a password reset token")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	token := model.PasswordResetToken{TokenHash: tokenHash}
+	query := "SELECT user_id, created_at, expires_at FROM PASSWORD_RESET_TOKEN WHERE token_hash = ? FOR UPDATE"
+	err = tx.QueryRow(query, tokenHash).Scan(&token.UserID, &token.CreatedAt, &token.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("password reset token", "")
+	} else if err != nil {
+		s.logger.WithError(err).Error("Error retrieving the password reset token")
+		return nil, err
+	}
+	if !time.Now().Before(token.ExpiresAt) {
+		return nil, notFound("password reset token", "")
+	}
+
+	if _, err = tx.Exec("DELETE FROM PASSWORD_RESET_TOKEN WHERE user_id = ?", token.UserID); err != nil {
+		s.logger.WithError(err).WithField("userId", token.UserID).Error("Error deleting the password reset tokens")
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("userId", token.UserID).Error("Error committing the use of a password reset token")
+		return nil, err
+	}
+
+	s.logger.WithField("userId", token.UserID).Info("Password reset token used with success")
+	return &token, nil
+}
+
+// PurgeExpiredPasswordResetTokens deletes the password reset tokens that have expired.
+//
+// Returns the number of deleted tokens, or an error returned by the database.
+func (s *MySQLStore) PurgeExpiredPasswordResetTokens() (int, error) {
+	result, err := s.db.Exec("DELETE FROM PASSWORD_RESET_TOKEN WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		s.logger.WithError(err).Error("Error purging the expired password reset tokens")
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).
+			Error("Error getting the number of rows affected when trying to purge the expired password reset tokens")
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
@@ -1,47 +1,66 @@
 package repository
 
 import (
-	"database/sql"
+	"GolandRestApi/pkg/model"
 	"fmt"
 	"github.com/sirupsen/logrus"
 )
 
 // GetPermissionsByRoleId retrieves a list of permissions associated with a specific role ID from the database.
 //
-// logger: A logrus.Logger instance for logging information and errors.
-// db: A pointer to the SQL database connection.
 // roleId: The ID of the role for which permissions are to be retrieved.
 //
 // Returns a slice of strings containing permission names and an error, if any.
-// If successful, the permissions are retrieved and returned without errors.
-func GetPermissionsByRoleId(logger *logrus.Logger, db *sql.DB, roleId int) ([]string, error) {
+// Returns a NotFoundError if the role does not exist.
+func (s *MySQLStore) GetPermissionsByRoleId(roleId int) ([]string, error) {
+	exists, err := s.exists("SELECT COUNT(*) FROM ROLE WHERE id = ?", roleId)
+	if err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error verifying if role exists")
+		return nil, err
+	} else if !exists {
+		return nil, notFound("role", roleId)
+	}
+
 	var permissions []string
-	query := "SELECT p.* FROM PERMISSION p INNER JOIN ROLE_PERMISSION rp ON p.id = rp.permission_id WHERE rp.role_id = ?"
-	err := db.QueryRow(query, roleId).Scan(&permissions)
+	query := "SELECT p.name FROM PERMISSION p INNER JOIN ROLE_PERMISSION rp ON p.id = rp.permission_id WHERE rp.role_id = ? ORDER BY p.id"
+	rows, err := s.db.Query(query, roleId)
 	if err != nil {
-		logger.WithError(err).WithField("roleId", roleId).Error("Error retrieving permissions using roleId")
-		return permissions, err
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error retrieving permissions using roleId")
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			s.logger.WithError(err).WithField("roleId", roleId).Error("Error scanning permission")
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error iterating over permissions")
+		return nil, err
 	}
 
-	logger.WithField("roleId", roleId).Info("Permission retrieved successfully using the roleId ")
+	s.logger.WithField("roleId", roleId).Info("Permission retrieved successfully using the roleId ")
 	return permissions, nil
 }
 
 // GetUserRolesByUserId retrieves a list of role names associated with a specific user ID from the database.
 //
-// logger: A logrus.Logger instance for logging information and errors.
-// db: A pointer to the SQL database connection.
 // userId: The ID of the user for which roles are to be retrieved.
 //
 // Returns a slice of strings containing role names and an error, if any.
 // If successful, the roles are retrieved and returned without errors.
-func GetUserRolesByUserId(logger *logrus.Logger, db *sql.DB, userId int) ([]string, error) {
+func (s *MySQLStore) GetUserRolesByUserId(userId int) ([]string, error) {
 	var roles []string
-	query := "SELECT r.name FROM ROLE r INNER JOIN USER_ROLE ur ON r.id = ur.role_id WHERE ur.user_id = ?"
+	query := "SELECT r.name FROM ROLE r INNER JOIN USER_ROLE ur ON r.id = ur.role_id WHERE ur.user_id = ? ORDER BY r.id"
 
-	rows, err := db.Query(query, userId)
+	rows, err := s.db.Query(query, userId)
 	if err != nil {
-		logger.WithError(err).WithField("userId", userId).Error("Error retrieving roles using userId")
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving roles using userId")
 		return nil, err
 	}
 	defer rows.Close()
@@ -49,53 +68,496 @@ func GetUserRolesByUserId(logger *logrus.Logger, db *sql.DB, userId int) ([]stri
 	for rows.Next() {
 		var role string
 		if err := rows.Scan(&role); err != nil {
-			logger.WithError(err).WithField("userId", userId).Error("Error scanning role")
+			s.logger.WithError(err).WithField("userId", userId).Error("Error scanning role")
 			return nil, err
 		}
 		roles = append(roles, role)
 	}
 
 	if err := rows.Err(); err != nil {
-		logger.WithError(err).WithField("userId", userId).Error("Error iterating over roles")
+		s.logger.WithError(err).WithField("userId", userId).Error("Error iterating over roles")
 		return nil, err
 	}
 
-	logger.WithField("userId", userId).Info("Roles retrieved successfully using the userId")
+	s.logger.WithField("userId", userId).Info("Roles retrieved successfully using the userId")
 	return roles, nil
 }
 
+// GetUserPermissionsByUserId retrieves the effective permissions of a user, i.e. every permission granted to
+// at least one of the user's roles, resolved in a single query through USER_ROLE and ROLE_PERMISSION.
+//
+// userId: The ID of the user for which permissions are to be retrieved.
+//
+// Returns a slice of distinct permission names sorted by name and an error, if any.
+func (s *MySQLStore) GetUserPermissionsByUserId(userId int) ([]string, error) {
+	var permissions []string
+	query := `SELECT DISTINCT p.name FROM PERMISSION p
+		INNER JOIN ROLE_PERMISSION rp ON p.id = rp.permission_id
+		INNER JOIN USER_ROLE ur ON rp.role_id = ur.role_id
+		WHERE ur.user_id = ? ORDER BY p.name`
+
+	rows, err := s.db.Query(query, userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving permissions using userId")
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error scanning permission")
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error iterating over permissions")
+		return nil, err
+	}
+
+	s.logger.WithField("userId", userId).Info("Permissions retrieved successfully using the userId")
+	return permissions, nil
+}
+
 // SetUserRole associates a user with a specific role in the database.
 //
-// logger: A logrus.Logger instance for logging information and errors.
-// db: A pointer to the SQL database connection.
 // userName: The username of the user to whom the role should be assigned.
 // roleName: The name of the role to be assigned to the user.
 //
 // Returns an error if there is any issue while setting the user role.
 // If successful, the user is associated with the specified role without errors.
-func SetUserRole(logger *logrus.Logger, db *sql.DB, userName string, roleName string) error {
+func (s *MySQLStore) SetUserRole(userName string, roleName string) error {
 
 	query := "INSERT INTO USER_ROLE (user_id, role_id) VALUES ((SELECT id FROM USERS WHERE username= ?),(SELECT id FROM ROLE WHERE name= ?))"
-	result, err := db.Exec(query, userName, roleName)
+	result, err := s.db.Exec(query, userName, roleName)
 	if err != nil {
-		logger.WithError(err).WithField("username", userName).Error("Error setting user role for user")
+		s.logger.WithError(err).WithField("username", userName).Error("Error setting user role for user")
 		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		logger.WithError(err).WithField("username", userName).
+		s.logger.WithError(err).WithField("username", userName).
 			Error("Error getting the number of rows affected when trying to store the refreshToken")
 		return err
 	}
 
 	if !(rowsAffected > 0) {
-		logger.WithError(err).WithField("username", userName).
+		s.logger.WithError(err).WithField("username", userName).
 			Warn("No errors setting up the userRole in DB, but rows affected <= 0")
 		return fmt.Errorf("no errors setting user role %s for username %s, but no rows affected", roleName, userName)
 	}
 
-	logger.WithField("username", userName).Info("Roles for user set up with success")
+	s.logger.WithField("username", userName).Info("Roles for user set up with success")
 	return nil
 
 }
+
+// CreateRole creates a new role with the given name.
+//
+// name: The name of the new role.
+//
+// Returns the created role and an error, if any.
+// Returns a ConflictError if a role with the same name already exists.
+func (s *MySQLStore) CreateRole(name string) (*model.Role, error) {
+	exists, err := s.exists("SELECT COUNT(*) FROM ROLE WHERE name = ?", name)
+	if err != nil {
+		s.logger.WithError(err).WithField("roleName", name).Error("Error verifying if role exists")
+		return nil, err
+	} else if exists {
+		return nil, conflict("role", name, "name already in use")
+	}
+
+	result, err := s.db.Exec("INSERT INTO ROLE (name) VALUES (?)", name)
+	if err != nil {
+		s.logger.WithError(err).WithField("roleName", name).Error("Error creating role")
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		s.logger.WithError(err).WithField("roleName", name).Error("Error getting the id of the new role")
+		return nil, err
+	}
+
+	s.logger.WithField("roleName", name).Info("Role created with success")
+	return &model.Role{ID: int(id), Name: name}, nil
+}
+
+// ListRoles retrieves every role, ordered by ID.
+//
+// Returns a slice of model.Role and an error, if any.
+func (s *MySQLStore) ListRoles() ([]model.Role, error) {
+	rows, err := s.db.Query("SELECT id, name FROM ROLE ORDER BY id")
+	if err != nil {
+		s.logger.WithError(err).Error("Error listing roles")
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []model.Role{}
+	for rows.Next() {
+		var role model.Role
+		if err := rows.Scan(&role.ID, &role.Name); err != nil {
+			s.logger.WithError(err).Error("Error scanning role")
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		s.logger.WithError(err).Error("Error iterating over roles")
+		return nil, err
+	}
+	return roles, nil
+}
+
+// RenameRole changes the name of a role.
+//
+// roleId: The ID of the role to rename.
+// name: The new name of the role.
+//
+// Returns a NotFoundError if the role does not exist and a ConflictError if another role already uses the name.
+func (s *MySQLStore) RenameRole(roleId int, name string) error {
+	return s.rename("ROLE", "role", roleId, name)
+}
+
+// DeleteRole removes a role together with the permissions granted to it.
+//
+// roleId: The ID of the role to remove.
+//
+// Returns a NotFoundError if the role does not exist and a ConflictError if it is still assigned to a user.
+func (s *MySQLStore) DeleteRole(roleId int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error beginning the multiple queries")
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM ROLE WHERE id = ?", roleId).Scan(&count); err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error verifying if role exists")
+		return err
+	} else if count == 0 {
+		return notFound("role", roleId)
+	}
+
+	if err := tx.QueryRow("SELECT COUNT(*) FROM USER_ROLE WHERE role_id = ?", roleId).Scan(&count); err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error verifying if role is assigned")
+		return err
+	} else if count > 0 {
+		return conflict("role", roleId, "still assigned to users")
+	}
+
+	if _, err := tx.Exec("DELETE FROM ROLE_PERMISSION WHERE role_id = ?", roleId); err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error removing the permissions of the role")
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM ROLE WHERE id = ?", roleId); err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error removing role")
+		return err
+	}
+
+	s.logger.WithField("roleId", roleId).Info("Role removed with success")
+	return tx.Commit()
+}
+
+// CreatePermission creates a new permission with the given name.
+//
+// name: The name of the new permission.
+//
+// Returns the created permission and an error, if any.
+// Returns a ConflictError if a permission with the same name already exists.
+func (s *MySQLStore) CreatePermission(name string) (*model.Permission, error) {
+	exists, err := s.exists("SELECT COUNT(*) FROM PERMISSION WHERE name = ?", name)
+	if err != nil {
+		s.logger.WithError(err).WithField("permissionName", name).Error("Error verifying if permission exists")
+		return nil, err
+	} else if exists {
+		return nil, conflict("permission", name, "name already in use")
+	}
+
+	result, err := s.db.Exec("INSERT INTO PERMISSION (name) VALUES (?)", name)
+	if err != nil {
+		s.logger.WithError(err).WithField("permissionName", name).Error("Error creating permission")
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		s.logger.WithError(err).WithField("permissionName", name).Error("Error getting the id of the new permission")
+		return nil, err
+	}
+
+	s.logger.WithField("permissionName", name).Info("Permission created with success")
+	return &model.Permission{ID: int(id), Name: name}, nil
+}
+
+// ListPermissions retrieves every permission, ordered by ID.
+//
+// Returns a slice of model.Permission and an error, if any.
+func (s *MySQLStore) ListPermissions() ([]model.Permission, error) {
+	rows, err := s.db.Query("SELECT id, name FROM PERMISSION ORDER BY id")
+	if err != nil {
+		s.logger.WithError(err).Error("Error listing permissions")
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []model.Permission{}
+	for rows.Next() {
+		var permission model.Permission
+		if err := rows.Scan(&permission.ID, &permission.Name); err != nil {
+			s.logger.WithError(err).Error("Error scanning permission")
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		s.logger.WithError(err).Error("Error iterating over permissions")
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// RenamePermission changes the name of a permission.
+//
+// permissionId: The ID of the permission to rename.
+// name: The new name of the permission.
+//
+// Returns a NotFoundError if the permission does not exist and a ConflictError if another permission already
+// uses the name.
+func (s *MySQLStore) RenamePermission(permissionId int, name string) error {
+	return s.rename("PERMISSION", "permission", permissionId, name)
+}
+
+// DeletePermission removes a permission.
+//
+// permissionId: The ID of the permission to remove.
+//
+// Returns a NotFoundError if the permission does not exist and a ConflictError if it is still granted to a role.
+func (s *MySQLStore) DeletePermission(permissionId int) error {
+	exists, err := s.exists("SELECT COUNT(*) FROM PERMISSION WHERE id = ?", permissionId)
+	if err != nil {
+		s.logger.WithError(err).WithField("permissionId", permissionId).Error("Error verifying if permission exists")
+		return err
+	} else if !exists {
+		return notFound("permission", permissionId)
+	}
+
+	granted, err := s.exists("SELECT COUNT(*) FROM ROLE_PERMISSION WHERE permission_id = ?", permissionId)
+	if err != nil {
+		s.logger.WithError(err).WithField("permissionId", permissionId).Error("Error verifying if permission is granted")
+		return err
+	} else if granted {
+		return conflict("permission", permissionId, "still granted to roles")
+	}
+
+	if _, err := s.db.Exec("DELETE FROM PERMISSION WHERE id = ?", permissionId); err != nil {
+		s.logger.WithError(err).WithField("permissionId", permissionId).Error("Error removing permission")
+		return err
+	}
+
+	s.logger.WithField("permissionId", permissionId).Info("Permission removed with success")
+	return nil
+}
+
+// GrantPermission grants a permission to a role.
+//
+// roleId: The ID of the role receiving the permission.
+// permissionId: The ID of the permission to grant.
+//
+// Returns a NotFoundError if the role or the permission does not exist and a ConflictError if the role
+// already holds the permission.
+func (s *MySQLStore) GrantPermission(roleId int, permissionId int) error {
+	if err := s.checkRoleAndPermission(roleId, permissionId); err != nil {
+		return err
+	}
+
+	granted, err := s.exists("SELECT COUNT(*) FROM ROLE_PERMISSION WHERE role_id = ? AND permission_id = ?",
+		roleId, permissionId)
+	if err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error verifying if permission is granted")
+		return err
+	} else if granted {
+		return conflict("permission", permissionId, fmt.Sprintf("already granted to role %d", roleId))
+	}
+
+	query := "INSERT INTO ROLE_PERMISSION (role_id, permission_id) VALUES (?, ?)"
+	if _, err := s.db.Exec(query, roleId, permissionId); err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error granting permission")
+		return err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"roleId":       roleId,
+		"permissionId": permissionId,
+	}).Info("Permission granted with success")
+	return nil
+}
+
+// RevokePermission revokes a permission from a role.
+//
+// roleId: The ID of the role losing the permission.
+// permissionId: The ID of the permission to revoke.
+//
+// Returns a NotFoundError if the role, the permission or the grant does not exist.
+func (s *MySQLStore) RevokePermission(roleId int, permissionId int) error {
+	if err := s.checkRoleAndPermission(roleId, permissionId); err != nil {
+		return err
+	}
+
+	query := "DELETE FROM ROLE_PERMISSION WHERE role_id = ? AND permission_id = ?"
+	result, err := s.db.Exec(query, roleId, permissionId)
+	if err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error revoking permission")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error getting rows affected")
+		return err
+	} else if rowsAffected == 0 {
+		return notFound("grant", fmt.Sprintf("of permission %d to role %d", permissionId, roleId))
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"roleId":       roleId,
+		"permissionId": permissionId,
+	}).Info("Permission revoked with success")
+	return nil
+}
+
+// AssignRole assigns a role to a user.
+//
+// userId: The ID of the user receiving the role.
+// roleId: The ID of the role to assign.
+//
+// Returns a NotFoundError if the user or the role does not exist and a ConflictError if the user already
+// has the role.
+func (s *MySQLStore) AssignRole(userId int, roleId int) error {
+	if err := s.checkUserAndRole(userId, roleId); err != nil {
+		return err
+	}
+
+	assigned, err := s.exists("SELECT COUNT(*) FROM USER_ROLE WHERE user_id = ? AND role_id = ?", userId, roleId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error verifying if role is assigned")
+		return err
+	} else if assigned {
+		return conflict("role", roleId, fmt.Sprintf("already assigned to user %d", userId))
+	}
+
+	if _, err := s.db.Exec("INSERT INTO USER_ROLE (user_id, role_id) VALUES (?, ?)", userId, roleId); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error assigning role")
+		return err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"userId": userId,
+		"roleId": roleId,
+	}).Info("Role assigned with success")
+	return nil
+}
+
+// UnassignRole removes a role from a user.
+//
+// userId: The ID of the user losing the role.
+// roleId: The ID of the role to remove.
+//
+// Returns a NotFoundError if the user, the role or the assignment does not exist.
+func (s *MySQLStore) UnassignRole(userId int, roleId int) error {
+	if err := s.checkUserAndRole(userId, roleId); err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec("DELETE FROM USER_ROLE WHERE user_id = ? AND role_id = ?", userId, roleId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error unassigning role")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error getting rows affected")
+		return err
+	} else if rowsAffected == 0 {
+		return notFound("assignment", fmt.Sprintf("of role %d to user %d", roleId, userId))
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"userId": userId,
+		"roleId": roleId,
+	}).Info("Role unassigned with success")
+	return nil
+}
+
+// rename changes the name column of a ROLE or PERMISSION row after checking that the row exists and
+// that the new name is not used by another row of the same table.
+func (s *MySQLStore) rename(table string, entity string, id int, name string) error {
+	exists, err := s.exists("SELECT COUNT(*) FROM "+table+" WHERE id = ?", id)
+	if err != nil {
+		s.logger.WithError(err).WithField(entity+"Id", id).Error("Error verifying if " + entity + " exists")
+		return err
+	} else if !exists {
+		return notFound(entity, id)
+	}
+
+	taken, err := s.exists("SELECT COUNT(*) FROM "+table+" WHERE name = ? AND id <> ?", name, id)
+	if err != nil {
+		s.logger.WithError(err).WithField(entity+"Id", id).Error("Error verifying if " + entity + " name is in use")
+		return err
+	} else if taken {
+		return conflict(entity, name, "name already in use")
+	}
+
+	if _, err := s.db.Exec("UPDATE "+table+" SET name = ? WHERE id = ?", name, id); err != nil {
+		s.logger.WithError(err).WithField(entity+"Id", id).Error("Error renaming " + entity)
+		return err
+	}
+
+	s.logger.WithField(entity+"Id", id).Info("Renamed " + entity + " with success")
+	return nil
+}
+
+// checkRoleAndPermission returns a NotFoundError if the role or the permission does not exist.
+func (s *MySQLStore) checkRoleAndPermission(roleId int, permissionId int) error {
+	if exists, err := s.exists("SELECT COUNT(*) FROM ROLE WHERE id = ?", roleId); err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error verifying if role exists")
+		return err
+	} else if !exists {
+		return notFound("role", roleId)
+	}
+
+	if exists, err := s.exists("SELECT COUNT(*) FROM PERMISSION WHERE id = ?", permissionId); err != nil {
+		s.logger.WithError(err).WithField("permissionId", permissionId).Error("Error verifying if permission exists")
+		return err
+	} else if !exists {
+		return notFound("permission", permissionId)
+	}
+	return nil
+}
+
+// checkUserAndRole returns a NotFoundError if the user or the role does not exist.
+func (s *MySQLStore) checkUserAndRole(userId int, roleId int) error {
+	if exists, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", userId); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error verifying if user exists")
+		return err
+	} else if !exists {
+		return notFound("user", userId)
+	}
+
+	if exists, err := s.exists("SELECT COUNT(*) FROM ROLE WHERE id = ?", roleId); err != nil {
+		s.logger.WithError(err).WithField("roleId", roleId).Error("Error verifying if role exists")
+		return err
+	} else if !exists {
+		return notFound("role", roleId)
+	}
+	return nil
+}
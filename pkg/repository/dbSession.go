@@ -0,0 +1,277 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"database/sql"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// sessionColumns are the columns scanned by scanSession, in order.
+const sessionColumns = "id, user_id, token_hash, device_label, user_agent, ip, created_at, last_used_at, expires_at, " +
+	"client_id, scope"
+
+// CreateSession stores a new session in the SESSION table, without touching the other sessions of the user.
+//
+// session: The session to store; its ID, UserID, TokenHash and ExpiresAt must be set. CreatedAt and
+// LastUsedAt default to the current time. ClientID and Scope are only set for the sessions of OAuth clients.
+//
+// Returns a NotFoundError if the user does not exist, a ConflictError if the session ID is already in use, or
+// any other error returned by the database.
+func (s *MySQLStore) CreateSession(session model.Session) error {
+	found, err := s.exists("SELECT 1 FROM USERS WHERE id = ?", session.UserID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFound("user", session.UserID)
+	}
+	found, err = s.exists("SELECT 1 FROM SESSION WHERE id = ?", session.ID)
+	if err != nil {
+		return err
+	}
+	if found {
+		return conflict("session", session.ID, "already exists")
+	}
+
+	now := time.Now().UTC()
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = now
+	}
+	if session.LastUsedAt.IsZero() {
+		session.LastUsedAt = now
+	}
+
+	clientId := sql.NullString{String: session.ClientID, Valid: session.ClientID != ""}
+	query := "INSERT INTO SESSION (id, user_id, token_hash, device_label, user_agent, ip, created_at, last_used_at, expires_at, " +
+		"client_id, scope) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err = s.db.Exec(query, session.ID, session.UserID, session.TokenHash, session.DeviceLabel,
+		session.UserAgent, session.IP, session.CreatedAt, session.LastUsedAt, session.ExpiresAt, clientId, session.Scope)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", session.UserID).Error("Error creating the session")
+		return err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"userId":    session.UserID,
+		"sessionId": session.ID,
+	}).Info("Session created with success")
+	return nil
+}
+
+// GetSession retrieves a session, whether it is active, expired or revoked.
+//
+// sessionId: The ID of the session.
+//
+// Returns the session, or a NotFoundError if it does not exist.
+func (s *MySQLStore) GetSession(sessionId string) (*model.Session, error) {
+	row := s.db.QueryRow("SELECT "+sessionColumns+" FROM SESSION WHERE id = ?", sessionId)
+	session, err := scanSession(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.WithField("sessionId", sessionId).Info("Session not found in DB")
+			return nil, notFound("session", sessionId)
+		}
+		s.logger.WithError(err).WithField("sessionId", sessionId).Error("Error retrieving the session from DB")
+		return nil, err
+	}
+	return session, nil
+}
+
+// ListSessions retrieves the active sessions of a user, i.e. the sessions that are neither revoked nor expired,
+// most recently used first.
+//
+// userId: The ID of the user.
+//
+// Returns the sessions, or a NotFoundError if the user does not exist.
+func (s *MySQLStore) ListSessions(userId int) ([]model.Session, error) {
+	found, err := s.exists("SELECT 1 FROM USERS WHERE id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, notFound("user", userId)
+	}
+
+	query := "SELECT " + sessionColumns + " FROM SESSION " +
+		"WHERE user_id = ? AND token_hash IS NOT NULL AND expires_at > ? ORDER BY last_used_at DESC, id"
+	rows, err := s.db.Query(query, userId, time.Now().UTC())
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error listing the sessions")
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []model.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error scanning a session")
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// RotateRefreshToken replaces the current refresh token of a session, if and only if it is still
+// previousTokenHash, records the session as used and keeps previousTokenHash in the ROTATED_REFRESH_TOKEN table.
+// The check and the update are a single statement, so two concurrent refreshes with the same token cannot both
+// succeed.
+//
+// sessionId: The ID of the session.
+// previousTokenHash: The hash of the refresh token being used.
+// tokenHash: The hash of the new refresh token.
+// expiresAt: The expiration time of the new refresh token.
+//
+// Returns a NotFoundError if the session does not exist or was revoked, and a ConflictError if
+// previousTokenHash is not the current token of the session anymore.
+func (s *MySQLStore) RotateRefreshToken(sessionId string,
+	previousTokenHash string,
+	tokenHash string,
+	expiresAt time.Time) error {
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("sessionId", sessionId).Error("Error starting the transaction")
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	query := "UPDATE SESSION SET token_hash = ?, expires_at = ?, last_used_at = ? WHERE id = ? AND token_hash = ?"
+	result, err := tx.Exec(query, tokenHash, expiresAt, now, sessionId, previousTokenHash)
+	if err != nil {
+		s.logger.WithError(err).WithField("sessionId", sessionId).Error("Error rotating the refresh token")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("sessionId", sessionId).
+			Error("Error getting the number of rows affected when trying to rotate the refresh token")
+		return err
+	}
+	if rowsAffected == 0 {
+		active, err := s.exists("SELECT 1 FROM SESSION WHERE id = ? AND token_hash IS NOT NULL", sessionId)
+		if err != nil {
+			return err
+		}
+		if !active {
+			return notFound("session", sessionId)
+		}
+		return conflict("session", sessionId, "the refresh token was already rotated")
+	}
+
+	query = "INSERT INTO ROTATED_REFRESH_TOKEN (token_hash, session_id, rotated_at) VALUES (?, ?, ?)"
+	_, err = tx.Exec(query, previousTokenHash, sessionId, now)
+	if err != nil {
+		s.logger.WithError(err).WithField("sessionId", sessionId).Error("Error recording the rotated refresh token")
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsRotatedRefreshToken reports whether a refresh token was issued for a session and rotated since, i.e. whether
+// presenting it again is a replay.
+//
+// sessionId: The ID of the session.
+// tokenHash: The hash of the refresh token.
+//
+// Returns true if the token was rotated, or an error returned by the database.
+func (s *MySQLStore) IsRotatedRefreshToken(sessionId string, tokenHash string) (bool, error) {
+	return s.exists("SELECT 1 FROM ROTATED_REFRESH_TOKEN WHERE token_hash = ? AND session_id = ?", tokenHash, sessionId)
+}
+
+// RevokeSession revokes a session by setting its current refresh token to NULL. Revoking a revoked session
+// succeeds without changing it.
+//
+// sessionId: The ID of the session.
+//
+// Returns a NotFoundError if the session does not exist, or any other error returned by the database.
+func (s *MySQLStore) RevokeSession(sessionId string) error {
+	found, err := s.exists("SELECT 1 FROM SESSION WHERE id = ?", sessionId)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFound("session", sessionId)
+	}
+
+	query := "UPDATE SESSION SET token_hash = NULL, revoked_at = ? WHERE id = ? AND token_hash IS NOT NULL"
+	_, err = s.db.Exec(query, time.Now().UTC(), sessionId)
+	if err != nil {
+		s.logger.WithError(err).WithField("sessionId", sessionId).Error("Error revoking the session")
+		return err
+	}
+
+	s.logger.WithField("sessionId", sessionId).Info("Session revoked with success")
+	return nil
+}
+
+// RevokeUserSessions revokes every active session of a user but one.
+//
+// userId: The ID of the user.
+// exceptSessionId: The ID of the session to keep, usually the session of the caller, or an empty string to
+// revoke every session.
+//
+// Returns the number of revoked sessions, or a NotFoundError if the user does not exist.
+func (s *MySQLStore) RevokeUserSessions(userId int, exceptSessionId string) (int, error) {
+	found, err := s.exists("SELECT 1 FROM USERS WHERE id = ?", userId)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, notFound("user", userId)
+	}
+
+	query := "UPDATE SESSION SET token_hash = NULL, revoked_at = ? WHERE user_id = ? AND id <> ? AND token_hash IS NOT NULL"
+	result, err := s.db.Exec(query, time.Now().UTC(), userId, exceptSessionId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error revoking the sessions")
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).
+			Error("Error getting the number of rows affected when trying to revoke the sessions")
+		return 0, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"userId":  userId,
+		"revoked": rowsAffected,
+	}).Info("Sessions revoked with success")
+	return int(rowsAffected), nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSession scans a row selected with sessionColumns.
+//
+// row: The *sql.Row or *sql.Rows to scan.
+//
+// Returns the session and an error, if any.
+func scanSession(row rowScanner) (*model.Session, error) {
+	var session model.Session
+	var tokenHash, deviceLabel, userAgent, ip, clientId sql.NullString
+	err := row.Scan(&session.ID, &session.UserID, &tokenHash, &deviceLabel, &userAgent, &ip,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &clientId, &session.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	session.TokenHash = tokenHash.String
+	session.Revoked = !tokenHash.Valid
+	session.DeviceLabel = deviceLabel.String
+	session.UserAgent = userAgent.String
+	session.IP = ip.String
+	session.ClientID = clientId.String
+	return &session, nil
+}
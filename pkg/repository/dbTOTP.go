@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SaveTOTP stores a pending TOTP enrollment of a user, replacing the previous pending one if any.
+//
+// totp: The enrollment; its UserID and EncryptedSecret must be set. CreatedAt defaults to the current time, the
+// other fields are ignored.
+//
+// Returns a NotFoundError if the user does not exist, a ConflictError if the TOTP of the user is enabled, or any
+// other error returned by the database.
+func (s *MySQLStore) SaveTOTP(totp model.TOTP) error {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", totp.UserID)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("user", totp.UserID)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", totp.UserID).Error("Error beginning the TOTP enrollment")
+		return err
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	err = tx.QueryRow("SELECT enabled FROM USER_TOTP WHERE user_id = ? FOR UPDATE", totp.UserID).Scan(&enabled)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.logger.WithError(err).WithField("userId", totp.UserID).Error("Error retrieving the TOTP of the user")
+		return err
+	} else if enabled {
+		return conflict("TOTP", totp.UserID, "already enabled")
+	}
+
+	if totp.CreatedAt.IsZero() {
+		totp.CreatedAt = time.Now()
+	}
+	query := "INSERT INTO USER_TOTP (user_id, secret, enabled, created_at) VALUES (?, ?, FALSE, ?) " +
+		"ON DUPLICATE KEY UPDATE secret = VALUES(secret), created_at = VALUES(created_at), confirmed_at = NULL, " +
+		"last_used_step = 0"
+	if _, err = tx.Exec(query, totp.UserID, totp.EncryptedSecret, totp.CreatedAt.UTC()); err != nil {
+		s.logger.WithError(err).WithField("userId", totp.UserID).Error("Error storing the TOTP enrollment")
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("userId", totp.UserID).Error("Error committing the TOTP enrollment")
+		return err
+	}
+
+	s.logger.WithField("userId", totp.UserID).Info("TOTP enrollment stored with success")
+	return nil
+}
+
+// GetTOTP retrieves the TOTP enrollment of a user, with the number of recovery codes it has left.
+//
+// userId: The ID of the user.
+//
+// Returns the enrollment, a NotFoundError if the user has none, or any other error returned by the database.
+func (s *MySQLStore) GetTOTP(userId int) (*model.TOTP, error) {
+	totp := model.TOTP{UserID: userId}
+	var confirmedAt sql.NullTime
+	query := "SELECT secret, enabled, created_at, confirmed_at, last_used_step, " +
+		"(SELECT COUNT(*) FROM TOTP_RECOVERY_CODE c WHERE c.user_id = t.user_id) " +
+		"FROM USER_TOTP t WHERE user_id = ?"
+	err := s.db.QueryRow(query, userId).Scan(&totp.EncryptedSecret, &totp.Enabled, &totp.CreatedAt, &confirmedAt,
+		&totp.LastUsedStep, &totp.RecoveryCodesLeft)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("TOTP", userId)
+	} else if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving the TOTP of the user")
+		return nil, err
+	}
+	totp.ConfirmedAt = confirmedAt.Time
+	return &totp, nil
+}
+
+// EnableTOTP enables the pending TOTP enrollment of a user, once confirmed with a first code, and replaces its
+// recovery codes.
+//
+// userId: The ID of the user.
+// step: The time step of the confirmation code, recorded so the code cannot be used to log in.
+// recoveryCodeHashes: The hashes of the new recovery codes.
+//
+// Returns a NotFoundError if the user has no enrollment, a ConflictError if it is already enabled, or any other
+// error returned by the database.
+func (s *MySQLStore) EnableTOTP(userId int, step int64, recoveryCodeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error beginning the TOTP activation")
+		return err
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	err = tx.QueryRow("SELECT enabled FROM USER_TOTP WHERE user_id = ? FOR UPDATE", userId).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound("TOTP", userId)
+	} else if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving the TOTP of the user")
+		return err
+	} else if enabled {
+		return conflict("TOTP", userId, "already enabled")
+	}
+
+	_, err = tx.Exec("UPDATE USER_TOTP SET enabled = TRUE, confirmed_at = ?, last_used_step = ? WHERE user_id = ?",
+		time.Now().UTC(), step, userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error enabling the TOTP of the user")
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM TOTP_RECOVERY_CODE WHERE user_id = ?", userId); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error deleting the recovery codes of the user")
+		return err
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec("INSERT INTO TOTP_RECOVERY_CODE (user_id, code_hash) VALUES (?, ?)", userId, codeHash)
+		if err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error storing a recovery code of the user")
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error committing the TOTP activation")
+		return err
+	}
+
+	s.logger.WithField("userId", userId).Info("TOTP enabled with success")
+	return nil
+}
+
+// UseTOTPStep records that a code of the enabled TOTP of a user was accepted. The update only applies to a
+// later time step, so concurrent requests cannot use the same code twice.
+//
+// userId: The ID of the user.
+// step: The time step of the accepted code.
+//
+// Returns a NotFoundError if the user has no enabled TOTP, a ConflictError if a code of this time step or of a
+// later one was already used, or any other error returned by the database.
+func (s *MySQLStore) UseTOTPStep(userId int, step int64) error {
+	result, err := s.db.Exec("UPDATE USER_TOTP SET last_used_step = ? "+
+		"WHERE user_id = ? AND enabled = TRUE AND last_used_step < ?", step, userId, step)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error recording the TOTP code of the user")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).
+			Error("Error getting the number of rows affected when trying to record the TOTP code")
+		return err
+	}
+	if rowsAffected == 0 {
+		found, err := s.exists("SELECT COUNT(*) FROM USER_TOTP WHERE user_id = ? AND enabled = TRUE", userId)
+		if err != nil {
+			return err
+		} else if !found {
+			return notFound("TOTP", userId)
+		}
+		return conflict("TOTP code", userId, "already used")
+	}
+	return nil
+}
+
+// UseRecoveryCode uses a recovery code of a user, deleting it so it cannot be used again.
+//
+// userId: The ID of the user.
+// codeHash: The hash of the recovery code.
+//
+// Returns a NotFoundError if the user has no such recovery code, or any other error returned by the database.
+func (s *MySQLStore) UseRecoveryCode(userId int, codeHash string) error {
+	result, err := s.db.Exec("DELETE FROM TOTP_RECOVERY_CODE WHERE user_id = ? AND code_hash = ?", userId, codeHash)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error using the recovery code of the user")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).
+			Error("Error getting the number of rows affected when trying to use a recovery code")
+		return err
+	}
+	if rowsAffected == 0 {
+		return notFound("recovery code", userId)
+	}
+
+	s.logger.WithField("userId", userId).Info("Recovery code used with success")
+	return nil
+}
+
+// DeleteTOTP deletes the TOTP enrollment of a user, pending or enabled, together with its recovery codes.
+//
+// userId: The ID of the user.
+//
+// Returns a NotFoundError if the user has no enrollment, or any other error returned by the database.
+func (s *MySQLStore) DeleteTOTP(userId int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error beginning the TOTP removal")
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("DELETE FROM TOTP_RECOVERY_CODE WHERE user_id = ?", userId); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error deleting the recovery codes of the user")
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM USER_TOTP WHERE user_id = ?", userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error deleting the TOTP of the user")
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).
+			Error("Error getting the number of rows affected when trying to delete the TOTP")
+		return err
+	}
+	if rowsAffected == 0 {
+		return notFound("TOTP", userId)
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error committing the TOTP removal")
+		return err
+	}
+
+	s.logger.WithField("userId", userId).Info("TOTP removed with success")
+	return nil
+}
@@ -2,199 +2,908 @@ package repository
 
 import (
 	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
 	"database/sql"
 	"errors"
 	"fmt"
-	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
 )
 
-// UserExists checks if a user with the given username or email already exists in the database.
+// UserExists checks if a user with the given username or email already exists in the database, including the
+// deleted users that are not purged yet.
 //
-// logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the sql.DB instance representing the database connection.
 // username: The username to be checked for existence.
-// email: The email to be checked for existence.
+// email: The email to be checked for existence; an empty email is not checked.
 //
 // Returns true if a user with the provided username or email exists in the database, false otherwise.
 // Returns an error if there is an issue with the database query.
-func UserExists(logger *logrus.Logger, db *sql.DB, username string, email string) (bool, error) {
+func (s *MySQLStore) UserExists(username string, email string) (bool, error) {
 	var count int
-	query := `SELECT COUNT(*) FROM USERS WHERE username = ? OR email = ?`
-	err := db.QueryRow(query, username, email).Scan(&count)
+	query := `SELECT COUNT(*) FROM USERS WHERE username = ? OR (? <> '' AND email = ?)`
+	err := s.db.QueryRow(query, username, email, email).Scan(&count)
 	if err != nil {
-		logger.WithError(err).WithField("username", username).Error("Error checking if user exists")
+		s.logger.WithError(err).WithField("username", username).Error("Error checking if user exists")
 		return false, err
 	}
 
-	logger.WithField("username", username).Info("Verify if user exists with success")
+	s.logger.WithField("username", username).Info("Verify if user exists with success")
 	return count >= 1, nil
 }
 
 // addUserWithoutRole adds a new user to the database with the provided user information and without assigning a role.
 //
-// logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the sql.DB instance representing the database connection.
 // user: A model.User struct containing the user information to be added.
 //
 // Returns an error if there is any issue while adding the user.
 // If successful, the user is added to the database without errors.
-func addUserWithoutRole(logger *logrus.Logger, db *sql.DB, user model.User) error {
-	query := `INSERT INTO USERS (username, hashed_password, email, country, phone) VALUES (?, ?, ?, ?, ?)`
-	result, err := db.Exec(query, user.Username, user.HashedPassword, user.Email, user.Country, user.Phone)
+func (s *MySQLStore) addUserWithoutRole(user model.User) error {
+	query := `INSERT INTO USERS (username, hashed_password, email, country, phone, email_verified, email_verified_at)
+		VALUES (?, ?, ?, ?, ?, ?, IF(?, CURRENT_TIMESTAMP, NULL))`
+	result, err := s.db.Exec(query, user.Username, user.HashedPassword, user.Email, user.Country, user.Phone,
+		user.EmailVerified, user.EmailVerified)
 	if err != nil {
-		logger.WithError(err).WithField("username", user.Username).Error("Error adding user without roles")
+		s.logger.WithError(err).WithField("username", user.Username).Error("Error adding user without roles")
 		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		logger.WithError(err).WithField("username", user.Username).Error("Error getting rows affected")
+		s.logger.WithError(err).WithField("username", user.Username).Error("Error getting rows affected")
 		return err
 	}
 
 	if !(rowsAffected > 0) {
-		logger.WithField("username", user.Username).Warn("No errors adding user, but no rows affected")
+		s.logger.WithField("username", user.Username).Warn("No errors adding user, but no rows affected")
 		return fmt.Errorf("no errors adding user %s, but no rows affected", user.Username)
 	}
 
-	logger.WithField("username", user.Username).Info("Add user without roles with success")
+	s.logger.WithField("username", user.Username).Info("Add user without roles with success")
 	return nil
 }
 
 // AddUser adds a new user to the database with the provided user information and assigns a specified role.
 //
-// logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the sql.DB instance representing the database connection.
 // user: A model.User struct containing the user information to be added.
 // roleName: The name of the role to be assigned to the user.
 //
 // Returns an error if there is any issue while adding the user or setting the role.
 // If successful, the user is added to the database with the specified role without errors.
-func AddUser(logger *logrus.Logger, db *sql.DB, user model.User, roleName string) error {
-	err := addUserWithoutRole(logger, db, user)
+func (s *MySQLStore) AddUser(user model.User, roleName string) error {
+	err := s.addUserWithoutRole(user)
 	if err != nil {
 		return err
 	}
 
-	err = SetUserRole(logger, db, user.Username, roleName)
+	err = s.SetUserRole(user.Username, roleName)
 	if err != nil {
 		return err
 	}
 
-	logger.WithField("username", user.Username).Info("Add user with success")
+	s.logger.WithField("username", user.Username).Info("Add user with success")
 	return nil
 }
 
+// userColumns are the columns of USERS read into a model.User by scanUser.
+const userColumns = `id, username, hashed_password, email, country, phone, date_created, updated_at, version,
+	email_verified, failed_logins, last_failed_login_at, locked_until, status, password_reset_required, deleted_at`
+
+// notDeleted is the condition leaving the deleted users out of a query on USERS, with utils.UserStatusDeleted as
+// argument.
+const notDeleted = "status <> ?"
+
+// scanUser reads a row of userColumns into a model.User.
+//
+// row: The row returned by a query selecting userColumns.
+//
+// Returns a pointer to the user, or the error of the scan, e.g. sql.ErrNoRows.
+func scanUser(row rowScanner) (*model.User, error) {
+	var user model.User
+	var lastFailedLoginAt, lockedUntil, deletedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Username, &user.HashedPassword, &user.Email, &user.Country, &user.Phone,
+		&user.DateCreated, &user.UpdatedAt, &user.Version, &user.EmailVerified, &user.FailedLogins,
+		&lastFailedLoginAt, &lockedUntil, &user.Status, &user.PasswordResetRequired, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	user.LastFailedLoginAt = lastFailedLoginAt.Time
+	user.LockedUntil = lockedUntil.Time
+	user.DeletedAt = deletedAt.Time
+	return &user, nil
+}
+
 // GetUserByUserName retrieves user information from the database based on the provided username.
 //
-// logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the sql.DB instance representing the database connection.
 // username: The username for which user information should be retrieved.
 //
 // Returns a pointer to a model.User struct containing the user information if found in the database.
-// Returns sql.ErrNoRows if no user with the provided username is found.
+// Returns sql.ErrNoRows if no user with the provided username is found, or if the user is deleted.
 // Returns an error if there is an issue with the database query.
-func GetUserByUserName(logger *logrus.Logger, db *sql.DB, username string) (*model.User, error) {
-	query := `SELECT id, hashed_password, email, country, phone FROM USERS WHERE username= ?`
-	var user model.User
-	user.Username = username
-	err := db.QueryRow(query, username).Scan(&user.ID, &user.HashedPassword, &user.Email, &user.Country, &user.Phone)
+func (s *MySQLStore) GetUserByUserName(username string) (*model.User, error) {
+	user, err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM USERS WHERE username = ? AND "+notDeleted,
+		username, utils.UserStatusDeleted))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			logger.WithField("username", username).Info("User not found in DB")
+			s.logger.WithField("username", username).Info("User not found in DB")
 			return nil, err
 		}
-		logger.WithError(err).WithField("username", username).Error("Error retrieving user from DB")
+		s.logger.WithError(err).WithField("username", username).Error("Error retrieving user from DB")
 		return nil, err
 	}
 
-	logger.WithField("username", username).Info("Get user by username with success")
-	return &user, nil
+	s.logger.WithField("username", username).Info("Get user by username with success")
+	return user, nil
+}
+
+// GetUserById retrieves user information from the database based on the provided user ID.
+//
+// userId: The ID of the user.
+//
+// Returns a pointer to a model.User struct containing the user information if found in the database.
+// Returns sql.ErrNoRows if no user with the provided ID is found or the user is deleted, or any error of the
+// database query.
+func (s *MySQLStore) GetUserById(userId int) (*model.User, error) {
+	user, err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM USERS WHERE id = ? AND "+notDeleted,
+		userId, utils.UserStatusDeleted))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.WithField("userId", userId).Info("User not found in DB")
+			return nil, err
+		}
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving user from DB")
+		return nil, err
+	}
+
+	s.logger.WithField("userId", userId).Info("Get user by userId with success")
+	return user, nil
+}
+
+// GetUserByEmail retrieves user information from the database based on the provided email address.
+//
+// email: The email address of the user.
+//
+// Returns a pointer to a model.User struct containing the user information if found in the database.
+// Returns sql.ErrNoRows if no user with the provided email is found or the user is deleted, or any error of the
+// database query.
+func (s *MySQLStore) GetUserByEmail(email string) (*model.User, error) {
+	user, err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM USERS WHERE email = ? AND "+notDeleted+
+		" LIMIT 1", email, utils.UserStatusDeleted))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.Info("User not found by email in DB")
+			return nil, err
+		}
+		s.logger.WithError(err).Error("Error retrieving user by email from DB")
+		return nil, err
+	}
+
+	s.logger.WithField("userId", user.ID).Info("Get user by email with success")
+	return user, nil
 }
 
 // GetUserNameByUserId retrieves the username associated with a user ID from the database.
 //
-// logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the SQL database instance.
 // userId: The ID of the user whose username needs to be retrieved.
 //
 // Returns the username associated with the given user ID.
-// Returns an error if the user is not found in the database or if there's any error during retrieval.
-func GetUserNameByUserId(logger *logrus.Logger, db *sql.DB, userId int) (string, error) {
-	query := `SELECT username FROM USERS WHERE id= ?`
+// Returns an error if the user is not found in the database or is deleted, or if there's any error during retrieval.
+func (s *MySQLStore) GetUserNameByUserId(userId int) (string, error) {
+	query := `SELECT username FROM USERS WHERE id= ? AND ` + notDeleted
 	var username string
-	err := db.QueryRow(query, userId).Scan(&username)
+	err := s.db.QueryRow(query, userId, utils.UserStatusDeleted).Scan(&username)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			logger.WithField("userId", userId).Info("Username not found in DB")
+			s.logger.WithField("userId", userId).Info("Username not found in DB")
 			return "", err
 		}
-		logger.WithError(err).WithField("userId", userId).Error("Error retrieving username from DB")
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving username from DB")
 		return "", err
 	}
 
-	logger.WithField("userId", userId).Info("Get username by userId with success")
+	s.logger.WithField("userId", userId).Info("Get username by userId with success")
 	return username, nil
 }
 
 // GetUserIdByUserName retrieves the user ID associated with a username from the database.
 //
-// logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the SQL database instance.
 // username: The username for which the user ID needs to be retrieved.
 //
 // Returns the user ID associated with the given username.
-// Returns -1 and an error if the user is not found in the database or if there's any error during retrieval.
-func GetUserIdByUserName(logger *logrus.Logger, db *sql.DB, username string) (int, error) {
-	query := `SELECT id FROM USERS WHERE username= ?`
+// Returns -1 and an error if the user is not found in the database or is deleted, or if there's any error during
+// retrieval.
+func (s *MySQLStore) GetUserIdByUserName(username string) (int, error) {
+	query := `SELECT id FROM USERS WHERE username= ? AND ` + notDeleted
 	var userId int
-	err := db.QueryRow(query, userId).Scan(&userId)
+	err := s.db.QueryRow(query, username, utils.UserStatusDeleted).Scan(&userId)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			logger.WithField("userName", username).Info("UserId not found in DB")
+			s.logger.WithField("userName", username).Info("UserId not found in DB")
 			return -1, err
 		}
-		logger.WithError(err).WithField("userName", username).Error("Error retrieving userId from DB")
+		s.logger.WithError(err).WithField("userName", username).Error("Error retrieving userId from DB")
 		return -1, err
 	}
 
-	logger.WithField("userName", username).Info("Get userId by username with success")
+	s.logger.WithField("userName", username).Info("Get userId by username with success")
 	return userId, nil
 }
 
-// DeleteUser removes a user and associated records from the database based on the user ID.
+// UpdatePassword replaces the password hash of a user in the database, which also clears a password reset
+// required by an administrator.
+//
+// userId: The ID of the user whose password is changed.
+// hashedPassword: The bcrypt hash of the new password.
+//
+// Returns sql.ErrNoRows if the user is not found in the database or is deleted, or any error during the update.
+func (s *MySQLStore) UpdatePassword(userId int, hashedPassword string) error {
+	result, err := s.db.Exec("UPDATE USERS SET hashed_password = ?, password_reset_required = FALSE "+
+		"WHERE id = ? AND "+notDeleted, hashedPassword, userId, utils.UserStatusDeleted)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error updating the password of the user")
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error updating the password of the user")
+		return err
+	}
+	if updated == 0 {
+		// MySQL does not count the rows left unchanged, so tell an unchanged hash from a missing user
+		exists, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ? AND "+notDeleted, userId,
+			utils.UserStatusDeleted)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			s.logger.WithField("userId", userId).Info("User not found in DB")
+			return sql.ErrNoRows
+		}
+	}
+
+	s.logger.WithField("userId", userId).Info("Password updated with success")
+	return nil
+}
+
+// UpdateProfile applies a partial update to the profile of a user and increments its version. The row of the
+// user is locked while the version and the email are checked, so concurrent updates are applied one at a time.
+//
+// userId: The ID of the user.
+// version: The version of the profile the update was made on.
+// update: The fields to change; nil fields are left unchanged.
+//
+// Returns a pointer to the updated user. Returns sql.ErrNoRows if the user is not found or is deleted, a
+// ConflictError if the profile is no longer at the given version or the new email is used by another user, or any
+// database error.
+func (s *MySQLStore) UpdateProfile(userId int, version int, update model.ProfileUpdate) (*model.User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error beginning the profile update")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM USERS WHERE id = ? AND "+notDeleted+
+		" FOR UPDATE", userId, utils.UserStatusDeleted))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.WithField("userId", userId).Info("User not found in DB")
+			return nil, err
+		}
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving user from DB")
+		return nil, err
+	}
+	if user.Version != version {
+		return nil, conflict("user", userId, fmt.Sprintf("version %d is outdated, the current version is %d",
+			version, user.Version))
+	}
+
+	if update.Email != nil && *update.Email != user.Email {
+		var count int
+		err = tx.QueryRow("SELECT COUNT(*) FROM USERS WHERE email = ? AND id <> ?", *update.Email, userId).Scan(&count)
+		if err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error checking if email is in use")
+			return nil, err
+		} else if count > 0 {
+			return nil, conflict("email", *update.Email, "already in use")
+		}
+	}
+	user.ApplyProfileUpdate(update)
+
+	_, err = tx.Exec("UPDATE USERS SET email = ?, country = ?, phone = ?, email_verified = ?, "+
+		"email_verified_at = IF(?, email_verified_at, NULL), updated_at = CURRENT_TIMESTAMP, "+
+		"version = version + 1 WHERE id = ?",
+		user.Email, user.Country, user.Phone, user.EmailVerified, user.EmailVerified, userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error updating the profile of the user")
+		return nil, err
+	}
+	user, err = scanUser(tx.QueryRow("SELECT "+userColumns+" FROM USERS WHERE id = ?", userId))
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving user from DB")
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error committing the profile update")
+		return nil, err
+	}
+
+	s.logger.WithField("userId", userId).Info("Profile updated with success")
+	return user, nil
+}
+
+// VerifyEmail marks the email address of a user as verified. The address is given, so a verification sent
+// before the user changed its email address cannot verify the new one.
+//
+// userId: The ID of the user whose email address was verified.
+// email: The email address the verification was sent to.
+//
+// Returns sql.ErrNoRows if the user is not found in the database or is deleted, a ConflictError if the email
+// address of the user is no longer the given one, or any error during the update. Verifying an address twice is
+// not an error.
+func (s *MySQLStore) VerifyEmail(userId int, email string) error {
+	result, err := s.db.Exec("UPDATE USERS SET email_verified = TRUE, email_verified_at = CURRENT_TIMESTAMP "+
+		"WHERE id = ? AND email = ? AND NOT email_verified AND "+notDeleted, userId, email, utils.UserStatusDeleted)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error verifying the email of the user")
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error verifying the email of the user")
+		return err
+	}
+	if updated == 0 {
+		// Nothing changed: the address is already verified, was replaced, or the user is gone
+		var current string
+		err = s.db.QueryRow("SELECT email FROM USERS WHERE id = ? AND "+notDeleted, userId,
+			utils.UserStatusDeleted).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.WithField("userId", userId).Info("User not found in DB")
+			return err
+		} else if err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving user from DB")
+			return err
+		}
+		if current != email {
+			return conflict("user", userId, "the email address changed since the verification was sent")
+		}
+	}
+
+	s.logger.WithField("userId", userId).Info("Email verified with success")
+	return nil
+}
+
+// DeleteUser removes a user and associated records from the database based on the user ID, for good. The
+// administrators and the users themselves delete users with SoftDeleteUser instead, which can be undone.
 //
-// logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the SQL database instance.
 // userId: The ID of the user to be removed.
 //
 // Returns an error if there is any issue while deleting the user or associated records.
 // If successful, the user and associated records are removed from the database without errors.
-func DeleteUser(logger *logrus.Logger, db *sql.DB, userId int) error {
-	tx, err := db.Begin()
+func (s *MySQLStore) DeleteUser(userId int) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		logger.WithError(err).WithField("userId", userId).Error("Error beginning the multiple queries")
+		s.logger.WithError(err).WithField("userId", userId).Error("Error beginning the multiple queries")
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.deleteUser(tx, userId); err != nil {
 		return err
 	}
 
+	s.logger.WithField("userId", userId).Info("user removed successfully")
+	return tx.Commit()
+}
+
+// deleteUser removes a user with its roles and sessions within a transaction; the other records of the user are
+// removed by the ON DELETE CASCADE of their foreign keys.
+func (s *MySQLStore) deleteUser(tx *sql.Tx, userId int) error {
 	if _, err := tx.Exec("DELETE FROM USER_ROLE WHERE user_id = ?", userId); err != nil {
-		logger.WithError(err).WithField("userId", userId).Error("Error executing the first query to remove a user")
-		tx.Rollback()
+		s.logger.WithError(err).WithField("userId", userId).Error("Error executing the first query to remove a user")
 		return err
 	}
 
-	if _, err := tx.Exec("DELETE FROM USER_AUTH WHERE user_id = ?", userId); err != nil {
-		logger.WithError(err).WithField("userId", userId).Error("Error executing the second query to remove a user")
-		tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM SESSION WHERE user_id = ?", userId); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error executing the second query to remove a user")
 		return err
 	}
 
 	if _, err := tx.Exec("DELETE FROM USERS WHERE id = ?", userId); err != nil {
-		logger.WithError(err).WithField("userId", userId).Error("Error executing the third query to remove a user")
-		tx.Rollback()
+		s.logger.WithError(err).WithField("userId", userId).Error("Error executing the third query to remove a user")
 		return err
 	}
+	return nil
+}
 
-	logger.WithField("userId", userId).Info("user removed successfully")
-	return tx.Commit()
+// SoftDeleteUser marks a user as deleted and removes its sessions. Its other records are kept, so RestoreUser can
+// undo the deletion until PurgeDeletedUsers removes the user for good. The username and the email address stay in
+// use meanwhile. The change of the status is recorded in USER_CHANGE.
+//
+// userId: The ID of the user to delete.
+// actorId: The ID of the user deleting it, the user itself when it deletes its own account.
+//
+// Returns sql.ErrNoRows if the user is not found or is already deleted, or any database error.
+func (s *MySQLStore) SoftDeleteUser(userId int, actorId int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error beginning the user deletion")
+		return err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM USERS WHERE id = ? AND "+notDeleted+
+		" FOR UPDATE", userId, utils.UserStatusDeleted))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.WithField("userId", userId).Info("User not found in DB")
+			return err
+		}
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving user from DB")
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.Exec("UPDATE USERS SET status = ?, deleted_at = ?, updated_at = CURRENT_TIMESTAMP, "+
+		"version = version + 1 WHERE id = ?", utils.UserStatusDeleted, now.UTC(), userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error deleting the user")
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM SESSION WHERE user_id = ?", userId); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error removing the sessions of the user")
+		return err
+	}
+	if err = s.recordStatusChange(tx, user, utils.UserStatusDeleted, actorId, now); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error committing the user deletion")
+		return err
+	}
+
+	s.logger.WithField("userId", userId).Info("User deleted with success")
+	return nil
+}
+
+// RestoreUser undoes the deletion of a user made by SoftDeleteUser: the user is active again, with the roles it
+// had, and can log in. The change of the status is recorded in USER_CHANGE.
+//
+// userId: The ID of the deleted user.
+// actorId: The ID of the administrator restoring it.
+//
+// Returns a pointer to the restored user. Returns sql.ErrNoRows if the user is not found, e.g. because it was
+// purged, a ConflictError if the user is not deleted, or any database error.
+func (s *MySQLStore) RestoreUser(userId int, actorId int) (*model.User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error beginning the user restoration")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM USERS WHERE id = ? FOR UPDATE", userId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.WithField("userId", userId).Info("User not found in DB")
+			return nil, err
+		}
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving user from DB")
+		return nil, err
+	}
+	if user.Status != utils.UserStatusDeleted {
+		return nil, conflict("user", userId, "the user is not deleted")
+	}
+
+	_, err = tx.Exec("UPDATE USERS SET status = ?, deleted_at = NULL, updated_at = CURRENT_TIMESTAMP, "+
+		"version = version + 1 WHERE id = ?", utils.UserStatusActive, userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error restoring the user")
+		return nil, err
+	}
+	if err = s.recordStatusChange(tx, user, utils.UserStatusActive, actorId, time.Now()); err != nil {
+		return nil, err
+	}
+	user, err = scanUser(tx.QueryRow("SELECT "+userColumns+" FROM USERS WHERE id = ?", userId))
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving user from DB")
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error committing the user restoration")
+		return nil, err
+	}
+
+	s.logger.WithField("userId", userId).Info("User restored with success")
+	return user, nil
+}
+
+// recordStatusChange records in USER_CHANGE the change of the status of a user within a transaction.
+func (s *MySQLStore) recordStatusChange(tx *sql.Tx, user *model.User, status string, actorId int, now time.Time) error {
+	after := *user
+	after.Status = status
+	for _, change := range userChanges(user, &after, nil, nil, actorId, now) {
+		_, err := tx.Exec("INSERT INTO USER_CHANGE (user_id, actor_id, field, old_value, new_value, changed_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?)", change.UserID, change.ActorID, change.Field, change.OldValue,
+			change.NewValue, change.ChangedAt.UTC())
+		if err != nil {
+			s.logger.WithError(err).WithField("userId", user.ID).Error("Error recording the change of the user")
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeDeletedUsers removes for good, with every record referencing them, the users deleted by SoftDeleteUser
+// before the given time. The users are locked while they are removed, so a user restored meanwhile is kept.
+//
+// before: The time before which the users were deleted.
+//
+// Returns the number of users removed, or any database error.
+func (s *MySQLStore) PurgeDeletedUsers(before time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).Error("Error beginning the purge of the deleted users")
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM USERS WHERE status = ? AND deleted_at < ? FOR UPDATE",
+		utils.UserStatusDeleted, before.UTC())
+	if err != nil {
+		s.logger.WithError(err).Error("Error selecting the deleted users")
+		return 0, err
+	}
+	var userIds []int
+	for rows.Next() {
+		var userId int
+		if err := rows.Scan(&userId); err != nil {
+			rows.Close()
+			s.logger.WithError(err).Error("Error scanning a deleted user")
+			return 0, err
+		}
+		userIds = append(userIds, userId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		s.logger.WithError(err).Error("Error iterating over the deleted users")
+		return 0, err
+	}
+
+	for _, userId := range userIds {
+		if err := s.deleteUser(tx, userId); err != nil {
+			return 0, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).Error("Error committing the purge of the deleted users")
+		return 0, err
+	}
+	return len(userIds), nil
+}
+
+// userSortExpressions maps the sort fields of a user listing to the expressions they sort by. NULL emails and
+// countries sort as empty strings, so the keyset condition of the cursor applies to every row.
+var userSortExpressions = map[string]string{
+	utils.UserSortUsername:    "username",
+	utils.UserSortEmail:       "COALESCE(email, '')",
+	utils.UserSortCountry:     "COALESCE(country, '')",
+	utils.UserSortDateCreated: "date_created",
+}
+
+// ListUsers retrieves a page of the users matching a query, with the names of their roles. The users are
+// selected, sorted and paginated by a single query, and the roles of the whole page are read by a second one.
+//
+// query: The filters, sort order, cursor and page size; a Limit of 0 or less selects every matching user.
+//
+// Returns the users in the sort order, or an error if a cursor value is invalid or the database fails.
+func (s *MySQLStore) ListUsers(query model.UserQuery) ([]model.UserSummary, error) {
+	conditions := []string{notDeleted}
+	args := []interface{}{utils.UserStatusDeleted}
+	if query.Status != "" {
+		conditions[0] = "status = ?"
+		args[0] = query.Status
+	}
+	if query.Role != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM USER_ROLE ur JOIN ROLE r ON r.id = ur.role_id "+
+			"WHERE ur.user_id = USERS.id AND r.name = ?)")
+		args = append(args, query.Role)
+	}
+	if query.Country != "" {
+		conditions = append(conditions, "LOWER(country) = LOWER(?)")
+		args = append(args, query.Country)
+	}
+	if query.Search != "" {
+		pattern := "%" + escapeLike(strings.ToLower(query.Search)) + "%"
+		conditions = append(conditions, "(LOWER(username) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!')")
+		args = append(args, pattern, pattern)
+	}
+	if !query.CreatedFrom.IsZero() {
+		conditions = append(conditions, "date_created >= ?")
+		args = append(args, query.CreatedFrom.UTC())
+	}
+	if !query.CreatedTo.IsZero() {
+		conditions = append(conditions, "date_created < ?")
+		args = append(args, query.CreatedTo.UTC())
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	sortExpression, sorted := userSortExpressions[query.Sort]
+	if query.After != nil {
+		if !sorted {
+			conditions = append(conditions, "id "+comparison+" ?")
+			args = append(args, query.After.ID)
+		} else {
+			var value interface{} = query.After.Value
+			if query.Sort == utils.UserSortDateCreated {
+				createdAt, err := time.Parse(time.RFC3339Nano, query.After.Value)
+				if err != nil {
+					return nil, err
+				}
+				value = createdAt.UTC()
+			}
+			conditions = append(conditions, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))",
+				sortExpression, comparison, sortExpression, comparison))
+			args = append(args, value, value, query.After.ID)
+		}
+	}
+
+	statement := "SELECT " + userColumns + " FROM USERS WHERE " + strings.Join(conditions, " AND ")
+	if sorted {
+		statement += " ORDER BY " + sortExpression + " " + direction + ", id " + direction
+	} else {
+		statement += " ORDER BY id " + direction
+	}
+	if query.Limit > 0 {
+		statement += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.db.Query(statement, args...)
+	if err != nil {
+		s.logger.WithError(err).Error("Error listing the users")
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []model.UserSummary{}
+	positions := make(map[int]int)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			s.logger.WithError(err).Error("Error scanning a user")
+			return nil, err
+		}
+		positions[user.ID] = len(users)
+		users = append(users, model.UserSummary{Profile: user.Profile(), Roles: []string{}})
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.WithError(err).Error("Error iterating over the users")
+		return nil, err
+	}
+	if len(users) == 0 {
+		return users, nil
+	}
+
+	placeholders := make([]string, len(users))
+	userIds := make([]interface{}, len(users))
+	for i, user := range users {
+		placeholders[i] = "?"
+		userIds[i] = user.ID
+	}
+	roleRows, err := s.db.Query("SELECT ur.user_id, r.name FROM USER_ROLE ur JOIN ROLE r ON r.id = ur.role_id "+
+		"WHERE ur.user_id IN ("+strings.Join(placeholders, ", ")+") ORDER BY ur.user_id, r.id", userIds...)
+	if err != nil {
+		s.logger.WithError(err).Error("Error listing the roles of the users")
+		return nil, err
+	}
+	defer roleRows.Close()
+
+	for roleRows.Next() {
+		var userId int
+		var role string
+		if err := roleRows.Scan(&userId, &role); err != nil {
+			s.logger.WithError(err).Error("Error scanning a role of a user")
+			return nil, err
+		}
+		users[positions[userId]].Roles = append(users[positions[userId]].Roles, role)
+	}
+	return users, roleRows.Err()
+}
+
+// userRoleNames reads the names of the roles of a user within a transaction, ordered by role ID.
+func userRoleNames(tx *sql.Tx, userId int) ([]string, error) {
+	rows, err := tx.Query("SELECT r.name FROM ROLE r INNER JOIN USER_ROLE ur ON r.id = ur.role_id "+
+		"WHERE ur.user_id = ? ORDER BY r.id", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, with ! as escape character.
+func escapeLike(value string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value)
+}
+
+// UpdateUser applies the changes of an administrator to a user and increments its version. The profile, the
+// status and the roles are changed in a single transaction, with the row of the user locked, and every changed
+// field is recorded in USER_CHANGE.
+//
+// userId: The ID of the user.
+// version: The version of the user the update was made on.
+// update: The fields to change; nil fields are left unchanged and non-nil Roles replace every role of the user.
+// actorId: The ID of the administrator making the update.
+//
+// Returns a pointer to the updated user. Returns sql.ErrNoRows if the user is not found or is deleted, a
+// NotFoundError if a role does not exist, a ConflictError if the user is no longer at the given version or the new
+// email is used by another user, or any database error.
+func (s *MySQLStore) UpdateUser(userId int,
+	version int,
+	update model.AdminUserUpdate,
+	actorId int) (*model.User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error beginning the user update")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM USERS WHERE id = ? AND "+notDeleted+
+		" FOR UPDATE", userId, utils.UserStatusDeleted))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.logger.WithField("userId", userId).Info("User not found in DB")
+			return nil, err
+		}
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving user from DB")
+		return nil, err
+	}
+	if user.Version != version {
+		return nil, conflict("user", userId, fmt.Sprintf("version %d is outdated, the current version is %d",
+			version, user.Version))
+	}
+
+	if update.Email != nil && *update.Email != user.Email {
+		var count int
+		err = tx.QueryRow("SELECT COUNT(*) FROM USERS WHERE email = ? AND id <> ?", *update.Email, userId).Scan(&count)
+		if err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error checking if email is in use")
+			return nil, err
+		} else if count > 0 {
+			return nil, conflict("email", *update.Email, "already in use")
+		}
+	}
+
+	var roleIds []int
+	var beforeRoles, afterRoles []string
+	if update.Roles != nil {
+		if beforeRoles, err = userRoleNames(tx, userId); err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving the roles of the user")
+			return nil, err
+		}
+
+		// A name given twice is assigned once, and the roles are recorded in the order of their IDs
+		names := make(map[int]string)
+		for _, name := range *update.Roles {
+			var roleId int
+			err = tx.QueryRow("SELECT id FROM ROLE WHERE name = ?", name).Scan(&roleId)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, notFound("role", name)
+			} else if err != nil {
+				s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving the role")
+				return nil, err
+			}
+			if _, ok := names[roleId]; !ok {
+				names[roleId] = name
+				roleIds = append(roleIds, roleId)
+			}
+		}
+		sort.Ints(roleIds)
+		afterRoles = []string{}
+		for _, roleId := range roleIds {
+			afterRoles = append(afterRoles, names[roleId])
+		}
+	}
+
+	before := *user
+	user.ApplyAdminUserUpdate(update)
+	_, err = tx.Exec("UPDATE USERS SET email = ?, country = ?, phone = ?, email_verified = ?, "+
+		"email_verified_at = IF(?, email_verified_at, NULL), status = ?, password_reset_required = ?, "+
+		"updated_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ?",
+		user.Email, user.Country, user.Phone, user.EmailVerified, user.EmailVerified, user.Status,
+		user.PasswordResetRequired, userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error updating the user")
+		return nil, err
+	}
+
+	if update.Roles != nil {
+		if _, err = tx.Exec("DELETE FROM USER_ROLE WHERE user_id = ?", userId); err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error removing the roles of the user")
+			return nil, err
+		}
+		for _, roleId := range roleIds {
+			if _, err = tx.Exec("INSERT INTO USER_ROLE (user_id, role_id) VALUES (?, ?)", userId, roleId); err != nil {
+				s.logger.WithError(err).WithField("userId", userId).Error("Error assigning a role to the user")
+				return nil, err
+			}
+		}
+	}
+
+	for _, change := range userChanges(&before, user, beforeRoles, afterRoles, actorId, time.Now()) {
+		_, err = tx.Exec("INSERT INTO USER_CHANGE (user_id, actor_id, field, old_value, new_value, changed_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?)", change.UserID, change.ActorID, change.Field, change.OldValue,
+			change.NewValue, change.ChangedAt.UTC())
+		if err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error recording the change of the user")
+			return nil, err
+		}
+	}
+
+	user, err = scanUser(tx.QueryRow("SELECT "+userColumns+" FROM USERS WHERE id = ?", userId))
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error retrieving user from DB")
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error committing the user update")
+		return nil, err
+	}
+
+	s.logger.WithField("userId", userId).Info("User updated with success")
+	return user, nil
+}
+
+// ListUserChanges retrieves the changes made to a user by the administrators, oldest first. The changes of a
+// deleted user are listed until it is purged.
+//
+// userId: The ID of the user.
+//
+// Returns the changes. Returns sql.ErrNoRows if the user is not found, or any database error.
+func (s *MySQLStore) ListUserChanges(userId int) ([]model.UserChange, error) {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", userId)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		s.logger.WithField("userId", userId).Info("User not found in DB")
+		return nil, sql.ErrNoRows
+	}
+
+	rows, err := s.db.Query("SELECT id, user_id, actor_id, field, old_value, new_value, changed_at "+
+		"FROM USER_CHANGE WHERE user_id = ? ORDER BY changed_at, id", userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error listing the changes of the user")
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []model.UserChange{}
+	for rows.Next() {
+		var change model.UserChange
+		err := rows.Scan(&change.ID, &change.UserID, &change.ActorID, &change.Field, &change.OldValue,
+			&change.NewValue, &change.ChangedAt)
+		if err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error scanning a change of the user")
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
 }
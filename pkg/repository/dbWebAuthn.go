@@ -0,0 +1,257 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const webAuthnCredentialColumns = "id, user_id, name, public_key, sign_count, created_at, last_used_at"
+
+// CreateWebAuthnChallenge stores the hash of a new challenge of a WebAuthn ceremony.
+//
+// challenge: The challenge; its ChallengeHash, Ceremony and ExpiresAt must be set, and its UserID for a
+// registration. A UserID of 0 stores a challenge without a user.
+//
+// Returns a NotFoundError if the user does not exist, a ConflictError if the hash is already stored, or any
+// other error returned by the database.
+func (s *MySQLStore) CreateWebAuthnChallenge(challenge model.WebAuthnChallenge) error {
+	userId := sql.NullInt64{Int64: int64(challenge.UserID), Valid: challenge.UserID != 0}
+	if userId.Valid {
+		found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", challenge.UserID)
+		if err != nil {
+			return err
+		} else if !found {
+			return notFound("user", challenge.UserID)
+		}
+	}
+	found, err := s.exists("SELECT COUNT(*) FROM WEBAUTHN_CHALLENGE WHERE challenge_hash = ?", challenge.ChallengeHash)
+	if err != nil {
+		return err
+	} else if found {
+		return conflict("WebAuthn challenge", challenge.UserID, "already stored")
+	}
+
+	query := "INSERT INTO WEBAUTHN_CHALLENGE (challenge_hash, user_id, ceremony, expires_at) VALUES (?, ?, ?, ?)"
+	_, err = s.db.Exec(query, challenge.ChallengeHash, userId, challenge.Ceremony, challenge.ExpiresAt.UTC())
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", challenge.UserID).Error("Error storing the WebAuthn challenge")
+		return err
+	}
+	return nil
+}
+
+// ConsumeWebAuthnChallenge uses a challenge of a WebAuthn ceremony, deleting it so it can only be used once, even
+// by concurrent requests.
+//
+// challengeHash: The hash of the challenge.
+// ceremony: The ceremony the challenge must have been issued for, utils.WebAuthnRegistration or
+// utils.WebAuthnLogin.
+//
+// Returns the used challenge, a NotFoundError if no such challenge exists for the ceremony or it has expired, or
+// any other error returned by the database.
+func (s *MySQLStore) ConsumeWebAuthnChallenge(challengeHash string, ceremony string) (*model.WebAuthnChallenge, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.WithError(err).Error("Error beginning the use of a WebAuthn challenge")
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	challenge := model.WebAuthnChallenge{ChallengeHash: challengeHash}
+	var userId sql.NullInt64
+	query := "SELECT user_id, ceremony, expires_at FROM WEBAUTHN_CHALLENGE WHERE challenge_hash = ? FOR UPDATE"
+	err = tx.QueryRow(query, challengeHash).Scan(&userId, &challenge.Ceremony, &challenge.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("WebAuthn challenge", "")
+	} else if err != nil {
+		s.logger.WithError(err).Error("Error retrieving the WebAuthn challenge")
+		return nil, err
+	}
+	challenge.UserID = int(userId.Int64)
+	if challenge.Ceremony != ceremony || !time.Now().Before(challenge.ExpiresAt) {
+		return nil, notFound("WebAuthn challenge", "")
+	}
+
+	if _, err = tx.Exec("DELETE FROM WEBAUTHN_CHALLENGE WHERE challenge_hash = ?", challengeHash); err != nil {
+		s.logger.WithError(err).Error("Error deleting the WebAuthn challenge")
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		s.logger.WithError(err).Error("Error committing the use of a WebAuthn challenge")
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// PurgeExpiredWebAuthnChallenges deletes the WebAuthn challenges that have expired.
+//
+// Returns the number of deleted challenges, or an error returned by the database.
+func (s *MySQLStore) PurgeExpiredWebAuthnChallenges() (int, error) {
+	result, err := s.db.Exec("DELETE FROM WEBAUTHN_CHALLENGE WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		s.logger.WithError(err).Error("Error purging the expired WebAuthn challenges")
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).
+			Error("Error getting the number of rows affected when trying to purge the expired WebAuthn challenges")
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// AddWebAuthnCredential stores a new WebAuthn credential of a user.
+//
+// credential: The credential; its ID, UserID and PublicKey must be set. CreatedAt defaults to the current time
+// and LastUsedAt is ignored.
+//
+// Returns a NotFoundError if the user does not exist, a ConflictError if the credential ID is already
+// registered, or any other error returned by the database.
+func (s *MySQLStore) AddWebAuthnCredential(credential model.WebAuthnCredential) error {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", credential.UserID)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("user", credential.UserID)
+	}
+	found, err = s.exists("SELECT COUNT(*) FROM WEBAUTHN_CREDENTIAL WHERE id = ?", credential.ID)
+	if err != nil {
+		return err
+	} else if found {
+		return conflict("WebAuthn credential", credential.ID, "already registered")
+	}
+
+	if credential.CreatedAt.IsZero() {
+		credential.CreatedAt = time.Now()
+	}
+	query := "INSERT INTO WEBAUTHN_CREDENTIAL (id, user_id, name, public_key, sign_count, created_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?)"
+	_, err = s.db.Exec(query, credential.ID, credential.UserID, credential.Name, credential.PublicKey,
+		credential.SignCount, credential.CreatedAt.UTC())
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", credential.UserID).Error("Error storing the WebAuthn credential")
+		return err
+	}
+
+	s.logger.WithField("userId", credential.UserID).Info("WebAuthn credential registered with success")
+	return nil
+}
+
+// GetWebAuthnCredential retrieves a WebAuthn credential by its ID.
+//
+// credentialId: The base64url-encoded credential ID.
+//
+// Returns the credential, a NotFoundError if it does not exist, or any other error returned by the database.
+func (s *MySQLStore) GetWebAuthnCredential(credentialId string) (*model.WebAuthnCredential, error) {
+	row := s.db.QueryRow("SELECT "+webAuthnCredentialColumns+" FROM WEBAUTHN_CREDENTIAL WHERE id = ?", credentialId)
+	credential, err := scanWebAuthnCredential(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("WebAuthn credential", credentialId)
+	} else if err != nil {
+		s.logger.WithError(err).Error("Error retrieving the WebAuthn credential")
+		return nil, err
+	}
+	return credential, nil
+}
+
+// ListWebAuthnCredentials retrieves the WebAuthn credentials of a user, oldest first.
+//
+// userId: The ID of the user.
+//
+// Returns the credentials, a NotFoundError if the user does not exist, or any other error returned by the
+// database.
+func (s *MySQLStore) ListWebAuthnCredentials(userId int) ([]model.WebAuthnCredential, error) {
+	found, err := s.exists("SELECT COUNT(*) FROM USERS WHERE id = ?", userId)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		return nil, notFound("user", userId)
+	}
+
+	query := "SELECT " + webAuthnCredentialColumns + " FROM WEBAUTHN_CREDENTIAL WHERE user_id = ? ORDER BY created_at, id"
+	rows, err := s.db.Query(query, userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error listing the WebAuthn credentials")
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []model.WebAuthnCredential{}
+	for rows.Next() {
+		credential, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			s.logger.WithError(err).WithField("userId", userId).Error("Error scanning a WebAuthn credential")
+			return nil, err
+		}
+		credentials = append(credentials, *credential)
+	}
+	return credentials, rows.Err()
+}
+
+// UpdateWebAuthnSignCount records a successful login with a WebAuthn credential: its signature counter and the
+// time it was last used.
+//
+// credentialId: The base64url-encoded credential ID.
+// signCount: The signature counter reported by the authenticator.
+//
+// Returns a NotFoundError if the credential does not exist, or any other error returned by the database.
+func (s *MySQLStore) UpdateWebAuthnSignCount(credentialId string, signCount uint32) error {
+	found, err := s.exists("SELECT COUNT(*) FROM WEBAUTHN_CREDENTIAL WHERE id = ?", credentialId)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("WebAuthn credential", credentialId)
+	}
+
+	_, err = s.db.Exec("UPDATE WEBAUTHN_CREDENTIAL SET sign_count = ?, last_used_at = ? WHERE id = ?",
+		signCount, time.Now().UTC(), credentialId)
+	if err != nil {
+		s.logger.WithError(err).Error("Error updating the WebAuthn credential")
+		return err
+	}
+	return nil
+}
+
+// DeleteWebAuthnCredential deletes a WebAuthn credential of a user.
+//
+// userId: The ID of the user owning the credential.
+// credentialId: The base64url-encoded credential ID.
+//
+// Returns a NotFoundError if the user has no such credential, or any other error returned by the database.
+func (s *MySQLStore) DeleteWebAuthnCredential(userId int, credentialId string) error {
+	result, err := s.db.Exec("DELETE FROM WEBAUTHN_CREDENTIAL WHERE id = ? AND user_id = ?", credentialId, userId)
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).Error("Error deleting the WebAuthn credential")
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		s.logger.WithError(err).WithField("userId", userId).
+			Error("Error getting the number of rows affected when trying to delete a WebAuthn credential")
+		return err
+	}
+	if rowsAffected == 0 {
+		return notFound("WebAuthn credential", credentialId)
+	}
+
+	s.logger.WithField("userId", userId).Info("WebAuthn credential removed with success")
+	return nil
+}
+
+// scanWebAuthnCredential reads a WebAuthn credential from a row selecting webAuthnCredentialColumns.
+func scanWebAuthnCredential(row rowScanner) (*model.WebAuthnCredential, error) {
+	var credential model.WebAuthnCredential
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&credential.ID, &credential.UserID, &credential.Name, &credential.PublicKey,
+		&credential.SignCount, &credential.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	credential.LastUsedAt = lastUsedAt.Time
+	return &credential, nil
+}
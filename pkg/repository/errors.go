@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is matched by every NotFoundError, so callers can use errors.Is(err, ErrNotFound).
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict is matched by every ConflictError, so callers can use errors.Is(err, ErrConflict).
+	ErrConflict = errors.New("conflict")
+)
+
+// NotFoundError is returned when an operation references a record that does not exist.
+type NotFoundError struct {
+	Entity string
+	Key    string
+}
+
+// Error returns a message naming the missing record.
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found", e.Entity, e.Key)
+}
+
+// Is reports whether target is ErrNotFound.
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// ConflictError is returned when an operation would break a uniqueness or referential integrity rule.
+type ConflictError struct {
+	Entity string
+	Key    string
+	Reason string
+}
+
+// Error returns a message naming the conflicting record and the reason of the conflict.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Entity, e.Key, e.Reason)
+}
+
+// Is reports whether target is ErrConflict.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// notFound builds a NotFoundError for the given entity and key.
+func notFound(entity string, key interface{}) error {
+	return &NotFoundError{Entity: entity, Key: fmt.Sprint(key)}
+}
+
+// conflict builds a ConflictError for the given entity, key and reason.
+func conflict(entity string, key interface{}, reason string) error {
+	return &ConflictError{Entity: entity, Key: fmt.Sprint(key), Reason: reason}
+}
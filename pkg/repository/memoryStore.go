@@ -0,0 +1,2156 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a thread-safe Store implementation that keeps every record in memory.
+// It mirrors the behaviour of MySQLStore, so it can be used to run the whole API in unit tests
+// and local demos without a database.
+type MemoryStore struct {
+	logger *logrus.Logger
+	mu     sync.RWMutex
+
+	nextUserId int
+	users      map[int]*model.User
+
+	nextUserChangeId int
+	userChanges      []model.UserChange
+
+	nextRoleId       int
+	roles            map[int]*model.Role
+	nextPermissionId int
+	permissions      map[int]*model.Permission
+	rolePermissions  map[int]map[int]bool
+	userRoles        map[int]map[int]bool
+
+	sessions map[string]*model.Session
+	// rotatedTokens maps the hash of every rotated refresh token to its session
+	rotatedTokens map[string]string
+
+	accessTokens map[string]*model.AccessToken
+
+	passwordResetTokens map[string]*model.PasswordResetToken
+
+	// ipLoginFailures holds the failed login counters of the client IP addresses
+	ipLoginFailures map[string]*model.LoginLockout
+
+	totps map[int]*model.TOTP
+	// recoveryCodes holds the hashes of the unused recovery codes of every user
+	recoveryCodes map[int]map[string]bool
+
+	webAuthnCredentials map[string]*model.WebAuthnCredential
+	webAuthnChallenges  map[string]*model.WebAuthnChallenge
+
+	oauthClients map[string]*model.OAuthClient
+	oauthCodes   map[string]*model.OAuthAuthorizationCode
+
+	externalIdentities   map[externalIdentityKey]*model.ExternalIdentity
+	federatedLoginStates map[string]*model.FederatedLoginState
+
+	apiKeys map[string]*model.APIKey
+}
+
+// externalIdentityKey identifies an external identity by its provider and subject.
+type externalIdentityKey struct {
+	provider string
+	subject  string
+}
+
+// NewMemoryStore creates an empty MemoryStore seeded with the same default roles, permissions
+// and admin account that the schema migrations create.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+//
+// Returns a pointer to the MemoryStore.
+func NewMemoryStore(logger *logrus.Logger) *MemoryStore {
+	s := &MemoryStore{
+		logger:           logger,
+		nextUserId:       1,
+		users:            make(map[int]*model.User),
+		nextUserChangeId: 1,
+		nextRoleId:       1,
+		roles:            make(map[int]*model.Role),
+		nextPermissionId: 1,
+		permissions:      make(map[int]*model.Permission),
+		rolePermissions:  make(map[int]map[int]bool),
+		userRoles:        make(map[int]map[int]bool),
+		sessions:         make(map[string]*model.Session),
+		rotatedTokens:    make(map[string]string),
+		accessTokens:     make(map[string]*model.AccessToken),
+
+		passwordResetTokens: make(map[string]*model.PasswordResetToken),
+		ipLoginFailures:     make(map[string]*model.LoginLockout),
+		totps:               make(map[int]*model.TOTP),
+		recoveryCodes:       make(map[int]map[string]bool),
+		webAuthnCredentials: make(map[string]*model.WebAuthnCredential),
+		webAuthnChallenges:  make(map[string]*model.WebAuthnChallenge),
+		oauthClients:        make(map[string]*model.OAuthClient),
+		oauthCodes:          make(map[string]*model.OAuthAuthorizationCode),
+
+		externalIdentities:   make(map[externalIdentityKey]*model.ExternalIdentity),
+		federatedLoginStates: make(map[string]*model.FederatedLoginState),
+
+		apiKeys: make(map[string]*model.APIKey),
+	}
+	s.seedDefaults()
+	return s
+}
+
+// seedDefaults loads the default configuration of the schema migrations: the admin and user roles, the
+// read/write/delete permissions, the permissions required by the API routes (all granted to admin)
+// and the admin account (username: admin, password: admin).
+func (s *MemoryStore) seedDefaults() {
+	admin := s.insertRole(utils.AdminRole)
+	user := s.insertRole(utils.UserRole)
+	read := s.insertPermission("read")
+	write := s.insertPermission("write")
+	del := s.insertPermission("delete")
+
+	s.rolePermissions[admin] = map[int]bool{read: true, write: true, del: true}
+	s.rolePermissions[user] = map[int]bool{read: true}
+
+	for _, name := range []string{
+		utils.PermissionUserCreate,
+		utils.PermissionUserRead,
+		utils.PermissionUserDelete,
+		utils.PermissionRoleRead,
+		utils.PermissionRoleWrite,
+		utils.PermissionRoleAssign,
+		utils.PermissionKeyManage,
+		utils.PermissionSessionRevoke,
+		utils.PermissionUserUnlock,
+		utils.PermissionOAuthClientManage,
+		utils.PermissionAPIKeyManage,
+		utils.PermissionUserUpdate,
+	} {
+		s.rolePermissions[admin][s.insertPermission(name)] = true
+	}
+
+	adminUser := model.NewUser("admin", "",
+		"$2a$10$H7POZPYUzJS15D2/XSq7f.QHsyZBeMetjZa6W8Yffbhz1vhmGLG9C",
+		"admin@example.com", "Admin Country", "1234567890")
+	adminUser.EmailVerified = true
+	adminId := s.insertUser(*adminUser)
+	s.userRoles[adminId] = map[int]bool{admin: true}
+}
+
+// insertRole adds a role and returns its ID. The caller must hold the write lock.
+func (s *MemoryStore) insertRole(name string) int {
+	id := s.nextRoleId
+	s.nextRoleId++
+	s.roles[id] = &model.Role{ID: id, Name: name}
+	return id
+}
+
+// insertPermission adds a permission and returns its ID. The caller must hold the write lock.
+func (s *MemoryStore) insertPermission(name string) int {
+	id := s.nextPermissionId
+	s.nextPermissionId++
+	s.permissions[id] = &model.Permission{ID: id, Name: name}
+	return id
+}
+
+// insertUser adds a user and returns its ID. The plaintext password is never kept.
+// The caller must hold the write lock.
+func (s *MemoryStore) insertUser(user model.User) int {
+	id := s.nextUserId
+	s.nextUserId++
+	user.ID = id
+	user.Password = ""
+	if user.DateCreated.IsZero() {
+		user.DateCreated = time.Now()
+	}
+	user.UpdatedAt = user.DateCreated
+	user.Version = 1
+	user.Status = utils.UserStatusActive
+	user.PasswordResetRequired = false
+	s.users[id] = &user
+	return id
+}
+
+// userIdByName returns the ID of the user with the given username. The caller must hold a lock.
+func (s *MemoryStore) userIdByName(username string) (int, bool) {
+	for id, user := range s.users {
+		if user.Username == username {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// liveUser returns the user with the given ID unless it is deleted. The caller must hold a lock.
+func (s *MemoryStore) liveUser(userId int) (*model.User, bool) {
+	user, ok := s.users[userId]
+	if !ok || user.Status == utils.UserStatusDeleted {
+		return nil, false
+	}
+	return user, true
+}
+
+// roleIdByName returns the ID of the role with the given name. The caller must hold a lock.
+func (s *MemoryStore) roleIdByName(name string) (int, bool) {
+	for id, role := range s.roles {
+		if role.Name == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// UserExists checks if a user with the given username or email already exists, including the deleted users that
+// are not purged yet. An empty email is not checked.
+func (s *MemoryStore) UserExists(username string, email string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Username == username || email != "" && user.Email == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AddUser adds a new user and assigns it the specified role. As with MySQLStore, the user is kept
+// even when the role cannot be assigned.
+func (s *MemoryStore) AddUser(user model.User, roleName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userId := s.insertUser(user)
+	roleId, ok := s.roleIdByName(roleName)
+	if !ok {
+		s.logger.WithField("username", user.Username).Error("Error setting user role for user")
+		return fmt.Errorf("role %s does not exist", roleName)
+	}
+	s.userRoles[userId] = map[int]bool{roleId: true}
+
+	s.logger.WithField("username", user.Username).Info("Add user with success")
+	return nil
+}
+
+// GetUserByUserName retrieves the user with the provided username.
+// Returns sql.ErrNoRows if no user with the provided username exists or the user is deleted.
+func (s *MemoryStore) GetUserByUserName(username string) (*model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.userIdByName(username)
+	if ok {
+		_, ok = s.liveUser(id)
+	}
+	if !ok {
+		s.logger.WithField("username", username).Info("User not found in store")
+		return nil, sql.ErrNoRows
+	}
+
+	user := *s.users[id]
+	return &user, nil
+}
+
+// GetUserById retrieves the user with the provided ID.
+// Returns sql.ErrNoRows if no user with the provided ID exists or the user is deleted.
+func (s *MemoryStore) GetUserById(userId int) (*model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.liveUser(userId)
+	if !ok {
+		s.logger.WithField("userId", userId).Info("User not found in store")
+		return nil, sql.ErrNoRows
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// GetUserByEmail retrieves a user with the provided email address.
+// Returns sql.ErrNoRows if no user with the provided email exists or the user is deleted.
+func (s *MemoryStore) GetUserByEmail(email string) (*model.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Email == email && user.Status != utils.UserStatusDeleted {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	s.logger.Info("User not found by email in store")
+	return nil, sql.ErrNoRows
+}
+
+// GetUserNameByUserId retrieves the username associated with a user ID.
+// Returns sql.ErrNoRows if no user with the provided ID exists or the user is deleted.
+func (s *MemoryStore) GetUserNameByUserId(userId int) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.liveUser(userId)
+	if !ok {
+		s.logger.WithField("userId", userId).Info("Username not found in store")
+		return "", sql.ErrNoRows
+	}
+	return user.Username, nil
+}
+
+// GetUserIdByUserName retrieves the user ID associated with a username.
+// Returns -1 and sql.ErrNoRows if no user with the provided username exists or the user is deleted.
+func (s *MemoryStore) GetUserIdByUserName(username string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.userIdByName(username)
+	if ok {
+		_, ok = s.liveUser(id)
+	}
+	if !ok {
+		s.logger.WithField("userName", username).Info("UserId not found in store")
+		return -1, sql.ErrNoRows
+	}
+	return id, nil
+}
+
+// UpdatePassword replaces the password hash of a user and clears a password reset required by an administrator.
+// Returns sql.ErrNoRows if no user with the provided ID exists or the user is deleted.
+func (s *MemoryStore) UpdatePassword(userId int, hashedPassword string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.liveUser(userId)
+	if !ok {
+		s.logger.WithField("userId", userId).Info("User not found in store")
+		return sql.ErrNoRows
+	}
+	user.HashedPassword = hashedPassword
+	user.PasswordResetRequired = false
+
+	s.logger.WithField("userId", userId).Info("Password updated with success")
+	return nil
+}
+
+// UpdateProfile applies a partial update to the profile of a user and increments its version.
+// Returns sql.ErrNoRows if no user with the provided ID exists or the user is deleted and a ConflictError if the
+// profile is no longer at the given version or the new email is used by another user.
+func (s *MemoryStore) UpdateProfile(userId int, version int, update model.ProfileUpdate) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.liveUser(userId)
+	if !ok {
+		s.logger.WithField("userId", userId).Info("User not found in store")
+		return nil, sql.ErrNoRows
+	}
+	if user.Version != version {
+		return nil, conflict("user", userId, fmt.Sprintf("version %d is outdated, the current version is %d",
+			version, user.Version))
+	}
+	if update.Email != nil && *update.Email != user.Email {
+		for id, other := range s.users {
+			if id != userId && other.Email == *update.Email {
+				return nil, conflict("email", *update.Email, "already in use")
+			}
+		}
+	}
+
+	user.ApplyProfileUpdate(update)
+	user.UpdatedAt = time.Now()
+	user.Version++
+
+	s.logger.WithField("userId", userId).Info("Profile updated with success")
+	copied := *user
+	return &copied, nil
+}
+
+// VerifyEmail marks the email address of a user as verified.
+// Returns sql.ErrNoRows if no user with the provided ID exists or the user is deleted and a ConflictError if the
+// email address of the user is no longer the given one.
+func (s *MemoryStore) VerifyEmail(userId int, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.liveUser(userId)
+	if !ok {
+		s.logger.WithField("userId", userId).Info("User not found in store")
+		return sql.ErrNoRows
+	}
+	if user.Email != email {
+		return conflict("user", userId, "the email address changed since the verification was sent")
+	}
+	user.EmailVerified = true
+
+	s.logger.WithField("userId", userId).Info("Email verified with success")
+	return nil
+}
+
+// DeleteUser removes a user for good, together with its roles, sessions and every other record referencing it.
+func (s *MemoryStore) DeleteUser(userId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteUser(userId)
+	s.logger.WithField("userId", userId).Info("user removed successfully")
+	return nil
+}
+
+// deleteUser removes a user with every record referencing it. The caller must hold the write lock.
+func (s *MemoryStore) deleteUser(userId int) {
+	delete(s.userRoles, userId)
+	s.deleteSessions(userId)
+	s.deletePasswordResetTokens(userId)
+	delete(s.totps, userId)
+	delete(s.recoveryCodes, userId)
+	for id, credential := range s.webAuthnCredentials {
+		if credential.UserID == userId {
+			delete(s.webAuthnCredentials, id)
+		}
+	}
+	for challengeHash, challenge := range s.webAuthnChallenges {
+		if challenge.UserID == userId {
+			delete(s.webAuthnChallenges, challengeHash)
+		}
+	}
+	for codeHash, code := range s.oauthCodes {
+		if code.UserID == userId {
+			delete(s.oauthCodes, codeHash)
+		}
+	}
+	for key, identity := range s.externalIdentities {
+		if identity.UserID == userId {
+			delete(s.externalIdentities, key)
+		}
+	}
+	for stateHash, state := range s.federatedLoginStates {
+		if state.UserID == userId {
+			delete(s.federatedLoginStates, stateHash)
+		}
+	}
+	for id, key := range s.apiKeys {
+		if key.UserID == userId {
+			delete(s.apiKeys, id)
+		}
+	}
+	changes := s.userChanges[:0]
+	for _, change := range s.userChanges {
+		if change.UserID != userId {
+			changes = append(changes, change)
+		}
+	}
+	s.userChanges = changes
+	delete(s.users, userId)
+}
+
+// deleteSessions removes the sessions of a user and their rotated refresh tokens. The caller must hold the write
+// lock.
+func (s *MemoryStore) deleteSessions(userId int) {
+	for id, session := range s.sessions {
+		if session.UserID == userId {
+			delete(s.sessions, id)
+		}
+	}
+	for tokenHash, sessionId := range s.rotatedTokens {
+		if _, ok := s.sessions[sessionId]; !ok {
+			delete(s.rotatedTokens, tokenHash)
+		}
+	}
+}
+
+// SoftDeleteUser marks a user as deleted, removes its sessions and records the change of its status. Its other
+// records are kept until PurgeDeletedUsers. Returns sql.ErrNoRows if no user with the provided ID exists or the
+// user is already deleted.
+func (s *MemoryStore) SoftDeleteUser(userId int, actorId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.liveUser(userId)
+	if !ok {
+		s.logger.WithField("userId", userId).Info("User not found in store")
+		return sql.ErrNoRows
+	}
+
+	s.recordStatusChange(user, utils.UserStatusDeleted, actorId)
+	user.DeletedAt = user.UpdatedAt
+	s.deleteSessions(userId)
+
+	s.logger.WithField("userId", userId).Info("User deleted with success")
+	return nil
+}
+
+// RestoreUser makes a user deleted by SoftDeleteUser active again and records the change of its status.
+// Returns sql.ErrNoRows if no user with the provided ID exists and a ConflictError if the user is not deleted.
+func (s *MemoryStore) RestoreUser(userId int, actorId int) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		s.logger.WithField("userId", userId).Info("User not found in store")
+		return nil, sql.ErrNoRows
+	}
+	if user.Status != utils.UserStatusDeleted {
+		return nil, conflict("user", userId, "the user is not deleted")
+	}
+
+	s.recordStatusChange(user, utils.UserStatusActive, actorId)
+	user.DeletedAt = time.Time{}
+
+	s.logger.WithField("userId", userId).Info("User restored with success")
+	copied := *user
+	return &copied, nil
+}
+
+// recordStatusChange sets the status of a user, increments its version and records the change. The caller must
+// hold the write lock.
+func (s *MemoryStore) recordStatusChange(user *model.User, status string, actorId int) {
+	before := *user
+	user.Status = status
+	user.UpdatedAt = time.Now()
+	user.Version++
+	for _, change := range userChanges(&before, user, nil, nil, actorId, user.UpdatedAt) {
+		change.ID = s.nextUserChangeId
+		s.nextUserChangeId++
+		s.userChanges = append(s.userChanges, change)
+	}
+}
+
+// PurgeDeletedUsers removes for good the users deleted by SoftDeleteUser before the given time, with every record
+// referencing them. Returns the number of users removed.
+func (s *MemoryStore) PurgeDeletedUsers(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for userId, user := range s.users {
+		if user.Status == utils.UserStatusDeleted && user.DeletedAt.Before(before) {
+			s.deleteUser(userId)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// ListUsers retrieves a page of the users matching a query, with the names of their roles, ordered by role ID.
+// Text fields are compared ignoring case, and the deleted users are left out unless the query selects them. Returns an error if a cursor value is invalid.
+func (s *MemoryStore) ListUsers(query model.UserQuery) ([]model.UserSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var after time.Time
+	if query.After != nil && query.Sort == utils.UserSortDateCreated {
+		var err error
+		if after, err = time.Parse(time.RFC3339Nano, query.After.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	// compare orders two users by the sort field, then by ID
+	compare := func(a *model.User, b *model.User) int {
+		var result int
+		switch query.Sort {
+		case utils.UserSortUsername:
+			result = strings.Compare(strings.ToLower(a.Username), strings.ToLower(b.Username))
+		case utils.UserSortEmail:
+			result = strings.Compare(strings.ToLower(a.Email), strings.ToLower(b.Email))
+		case utils.UserSortCountry:
+			result = strings.Compare(strings.ToLower(a.Country), strings.ToLower(b.Country))
+		case utils.UserSortDateCreated:
+			result = a.DateCreated.Compare(b.DateCreated)
+		}
+		if result == 0 {
+			result = a.ID - b.ID
+		}
+		if query.Descending {
+			return -result
+		}
+		return result
+	}
+	var cursor *model.User
+	if query.After != nil {
+		cursor = &model.User{ID: query.After.ID, Username: query.After.Value, Email: query.After.Value,
+			Country: query.After.Value, DateCreated: after}
+	}
+
+	search := strings.ToLower(query.Search)
+	var users []*model.User
+	for _, user := range s.users {
+		switch {
+		case query.Status == "" && user.Status == utils.UserStatusDeleted,
+			query.Status != "" && user.Status != query.Status,
+			query.Role != "" && !s.hasRole(user.ID, query.Role),
+			query.Country != "" && !strings.EqualFold(user.Country, query.Country),
+			search != "" && !strings.Contains(strings.ToLower(user.Username), search) &&
+				!strings.Contains(strings.ToLower(user.Email), search),
+			!query.CreatedFrom.IsZero() && user.DateCreated.Before(query.CreatedFrom),
+			!query.CreatedTo.IsZero() && !user.DateCreated.Before(query.CreatedTo),
+			cursor != nil && compare(user, cursor) <= 0:
+			continue
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return compare(users[i], users[j]) < 0
+	})
+	if query.Limit > 0 && len(users) > query.Limit {
+		users = users[:query.Limit]
+	}
+
+	summaries := []model.UserSummary{}
+	for _, user := range users {
+		summaries = append(summaries, model.UserSummary{
+			Profile: user.Profile(),
+			Roles:   s.roleNames(s.userRoles[user.ID]),
+		})
+	}
+	return summaries, nil
+}
+
+// UpdateUser applies the changes of an administrator to a user, increments its version and records every changed
+// field. Returns sql.ErrNoRows if no user with the provided ID exists or the user is deleted, a NotFoundError if a
+// role does not exist and a ConflictError if the user is no longer at the given version or the new email is used
+// by another user. Nothing is changed when an error is returned.
+func (s *MemoryStore) UpdateUser(userId int,
+	version int,
+	update model.AdminUserUpdate,
+	actorId int) (*model.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.liveUser(userId)
+	if !ok {
+		s.logger.WithField("userId", userId).Info("User not found in store")
+		return nil, sql.ErrNoRows
+	}
+	if user.Version != version {
+		return nil, conflict("user", userId, fmt.Sprintf("version %d is outdated, the current version is %d",
+			version, user.Version))
+	}
+	if update.Email != nil && *update.Email != user.Email {
+		for id, other := range s.users {
+			if id != userId && other.Email == *update.Email {
+				return nil, conflict("email", *update.Email, "already in use")
+			}
+		}
+	}
+
+	var roles map[int]bool
+	var beforeRoles, afterRoles []string
+	if update.Roles != nil {
+		roles = make(map[int]bool)
+		for _, name := range *update.Roles {
+			roleId, ok := s.roleIdByName(name)
+			if !ok {
+				return nil, notFound("role", name)
+			}
+			roles[roleId] = true
+		}
+		beforeRoles = s.roleNames(s.userRoles[userId])
+		afterRoles = s.roleNames(roles)
+	}
+
+	before := *user
+	user.ApplyAdminUserUpdate(update)
+	user.UpdatedAt = time.Now()
+	user.Version++
+	if roles != nil {
+		s.userRoles[userId] = roles
+	}
+	for _, change := range userChanges(&before, user, beforeRoles, afterRoles, actorId, user.UpdatedAt) {
+		change.ID = s.nextUserChangeId
+		s.nextUserChangeId++
+		s.userChanges = append(s.userChanges, change)
+	}
+
+	s.logger.WithField("userId", userId).Info("User updated with success")
+	copied := *user
+	return &copied, nil
+}
+
+// ListUserChanges retrieves the changes made to a user by the administrators, oldest first.
+// Returns sql.ErrNoRows if no user with the provided ID exists.
+func (s *MemoryStore) ListUserChanges(userId int) ([]model.UserChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.users[userId]; !ok {
+		s.logger.WithField("userId", userId).Info("User not found in store")
+		return nil, sql.ErrNoRows
+	}
+
+	changes := []model.UserChange{}
+	for _, change := range s.userChanges {
+		if change.UserID == userId {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// roleNames returns the names of a set of roles, ordered by role ID. The caller must hold a lock.
+func (s *MemoryStore) roleNames(roleIds map[int]bool) []string {
+	var ids []int
+	for roleId := range roleIds {
+		ids = append(ids, roleId)
+	}
+	sort.Ints(ids)
+	names := []string{}
+	for _, roleId := range ids {
+		names = append(names, s.roles[roleId].Name)
+	}
+	return names
+}
+
+// hasRole reports whether a user holds the role with the given name. The caller must hold the lock.
+func (s *MemoryStore) hasRole(userId int, roleName string) bool {
+	for roleId := range s.userRoles[userId] {
+		if s.roles[roleId].Name == roleName {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateSession stores a new session, without touching the other sessions of the user.
+// CreatedAt and LastUsedAt default to the current time. Returns a NotFoundError if the user does not exist and
+// a ConflictError if the session ID is already in use.
+func (s *MemoryStore) CreateSession(session model.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[session.UserID]; !ok {
+		return notFound("user", session.UserID)
+	}
+	if _, ok := s.sessions[session.ID]; ok {
+		return conflict("session", session.ID, "already exists")
+	}
+
+	now := time.Now().UTC()
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = now
+	}
+	if session.LastUsedAt.IsZero() {
+		session.LastUsedAt = now
+	}
+	session.Revoked = false
+	s.sessions[session.ID] = &session
+
+	s.logger.WithFields(logrus.Fields{
+		"userId":    session.UserID,
+		"sessionId": session.ID,
+	}).Info("Session created with success")
+	return nil
+}
+
+// GetSession retrieves a session, whether it is active, expired or revoked.
+// Returns a NotFoundError if the session does not exist.
+func (s *MemoryStore) GetSession(sessionId string) (*model.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[sessionId]
+	if !ok {
+		s.logger.WithField("sessionId", sessionId).Info("Session not found in store")
+		return nil, notFound("session", sessionId)
+	}
+	copied := *session
+	return &copied, nil
+}
+
+// ListSessions retrieves the active sessions of a user, most recently used first.
+// Returns a NotFoundError if the user does not exist.
+func (s *MemoryStore) ListSessions(userId int) ([]model.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.users[userId]; !ok {
+		return nil, notFound("user", userId)
+	}
+
+	now := time.Now()
+	sessions := []model.Session{}
+	for _, session := range s.sessions {
+		if session.UserID == userId && session.Active(now) {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsedAt.Equal(sessions[j].LastUsedAt) {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil
+}
+
+// RotateRefreshToken replaces the current refresh token of a session, if and only if it is still
+// previousTokenHash, records the session as used and keeps previousTokenHash as a rotated token. Returns a
+// NotFoundError if the session does not exist or was revoked, and a ConflictError if previousTokenHash is not
+// the current token of the session anymore.
+func (s *MemoryStore) RotateRefreshToken(sessionId string,
+	previousTokenHash string,
+	tokenHash string,
+	expiresAt time.Time) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionId]
+	if !ok || session.Revoked {
+		return notFound("session", sessionId)
+	}
+	if session.TokenHash != previousTokenHash {
+		return conflict("session", sessionId, "the refresh token was already rotated")
+	}
+	session.TokenHash = tokenHash
+	session.ExpiresAt = expiresAt
+	session.LastUsedAt = time.Now().UTC()
+	s.rotatedTokens[previousTokenHash] = sessionId
+	return nil
+}
+
+// IsRotatedRefreshToken reports whether a refresh token was issued for a session and rotated since.
+func (s *MemoryStore) IsRotatedRefreshToken(sessionId string, tokenHash string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rotatedSessionId, ok := s.rotatedTokens[tokenHash]
+	return ok && rotatedSessionId == sessionId, nil
+}
+
+// RevokeSession revokes a session. Revoking a revoked session succeeds without changing it.
+// Returns a NotFoundError if the session does not exist.
+func (s *MemoryStore) RevokeSession(sessionId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionId]
+	if !ok {
+		return notFound("session", sessionId)
+	}
+	session.Revoked = true
+	session.TokenHash = ""
+
+	s.logger.WithField("sessionId", sessionId).Info("Session revoked with success")
+	return nil
+}
+
+// RevokeUserSessions revokes every active session of a user but exceptSessionId, which may be empty.
+// Returns the number of revoked sessions, or a NotFoundError if the user does not exist.
+func (s *MemoryStore) RevokeUserSessions(userId int, exceptSessionId string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userId]; !ok {
+		return 0, notFound("user", userId)
+	}
+
+	revoked := 0
+	for id, session := range s.sessions {
+		if session.UserID == userId && id != exceptSessionId && !session.Revoked {
+			session.Revoked = true
+			session.TokenHash = ""
+			revoked++
+		}
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"userId":  userId,
+		"revoked": revoked,
+	}).Info("Sessions revoked with success")
+	return revoked, nil
+}
+
+// RecordAccessToken stores an issued access token. The tokens of a deleted user are kept, so denied tokens stay
+// denied until they expire. Returns a ConflictError if the jti is already recorded.
+func (s *MemoryStore) RecordAccessToken(token model.AccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accessTokens[token.ID]; ok {
+		return conflict("access token", token.ID, "already recorded")
+	}
+	token.Denied = false
+	s.accessTokens[token.ID] = &token
+	return nil
+}
+
+// DenySessionAccessTokens adds the outstanding access tokens of a session to the denylist.
+// Returns the number of denied tokens.
+func (s *MemoryStore) DenySessionAccessTokens(sessionId string) (int, error) {
+	return s.denyAccessTokens(func(token *model.AccessToken) bool {
+		return token.SessionID == sessionId
+	}), nil
+}
+
+// DenyUserAccessTokens adds the outstanding access tokens of every session of a user to the denylist.
+// Returns the number of denied tokens.
+func (s *MemoryStore) DenyUserAccessTokens(userId int) (int, error) {
+	return s.denyAccessTokens(func(token *model.AccessToken) bool {
+		return token.UserID == userId
+	}), nil
+}
+
+// denyAccessTokens denies the outstanding access tokens selected by the given function and returns their number.
+func (s *MemoryStore) denyAccessTokens(selected func(token *model.AccessToken) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	denied := 0
+	for _, token := range s.accessTokens {
+		if !token.Denied && now.Before(token.ExpiresAt) && selected(token) {
+			token.Denied = true
+			denied++
+		}
+	}
+	return denied
+}
+
+// IsAccessTokenDenied reports whether an access token is on the denylist and not expired yet. Tokens that were
+// never recorded are not denied.
+func (s *MemoryStore) IsAccessTokenDenied(tokenId string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.accessTokens[tokenId]
+	return ok && token.Denied && time.Now().Before(token.ExpiresAt), nil
+}
+
+// PurgeExpiredAccessTokens deletes the access tokens that have expired, denied or not.
+// Returns the number of deleted tokens.
+func (s *MemoryStore) PurgeExpiredAccessTokens() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	purged := 0
+	for id, token := range s.accessTokens {
+		if !now.Before(token.ExpiresAt) {
+			delete(s.accessTokens, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// CreatePasswordResetToken stores the hash of a new password reset token of a user. CreatedAt defaults to the
+// current time. Returns a NotFoundError if the user does not exist and a ConflictError if the hash is already
+// stored.
+func (s *MemoryStore) CreatePasswordResetToken(token model.PasswordResetToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[token.UserID]; !ok {
+		return notFound("user", token.UserID)
+	}
+	if _, ok := s.passwordResetTokens[token.TokenHash]; ok {
+		return conflict("password reset token", token.UserID, "already stored")
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	s.passwordResetTokens[token.TokenHash] = &token
+
+	s.logger.WithField("userId", token.UserID).Info("Password reset token created with success")
+	return nil
+}
+
+// ConsumePasswordResetToken uses a password reset token, deleting it together with the other reset tokens of
+// its user. Returns a NotFoundError if no such token exists or it has expired.
+func (s *MemoryStore) ConsumePasswordResetToken(tokenHash string) (*model.PasswordResetToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.passwordResetTokens[tokenHash]
+	if !ok || !time.Now().Before(token.ExpiresAt) {
+		return nil, notFound("password reset token", "")
+	}
+	s.deletePasswordResetTokens(token.UserID)
+
+	s.logger.WithField("userId", token.UserID).Info("Password reset token used with success")
+	copied := *token
+	return &copied, nil
+}
+
+// PurgeExpiredPasswordResetTokens deletes the password reset tokens that have expired.
+// Returns the number of deleted tokens.
+func (s *MemoryStore) PurgeExpiredPasswordResetTokens() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	purged := 0
+	for tokenHash, token := range s.passwordResetTokens {
+		if !now.Before(token.ExpiresAt) {
+			delete(s.passwordResetTokens, tokenHash)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// deletePasswordResetTokens deletes every password reset token of a user. The caller must hold the write lock.
+func (s *MemoryStore) deletePasswordResetTokens(userId int) {
+	for tokenHash, token := range s.passwordResetTokens {
+		if token.UserID == userId {
+			delete(s.passwordResetTokens, tokenHash)
+		}
+	}
+}
+
+// GetPermissionsByRoleId retrieves the names of the permissions granted to a role, sorted by ID.
+// Returns a NotFoundError if the role does not exist.
+func (s *MemoryStore) GetPermissionsByRoleId(roleId int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.roles[roleId]; !ok {
+		return nil, notFound("role", roleId)
+	}
+
+	var ids []int
+	for permissionId := range s.rolePermissions[roleId] {
+		ids = append(ids, permissionId)
+	}
+	sort.Ints(ids)
+
+	var permissions []string
+	for _, id := range ids {
+		permissions = append(permissions, s.permissions[id].Name)
+	}
+	return permissions, nil
+}
+
+// GetUserRolesByUserId retrieves the names of the roles assigned to a user, sorted by ID.
+func (s *MemoryStore) GetUserRolesByUserId(userId int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []int
+	for roleId := range s.userRoles[userId] {
+		ids = append(ids, roleId)
+	}
+	sort.Ints(ids)
+
+	var roles []string
+	for _, id := range ids {
+		roles = append(roles, s.roles[id].Name)
+	}
+	return roles, nil
+}
+
+// GetUserPermissionsByUserId retrieves the distinct names of the permissions granted to any role of a user,
+// sorted by name.
+func (s *MemoryStore) GetUserPermissionsByUserId(userId int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make(map[string]bool)
+	for roleId := range s.userRoles[userId] {
+		for permissionId := range s.rolePermissions[roleId] {
+			names[s.permissions[permissionId].Name] = true
+		}
+	}
+
+	var permissions []string
+	for name := range names {
+		permissions = append(permissions, name)
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
+
+// SetUserRole associates a user with a specific role.
+// Returns an error if the user or the role does not exist, or if the role is already assigned.
+func (s *MemoryStore) SetUserRole(userName string, roleName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userId, ok := s.userIdByName(userName)
+	if !ok {
+		return fmt.Errorf("user %s does not exist", userName)
+	}
+	roleId, ok := s.roleIdByName(roleName)
+	if !ok {
+		return fmt.Errorf("role %s does not exist", roleName)
+	}
+	if s.userRoles[userId][roleId] {
+		return fmt.Errorf("role %s is already assigned to username %s", roleName, userName)
+	}
+
+	if s.userRoles[userId] == nil {
+		s.userRoles[userId] = make(map[int]bool)
+	}
+	s.userRoles[userId][roleId] = true
+
+	s.logger.WithField("username", userName).Info("Roles for user set up with success")
+	return nil
+}
+
+// CreateRole creates a new role with the given name.
+// Returns a ConflictError if a role with the same name already exists.
+func (s *MemoryStore) CreateRole(name string) (*model.Role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.roleIdByName(name); ok {
+		return nil, conflict("role", name, "name already in use")
+	}
+	role := *s.roles[s.insertRole(name)]
+
+	s.logger.WithField("roleName", name).Info("Role created with success")
+	return &role, nil
+}
+
+// ListRoles retrieves every role, ordered by ID.
+func (s *MemoryStore) ListRoles() ([]model.Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roles := []model.Role{}
+	for _, role := range s.roles {
+		roles = append(roles, *role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].ID < roles[j].ID })
+	return roles, nil
+}
+
+// RenameRole changes the name of a role.
+// Returns a NotFoundError if the role does not exist and a ConflictError if another role already uses the name.
+func (s *MemoryStore) RenameRole(roleId int, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	role, ok := s.roles[roleId]
+	if !ok {
+		return notFound("role", roleId)
+	}
+	if id, ok := s.roleIdByName(name); ok && id != roleId {
+		return conflict("role", name, "name already in use")
+	}
+	role.Name = name
+
+	s.logger.WithField("roleId", roleId).Info("Renamed role with success")
+	return nil
+}
+
+// DeleteRole removes a role together with the permissions granted to it.
+// Returns a NotFoundError if the role does not exist and a ConflictError if it is still assigned to a user.
+func (s *MemoryStore) DeleteRole(roleId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.roles[roleId]; !ok {
+		return notFound("role", roleId)
+	}
+	for _, roles := range s.userRoles {
+		if roles[roleId] {
+			return conflict("role", roleId, "still assigned to users")
+		}
+	}
+	delete(s.rolePermissions, roleId)
+	delete(s.roles, roleId)
+
+	s.logger.WithField("roleId", roleId).Info("Role removed with success")
+	return nil
+}
+
+// permissionIdByName returns the ID of the permission with the given name. The caller must hold a lock.
+func (s *MemoryStore) permissionIdByName(name string) (int, bool) {
+	for id, permission := range s.permissions {
+		if permission.Name == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// CreatePermission creates a new permission with the given name.
+// Returns a ConflictError if a permission with the same name already exists.
+func (s *MemoryStore) CreatePermission(name string) (*model.Permission, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.permissionIdByName(name); ok {
+		return nil, conflict("permission", name, "name already in use")
+	}
+	permission := *s.permissions[s.insertPermission(name)]
+
+	s.logger.WithField("permissionName", name).Info("Permission created with success")
+	return &permission, nil
+}
+
+// ListPermissions retrieves every permission, ordered by ID.
+func (s *MemoryStore) ListPermissions() ([]model.Permission, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	permissions := []model.Permission{}
+	for _, permission := range s.permissions {
+		permissions = append(permissions, *permission)
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i].ID < permissions[j].ID })
+	return permissions, nil
+}
+
+// RenamePermission changes the name of a permission.
+// Returns a NotFoundError if the permission does not exist and a ConflictError if another permission already
+// uses the name.
+func (s *MemoryStore) RenamePermission(permissionId int, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	permission, ok := s.permissions[permissionId]
+	if !ok {
+		return notFound("permission", permissionId)
+	}
+	if id, ok := s.permissionIdByName(name); ok && id != permissionId {
+		return conflict("permission", name, "name already in use")
+	}
+	permission.Name = name
+
+	s.logger.WithField("permissionId", permissionId).Info("Renamed permission with success")
+	return nil
+}
+
+// DeletePermission removes a permission.
+// Returns a NotFoundError if the permission does not exist and a ConflictError if it is still granted to a role.
+func (s *MemoryStore) DeletePermission(permissionId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.permissions[permissionId]; !ok {
+		return notFound("permission", permissionId)
+	}
+	for _, permissions := range s.rolePermissions {
+		if permissions[permissionId] {
+			return conflict("permission", permissionId, "still granted to roles")
+		}
+	}
+	delete(s.permissions, permissionId)
+
+	s.logger.WithField("permissionId", permissionId).Info("Permission removed with success")
+	return nil
+}
+
+// GrantPermission grants a permission to a role.
+// Returns a NotFoundError if the role or the permission does not exist and a ConflictError if the role
+// already holds the permission.
+func (s *MemoryStore) GrantPermission(roleId int, permissionId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkRoleAndPermission(roleId, permissionId); err != nil {
+		return err
+	}
+	if s.rolePermissions[roleId][permissionId] {
+		return conflict("permission", permissionId, fmt.Sprintf("already granted to role %d", roleId))
+	}
+	if s.rolePermissions[roleId] == nil {
+		s.rolePermissions[roleId] = make(map[int]bool)
+	}
+	s.rolePermissions[roleId][permissionId] = true
+
+	s.logger.WithFields(logrus.Fields{
+		"roleId":       roleId,
+		"permissionId": permissionId,
+	}).Info("Permission granted with success")
+	return nil
+}
+
+// RevokePermission revokes a permission from a role.
+// Returns a NotFoundError if the role, the permission or the grant does not exist.
+func (s *MemoryStore) RevokePermission(roleId int, permissionId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkRoleAndPermission(roleId, permissionId); err != nil {
+		return err
+	}
+	if !s.rolePermissions[roleId][permissionId] {
+		return notFound("grant", fmt.Sprintf("of permission %d to role %d", permissionId, roleId))
+	}
+	delete(s.rolePermissions[roleId], permissionId)
+
+	s.logger.WithFields(logrus.Fields{
+		"roleId":       roleId,
+		"permissionId": permissionId,
+	}).Info("Permission revoked with success")
+	return nil
+}
+
+// AssignRole assigns a role to a user.
+// Returns a NotFoundError if the user or the role does not exist and a ConflictError if the user already
+// has the role.
+func (s *MemoryStore) AssignRole(userId int, roleId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUserAndRole(userId, roleId); err != nil {
+		return err
+	}
+	if s.userRoles[userId][roleId] {
+		return conflict("role", roleId, fmt.Sprintf("already assigned to user %d", userId))
+	}
+	if s.userRoles[userId] == nil {
+		s.userRoles[userId] = make(map[int]bool)
+	}
+	s.userRoles[userId][roleId] = true
+
+	s.logger.WithFields(logrus.Fields{
+		"userId": userId,
+		"roleId": roleId,
+	}).Info("Role assigned with success")
+	return nil
+}
+
+// UnassignRole removes a role from a user.
+// Returns a NotFoundError if the user, the role or the assignment does not exist.
+func (s *MemoryStore) UnassignRole(userId int, roleId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUserAndRole(userId, roleId); err != nil {
+		return err
+	}
+	if !s.userRoles[userId][roleId] {
+		return notFound("assignment", fmt.Sprintf("of role %d to user %d", roleId, userId))
+	}
+	delete(s.userRoles[userId], roleId)
+
+	s.logger.WithFields(logrus.Fields{
+		"userId": userId,
+		"roleId": roleId,
+	}).Info("Role unassigned with success")
+	return nil
+}
+
+// checkRoleAndPermission returns a NotFoundError if the role or the permission does not exist.
+// The caller must hold a lock.
+func (s *MemoryStore) checkRoleAndPermission(roleId int, permissionId int) error {
+	if _, ok := s.roles[roleId]; !ok {
+		return notFound("role", roleId)
+	}
+	if _, ok := s.permissions[permissionId]; !ok {
+		return notFound("permission", permissionId)
+	}
+	return nil
+}
+
+// checkUserAndRole returns a NotFoundError if the user or the role does not exist. The caller must hold a lock.
+func (s *MemoryStore) checkUserAndRole(userId int, roleId int) error {
+	if _, ok := s.users[userId]; !ok {
+		return notFound("user", userId)
+	}
+	if _, ok := s.roles[roleId]; !ok {
+		return notFound("role", roleId)
+	}
+	return nil
+}
+
+// RecordUserLoginFailure counts a failed login of a user, restarting the counter if the previous failure
+// happened before since. Returns the number of consecutive failures and a NotFoundError if the user does not
+// exist.
+func (s *MemoryStore) RecordUserLoginFailure(userId int, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return 0, notFound("user", userId)
+	}
+	user.FailedLogins = nextFailureCount(user.FailedLogins, user.LastFailedLoginAt, since)
+	user.LastFailedLoginAt = time.Now()
+
+	s.logger.WithFields(logrus.Fields{"userId": userId, "failures": user.FailedLogins}).Info("Login failure recorded")
+	return user.FailedLogins, nil
+}
+
+// LockUser prevents a user from logging in until the given time.
+// Returns a NotFoundError if the user does not exist.
+func (s *MemoryStore) LockUser(userId int, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return notFound("user", userId)
+	}
+	user.LockedUntil = until
+
+	s.logger.WithField("userId", userId).Info("User locked")
+	return nil
+}
+
+// UnlockUser clears the failed login counter and the lock of a user.
+// Returns a NotFoundError if the user does not exist.
+func (s *MemoryStore) UnlockUser(userId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return notFound("user", userId)
+	}
+	user.FailedLogins = 0
+	user.LastFailedLoginAt = time.Time{}
+	user.LockedUntil = time.Time{}
+
+	s.logger.WithField("userId", userId).Info("User unlocked")
+	return nil
+}
+
+// RecordIPLoginFailure counts a failed login from a client IP address, restarting the counter if the previous
+// failure happened before since. Returns the number of failures from the address.
+func (s *MemoryStore) RecordIPLoginFailure(ip string, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failures, ok := s.ipLoginFailures[ip]
+	if !ok {
+		failures = &model.LoginLockout{IP: ip}
+		s.ipLoginFailures[ip] = failures
+	}
+	failures.FailedLogins = nextFailureCount(failures.FailedLogins, failures.LastFailedAt, since)
+	failures.LastFailedAt = time.Now()
+
+	s.logger.WithFields(logrus.Fields{"ip": ip, "failures": failures.FailedLogins}).Info("Login failure recorded")
+	return failures.FailedLogins, nil
+}
+
+// LockIP prevents any login from a client IP address until the given time.
+// Returns a NotFoundError if no failure was recorded for the address.
+func (s *MemoryStore) LockIP(ip string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failures, ok := s.ipLoginFailures[ip]
+	if !ok {
+		return notFound("login failure", ip)
+	}
+	failures.LockedUntil = until
+
+	s.logger.WithField("ip", ip).Info("Address locked")
+	return nil
+}
+
+// IPLockedUntil returns the end of the lock of a client IP address, the zero time if it was never locked.
+func (s *MemoryStore) IPLockedUntil(ip string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if failures, ok := s.ipLoginFailures[ip]; ok {
+		return failures.LockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+// UnlockIP forgets the failed logins and the lock of a client IP address.
+// Returns a NotFoundError if no failure was recorded for the address.
+func (s *MemoryStore) UnlockIP(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ipLoginFailures[ip]; !ok {
+		return notFound("login failure", ip)
+	}
+	delete(s.ipLoginFailures, ip)
+
+	s.logger.WithField("ip", ip).Info("Address unlocked")
+	return nil
+}
+
+// ListLockouts lists the accounts and the client IP addresses that are currently locked, accounts first, each
+// sorted by the end of their lock.
+func (s *MemoryStore) ListLockouts() ([]model.LoginLockout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var users, ips []model.LoginLockout
+	for _, user := range s.users {
+		if user.Locked(now) {
+			users = append(users, model.LoginLockout{
+				UserID:       user.ID,
+				Username:     user.Username,
+				FailedLogins: user.FailedLogins,
+				LastFailedAt: user.LastFailedLoginAt,
+				LockedUntil:  user.LockedUntil,
+			})
+		}
+	}
+	for _, failures := range s.ipLoginFailures {
+		if now.Before(failures.LockedUntil) {
+			ips = append(ips, *failures)
+		}
+	}
+	for _, lockouts := range [][]model.LoginLockout{users, ips} {
+		sort.Slice(lockouts, func(i, j int) bool { return lockouts[i].LockedUntil.Before(lockouts[j].LockedUntil) })
+	}
+	return append(append([]model.LoginLockout{}, users...), ips...), nil
+}
+
+// PurgeLoginFailures deletes the failed login counters of the client IP addresses whose last failure happened
+// before the given time and which are not locked. Returns the number of deleted counters.
+func (s *MemoryStore) PurgeLoginFailures(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	purged := 0
+	for ip, failures := range s.ipLoginFailures {
+		if failures.LastFailedAt.Before(before) && !now.Before(failures.LockedUntil) {
+			delete(s.ipLoginFailures, ip)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// SaveTOTP stores a pending TOTP enrollment of a user, replacing the previous pending one. CreatedAt defaults to
+// the current time. Returns a NotFoundError if the user does not exist and a ConflictError if the TOTP of the
+// user is enabled.
+func (s *MemoryStore) SaveTOTP(totp model.TOTP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[totp.UserID]; !ok {
+		return notFound("user", totp.UserID)
+	}
+	if current, ok := s.totps[totp.UserID]; ok && current.Enabled {
+		return conflict("TOTP", totp.UserID, "already enabled")
+	}
+	if totp.CreatedAt.IsZero() {
+		totp.CreatedAt = time.Now()
+	}
+	s.totps[totp.UserID] = &model.TOTP{
+		UserID:          totp.UserID,
+		EncryptedSecret: totp.EncryptedSecret,
+		CreatedAt:       totp.CreatedAt,
+	}
+
+	s.logger.WithField("userId", totp.UserID).Info("TOTP enrollment stored with success")
+	return nil
+}
+
+// GetTOTP retrieves the TOTP enrollment of a user, with the number of recovery codes it has left.
+// Returns a NotFoundError if the user has none.
+func (s *MemoryStore) GetTOTP(userId int) (*model.TOTP, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	totp, ok := s.totps[userId]
+	if !ok {
+		return nil, notFound("TOTP", userId)
+	}
+	copied := *totp
+	copied.RecoveryCodesLeft = len(s.recoveryCodes[userId])
+	return &copied, nil
+}
+
+// EnableTOTP enables the pending TOTP enrollment of a user, records the time step of the confirmation code and
+// replaces the recovery codes of the user. Returns a NotFoundError if the user has no enrollment and a
+// ConflictError if it is already enabled.
+func (s *MemoryStore) EnableTOTP(userId int, step int64, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[userId]
+	if !ok {
+		return notFound("TOTP", userId)
+	} else if totp.Enabled {
+		return conflict("TOTP", userId, "already enabled")
+	}
+	totp.Enabled = true
+	totp.ConfirmedAt = time.Now()
+	totp.LastUsedStep = step
+	s.recoveryCodes[userId] = make(map[string]bool, len(recoveryCodeHashes))
+	for _, codeHash := range recoveryCodeHashes {
+		s.recoveryCodes[userId][codeHash] = true
+	}
+
+	s.logger.WithField("userId", userId).Info("TOTP enabled with success")
+	return nil
+}
+
+// UseTOTPStep records that a code of the enabled TOTP of a user was accepted. Returns a NotFoundError if the
+// user has no enabled TOTP and a ConflictError if a code of this time step or of a later one was already used.
+func (s *MemoryStore) UseTOTPStep(userId int, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[userId]
+	if !ok || !totp.Enabled {
+		return notFound("TOTP", userId)
+	} else if step <= totp.LastUsedStep {
+		return conflict("TOTP code", userId, "already used")
+	}
+	totp.LastUsedStep = step
+	return nil
+}
+
+// UseRecoveryCode deletes a recovery code of a user, so it cannot be used again.
+// Returns a NotFoundError if the user has no such recovery code.
+func (s *MemoryStore) UseRecoveryCode(userId int, codeHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.recoveryCodes[userId][codeHash] {
+		return notFound("recovery code", userId)
+	}
+	delete(s.recoveryCodes[userId], codeHash)
+
+	s.logger.WithField("userId", userId).Info("Recovery code used with success")
+	return nil
+}
+
+// DeleteTOTP deletes the TOTP enrollment of a user together with its recovery codes.
+// Returns a NotFoundError if the user has no enrollment.
+func (s *MemoryStore) DeleteTOTP(userId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.totps[userId]; !ok {
+		return notFound("TOTP", userId)
+	}
+	delete(s.totps, userId)
+	delete(s.recoveryCodes, userId)
+
+	s.logger.WithField("userId", userId).Info("TOTP removed with success")
+	return nil
+}
+
+// CreateWebAuthnChallenge stores the hash of a new challenge of a WebAuthn ceremony. A UserID of 0 stores a
+// challenge without a user. Returns a NotFoundError if the user does not exist and a ConflictError if the hash is
+// already stored.
+func (s *MemoryStore) CreateWebAuthnChallenge(challenge model.WebAuthnChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[challenge.UserID]; challenge.UserID != 0 && !ok {
+		return notFound("user", challenge.UserID)
+	}
+	if _, ok := s.webAuthnChallenges[challenge.ChallengeHash]; ok {
+		return conflict("WebAuthn challenge", challenge.UserID, "already stored")
+	}
+	s.webAuthnChallenges[challenge.ChallengeHash] = &challenge
+	return nil
+}
+
+// ConsumeWebAuthnChallenge uses a challenge of a WebAuthn ceremony, deleting it. Returns a NotFoundError if no
+// such challenge exists for the ceremony or it has expired.
+func (s *MemoryStore) ConsumeWebAuthnChallenge(challengeHash string, ceremony string) (*model.WebAuthnChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.webAuthnChallenges[challengeHash]
+	if !ok || challenge.Ceremony != ceremony || !time.Now().Before(challenge.ExpiresAt) {
+		return nil, notFound("WebAuthn challenge", "")
+	}
+	delete(s.webAuthnChallenges, challengeHash)
+
+	copied := *challenge
+	return &copied, nil
+}
+
+// PurgeExpiredWebAuthnChallenges deletes the WebAuthn challenges that have expired.
+// Returns the number of deleted challenges.
+func (s *MemoryStore) PurgeExpiredWebAuthnChallenges() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	purged := 0
+	for challengeHash, challenge := range s.webAuthnChallenges {
+		if !now.Before(challenge.ExpiresAt) {
+			delete(s.webAuthnChallenges, challengeHash)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// AddWebAuthnCredential stores a new WebAuthn credential of a user. CreatedAt defaults to the current time.
+// Returns a NotFoundError if the user does not exist and a ConflictError if the credential ID is already
+// registered.
+func (s *MemoryStore) AddWebAuthnCredential(credential model.WebAuthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[credential.UserID]; !ok {
+		return notFound("user", credential.UserID)
+	}
+	if _, ok := s.webAuthnCredentials[credential.ID]; ok {
+		return conflict("WebAuthn credential", credential.ID, "already registered")
+	}
+	if credential.CreatedAt.IsZero() {
+		credential.CreatedAt = time.Now()
+	}
+	credential.LastUsedAt = time.Time{}
+	credential.PublicKey = append([]byte(nil), credential.PublicKey...)
+	s.webAuthnCredentials[credential.ID] = &credential
+
+	s.logger.WithField("userId", credential.UserID).Info("WebAuthn credential registered with success")
+	return nil
+}
+
+// GetWebAuthnCredential retrieves a WebAuthn credential by its ID.
+// Returns a NotFoundError if it does not exist.
+func (s *MemoryStore) GetWebAuthnCredential(credentialId string) (*model.WebAuthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	credential, ok := s.webAuthnCredentials[credentialId]
+	if !ok {
+		return nil, notFound("WebAuthn credential", credentialId)
+	}
+	copied := *credential
+	return &copied, nil
+}
+
+// ListWebAuthnCredentials retrieves the WebAuthn credentials of a user, oldest first.
+// Returns a NotFoundError if the user does not exist.
+func (s *MemoryStore) ListWebAuthnCredentials(userId int) ([]model.WebAuthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.users[userId]; !ok {
+		return nil, notFound("user", userId)
+	}
+	credentials := []model.WebAuthnCredential{}
+	for _, credential := range s.webAuthnCredentials {
+		if credential.UserID == userId {
+			credentials = append(credentials, *credential)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		if !credentials[i].CreatedAt.Equal(credentials[j].CreatedAt) {
+			return credentials[i].CreatedAt.Before(credentials[j].CreatedAt)
+		}
+		return credentials[i].ID < credentials[j].ID
+	})
+	return credentials, nil
+}
+
+// UpdateWebAuthnSignCount records the signature counter of a WebAuthn credential and the time it was last used.
+// Returns a NotFoundError if the credential does not exist.
+func (s *MemoryStore) UpdateWebAuthnSignCount(credentialId string, signCount uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, ok := s.webAuthnCredentials[credentialId]
+	if !ok {
+		return notFound("WebAuthn credential", credentialId)
+	}
+	credential.SignCount = signCount
+	credential.LastUsedAt = time.Now()
+	return nil
+}
+
+// DeleteWebAuthnCredential deletes a WebAuthn credential of a user.
+// Returns a NotFoundError if the user has no such credential.
+func (s *MemoryStore) DeleteWebAuthnCredential(userId int, credentialId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, ok := s.webAuthnCredentials[credentialId]
+	if !ok || credential.UserID != userId {
+		return notFound("WebAuthn credential", credentialId)
+	}
+	delete(s.webAuthnCredentials, credentialId)
+
+	s.logger.WithField("userId", userId).Info("WebAuthn credential removed with success")
+	return nil
+}
+
+// CreateOAuthClient registers a new OAuth client. CreatedAt defaults to the current time.
+// Returns a ConflictError if the client ID is already registered.
+func (s *MemoryStore) CreateOAuthClient(client model.OAuthClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.oauthClients[client.ID]; ok {
+		return conflict("OAuth client", client.ID, "already registered")
+	}
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now()
+	}
+	client.RedirectURIs = append([]string{}, client.RedirectURIs...)
+	client.Scopes = append([]string{}, client.Scopes...)
+	client.GrantTypes = append([]string{}, client.GrantTypes...)
+	s.oauthClients[client.ID] = &client
+
+	s.logger.WithField("clientId", client.ID).Info("OAuth client registered with success")
+	return nil
+}
+
+// GetOAuthClient retrieves an OAuth client by its ID.
+// Returns a NotFoundError if it does not exist.
+func (s *MemoryStore) GetOAuthClient(clientId string) (*model.OAuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client, ok := s.oauthClients[clientId]
+	if !ok {
+		return nil, notFound("OAuth client", clientId)
+	}
+	return copyOAuthClient(client), nil
+}
+
+// ListOAuthClients retrieves every OAuth client, oldest first.
+func (s *MemoryStore) ListOAuthClients() ([]model.OAuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clients := []model.OAuthClient{}
+	for _, client := range s.oauthClients {
+		clients = append(clients, *copyOAuthClient(client))
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if !clients[i].CreatedAt.Equal(clients[j].CreatedAt) {
+			return clients[i].CreatedAt.Before(clients[j].CreatedAt)
+		}
+		return clients[i].ID < clients[j].ID
+	})
+	return clients, nil
+}
+
+// DeleteOAuthClient removes an OAuth client with its authorization codes, and revokes the sessions started through
+// it. Returns a NotFoundError if the client does not exist.
+func (s *MemoryStore) DeleteOAuthClient(clientId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.oauthClients[clientId]; !ok {
+		return notFound("OAuth client", clientId)
+	}
+	delete(s.oauthClients, clientId)
+	for codeHash, code := range s.oauthCodes {
+		if code.ClientID == clientId {
+			delete(s.oauthCodes, codeHash)
+		}
+	}
+	revoked := 0
+	for _, session := range s.sessions {
+		if session.ClientID == clientId && !session.Revoked {
+			session.Revoked = true
+			session.TokenHash = ""
+			revoked++
+		}
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"clientId": clientId,
+		"revoked":  revoked,
+	}).Info("OAuth client removed with success")
+	return nil
+}
+
+// CreateOAuthAuthorizationCode stores the hash of a new authorization code. Returns a NotFoundError if the client
+// or the user does not exist and a ConflictError if the hash is already stored.
+func (s *MemoryStore) CreateOAuthAuthorizationCode(code model.OAuthAuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.oauthClients[code.ClientID]; !ok {
+		return notFound("OAuth client", code.ClientID)
+	}
+	if _, ok := s.users[code.UserID]; !ok {
+		return notFound("user", code.UserID)
+	}
+	if _, ok := s.oauthCodes[code.CodeHash]; ok {
+		return conflict("OAuth authorization code", code.ClientID, "already stored")
+	}
+	s.oauthCodes[code.CodeHash] = &code
+	return nil
+}
+
+// ConsumeOAuthAuthorizationCode uses an authorization code, deleting it. Returns a NotFoundError if no such code
+// exists or it has expired.
+func (s *MemoryStore) ConsumeOAuthAuthorizationCode(codeHash string) (*model.OAuthAuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, ok := s.oauthCodes[codeHash]
+	if !ok || !time.Now().Before(code.ExpiresAt) {
+		return nil, notFound("OAuth authorization code", "")
+	}
+	delete(s.oauthCodes, codeHash)
+
+	copied := *code
+	return &copied, nil
+}
+
+// PurgeExpiredOAuthAuthorizationCodes deletes the authorization codes that have expired.
+// Returns the number of deleted codes.
+func (s *MemoryStore) PurgeExpiredOAuthAuthorizationCodes() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	purged := 0
+	for codeHash, code := range s.oauthCodes {
+		if !now.Before(code.ExpiresAt) {
+			delete(s.oauthCodes, codeHash)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// copyOAuthClient returns a copy of a client that does not share its lists. The caller must hold a lock.
+func copyOAuthClient(client *model.OAuthClient) *model.OAuthClient {
+	copied := *client
+	copied.RedirectURIs = append([]string{}, client.RedirectURIs...)
+	copied.Scopes = append([]string{}, client.Scopes...)
+	copied.GrantTypes = append([]string{}, client.GrantTypes...)
+	return &copied
+}
+
+// CreateExternalIdentity links an identity of an upstream provider to a user. CreatedAt defaults to the current
+// time. Returns a NotFoundError if the user does not exist and a ConflictError if the subject is already linked or
+// the user already has an identity at the provider.
+func (s *MemoryStore) CreateExternalIdentity(identity model.ExternalIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[identity.UserID]; !ok {
+		return notFound("user", identity.UserID)
+	}
+	key := externalIdentityKey{provider: identity.Provider, subject: identity.Subject}
+	if _, ok := s.externalIdentities[key]; ok {
+		return conflict("external identity", identity.Provider, "already linked to a user")
+	}
+	for _, linked := range s.externalIdentities {
+		if linked.UserID == identity.UserID && linked.Provider == identity.Provider {
+			return conflict("external identity", identity.Provider, "the user already has an identity at this provider")
+		}
+	}
+
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now()
+	}
+	s.externalIdentities[key] = &identity
+
+	s.logger.WithFields(logrus.Fields{
+		"provider": identity.Provider,
+		"userId":   identity.UserID,
+	}).Info("External identity linked with success")
+	return nil
+}
+
+// GetExternalIdentity retrieves the identity of a subject of an upstream provider.
+// Returns a NotFoundError if the subject is not linked.
+func (s *MemoryStore) GetExternalIdentity(provider string, subject string) (*model.ExternalIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identity, ok := s.externalIdentities[externalIdentityKey{provider: provider, subject: subject}]
+	if !ok {
+		return nil, notFound("external identity", provider)
+	}
+	copied := *identity
+	return &copied, nil
+}
+
+// ListExternalIdentities retrieves the identities of a user at the upstream providers, ordered by provider.
+// Returns a NotFoundError if the user does not exist.
+func (s *MemoryStore) ListExternalIdentities(userId int) ([]model.ExternalIdentity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.users[userId]; !ok {
+		return nil, notFound("user", userId)
+	}
+	identities := []model.ExternalIdentity{}
+	for _, identity := range s.externalIdentities {
+		if identity.UserID == userId {
+			identities = append(identities, *identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].Provider < identities[j].Provider
+	})
+	return identities, nil
+}
+
+// DeleteExternalIdentity unlinks the identity of a user at an upstream provider.
+// Returns a NotFoundError if the user has no identity at the provider.
+func (s *MemoryStore) DeleteExternalIdentity(userId int, provider string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, identity := range s.externalIdentities {
+		if identity.UserID == userId && identity.Provider == provider {
+			delete(s.externalIdentities, key)
+			s.logger.WithFields(logrus.Fields{
+				"provider": provider,
+				"userId":   userId,
+			}).Info("External identity unlinked with success")
+			return nil
+		}
+	}
+	return notFound("external identity", provider)
+}
+
+// CreateFederatedLoginState stores the hash of the state of a new login through an upstream provider. A UserID of
+// 0 stores a state without a user. Returns a NotFoundError if the user does not exist and a ConflictError if the
+// hash is already stored.
+func (s *MemoryStore) CreateFederatedLoginState(state model.FederatedLoginState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[state.UserID]; state.UserID != 0 && !ok {
+		return notFound("user", state.UserID)
+	}
+	if _, ok := s.federatedLoginStates[state.StateHash]; ok {
+		return conflict("federated login state", state.Provider, "already stored")
+	}
+	s.federatedLoginStates[state.StateHash] = &state
+	return nil
+}
+
+// ConsumeFederatedLoginState uses the state of a login through an upstream provider, deleting it. Returns a
+// NotFoundError if no such state exists for the provider or it has expired.
+func (s *MemoryStore) ConsumeFederatedLoginState(stateHash string, provider string) (*model.FederatedLoginState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.federatedLoginStates[stateHash]
+	if !ok || state.Provider != provider || !time.Now().Before(state.ExpiresAt) {
+		return nil, notFound("federated login state", provider)
+	}
+	delete(s.federatedLoginStates, stateHash)
+
+	copied := *state
+	return &copied, nil
+}
+
+// PurgeExpiredFederatedLoginStates deletes the federated login states that have expired.
+// Returns the number of deleted states.
+func (s *MemoryStore) PurgeExpiredFederatedLoginStates() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	purged := 0
+	for stateHash, state := range s.federatedLoginStates {
+		if !now.Before(state.ExpiresAt) {
+			delete(s.federatedLoginStates, stateHash)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// CreateAPIKey stores a new API key of a user. CreatedAt defaults to the current time.
+// Returns a NotFoundError if the user does not exist and a ConflictError if the ID is already stored.
+func (s *MemoryStore) CreateAPIKey(key model.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[key.UserID]; !ok {
+		return notFound("user", key.UserID)
+	}
+	if _, ok := s.apiKeys[key.ID]; ok {
+		return conflict("API key", key.ID, "already stored")
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	key.LastUsedAt = nil
+	copied := copyAPIKey(key)
+	s.apiKeys[key.ID] = &copied
+
+	s.logger.WithField("userId", key.UserID).Info("API key created with success")
+	return nil
+}
+
+// GetAPIKey retrieves an API key by its ID.
+// Returns a NotFoundError if it does not exist.
+func (s *MemoryStore) GetAPIKey(keyId string) (*model.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.apiKeys[keyId]
+	if !ok {
+		return nil, notFound("API key", keyId)
+	}
+	copied := copyAPIKey(*key)
+	return &copied, nil
+}
+
+// ListAPIKeys retrieves the API keys of a user, oldest first, including the expired ones.
+// Returns a NotFoundError if the user does not exist.
+func (s *MemoryStore) ListAPIKeys(userId int) ([]model.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.users[userId]; !ok {
+		return nil, notFound("user", userId)
+	}
+	keys := []model.APIKey{}
+	for _, key := range s.apiKeys {
+		if key.UserID == userId {
+			keys = append(keys, copyAPIKey(*key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// TouchAPIKey records the time an API key was last used.
+// Returns a NotFoundError if the key does not exist.
+func (s *MemoryStore) TouchAPIKey(keyId string, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[keyId]
+	if !ok {
+		return notFound("API key", keyId)
+	}
+	key.LastUsedAt = &usedAt
+	return nil
+}
+
+// DeleteAPIKey deletes an API key of a user.
+// Returns a NotFoundError if the user has no such key.
+func (s *MemoryStore) DeleteAPIKey(userId int, keyId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.apiKeys[keyId]
+	if !ok || key.UserID != userId {
+		return notFound("API key", keyId)
+	}
+	delete(s.apiKeys, keyId)
+
+	s.logger.WithField("userId", userId).Info("API key deleted with success")
+	return nil
+}
+
+// copyAPIKey copies an API key, so the stored key does not share its permissions or expiration with the caller.
+func copyAPIKey(key model.APIKey) model.APIKey {
+	key.Permissions = append([]string{}, key.Permissions...)
+	if key.ExpiresAt != nil {
+		expiresAt := *key.ExpiresAt
+		key.ExpiresAt = &expiresAt
+	}
+	if key.LastUsedAt != nil {
+		lastUsedAt := *key.LastUsedAt
+		key.LastUsedAt = &lastUsedAt
+	}
+	return key
+}
@@ -0,0 +1,23 @@
+package repository_test
+
+import (
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/repository/storetest"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+)
+
+// quietLogger returns a logger discarding its output, so the logs of the stores do not flood the test output.
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// TestMemoryStore runs the conformance suite against a fresh MemoryStore per subtest.
+func TestMemoryStore(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) repository.Store {
+		return repository.NewMemoryStore(quietLogger())
+	})
+}
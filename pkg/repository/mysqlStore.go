@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"database/sql"
+	"github.com/sirupsen/logrus"
+)
+
+// MySQLStore is the Store implementation backed by the MariaDB/MySQL schema defined by the migrations
+// of pkg/migrations.
+type MySQLStore struct {
+	logger *logrus.Logger
+	db     *sql.DB
+}
+
+// NewMySQLStore creates a MySQLStore that runs its queries on the given database connection.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// db: A pointer to the sql.DB instance representing the database connection.
+//
+// Returns a pointer to the MySQLStore.
+func NewMySQLStore(logger *logrus.Logger, db *sql.DB) *MySQLStore {
+	return &MySQLStore{
+		logger: logger,
+		db:     db,
+	}
+}
+
+// exists runs a COUNT(*) query and reports whether it counted at least one row.
+//
+// query: A SELECT COUNT(*) query.
+// args: The arguments of the query.
+//
+// Returns true if the count is greater than zero and an error, if any.
+func (s *MySQLStore) exists(query string, args ...interface{}) (bool, error) {
+	var count int
+	if err := s.db.QueryRow(query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,46 @@
+package repository_test
+
+import (
+	"GolandRestApi/pkg/migrations"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/repository/storetest"
+	"database/sql"
+	_ "github.com/go-sql-driver/mysql"
+	"os"
+	"testing"
+)
+
+// mysqlDSNVariable names the environment variable holding the DSN of the database TestMySQLStore runs against,
+// e.g. user:password@tcp(localhost:3306)/RestApiTest?parseTime=true. The pending migrations are applied to it.
+const mysqlDSNVariable = "STORETEST_MYSQL_DSN"
+
+// TestMySQLStore runs the conformance suite against a MySQLStore. It is skipped unless STORETEST_MYSQL_DSN is
+// set. Every subtest shares the database, which the suite supports by using unique names.
+func TestMySQLStore(t *testing.T) {
+	dsn := os.Getenv(mysqlDSNVariable)
+	if dsn == "" {
+		t.Skipf("%s is not set", mysqlDSNVariable)
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err = db.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	migrator, err := migrations.NewMigrator(quietLogger(), db)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	if _, err = migrator.Up(0); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	store := repository.NewMySQLStore(quietLogger(), db)
+	storetest.TestStore(t, func(t *testing.T) repository.Store {
+		return store
+	})
+}
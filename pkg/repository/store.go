@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserStore groups the persistence operations on user accounts used by the handlers.
+// Lookups that do not match any user return sql.ErrNoRows, regardless of the implementation. Every profile
+// update increments the version of the user; an update made on an older version returns a ConflictError.
+// Changing the email address of a user marks it as not verified until VerifyEmail is called for the new one.
+// ListUsers returns the users matching a query with the names of their roles, without a lookup per user.
+// UpdateUser applies the changes of an administrator in a single transaction, replacing the roles of the user as a
+// whole, and records a UserChange per changed field. Changing the password clears the reset required by an
+// administrator.
+// SoftDeleteUser marks a user as deleted, which the lookups and ListUsers treat as missing while UserExists keeps
+// its username and email address in use, until RestoreUser makes it active again or PurgeDeletedUsers removes it
+// with DeleteUser, which removes a user for good.
+type UserStore interface {
+	UserExists(username string, email string) (bool, error)
+	AddUser(user model.User, roleName string) error
+	GetUserByUserName(username string) (*model.User, error)
+	GetUserById(userId int) (*model.User, error)
+	GetUserByEmail(email string) (*model.User, error)
+	GetUserNameByUserId(userId int) (string, error)
+	GetUserIdByUserName(username string) (int, error)
+	UpdatePassword(userId int, hashedPassword string) error
+	UpdateProfile(userId int, version int, update model.ProfileUpdate) (*model.User, error)
+	VerifyEmail(userId int, email string) error
+	DeleteUser(userId int) error
+	ListUsers(query model.UserQuery) ([]model.UserSummary, error)
+	UpdateUser(userId int, version int, update model.AdminUserUpdate, actorId int) (*model.User, error)
+	ListUserChanges(userId int) ([]model.UserChange, error)
+	SoftDeleteUser(userId int, actorId int) error
+	RestoreUser(userId int, actorId int) (*model.User, error)
+	PurgeDeletedUsers(before time.Time) (int, error)
+}
+
+// TokenStore groups the persistence operations on the sessions of users and their refresh tokens. Every login
+// creates a session, so a user can be logged in on several devices at once. The refresh tokens of a session
+// form a token family: every refresh rotates the current token of the session, identified by its keyed hash,
+// and the hashes of the rotated tokens are kept to recognise a replayed token. The sessions started by an OAuth
+// client record its ID and the granted scope. Operations referencing a missing user or session return a
+// NotFoundError.
+type TokenStore interface {
+	CreateSession(session model.Session) error
+	GetSession(sessionId string) (*model.Session, error)
+	ListSessions(userId int) ([]model.Session, error)
+	RotateRefreshToken(sessionId string, previousTokenHash string, tokenHash string, expiresAt time.Time) error
+	IsRotatedRefreshToken(sessionId string, tokenHash string) (bool, error)
+	RevokeSession(sessionId string) error
+	RevokeUserSessions(userId int, exceptSessionId string) (int, error)
+}
+
+// DenylistStore groups the persistence operations on the access tokens issued by the API and the denylist of
+// revoked ones. Every issued access token is recorded with its session, user and expiration time, so logging a
+// session or a user out can deny all its outstanding tokens. Entries are ignored once the token has expired and
+// can then be purged.
+type DenylistStore interface {
+	RecordAccessToken(token model.AccessToken) error
+	DenySessionAccessTokens(sessionId string) (int, error)
+	DenyUserAccessTokens(userId int) (int, error)
+	IsAccessTokenDenied(tokenId string) (bool, error)
+	PurgeExpiredAccessTokens() (int, error)
+}
+
+// PasswordResetStore groups the persistence operations on the password reset tokens. A reset token can be used
+// once, before it expires, and only its hash is stored. Using a token discards the other tokens of its user.
+type PasswordResetStore interface {
+	CreatePasswordResetToken(token model.PasswordResetToken) error
+	ConsumePasswordResetToken(tokenHash string) (*model.PasswordResetToken, error)
+	PurgeExpiredPasswordResetTokens() (int, error)
+}
+
+// LockoutStore groups the persistence operations on the failed login counters of the accounts and of the client
+// IP addresses. Recording a failure increments the counter, or restarts it at 1 when the previous failure
+// happened before the given time. Locks are only set by the caller, which decides on the lockout window.
+// Operations referencing a missing user or address return a NotFoundError.
+type LockoutStore interface {
+	RecordUserLoginFailure(userId int, since time.Time) (int, error)
+	LockUser(userId int, until time.Time) error
+	UnlockUser(userId int) error
+	RecordIPLoginFailure(ip string, since time.Time) (int, error)
+	LockIP(ip string, until time.Time) error
+	IPLockedUntil(ip string) (time.Time, error)
+	UnlockIP(ip string) error
+	ListLockouts() ([]model.LoginLockout, error)
+	PurgeLoginFailures(before time.Time) (int, error)
+}
+
+// TOTPStore groups the persistence operations on the TOTP second factor of the users and their recovery codes.
+// A user has at most one enrollment, pending until it is enabled; an enabled enrollment cannot be replaced, only
+// deleted. Accepting a code records its time step, so the code and the earlier ones cannot be used again. Only
+// the hashes of the recovery codes are stored, and a recovery code is deleted when it is used. Operations
+// referencing a missing user or enrollment return a NotFoundError.
+type TOTPStore interface {
+	SaveTOTP(totp model.TOTP) error
+	GetTOTP(userId int) (*model.TOTP, error)
+	EnableTOTP(userId int, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(userId int, step int64) error
+	UseRecoveryCode(userId int, codeHash string) error
+	DeleteTOTP(userId int) error
+}
+
+// WebAuthnStore groups the persistence operations on the WebAuthn credentials (passkeys) of the users and on the
+// challenges of the registration and login ceremonies. A credential ID is unique across users. A challenge can be
+// used once, for the ceremony it was issued for and before it expires, and only its hash is stored. Operations
+// referencing a missing user, credential or challenge return a NotFoundError.
+type WebAuthnStore interface {
+	CreateWebAuthnChallenge(challenge model.WebAuthnChallenge) error
+	ConsumeWebAuthnChallenge(challengeHash string, ceremony string) (*model.WebAuthnChallenge, error)
+	PurgeExpiredWebAuthnChallenges() (int, error)
+
+	AddWebAuthnCredential(credential model.WebAuthnCredential) error
+	GetWebAuthnCredential(credentialId string) (*model.WebAuthnCredential, error)
+	ListWebAuthnCredentials(userId int) ([]model.WebAuthnCredential, error)
+	UpdateWebAuthnSignCount(credentialId string, signCount uint32) error
+	DeleteWebAuthnCredential(userId int, credentialId string) error
+}
+
+// OAuthStore groups the persistence operations on the OAuth clients and the authorization codes issued to them.
+// Only the hashes of the client secrets and of the codes are stored. A code can be used once, before it expires.
+// Deleting a client deletes its outstanding codes and revokes the sessions started through it. Operations
+// referencing a missing client, user or code return a NotFoundError.
+type OAuthStore interface {
+	CreateOAuthClient(client model.OAuthClient) error
+	GetOAuthClient(clientId string) (*model.OAuthClient, error)
+	ListOAuthClients() ([]model.OAuthClient, error)
+	DeleteOAuthClient(clientId string) error
+
+	CreateOAuthAuthorizationCode(code model.OAuthAuthorizationCode) error
+	ConsumeOAuthAuthorizationCode(codeHash string) (*model.OAuthAuthorizationCode, error)
+	PurgeExpiredOAuthAuthorizationCodes() (int, error)
+}
+
+// FederationStore groups the persistence operations on the identities of the users at the upstream identity
+// providers and on the states of the logins through them. A subject of a provider is linked to one user, and a
+// user has at most one identity per provider. A login state can be used once, for the provider it was issued for
+// and before it expires, and only its hash is stored. Operations referencing a missing user, identity or state
+// return a NotFoundError, and linking an identity already linked returns a ConflictError.
+type FederationStore interface {
+	CreateExternalIdentity(identity model.ExternalIdentity) error
+	GetExternalIdentity(provider string, subject string) (*model.ExternalIdentity, error)
+	ListExternalIdentities(userId int) ([]model.ExternalIdentity, error)
+	DeleteExternalIdentity(userId int, provider string) error
+
+	CreateFederatedLoginState(state model.FederatedLoginState) error
+	ConsumeFederatedLoginState(stateHash string, provider string) (*model.FederatedLoginState, error)
+	PurgeExpiredFederatedLoginStates() (int, error)
+}
+
+// APIKeyStore groups the persistence operations on the API keys of the users. Only the hash of the secret of a key
+// is stored. Operations referencing a missing user or key return a NotFoundError, and creating a key with an ID
+// already stored returns a ConflictError.
+type APIKeyStore interface {
+	CreateAPIKey(key model.APIKey) error
+	GetAPIKey(keyId string) (*model.APIKey, error)
+	ListAPIKeys(userId int) ([]model.APIKey, error)
+	TouchAPIKey(keyId string, usedAt time.Time) error
+	DeleteAPIKey(userId int, keyId string) error
+}
+
+// RBACStore groups the persistence operations on roles, permissions and their assignments.
+// Operations referencing a missing role, permission, user or assignment return a NotFoundError, and
+// operations breaking a uniqueness or referential integrity rule return a ConflictError.
+type RBACStore interface {
+	GetPermissionsByRoleId(roleId int) ([]string, error)
+	GetUserRolesByUserId(userId int) ([]string, error)
+	GetUserPermissionsByUserId(userId int) ([]string, error)
+	SetUserRole(userName string, roleName string) error
+
+	CreateRole(name string) (*model.Role, error)
+	ListRoles() ([]model.Role, error)
+	RenameRole(roleId int, name string) error
+	DeleteRole(roleId int) error
+
+	CreatePermission(name string) (*model.Permission, error)
+	ListPermissions() ([]model.Permission, error)
+	RenamePermission(permissionId int, name string) error
+	DeletePermission(permissionId int) error
+
+	GrantPermission(roleId int, permissionId int) error
+	RevokePermission(roleId int, permissionId int) error
+	AssignRole(userId int, roleId int) error
+	UnassignRole(userId int, roleId int) error
+}
+
+// Store is the complete storage backend of the application. Both the MySQL and the in-memory
+// implementations satisfy it, so the API can run against either of them.
+type Store interface {
+	UserStore
+	TokenStore
+	DenylistStore
+	PasswordResetStore
+	LockoutStore
+	TOTPStore
+	WebAuthnStore
+	OAuthStore
+	FederationStore
+	APIKeyStore
+	RBACStore
+}
+
+// nextFailureCount computes a failed login counter after a new failure.
+//
+// failures: The current value of the counter.
+// lastFailedAt: The time of the previous failure, or the zero time if there was none.
+// since: The time before which a previous failure is forgotten.
+//
+// Returns the new value of the counter: 1 if the previous failure is forgotten, failures + 1 otherwise.
+func nextFailureCount(failures int, lastFailedAt time.Time, since time.Time) int {
+	if lastFailedAt.IsZero() || lastFailedAt.Before(since) {
+		return 1
+	}
+	return failures + 1
+}
+
+// userChanges lists the fields of a user changed by an administrator.
+//
+// before: The user before the update.
+// after: The user after the update.
+// beforeRoles: The names of the roles of the user before the update, ordered by role ID.
+// afterRoles: The names of the roles of the user after the update, ordered by role ID; nil if they are unchanged.
+// actorId: The ID of the administrator making the update.
+// changedAt: The time of the update.
+//
+// Returns a change for every field whose value differs, without ID.
+func userChanges(before *model.User,
+	after *model.User,
+	beforeRoles []string,
+	afterRoles []string,
+	actorId int,
+	changedAt time.Time) []model.UserChange {
+	type value struct {
+		field    string
+		old, new string
+	}
+	values := []value{
+		{utils.UserFieldEmail, before.Email, after.Email},
+		{utils.UserFieldCountry, before.Country, after.Country},
+		{utils.UserFieldPhone, before.Phone, after.Phone},
+		{utils.UserFieldStatus, before.Status, after.Status},
+		{utils.UserFieldPasswordResetRequired, strconv.FormatBool(before.PasswordResetRequired),
+			strconv.FormatBool(after.PasswordResetRequired)},
+	}
+	if afterRoles != nil {
+		values = append(values, value{utils.UserFieldRoles, strings.Join(beforeRoles, ","), strings.Join(afterRoles, ",")})
+	}
+
+	var changes []model.UserChange
+	for _, v := range values {
+		if v.old != v.new {
+			changes = append(changes, model.UserChange{
+				UserID:    after.ID,
+				ActorID:   actorId,
+				Field:     v.field,
+				OldValue:  v.old,
+				NewValue:  v.new,
+				ChangedAt: changedAt,
+			})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,1866 @@
+// Package storetest provides the conformance suite that every repository.Store implementation
+// must pass. It expects a store seeded with the default roles of the schema migrations ("admin" and "user").
+package storetest
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/utils"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// NewStoreFunc returns a fresh store for one subtest of the suite.
+type NewStoreFunc func(t *testing.T) repository.Store
+
+// TestStore runs the full conformance suite against the stores returned by newStore.
+//
+// t: The testing.T of the calling test.
+// newStore: A function returning a fresh store, seeded with the default roles, for every subtest.
+func TestStore(t *testing.T, newStore NewStoreFunc) {
+	t.Run("UserStore", func(t *testing.T) { TestUserStore(t, newStore) })
+	t.Run("TokenStore", func(t *testing.T) { TestTokenStore(t, newStore) })
+	t.Run("DenylistStore", func(t *testing.T) { TestDenylistStore(t, newStore) })
+	t.Run("PasswordResetStore", func(t *testing.T) { TestPasswordResetStore(t, newStore) })
+	t.Run("LockoutStore", func(t *testing.T) { TestLockoutStore(t, newStore) })
+	t.Run("TOTPStore", func(t *testing.T) { TestTOTPStore(t, newStore) })
+	t.Run("WebAuthnStore", func(t *testing.T) { TestWebAuthnStore(t, newStore) })
+	t.Run("OAuthStore", func(t *testing.T) { TestOAuthStore(t, newStore) })
+	t.Run("FederationStore", func(t *testing.T) { TestFederationStore(t, newStore) })
+	t.Run("APIKeyStore", func(t *testing.T) { TestAPIKeyStore(t, newStore) })
+	t.Run("RBACStore", func(t *testing.T) { TestRBACStore(t, newStore) })
+	t.Run("Concurrency", func(t *testing.T) { TestConcurrency(t, newStore) })
+}
+
+// uniqueName returns a username that does not collide with the data of other subtests, so the
+// suite can also run against a shared database.
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s_%d", prefix, time.Now().UnixNano())
+}
+
+// createSession creates a session of the user, valid for an hour, whose current refresh token hash is tokenHash.
+func createSession(t *testing.T, store repository.Store, userId int, tokenHash string) string {
+	t.Helper()
+	sessionId := uniqueName("session")
+	err := store.CreateSession(model.Session{
+		ID:          sessionId,
+		UserID:      userId,
+		DeviceLabel: "laptop",
+		UserAgent:   "storetest",
+		IP:          "192.0.2.1",
+		ExpiresAt:   time.Now().Add(time.Hour),
+		TokenHash:   tokenHash,
+	})
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	return sessionId
+}
+
+// addUser creates a user with the "user" role and returns its ID.
+func addUser(t *testing.T, store repository.Store, username string) int {
+	t.Helper()
+	user := model.NewUser(username, "secret", "hashed-secret", username+"@example.com", "Portugal", "123456789")
+	if err := store.AddUser(*user, "user"); err != nil {
+		t.Fatalf("AddUser(%s): %v", username, err)
+	}
+
+	userId, err := store.GetUserIdByUserName(username)
+	if err != nil {
+		t.Fatalf("GetUserIdByUserName(%s): %v", username, err)
+	}
+	return userId
+}
+
+// TestUserStore checks the UserStore operations.
+func TestUserStore(t *testing.T, newStore NewStoreFunc) {
+	t.Run("AddAndGet", func(t *testing.T) {
+		store := newStore(t)
+		username := uniqueName("alice")
+		userId := addUser(t, store, username)
+
+		user, err := store.GetUserByUserName(username)
+		if err != nil {
+			t.Fatalf("GetUserByUserName: %v", err)
+		}
+		if user.ID != userId || user.Username != username || user.HashedPassword != "hashed-secret" ||
+			user.Email != username+"@example.com" || user.Country != "Portugal" || user.Phone != "123456789" {
+			t.Errorf("GetUserByUserName returned %+v", user)
+		}
+		if user.Password != "" {
+			t.Errorf("GetUserByUserName returned the plaintext password")
+		}
+
+		name, err := store.GetUserNameByUserId(userId)
+		if err != nil || name != username {
+			t.Errorf("GetUserNameByUserId = %q, %v; want %q", name, err, username)
+		}
+	})
+
+	t.Run("Exists", func(t *testing.T) {
+		store := newStore(t)
+		username := uniqueName("bob")
+		addUser(t, store, username)
+
+		for _, c := range []struct {
+			username, email string
+			want            bool
+		}{
+			{username, "other@example.com", true},
+			{uniqueName("nobody"), username + "@example.com", true},
+			{uniqueName("nobody"), "nobody@example.com", false},
+		} {
+			exists, err := store.UserExists(c.username, c.email)
+			if err != nil || exists != c.want {
+				t.Errorf("UserExists(%q, %q) = %v, %v; want %v", c.username, c.email, exists, err, c.want)
+			}
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		store := newStore(t)
+		if _, err := store.GetUserByUserName(uniqueName("ghost")); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUserByUserName error = %v; want sql.ErrNoRows", err)
+		}
+		if _, err := store.GetUserNameByUserId(-42); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUserNameByUserId error = %v; want sql.ErrNoRows", err)
+		}
+		if id, err := store.GetUserIdByUserName(uniqueName("ghost")); !errors.Is(err, sql.ErrNoRows) || id != -1 {
+			t.Errorf("GetUserIdByUserName = %d, %v; want -1, sql.ErrNoRows", id, err)
+		}
+	})
+
+	t.Run("UnknownRole", func(t *testing.T) {
+		store := newStore(t)
+		user := model.NewUser(uniqueName("carol"), "secret", "hashed", "", "", "")
+		if err := store.AddUser(*user, "no-such-role"); err == nil {
+			t.Errorf("AddUser with an unknown role succeeded")
+		}
+	})
+
+	t.Run("UpdatePassword", func(t *testing.T) {
+		store := newStore(t)
+		username := uniqueName("carol")
+		userId := addUser(t, store, username)
+
+		if err := store.UpdatePassword(userId, "new-hashed-secret"); err != nil {
+			t.Fatalf("UpdatePassword: %v", err)
+		}
+		user, err := store.GetUserByUserName(username)
+		if err != nil {
+			t.Fatalf("GetUserByUserName: %v", err)
+		}
+		if user.HashedPassword != "new-hashed-secret" {
+			t.Errorf("HashedPassword = %q; want %q", user.HashedPassword, "new-hashed-secret")
+		}
+		if err := store.UpdatePassword(userId, "new-hashed-secret"); err != nil {
+			t.Errorf("UpdatePassword with an unchanged hash: %v", err)
+		}
+		if err := store.UpdatePassword(-1, "hash"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("UpdatePassword of a missing user error = %v; want sql.ErrNoRows", err)
+		}
+	})
+
+	t.Run("UpdateProfile", func(t *testing.T) {
+		store := newStore(t)
+		username := uniqueName("erin")
+		userId := addUser(t, store, username)
+
+		user, err := store.GetUserById(userId)
+		if err != nil {
+			t.Fatalf("GetUserById: %v", err)
+		}
+		if user.Username != username || user.Version != 1 {
+			t.Fatalf("GetUserById = %s version %d; want %s version 1", user.Username, user.Version, username)
+		}
+
+		email, country := username+"@example.org", ""
+		updated, err := store.UpdateProfile(userId, 1, model.ProfileUpdate{Email: &email, Country: &country})
+		if err != nil {
+			t.Fatalf("UpdateProfile: %v", err)
+		}
+		if updated.Email != email || updated.Country != "" || updated.Phone != user.Phone || updated.Version != 2 {
+			t.Errorf("UpdateProfile = %+v; want the new email, no country, the same phone and version 2", updated)
+		}
+		if updated.UpdatedAt.Before(user.UpdatedAt) {
+			t.Errorf("UpdatedAt = %v; want at least %v", updated.UpdatedAt, user.UpdatedAt)
+		}
+
+		phone := "987654321"
+		if _, err := store.UpdateProfile(userId, 1, model.ProfileUpdate{Phone: &phone}); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("UpdateProfile on an outdated version error = %v; want ErrConflict", err)
+		}
+
+		other := uniqueName("frank")
+		addUser(t, store, other)
+		otherEmail := other + "@example.com"
+		if _, err := store.UpdateProfile(userId, 2, model.ProfileUpdate{Email: &otherEmail}); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("UpdateProfile with the email of another user error = %v; want ErrConflict", err)
+		}
+		if _, err := store.UpdateProfile(-1, 1, model.ProfileUpdate{Phone: &phone}); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("UpdateProfile of a missing user error = %v; want sql.ErrNoRows", err)
+		}
+		if _, err := store.GetUserById(-1); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUserById of a missing user error = %v; want sql.ErrNoRows", err)
+		}
+	})
+
+	t.Run("VerifyEmail", func(t *testing.T) {
+		store := newStore(t)
+		username := uniqueName("grace")
+		userId := addUser(t, store, username)
+		email := username + "@example.com"
+
+		user, err := store.GetUserById(userId)
+		if err != nil {
+			t.Fatalf("GetUserById: %v", err)
+		}
+		if user.EmailVerified {
+			t.Fatalf("EmailVerified of a new user = true; want false")
+		}
+		for i := 0; i < 2; i++ {
+			if err := store.VerifyEmail(userId, email); err != nil {
+				t.Fatalf("VerifyEmail (call %d): %v", i+1, err)
+			}
+		}
+		if user, err = store.GetUserById(userId); err != nil || !user.EmailVerified {
+			t.Fatalf("GetUserById after VerifyEmail = %+v, %v; want a verified email", user, err)
+		}
+
+		newEmail := username + "@example.org"
+		user, err = store.UpdateProfile(userId, 1, model.ProfileUpdate{Email: &newEmail})
+		if err != nil {
+			t.Fatalf("UpdateProfile: %v", err)
+		}
+		if user.EmailVerified {
+			t.Errorf("EmailVerified after an email change = true; want false")
+		}
+		if err := store.VerifyEmail(userId, email); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("VerifyEmail of the previous email error = %v; want ErrConflict", err)
+		}
+		if err := store.VerifyEmail(-1, email); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("VerifyEmail of a missing user error = %v; want sql.ErrNoRows", err)
+		}
+
+		verified := uniqueName("heidi")
+		user = model.NewUser(verified, "secret", "hashed-secret", verified+"@example.com", "", "")
+		user.EmailVerified = true
+		if err := store.AddUser(*user, "user"); err != nil {
+			t.Fatalf("AddUser: %v", err)
+		}
+		if user, err = store.GetUserByUserName(verified); err != nil || !user.EmailVerified {
+			t.Errorf("GetUserByUserName of a user added verified = %+v, %v; want a verified email", user, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := newStore(t)
+		username := uniqueName("dave")
+		userId := addUser(t, store, username)
+		createSession(t, store, userId, "hash")
+
+		if err := store.DeleteUser(userId); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := store.GetUserByUserName(username); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUserByUserName after delete error = %v; want sql.ErrNoRows", err)
+		}
+		roles, err := store.GetUserRolesByUserId(userId)
+		if err != nil || len(roles) != 0 {
+			t.Errorf("GetUserRolesByUserId after delete = %v, %v; want no roles", roles, err)
+		}
+		if _, err := store.ListSessions(userId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ListSessions after delete error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("SoftDelete", func(t *testing.T) {
+		store := newStore(t)
+		username := uniqueName("judy")
+		userId := addUser(t, store, username)
+		createSession(t, store, userId, "hash")
+		actorId, err := store.GetUserIdByUserName("admin")
+		if err != nil {
+			t.Fatalf("GetUserIdByUserName(admin): %v", err)
+		}
+
+		if err := store.SoftDeleteUser(userId, actorId); err != nil {
+			t.Fatalf("SoftDeleteUser: %v", err)
+		}
+		if err := store.SoftDeleteUser(userId, actorId); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("SoftDeleteUser of a deleted user error = %v; want sql.ErrNoRows", err)
+		}
+		if _, err := store.GetUserByUserName(username); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUserByUserName after soft delete error = %v; want sql.ErrNoRows", err)
+		}
+		if _, err := store.GetUserById(userId); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUserById after soft delete error = %v; want sql.ErrNoRows", err)
+		}
+		if _, err := store.GetUserByEmail(username + "@example.com"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUserByEmail after soft delete error = %v; want sql.ErrNoRows", err)
+		}
+		if err := store.UpdatePassword(userId, "new-hash"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("UpdatePassword after soft delete error = %v; want sql.ErrNoRows", err)
+		}
+		if exists, err := store.UserExists(username, uniqueName("nobody")+"@example.com"); err != nil || !exists {
+			t.Errorf("UserExists after soft delete = %v, %v; want the username still in use", exists, err)
+		}
+		if sessions, err := store.ListSessions(userId); err != nil || len(sessions) != 0 {
+			t.Errorf("ListSessions after soft delete = %v, %v; want no sessions", sessions, err)
+		}
+		if users, err := store.ListUsers(model.UserQuery{Search: username}); err != nil || len(users) != 0 {
+			t.Errorf("ListUsers after soft delete = %+v, %v; want none", users, err)
+		}
+		users, err := store.ListUsers(model.UserQuery{Search: username, Status: utils.UserStatusDeleted})
+		if err != nil || len(users) != 1 || users[0].DeletedAt == nil || len(users[0].Roles) != 1 {
+			t.Errorf("ListUsers of the deleted users = %+v, %v; want the user with its deletion time and role",
+				users, err)
+		}
+		if purged, err := store.PurgeDeletedUsers(time.Now().Add(-time.Minute)); err != nil || purged != 0 {
+			t.Errorf("PurgeDeletedUsers before the deletion = %d, %v; want 0", purged, err)
+		}
+
+		user, err := store.RestoreUser(userId, actorId)
+		if err != nil {
+			t.Fatalf("RestoreUser: %v", err)
+		}
+		if !user.Active() || !user.DeletedAt.IsZero() || user.Version != 3 {
+			t.Errorf("RestoreUser = %+v; want an active user at version 3", user)
+		}
+		if _, err := store.RestoreUser(userId, actorId); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("RestoreUser of an active user error = %v; want ErrConflict", err)
+		}
+		if _, err := store.RestoreUser(-1, actorId); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("RestoreUser of a missing user error = %v; want sql.ErrNoRows", err)
+		}
+		if roles, err := store.GetUserRolesByUserId(userId); err != nil || len(roles) != 1 || roles[0] != "user" {
+			t.Errorf("GetUserRolesByUserId after restore = %v, %v; want [user]", roles, err)
+		}
+		changes, err := store.ListUserChanges(userId)
+		if err != nil || len(changes) != 2 || changes[0].NewValue != utils.UserStatusDeleted ||
+			changes[1].NewValue != utils.UserStatusActive || changes[1].ActorID != actorId {
+			t.Errorf("ListUserChanges after restore = %+v, %v; want the deletion and the restoration", changes, err)
+		}
+
+		if err := store.SoftDeleteUser(userId, userId); err != nil {
+			t.Fatalf("SoftDeleteUser: %v", err)
+		}
+		if purged, err := store.PurgeDeletedUsers(time.Now().Add(time.Minute)); err != nil || purged < 1 {
+			t.Errorf("PurgeDeletedUsers after the deletion = %d, %v; want at least 1", purged, err)
+		}
+		if exists, err := store.UserExists(username, uniqueName("nobody")+"@example.com"); err != nil || exists {
+			t.Errorf("UserExists after purge = %v, %v; want false", exists, err)
+		}
+		if _, err := store.RestoreUser(userId, actorId); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("RestoreUser of a purged user error = %v; want sql.ErrNoRows", err)
+		}
+	})
+
+	t.Run("ListUsers", func(t *testing.T) {
+		store := newStore(t)
+		prefix := uniqueName("list")
+		aliceId := addUser(t, store, prefix+"_alice")
+		bobId := addUser(t, store, prefix+"_bob")
+		carol := model.NewUser(prefix+"_carol", "secret", "hashed-secret", prefix+"_carol@example.com", "Spain", "")
+		if err := store.AddUser(*carol, "admin"); err != nil {
+			t.Fatalf("AddUser(%s): %v", carol.Username, err)
+		}
+		carolId, err := store.GetUserIdByUserName(carol.Username)
+		if err != nil {
+			t.Fatalf("GetUserIdByUserName(%s): %v", carol.Username, err)
+		}
+
+		// ids lists the IDs of the users returned by ListUsers for a query
+		ids := func(query model.UserQuery) []int {
+			t.Helper()
+			users, err := store.ListUsers(query)
+			if err != nil {
+				t.Fatalf("ListUsers(%+v): %v", query, err)
+			}
+			list := []int{}
+			for _, user := range users {
+				list = append(list, user.ID)
+			}
+			return list
+		}
+		equal := func(got []int, want ...int) bool {
+			return fmt.Sprint(got) == fmt.Sprint(want)
+		}
+
+		users, err := store.ListUsers(model.UserQuery{Search: strings.ToUpper(prefix), Sort: "username"})
+		if err != nil || len(users) != 3 || users[0].ID != aliceId || users[1].ID != bobId || users[2].ID != carolId {
+			t.Fatalf("ListUsers by username = %+v, %v; want alice, bob and carol", users, err)
+		}
+		if users[0].Username != prefix+"_alice" || users[0].Country != "Portugal" ||
+			len(users[0].Roles) != 1 || users[0].Roles[0] != "user" || users[2].Roles[0] != "admin" {
+			t.Errorf("ListUsers returned %+v; want the profiles and roles of the users", users)
+		}
+
+		page := ids(model.UserQuery{Search: prefix, Sort: "username", Descending: true, Limit: 2})
+		if !equal(page, carolId, bobId) {
+			t.Errorf("first page by username descending = %v; want %v", page, []int{carolId, bobId})
+		}
+		after := &model.UserCursor{Value: prefix + "_bob", ID: bobId}
+		if page := ids(model.UserQuery{Search: prefix, Sort: "username", Descending: true, After: after, Limit: 2}); !equal(page, aliceId) {
+			t.Errorf("second page by username descending = %v; want %v", page, []int{aliceId})
+		}
+		if page := ids(model.UserQuery{Search: prefix, Sort: "id", After: &model.UserCursor{ID: aliceId}}); !equal(page, bobId, carolId) {
+			t.Errorf("page by ID after alice = %v; want %v", page, []int{bobId, carolId})
+		}
+		if page := ids(model.UserQuery{Search: prefix, Sort: "email", Descending: true}); !equal(page, carolId, bobId, aliceId) {
+			t.Errorf("ListUsers by email descending = %v; want %v", page, []int{carolId, bobId, aliceId})
+		}
+
+		if page := ids(model.UserQuery{Search: prefix, Role: "admin"}); !equal(page, carolId) {
+			t.Errorf("ListUsers with role admin = %v; want %v", page, []int{carolId})
+		}
+		if page := ids(model.UserQuery{Search: prefix, Country: "spain"}); !equal(page, carolId) {
+			t.Errorf("ListUsers in spain = %v; want %v", page, []int{carolId})
+		}
+		if page := ids(model.UserQuery{Search: prefix, CreatedFrom: time.Now().Add(-time.Hour)}); len(page) != 3 {
+			t.Errorf("ListUsers created in the last hour = %v; want the 3 users", page)
+		}
+		if page := ids(model.UserQuery{Search: prefix, CreatedTo: time.Now().Add(-time.Hour)}); len(page) != 0 {
+			t.Errorf("ListUsers created over an hour ago = %v; want none", page)
+		}
+		if page := ids(model.UserQuery{Search: prefix + "%"}); len(page) != 0 {
+			t.Errorf("ListUsers with a wildcard = %v; want none", page)
+		}
+
+		if err := store.DeleteUser(bobId); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if page := ids(model.UserQuery{Search: prefix}); !equal(page, aliceId, carolId) {
+			t.Errorf("ListUsers after delete = %v; want %v", page, []int{aliceId, carolId})
+		}
+	})
+
+	t.Run("UpdateUser", func(t *testing.T) {
+		store := newStore(t)
+		username := uniqueName("ivan")
+		userId := addUser(t, store, username)
+		actorId, err := store.GetUserIdByUserName("admin")
+		if err != nil {
+			t.Fatalf("GetUserIdByUserName(admin): %v", err)
+		}
+
+		user, err := store.GetUserById(userId)
+		if err != nil || user.Status != "active" || user.PasswordResetRequired {
+			t.Fatalf("GetUserById = %+v, %v; want an active user without required password reset", user, err)
+		}
+
+		email, status, roles := username+"@example.org", "disabled", []string{"user", "admin", "admin"}
+		updated, err := store.UpdateUser(userId, 1, model.AdminUserUpdate{
+			ProfileUpdate:      model.ProfileUpdate{Email: &email},
+			Roles:              &roles,
+			Status:             &status,
+			ForcePasswordReset: true,
+		}, actorId)
+		if err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+		if updated.Email != email || updated.Status != "disabled" || !updated.PasswordResetRequired ||
+			updated.Active() || updated.Version != 2 || updated.Phone != user.Phone {
+			t.Errorf("UpdateUser = %+v; want the new email, disabled, a required password reset and version 2", updated)
+		}
+		if got, err := store.GetUserRolesByUserId(userId); err != nil || fmt.Sprint(got) != "[admin user]" {
+			t.Errorf("GetUserRolesByUserId after UpdateUser = %v, %v; want [admin user]", got, err)
+		}
+
+		changes, err := store.ListUserChanges(userId)
+		if err != nil {
+			t.Fatalf("ListUserChanges: %v", err)
+		}
+		recorded := make(map[string]model.UserChange)
+		for _, change := range changes {
+			if change.UserID != userId || change.ActorID != actorId || change.ChangedAt.IsZero() {
+				t.Errorf("ListUserChanges returned %+v; want a change of user %d by %d", change, userId, actorId)
+			}
+			recorded[change.Field] = change
+		}
+		if len(changes) != 4 ||
+			recorded["email"].OldValue != username+"@example.com" || recorded["email"].NewValue != email ||
+			recorded["roles"].OldValue != "user" || recorded["roles"].NewValue != "admin,user" ||
+			recorded["status"].OldValue != "active" || recorded["status"].NewValue != "disabled" ||
+			recorded["password_reset_required"].NewValue != "true" {
+			t.Errorf("ListUserChanges = %+v; want the changes of email, roles, status and password reset", changes)
+		}
+
+		if err := store.UpdatePassword(userId, "new-hashed-secret"); err != nil {
+			t.Fatalf("UpdatePassword: %v", err)
+		}
+		if user, err = store.GetUserById(userId); err != nil || user.PasswordResetRequired {
+			t.Errorf("GetUserById after UpdatePassword = %+v, %v; want no required password reset", user, err)
+		}
+
+		unknown := []string{"user", "no-such-role"}
+		if _, err := store.UpdateUser(userId, 2, model.AdminUserUpdate{Roles: &unknown}, actorId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UpdateUser with an unknown role error = %v; want ErrNotFound", err)
+		}
+		if got, err := store.GetUserRolesByUserId(userId); err != nil || fmt.Sprint(got) != "[admin user]" {
+			t.Errorf("GetUserRolesByUserId after a failed UpdateUser = %v, %v; want [admin user]", got, err)
+		}
+		if _, err := store.UpdateUser(userId, 1, model.AdminUserUpdate{Status: &status}, actorId); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("UpdateUser on an outdated version error = %v; want ErrConflict", err)
+		}
+		adminEmail := "admin@example.com"
+		if _, err := store.UpdateUser(userId, 2, model.AdminUserUpdate{ProfileUpdate: model.ProfileUpdate{Email: &adminEmail}}, actorId); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("UpdateUser with the email of another user error = %v; want ErrConflict", err)
+		}
+		if _, err := store.UpdateUser(-1, 1, model.AdminUserUpdate{Status: &status}, actorId); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("UpdateUser of a missing user error = %v; want sql.ErrNoRows", err)
+		}
+
+		none := []string{}
+		if updated, err = store.UpdateUser(userId, 2, model.AdminUserUpdate{Roles: &none}, actorId); err != nil || updated.Version != 3 {
+			t.Fatalf("UpdateUser removing the roles = %+v, %v; want version 3", updated, err)
+		}
+		if got, err := store.GetUserRolesByUserId(userId); err != nil || len(got) != 0 {
+			t.Errorf("GetUserRolesByUserId after removing the roles = %v, %v; want none", got, err)
+		}
+		if changes, err = store.ListUserChanges(userId); err != nil || len(changes) != 5 || changes[4].Field != "roles" {
+			t.Errorf("ListUserChanges after removing the roles = %+v, %v; want a fifth change of the roles", changes, err)
+		}
+
+		if err := store.DeleteUser(userId); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := store.ListUserChanges(userId); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("ListUserChanges after delete error = %v; want sql.ErrNoRows", err)
+		}
+	})
+}
+
+// TestTokenStore checks the TokenStore operations.
+func TestTokenStore(t *testing.T, newStore NewStoreFunc) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("erin"))
+
+		if _, err := store.GetSession(uniqueName("ghost")); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetSession of an unknown session error = %v; want ErrNotFound", err)
+		}
+
+		sessionId := createSession(t, store, userId, "hash-1")
+		session, err := store.GetSession(sessionId)
+		if err != nil || session.ID != sessionId || session.UserID != userId || session.TokenHash != "hash-1" ||
+			session.Revoked || session.DeviceLabel != "laptop" || session.UserAgent != "storetest" ||
+			session.IP != "192.0.2.1" || session.CreatedAt.IsZero() || session.LastUsedAt.IsZero() {
+			t.Errorf("GetSession = %+v, %v; want the created session", session, err)
+		}
+
+		err = store.CreateSession(model.Session{ID: sessionId, UserID: userId, ExpiresAt: time.Now().Add(time.Hour)})
+		if !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("CreateSession with a used ID error = %v; want ErrConflict", err)
+		}
+	})
+
+	t.Run("UnknownUser", func(t *testing.T) {
+		store := newStore(t)
+		err := store.CreateSession(model.Session{ID: uniqueName("session"), UserID: -42, ExpiresAt: time.Now().Add(time.Hour)})
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("CreateSession for an unknown user error = %v; want ErrNotFound", err)
+		}
+		if _, err := store.ListSessions(-42); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ListSessions for an unknown user error = %v; want ErrNotFound", err)
+		}
+		if _, err := store.RevokeUserSessions(-42, ""); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RevokeUserSessions for an unknown user error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("MultipleDevices", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("frank"))
+
+		first := createSession(t, store, userId, "hash-1")
+		second := createSession(t, store, userId, "hash-2")
+		expired := uniqueName("session")
+		err := store.CreateSession(model.Session{ID: expired, UserID: userId, ExpiresAt: time.Now().Add(-time.Minute),
+			TokenHash: "hash-3"})
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+
+		sessions, err := store.ListSessions(userId)
+		if err != nil || len(sessions) != 2 || !containsSession(sessions, first) || !containsSession(sessions, second) {
+			t.Fatalf("ListSessions = %+v, %v; want the two active sessions", sessions, err)
+		}
+
+		if err := store.RevokeSession(first); err != nil {
+			t.Fatalf("RevokeSession: %v", err)
+		}
+		if err := store.RevokeSession(first); err != nil {
+			t.Errorf("second RevokeSession: %v", err)
+		}
+		if session, err := store.GetSession(first); err != nil || !session.Revoked {
+			t.Errorf("GetSession after revocation = %+v, %v; want revoked", session, err)
+		}
+		if sessions, err := store.ListSessions(userId); err != nil || len(sessions) != 1 || sessions[0].ID != second {
+			t.Errorf("ListSessions after revocation = %+v, %v; want [%s]", sessions, err, second)
+		}
+		if err := store.RevokeSession(uniqueName("ghost")); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RevokeSession of an unknown session error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("RevokeAllButOne", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("grace"))
+		otherUserId := addUser(t, store, uniqueName("grace"))
+
+		current := createSession(t, store, userId, "hash-1")
+		createSession(t, store, userId, "hash-2")
+		createSession(t, store, userId, "hash-3")
+		other := createSession(t, store, otherUserId, "hash-4")
+
+		if revoked, err := store.RevokeUserSessions(userId, current); err != nil || revoked != 2 {
+			t.Errorf("RevokeUserSessions = %d, %v; want 2", revoked, err)
+		}
+		if sessions, err := store.ListSessions(userId); err != nil || len(sessions) != 1 || sessions[0].ID != current {
+			t.Errorf("ListSessions = %+v, %v; want [%s]", sessions, err, current)
+		}
+		if session, err := store.GetSession(other); err != nil || session.Revoked {
+			t.Errorf("session of another user = %+v, %v; want it untouched", session, err)
+		}
+
+		if revoked, err := store.RevokeUserSessions(userId, ""); err != nil || revoked != 1 {
+			t.Errorf("RevokeUserSessions of every session = %d, %v; want 1", revoked, err)
+		}
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("heidi"))
+		first, second, third := uniqueName("hash-1"), uniqueName("hash-2"), uniqueName("hash-3")
+		sessionId := createSession(t, store, userId, first)
+		created, err := store.GetSession(sessionId)
+		if err != nil {
+			t.Fatalf("GetSession: %v", err)
+		}
+		if rotated, err := store.IsRotatedRefreshToken(sessionId, first); err != nil || rotated {
+			t.Errorf("IsRotatedRefreshToken of the current token = %v, %v; want false", rotated, err)
+		}
+
+		expiresAt := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+		if err := store.RotateRefreshToken(sessionId, first, second, expiresAt); err != nil {
+			t.Fatalf("RotateRefreshToken: %v", err)
+		}
+		session, err := store.GetSession(sessionId)
+		if err != nil || session.TokenHash != second || !session.ExpiresAt.Equal(expiresAt) ||
+			session.LastUsedAt.Before(created.LastUsedAt) {
+			t.Errorf("GetSession after rotation = %+v, %v; want token %s expiring at %v", session, err, second, expiresAt)
+		}
+		if rotated, err := store.IsRotatedRefreshToken(sessionId, first); err != nil || !rotated {
+			t.Errorf("IsRotatedRefreshToken of the rotated token = %v, %v; want true", rotated, err)
+		}
+		if rotated, err := store.IsRotatedRefreshToken(uniqueName("ghost"), first); err != nil || rotated {
+			t.Errorf("IsRotatedRefreshToken for another session = %v, %v; want false", rotated, err)
+		}
+		if rotated, err := store.IsRotatedRefreshToken(sessionId, second); err != nil || rotated {
+			t.Errorf("IsRotatedRefreshToken of the current token = %v, %v; want false", rotated, err)
+		}
+		if err := store.RotateRefreshToken(sessionId, first, third, expiresAt); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("RotateRefreshToken with a rotated token error = %v; want ErrConflict", err)
+		}
+		if err := store.RotateRefreshToken(uniqueName("ghost"), second, third, expiresAt); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RotateRefreshToken of an unknown session error = %v; want ErrNotFound", err)
+		}
+
+		if err := store.RevokeSession(sessionId); err != nil {
+			t.Fatalf("RevokeSession: %v", err)
+		}
+		if err := store.RotateRefreshToken(sessionId, second, third, expiresAt); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RotateRefreshToken of a revoked session error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("ConcurrentRotation", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("ivan"))
+		initial := uniqueName("hash-0")
+		sessionId := createSession(t, store, userId, initial)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		succeeded := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				err := store.RotateRefreshToken(sessionId, initial, fmt.Sprintf("%s-%d", initial, i+1), time.Now().Add(time.Hour))
+				if err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if succeeded != 1 {
+			t.Errorf("%d concurrent rotations of the same token succeeded; want 1", succeeded)
+		}
+	})
+}
+
+// containsSession reports whether the sessions contain the session with the given ID.
+func containsSession(sessions []model.Session, sessionId string) bool {
+	for _, session := range sessions {
+		if session.ID == sessionId {
+			return true
+		}
+	}
+	return false
+}
+
+// TestDenylistStore checks the DenylistStore operations.
+func TestDenylistStore(t *testing.T, newStore NewStoreFunc) {
+	record := func(t *testing.T, store repository.Store, sessionId string, userId int, expiresAt time.Time) string {
+		t.Helper()
+		tokenId := uniqueName("jti")
+		err := store.RecordAccessToken(model.AccessToken{ID: tokenId, SessionID: sessionId, UserID: userId,
+			ExpiresAt: expiresAt})
+		if err != nil {
+			t.Fatalf("RecordAccessToken: %v", err)
+		}
+		return tokenId
+	}
+	denied := func(t *testing.T, store repository.Store, tokenId string) bool {
+		t.Helper()
+		denied, err := store.IsAccessTokenDenied(tokenId)
+		if err != nil {
+			t.Fatalf("IsAccessTokenDenied: %v", err)
+		}
+		return denied
+	}
+
+	t.Run("DenySessionAndUser", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("judy"))
+		first, second := uniqueName("session"), uniqueName("session")
+		current := record(t, store, first, userId, time.Now().Add(time.Hour))
+		other := record(t, store, second, userId, time.Now().Add(time.Hour))
+		expired := record(t, store, first, userId, time.Now().Add(-time.Minute))
+
+		if denied(t, store, current) || denied(t, store, other) || denied(t, store, uniqueName("ghost")) {
+			t.Errorf("IsAccessTokenDenied before any denial = true; want false")
+		}
+		err := store.RecordAccessToken(model.AccessToken{ID: current, SessionID: first, UserID: userId,
+			ExpiresAt: time.Now().Add(time.Hour)})
+		if !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("RecordAccessToken of a recorded jti error = %v; want ErrConflict", err)
+		}
+
+		if count, err := store.DenySessionAccessTokens(first); err != nil || count != 1 {
+			t.Errorf("DenySessionAccessTokens = %d, %v; want 1 (the expired token is not counted)", count, err)
+		}
+		if !denied(t, store, current) || denied(t, store, other) || denied(t, store, expired) {
+			t.Errorf("IsAccessTokenDenied after the session denial; want only %s denied", current)
+		}
+		if count, err := store.DenySessionAccessTokens(first); err != nil || count != 0 {
+			t.Errorf("DenySessionAccessTokens again = %d, %v; want 0", count, err)
+		}
+
+		if count, err := store.DenyUserAccessTokens(userId); err != nil || count != 1 {
+			t.Errorf("DenyUserAccessTokens = %d, %v; want 1", count, err)
+		}
+		if !denied(t, store, other) {
+			t.Errorf("IsAccessTokenDenied(%s) after the user denial = false; want true", other)
+		}
+	})
+
+	t.Run("DeletedUser", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("mallory"))
+		tokenId := record(t, store, uniqueName("session"), userId, time.Now().Add(time.Hour))
+
+		if _, err := store.DenyUserAccessTokens(userId); err != nil {
+			t.Fatalf("DenyUserAccessTokens: %v", err)
+		}
+		if err := store.DeleteUser(userId); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if !denied(t, store, tokenId) {
+			t.Errorf("IsAccessTokenDenied after the user was deleted = false; want true")
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		store := newStore(t)
+		sessionId := uniqueName("session")
+		expired := record(t, store, sessionId, 1, time.Now().Add(-time.Minute))
+		valid := record(t, store, sessionId, 1, time.Now().Add(time.Hour))
+		if _, err := store.DenySessionAccessTokens(sessionId); err != nil {
+			t.Fatalf("DenySessionAccessTokens: %v", err)
+		}
+
+		if count, err := store.PurgeExpiredAccessTokens(); err != nil || count < 1 {
+			t.Errorf("PurgeExpiredAccessTokens = %d, %v; want at least 1", count, err)
+		}
+		if !denied(t, store, valid) {
+			t.Errorf("IsAccessTokenDenied(%s) after the purge = false; want true", valid)
+		}
+		// The purged jti is not recorded anymore
+		err := store.RecordAccessToken(model.AccessToken{ID: expired, SessionID: sessionId, UserID: 1,
+			ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Errorf("RecordAccessToken of a purged jti error = %v; want nil", err)
+		}
+	})
+}
+
+// TestPasswordResetStore checks the PasswordResetStore operations.
+func TestPasswordResetStore(t *testing.T, newStore NewStoreFunc) {
+	create := func(t *testing.T, store repository.Store, userId int, expiresAt time.Time) string {
+		t.Helper()
+		tokenHash := uniqueName("reset")
+		err := store.CreatePasswordResetToken(model.PasswordResetToken{TokenHash: tokenHash, UserID: userId,
+			ExpiresAt: expiresAt})
+		if err != nil {
+			t.Fatalf("CreatePasswordResetToken: %v", err)
+		}
+		return tokenHash
+	}
+
+	t.Run("SingleUse", func(t *testing.T) {
+		store := newStore(t)
+		username := uniqueName("grace")
+		userId := addUser(t, store, username)
+		first := create(t, store, userId, time.Now().Add(time.Hour))
+		second := create(t, store, userId, time.Now().Add(time.Hour))
+
+		user, err := store.GetUserByEmail(username + "@example.com")
+		if err != nil || user.ID != userId {
+			t.Fatalf("GetUserByEmail = %v, %v; want user %d", user, err, userId)
+		}
+
+		token, err := store.ConsumePasswordResetToken(first)
+		if err != nil {
+			t.Fatalf("ConsumePasswordResetToken: %v", err)
+		}
+		if token.UserID != userId {
+			t.Errorf("ConsumePasswordResetToken user = %d; want %d", token.UserID, userId)
+		}
+		if _, err := store.ConsumePasswordResetToken(first); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumePasswordResetToken of a used token error = %v; want ErrNotFound", err)
+		}
+		if _, err := store.ConsumePasswordResetToken(second); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumePasswordResetToken of another token of the user error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("heidi"))
+		expired := create(t, store, userId, time.Now().Add(-time.Minute))
+		valid := create(t, store, userId, time.Now().Add(time.Hour))
+
+		if _, err := store.ConsumePasswordResetToken(expired); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumePasswordResetToken of an expired token error = %v; want ErrNotFound", err)
+		}
+		purged, err := store.PurgeExpiredPasswordResetTokens()
+		if err != nil || purged < 1 {
+			t.Errorf("PurgeExpiredPasswordResetTokens = %d, %v; want at least 1", purged, err)
+		}
+		if _, err := store.ConsumePasswordResetToken(valid); err != nil {
+			t.Errorf("ConsumePasswordResetToken after purge: %v", err)
+		}
+	})
+
+	t.Run("UnknownAndDeletedUser", func(t *testing.T) {
+		store := newStore(t)
+		err := store.CreatePasswordResetToken(model.PasswordResetToken{TokenHash: uniqueName("reset"), UserID: -1,
+			ExpiresAt: time.Now().Add(time.Hour)})
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("CreatePasswordResetToken for a missing user error = %v; want ErrNotFound", err)
+		}
+
+		userId := addUser(t, store, uniqueName("ivan"))
+		tokenHash := create(t, store, userId, time.Now().Add(time.Hour))
+		if err := store.DeleteUser(userId); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := store.ConsumePasswordResetToken(tokenHash); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumePasswordResetToken of a deleted user error = %v; want ErrNotFound", err)
+		}
+		if _, err := store.GetUserByEmail(uniqueName("nobody") + "@example.com"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUserByEmail of a missing email error = %v; want sql.ErrNoRows", err)
+		}
+	})
+}
+
+// TestLockoutStore checks the LockoutStore operations.
+func TestLockoutStore(t *testing.T, newStore NewStoreFunc) {
+	t.Run("User", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("ivan"))
+		longAgo := time.Now().Add(-time.Hour)
+
+		for want := 1; want <= 3; want++ {
+			failures, err := store.RecordUserLoginFailure(userId, longAgo)
+			if err != nil || failures != want {
+				t.Fatalf("RecordUserLoginFailure = %d, %v; want %d", failures, err, want)
+			}
+		}
+		if failures, err := store.RecordUserLoginFailure(userId, time.Now().Add(time.Hour)); err != nil || failures != 1 {
+			t.Errorf("RecordUserLoginFailure after the window = %d, %v; want the counter to restart at 1", failures, err)
+		}
+
+		until := time.Now().Add(time.Hour).Truncate(time.Second)
+		if err := store.LockUser(userId, until); err != nil {
+			t.Fatalf("LockUser: %v", err)
+		}
+		user, err := store.GetUserById(userId)
+		if err != nil || !user.Locked(time.Now()) || !user.LockedUntil.Equal(until) || user.FailedLogins != 1 {
+			t.Fatalf("GetUserById after LockUser = %+v, %v; want locked until %v with 1 failure", user, err, until)
+		}
+		lockouts, err := store.ListLockouts()
+		if err != nil || !containsLockout(lockouts, userId, "") {
+			t.Errorf("ListLockouts = %v, %v; want user %d", lockouts, err, userId)
+		}
+
+		if err := store.UnlockUser(userId); err != nil {
+			t.Fatalf("UnlockUser: %v", err)
+		}
+		if user, err = store.GetUserById(userId); err != nil || user.Locked(time.Now()) || user.FailedLogins != 0 {
+			t.Errorf("GetUserById after UnlockUser = %+v, %v; want unlocked with no failures", user, err)
+		}
+		if lockouts, err = store.ListLockouts(); err != nil || containsLockout(lockouts, userId, "") {
+			t.Errorf("ListLockouts after UnlockUser = %v, %v; want no user %d", lockouts, err, userId)
+		}
+
+		if _, err := store.RecordUserLoginFailure(-1, longAgo); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RecordUserLoginFailure of a missing user error = %v; want ErrNotFound", err)
+		}
+		if err := store.LockUser(-1, until); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("LockUser of a missing user error = %v; want ErrNotFound", err)
+		}
+		if err := store.UnlockUser(-1); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UnlockUser of a missing user error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("IP", func(t *testing.T) {
+		store := newStore(t)
+		ip := fmt.Sprintf("198.51.100.%d", time.Now().UnixNano()%250+1)
+		longAgo := time.Now().Add(-time.Hour)
+		_ = store.UnlockIP(ip)
+
+		if until, err := store.IPLockedUntil(ip); err != nil || !until.IsZero() {
+			t.Fatalf("IPLockedUntil of an unknown address = %v, %v; want the zero time", until, err)
+		}
+		if err := store.LockIP(ip, time.Now().Add(time.Hour)); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("LockIP of an unknown address error = %v; want ErrNotFound", err)
+		}
+		for want := 1; want <= 2; want++ {
+			failures, err := store.RecordIPLoginFailure(ip, longAgo)
+			if err != nil || failures != want {
+				t.Fatalf("RecordIPLoginFailure = %d, %v; want %d", failures, err, want)
+			}
+		}
+		if failures, err := store.RecordIPLoginFailure(ip, time.Now().Add(time.Hour)); err != nil || failures != 1 {
+			t.Errorf("RecordIPLoginFailure after the window = %d, %v; want the counter to restart at 1", failures, err)
+		}
+
+		until := time.Now().Add(time.Hour).Truncate(time.Second)
+		if err := store.LockIP(ip, until); err != nil {
+			t.Fatalf("LockIP: %v", err)
+		}
+		if locked, err := store.IPLockedUntil(ip); err != nil || !locked.Equal(until) {
+			t.Errorf("IPLockedUntil = %v, %v; want %v", locked, err, until)
+		}
+		lockouts, err := store.ListLockouts()
+		if err != nil || !containsLockout(lockouts, 0, ip) {
+			t.Errorf("ListLockouts = %v, %v; want address %s", lockouts, err, ip)
+		}
+		if purged, err := store.PurgeLoginFailures(time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("PurgeLoginFailures: %v", err)
+		} else if locked, _ := store.IPLockedUntil(ip); !locked.Equal(until) {
+			t.Errorf("IPLockedUntil after PurgeLoginFailures (%d purged) = %v; want the lock kept", purged, locked)
+		}
+
+		if err := store.UnlockIP(ip); err != nil {
+			t.Fatalf("UnlockIP: %v", err)
+		}
+		if locked, err := store.IPLockedUntil(ip); err != nil || !locked.IsZero() {
+			t.Errorf("IPLockedUntil after UnlockIP = %v, %v; want the zero time", locked, err)
+		}
+		if err := store.UnlockIP(ip); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UnlockIP of an unknown address error = %v; want ErrNotFound", err)
+		}
+
+		if _, err := store.RecordIPLoginFailure(ip, longAgo); err != nil {
+			t.Fatalf("RecordIPLoginFailure: %v", err)
+		}
+		if _, err := store.PurgeLoginFailures(time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("PurgeLoginFailures: %v", err)
+		}
+		if err := store.UnlockIP(ip); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UnlockIP after PurgeLoginFailures error = %v; want ErrNotFound", err)
+		}
+	})
+}
+
+// containsLockout reports whether a lockout of the given user, or of the given address, is in the list.
+func containsLockout(lockouts []model.LoginLockout, userId int, ip string) bool {
+	for _, lockout := range lockouts {
+		if (userId != 0 && lockout.UserID == userId) || (ip != "" && lockout.IP == ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestTOTPStore checks the TOTPStore operations.
+func TestTOTPStore(t *testing.T, newStore NewStoreFunc) {
+	t.Run("Enrollment", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("judy"))
+
+		if _, err := store.GetTOTP(userId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetTOTP without enrollment error = %v; want ErrNotFound", err)
+		}
+		if err := store.SaveTOTP(model.TOTP{UserID: userId, EncryptedSecret: "first"}); err != nil {
+			t.Fatalf("SaveTOTP: %v", err)
+		}
+		if err := store.SaveTOTP(model.TOTP{UserID: userId, EncryptedSecret: "second"}); err != nil {
+			t.Fatalf("SaveTOTP replacing a pending enrollment: %v", err)
+		}
+		totp, err := store.GetTOTP(userId)
+		if err != nil || totp.EncryptedSecret != "second" || totp.Enabled || totp.CreatedAt.IsZero() {
+			t.Fatalf("GetTOTP = %+v, %v; want the second pending enrollment", totp, err)
+		}
+		if err := store.UseTOTPStep(userId, 10); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UseTOTPStep of a pending enrollment error = %v; want ErrNotFound", err)
+		}
+
+		if err := store.EnableTOTP(userId, 10, []string{"code-a", "code-b"}); err != nil {
+			t.Fatalf("EnableTOTP: %v", err)
+		}
+		totp, err = store.GetTOTP(userId)
+		if err != nil || !totp.Enabled || totp.ConfirmedAt.IsZero() || totp.LastUsedStep != 10 ||
+			totp.RecoveryCodesLeft != 2 {
+			t.Fatalf("GetTOTP after EnableTOTP = %+v, %v; want enabled at step 10 with 2 recovery codes", totp, err)
+		}
+		if err := store.EnableTOTP(userId, 11, nil); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("EnableTOTP of an enabled TOTP error = %v; want ErrConflict", err)
+		}
+		if err := store.SaveTOTP(model.TOTP{UserID: userId, EncryptedSecret: "third"}); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("SaveTOTP over an enabled TOTP error = %v; want ErrConflict", err)
+		}
+
+		if err := store.DeleteTOTP(userId); err != nil {
+			t.Fatalf("DeleteTOTP: %v", err)
+		}
+		if err := store.DeleteTOTP(userId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("DeleteTOTP twice error = %v; want ErrNotFound", err)
+		}
+		if err := store.UseRecoveryCode(userId, "code-a"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UseRecoveryCode after DeleteTOTP error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("SingleUseCodes", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("kate"))
+		if err := store.SaveTOTP(model.TOTP{UserID: userId, EncryptedSecret: "secret"}); err != nil {
+			t.Fatalf("SaveTOTP: %v", err)
+		}
+		if err := store.EnableTOTP(userId, 10, []string{"code-a", "code-b"}); err != nil {
+			t.Fatalf("EnableTOTP: %v", err)
+		}
+
+		if err := store.UseTOTPStep(userId, 10); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("UseTOTPStep of the confirmation step error = %v; want ErrConflict", err)
+		}
+		if err := store.UseTOTPStep(userId, 12); err != nil {
+			t.Errorf("UseTOTPStep: %v", err)
+		}
+		if err := store.UseTOTPStep(userId, 11); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("UseTOTPStep of an earlier step error = %v; want ErrConflict", err)
+		}
+
+		if err := store.UseRecoveryCode(userId, "code-a"); err != nil {
+			t.Errorf("UseRecoveryCode: %v", err)
+		}
+		if err := store.UseRecoveryCode(userId, "code-a"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UseRecoveryCode twice error = %v; want ErrNotFound", err)
+		}
+		if totp, err := store.GetTOTP(userId); err != nil || totp.RecoveryCodesLeft != 1 {
+			t.Errorf("GetTOTP = %+v, %v; want 1 recovery code left", totp, err)
+		}
+	})
+
+	t.Run("UnknownAndDeletedUser", func(t *testing.T) {
+		store := newStore(t)
+		if err := store.SaveTOTP(model.TOTP{UserID: -1, EncryptedSecret: "secret"}); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("SaveTOTP for a missing user error = %v; want ErrNotFound", err)
+		}
+		if err := store.EnableTOTP(-1, 10, nil); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("EnableTOTP for a missing user error = %v; want ErrNotFound", err)
+		}
+
+		userId := addUser(t, store, uniqueName("leo"))
+		if err := store.SaveTOTP(model.TOTP{UserID: userId, EncryptedSecret: "secret"}); err != nil {
+			t.Fatalf("SaveTOTP: %v", err)
+		}
+		if err := store.EnableTOTP(userId, 10, []string{"code-a"}); err != nil {
+			t.Fatalf("EnableTOTP: %v", err)
+		}
+		if err := store.DeleteUser(userId); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := store.GetTOTP(userId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetTOTP of a deleted user error = %v; want ErrNotFound", err)
+		}
+		if err := store.UseRecoveryCode(userId, "code-a"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UseRecoveryCode of a deleted user error = %v; want ErrNotFound", err)
+		}
+	})
+}
+
+// TestWebAuthnStore checks the WebAuthnStore operations.
+func TestWebAuthnStore(t *testing.T, newStore NewStoreFunc) {
+	t.Run("Credentials", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("mallory"))
+		otherId := addUser(t, store, uniqueName("nina"))
+		credentialId := uniqueName("credential")
+
+		err := store.AddWebAuthnCredential(model.WebAuthnCredential{ID: credentialId, UserID: userId, Name: "laptop",
+			PublicKey: []byte{1, 2, 3}, SignCount: 4})
+		if err != nil {
+			t.Fatalf("AddWebAuthnCredential: %v", err)
+		}
+		err = store.AddWebAuthnCredential(model.WebAuthnCredential{ID: credentialId, UserID: otherId,
+			PublicKey: []byte{1}})
+		if !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("AddWebAuthnCredential with a registered ID error = %v; want ErrConflict", err)
+		}
+
+		credential, err := store.GetWebAuthnCredential(credentialId)
+		if err != nil || credential.UserID != userId || credential.Name != "laptop" ||
+			string(credential.PublicKey) != string([]byte{1, 2, 3}) || credential.SignCount != 4 ||
+			credential.CreatedAt.IsZero() || !credential.LastUsedAt.IsZero() {
+			t.Fatalf("GetWebAuthnCredential = %+v, %v; want the registered credential", credential, err)
+		}
+		if err := store.UpdateWebAuthnSignCount(credentialId, 9); err != nil {
+			t.Fatalf("UpdateWebAuthnSignCount: %v", err)
+		}
+		credentials, err := store.ListWebAuthnCredentials(userId)
+		if err != nil || len(credentials) != 1 || credentials[0].SignCount != 9 || credentials[0].LastUsedAt.IsZero() {
+			t.Errorf("ListWebAuthnCredentials = %+v, %v; want the used credential", credentials, err)
+		}
+		if credentials, err := store.ListWebAuthnCredentials(otherId); err != nil || len(credentials) != 0 {
+			t.Errorf("ListWebAuthnCredentials of another user = %+v, %v; want none", credentials, err)
+		}
+
+		if err := store.DeleteWebAuthnCredential(otherId, credentialId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("DeleteWebAuthnCredential by another user error = %v; want ErrNotFound", err)
+		}
+		if err := store.DeleteWebAuthnCredential(userId, credentialId); err != nil {
+			t.Fatalf("DeleteWebAuthnCredential: %v", err)
+		}
+		if _, err := store.GetWebAuthnCredential(credentialId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetWebAuthnCredential after delete error = %v; want ErrNotFound", err)
+		}
+		if err := store.UpdateWebAuthnSignCount(credentialId, 10); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UpdateWebAuthnSignCount after delete error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Challenges", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("oscar"))
+		registration := uniqueName("challenge")
+		login := uniqueName("challenge")
+		expired := uniqueName("challenge")
+		for _, challenge := range []model.WebAuthnChallenge{
+			{ChallengeHash: registration, UserID: userId, Ceremony: "registration", ExpiresAt: time.Now().Add(time.Hour)},
+			{ChallengeHash: login, Ceremony: "login", ExpiresAt: time.Now().Add(time.Hour)},
+			{ChallengeHash: expired, Ceremony: "login", ExpiresAt: time.Now().Add(-time.Minute)},
+		} {
+			if err := store.CreateWebAuthnChallenge(challenge); err != nil {
+				t.Fatalf("CreateWebAuthnChallenge: %v", err)
+			}
+		}
+
+		if _, err := store.ConsumeWebAuthnChallenge(registration, "login"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeWebAuthnChallenge for another ceremony error = %v; want ErrNotFound", err)
+		}
+		challenge, err := store.ConsumeWebAuthnChallenge(registration, "registration")
+		if err != nil || challenge.UserID != userId {
+			t.Fatalf("ConsumeWebAuthnChallenge = %+v, %v; want the challenge of user %d", challenge, err, userId)
+		}
+		if _, err := store.ConsumeWebAuthnChallenge(registration, "registration"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeWebAuthnChallenge twice error = %v; want ErrNotFound", err)
+		}
+		if challenge, err := store.ConsumeWebAuthnChallenge(login, "login"); err != nil || challenge.UserID != 0 {
+			t.Errorf("ConsumeWebAuthnChallenge of a login challenge = %+v, %v; want no user", challenge, err)
+		}
+		if _, err := store.ConsumeWebAuthnChallenge(expired, "login"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeWebAuthnChallenge of an expired challenge error = %v; want ErrNotFound", err)
+		}
+		if purged, err := store.PurgeExpiredWebAuthnChallenges(); err != nil || purged < 1 {
+			t.Errorf("PurgeExpiredWebAuthnChallenges = %d, %v; want at least 1", purged, err)
+		}
+	})
+
+	t.Run("UnknownAndDeletedUser", func(t *testing.T) {
+		store := newStore(t)
+		err := store.AddWebAuthnCredential(model.WebAuthnCredential{ID: uniqueName("credential"), UserID: -1,
+			PublicKey: []byte{1}})
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("AddWebAuthnCredential for a missing user error = %v; want ErrNotFound", err)
+		}
+		err = store.CreateWebAuthnChallenge(model.WebAuthnChallenge{ChallengeHash: uniqueName("challenge"), UserID: -1,
+			Ceremony: "registration", ExpiresAt: time.Now().Add(time.Hour)})
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("CreateWebAuthnChallenge for a missing user error = %v; want ErrNotFound", err)
+		}
+		if _, err := store.ListWebAuthnCredentials(-1); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ListWebAuthnCredentials of a missing user error = %v; want ErrNotFound", err)
+		}
+
+		userId := addUser(t, store, uniqueName("peggy"))
+		credentialId := uniqueName("credential")
+		challengeHash := uniqueName("challenge")
+		if err := store.AddWebAuthnCredential(model.WebAuthnCredential{ID: credentialId, UserID: userId,
+			PublicKey: []byte{1}}); err != nil {
+			t.Fatalf("AddWebAuthnCredential: %v", err)
+		}
+		if err := store.CreateWebAuthnChallenge(model.WebAuthnChallenge{ChallengeHash: challengeHash, UserID: userId,
+			Ceremony: "registration", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatalf("CreateWebAuthnChallenge: %v", err)
+		}
+		if err := store.DeleteUser(userId); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := store.GetWebAuthnCredential(credentialId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetWebAuthnCredential of a deleted user error = %v; want ErrNotFound", err)
+		}
+		if _, err := store.ConsumeWebAuthnChallenge(challengeHash, "registration"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeWebAuthnChallenge of a deleted user error = %v; want ErrNotFound", err)
+		}
+	})
+}
+
+// TestOAuthStore checks the OAuthStore operations.
+func TestOAuthStore(t *testing.T, newStore NewStoreFunc) {
+	t.Run("Clients", func(t *testing.T) {
+		store := newStore(t)
+		confidential := model.OAuthClient{ID: uniqueName("client"), SecretHash: "secret-hash", Name: "Billing",
+			RedirectURIs: []string{"https://billing.example.com/callback", "http://localhost:3000/callback"},
+			Scopes:       []string{"openid", "email"},
+			GrantTypes:   []string{"authorization_code", "refresh_token", "client_credentials"}}
+		public := model.OAuthClient{ID: uniqueName("client"), Name: "Mobile",
+			RedirectURIs: []string{"com.example.app:/callback"}, GrantTypes: []string{"authorization_code"}}
+		for _, client := range []model.OAuthClient{confidential, public} {
+			if err := store.CreateOAuthClient(client); err != nil {
+				t.Fatalf("CreateOAuthClient(%s): %v", client.Name, err)
+			}
+		}
+		if err := store.CreateOAuthClient(confidential); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("CreateOAuthClient with a registered ID error = %v; want ErrConflict", err)
+		}
+
+		client, err := store.GetOAuthClient(confidential.ID)
+		if err != nil || client.Name != "Billing" || !client.Confidential() || len(client.RedirectURIs) != 2 ||
+			!client.AllowsRedirectURI("http://localhost:3000/callback") || !client.AllowsScope("openid email") ||
+			client.AllowsScope("openid profile") || !client.AllowsGrant("client_credentials") || client.CreatedAt.IsZero() {
+			t.Errorf("GetOAuthClient = %+v, %v; want the confidential client", client, err)
+		}
+		client, err = store.GetOAuthClient(public.ID)
+		if err != nil || client.Confidential() || len(client.Scopes) != 0 || client.AllowsGrant("refresh_token") {
+			t.Errorf("GetOAuthClient = %+v, %v; want the public client", client, err)
+		}
+
+		clients, err := store.ListOAuthClients()
+		if err != nil || !containsOAuthClient(clients, confidential.ID) || !containsOAuthClient(clients, public.ID) {
+			t.Errorf("ListOAuthClients = %+v, %v; want both clients", clients, err)
+		}
+
+		if err := store.DeleteOAuthClient(public.ID); err != nil {
+			t.Fatalf("DeleteOAuthClient: %v", err)
+		}
+		if _, err := store.GetOAuthClient(public.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetOAuthClient after delete error = %v; want ErrNotFound", err)
+		}
+		if err := store.DeleteOAuthClient(public.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("DeleteOAuthClient twice error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("AuthorizationCodes", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("quentin"))
+		clientId := uniqueName("client")
+		if err := store.CreateOAuthClient(model.OAuthClient{ID: clientId, Name: "Billing",
+			RedirectURIs: []string{"https://billing.example.com/callback"},
+			GrantTypes:   []string{"authorization_code"}}); err != nil {
+			t.Fatalf("CreateOAuthClient: %v", err)
+		}
+
+		valid := uniqueName("code")
+		expired := uniqueName("code")
+		for _, code := range []model.OAuthAuthorizationCode{
+			{CodeHash: valid, ClientID: clientId, UserID: userId, RedirectURI: "https://billing.example.com/callback",
+				Scope: "openid", CodeChallenge: "challenge", Nonce: "nonce", ExpiresAt: time.Now().Add(time.Minute)},
+			{CodeHash: expired, ClientID: clientId, UserID: userId, RedirectURI: "https://billing.example.com/callback",
+				CodeChallenge: "challenge", ExpiresAt: time.Now().Add(-time.Minute)},
+		} {
+			if err := store.CreateOAuthAuthorizationCode(code); err != nil {
+				t.Fatalf("CreateOAuthAuthorizationCode: %v", err)
+			}
+		}
+		err := store.CreateOAuthAuthorizationCode(model.OAuthAuthorizationCode{CodeHash: valid, ClientID: clientId,
+			UserID: userId, RedirectURI: "https://billing.example.com/callback", ExpiresAt: time.Now().Add(time.Minute)})
+		if !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("CreateOAuthAuthorizationCode with a stored hash error = %v; want ErrConflict", err)
+		}
+		for _, code := range []model.OAuthAuthorizationCode{
+			{CodeHash: uniqueName("code"), ClientID: uniqueName("client"), UserID: userId, ExpiresAt: time.Now().Add(time.Minute)},
+			{CodeHash: uniqueName("code"), ClientID: clientId, UserID: -1, ExpiresAt: time.Now().Add(time.Minute)},
+		} {
+			if err := store.CreateOAuthAuthorizationCode(code); !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("CreateOAuthAuthorizationCode(%+v) error = %v; want ErrNotFound", code, err)
+			}
+		}
+
+		code, err := store.ConsumeOAuthAuthorizationCode(valid)
+		if err != nil || code.ClientID != clientId || code.UserID != userId || code.Scope != "openid" ||
+			code.CodeChallenge != "challenge" || code.Nonce != "nonce" ||
+			code.RedirectURI != "https://billing.example.com/callback" {
+			t.Fatalf("ConsumeOAuthAuthorizationCode = %+v, %v; want the stored code", code, err)
+		}
+		if _, err := store.ConsumeOAuthAuthorizationCode(valid); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeOAuthAuthorizationCode twice error = %v; want ErrNotFound", err)
+		}
+		if _, err := store.ConsumeOAuthAuthorizationCode(expired); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeOAuthAuthorizationCode of an expired code error = %v; want ErrNotFound", err)
+		}
+		if purged, err := store.PurgeExpiredOAuthAuthorizationCodes(); err != nil || purged < 1 {
+			t.Errorf("PurgeExpiredOAuthAuthorizationCodes = %d, %v; want at least 1", purged, err)
+		}
+	})
+
+	t.Run("ClientSessions", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("rupert"))
+		clientId := uniqueName("client")
+		if err := store.CreateOAuthClient(model.OAuthClient{ID: clientId, Name: "Billing",
+			RedirectURIs: []string{"https://billing.example.com/callback"},
+			GrantTypes:   []string{"authorization_code", "refresh_token"}}); err != nil {
+			t.Fatalf("CreateOAuthClient: %v", err)
+		}
+		codeHash := uniqueName("code")
+		if err := store.CreateOAuthAuthorizationCode(model.OAuthAuthorizationCode{CodeHash: codeHash,
+			ClientID: clientId, UserID: userId, RedirectURI: "https://billing.example.com/callback",
+			CodeChallenge: "challenge", ExpiresAt: time.Now().Add(time.Minute)}); err != nil {
+			t.Fatalf("CreateOAuthAuthorizationCode: %v", err)
+		}
+
+		sessionId := uniqueName("session")
+		err := store.CreateSession(model.Session{ID: sessionId, UserID: userId, ClientID: clientId,
+			Scope: "openid email", ExpiresAt: time.Now().Add(time.Hour), TokenHash: uniqueName("hash")})
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		otherSessionId := createSession(t, store, userId, uniqueName("hash"))
+
+		session, err := store.GetSession(sessionId)
+		if err != nil || session.ClientID != clientId || session.Scope != "openid email" {
+			t.Errorf("GetSession = %+v, %v; want the client and scope of the session", session, err)
+		}
+		if session, err := store.GetSession(otherSessionId); err != nil || session.ClientID != "" || session.Scope != "" {
+			t.Errorf("GetSession = %+v, %v; want a session without client", session, err)
+		}
+
+		if err := store.DeleteOAuthClient(clientId); err != nil {
+			t.Fatalf("DeleteOAuthClient: %v", err)
+		}
+		if session, err := store.GetSession(sessionId); err != nil || !session.Revoked {
+			t.Errorf("GetSession after DeleteOAuthClient = %+v, %v; want a revoked session", session, err)
+		}
+		if session, err := store.GetSession(otherSessionId); err != nil || session.Revoked {
+			t.Errorf("GetSession of another session after DeleteOAuthClient = %+v, %v; want it active", session, err)
+		}
+		if _, err := store.ConsumeOAuthAuthorizationCode(codeHash); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeOAuthAuthorizationCode after DeleteOAuthClient error = %v; want ErrNotFound", err)
+		}
+	})
+}
+
+// containsOAuthClient reports whether clients contains a client with the given ID.
+func containsOAuthClient(clients []model.OAuthClient, clientId string) bool {
+	for _, client := range clients {
+		if client.ID == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+// TestFederationStore checks the FederationStore operations.
+func TestFederationStore(t *testing.T, newStore NewStoreFunc) {
+	t.Run("Identities", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("quentin"))
+		otherId := addUser(t, store, uniqueName("rupert"))
+		subject := uniqueName("subject")
+
+		err := store.CreateExternalIdentity(model.ExternalIdentity{Provider: "corp", Subject: subject, UserID: userId,
+			Email: "quentin@corp.example.com"})
+		if err != nil {
+			t.Fatalf("CreateExternalIdentity: %v", err)
+		}
+		err = store.CreateExternalIdentity(model.ExternalIdentity{Provider: "corp", Subject: subject, UserID: otherId})
+		if !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("CreateExternalIdentity with a linked subject error = %v; want ErrConflict", err)
+		}
+		err = store.CreateExternalIdentity(model.ExternalIdentity{Provider: "corp", Subject: uniqueName("subject"),
+			UserID: userId})
+		if !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("CreateExternalIdentity with a second identity at the provider error = %v; want ErrConflict", err)
+		}
+		if err := store.CreateExternalIdentity(model.ExternalIdentity{Provider: "acme", Subject: subject,
+			UserID: userId}); err != nil {
+			t.Fatalf("CreateExternalIdentity at another provider: %v", err)
+		}
+
+		identity, err := store.GetExternalIdentity("corp", subject)
+		if err != nil || identity.UserID != userId || identity.Email != "quentin@corp.example.com" ||
+			identity.CreatedAt.IsZero() {
+			t.Fatalf("GetExternalIdentity = %+v, %v; want the linked identity", identity, err)
+		}
+		identities, err := store.ListExternalIdentities(userId)
+		if err != nil || len(identities) != 2 || identities[0].Provider != "acme" || identities[1].Provider != "corp" {
+			t.Errorf("ListExternalIdentities = %+v, %v; want the acme and corp identities", identities, err)
+		}
+		if identities, err := store.ListExternalIdentities(otherId); err != nil || len(identities) != 0 {
+			t.Errorf("ListExternalIdentities of another user = %+v, %v; want none", identities, err)
+		}
+
+		if err := store.DeleteExternalIdentity(otherId, "corp"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("DeleteExternalIdentity by another user error = %v; want ErrNotFound", err)
+		}
+		if err := store.DeleteExternalIdentity(userId, "corp"); err != nil {
+			t.Fatalf("DeleteExternalIdentity: %v", err)
+		}
+		if _, err := store.GetExternalIdentity("corp", subject); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetExternalIdentity after delete error = %v; want ErrNotFound", err)
+		}
+		if err := store.CreateExternalIdentity(model.ExternalIdentity{Provider: "corp", Subject: subject,
+			UserID: otherId}); err != nil {
+			t.Errorf("CreateExternalIdentity of an unlinked subject: %v", err)
+		}
+	})
+
+	t.Run("LoginStates", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("sybil"))
+		link := uniqueName("state")
+		login := uniqueName("state")
+		expired := uniqueName("state")
+		for _, state := range []model.FederatedLoginState{
+			{StateHash: link, Provider: "corp", UserID: userId, Nonce: "nonce", CodeVerifier: "verifier",
+				ExpiresAt: time.Now().Add(time.Hour)},
+			{StateHash: login, Provider: "corp", Nonce: "nonce", CodeVerifier: "verifier",
+				ExpiresAt: time.Now().Add(time.Hour)},
+			{StateHash: expired, Provider: "corp", Nonce: "nonce", CodeVerifier: "verifier",
+				ExpiresAt: time.Now().Add(-time.Minute)},
+		} {
+			if err := store.CreateFederatedLoginState(state); err != nil {
+				t.Fatalf("CreateFederatedLoginState: %v", err)
+			}
+		}
+		err := store.CreateFederatedLoginState(model.FederatedLoginState{StateHash: login, Provider: "corp",
+			ExpiresAt: time.Now().Add(time.Hour)})
+		if !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("CreateFederatedLoginState with a stored hash error = %v; want ErrConflict", err)
+		}
+
+		if _, err := store.ConsumeFederatedLoginState(link, "acme"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeFederatedLoginState for another provider error = %v; want ErrNotFound", err)
+		}
+		state, err := store.ConsumeFederatedLoginState(link, "corp")
+		if err != nil || state.UserID != userId || state.Nonce != "nonce" || state.CodeVerifier != "verifier" {
+			t.Fatalf("ConsumeFederatedLoginState = %+v, %v; want the state of user %d", state, err, userId)
+		}
+		if _, err := store.ConsumeFederatedLoginState(link, "corp"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeFederatedLoginState twice error = %v; want ErrNotFound", err)
+		}
+		if state, err := store.ConsumeFederatedLoginState(login, "corp"); err != nil || state.UserID != 0 {
+			t.Errorf("ConsumeFederatedLoginState of a login state = %+v, %v; want no user", state, err)
+		}
+		if _, err := store.ConsumeFederatedLoginState(expired, "corp"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeFederatedLoginState of an expired state error = %v; want ErrNotFound", err)
+		}
+		if purged, err := store.PurgeExpiredFederatedLoginStates(); err != nil || purged < 1 {
+			t.Errorf("PurgeExpiredFederatedLoginStates = %d, %v; want at least 1", purged, err)
+		}
+	})
+
+	t.Run("UnknownAndDeletedUser", func(t *testing.T) {
+		store := newStore(t)
+		err := store.CreateExternalIdentity(model.ExternalIdentity{Provider: "corp", Subject: uniqueName("subject"),
+			UserID: -1})
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("CreateExternalIdentity for a missing user error = %v; want ErrNotFound", err)
+		}
+		err = store.CreateFederatedLoginState(model.FederatedLoginState{StateHash: uniqueName("state"),
+			Provider: "corp", UserID: -1, ExpiresAt: time.Now().Add(time.Hour)})
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("CreateFederatedLoginState for a missing user error = %v; want ErrNotFound", err)
+		}
+		if _, err := store.ListExternalIdentities(-1); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ListExternalIdentities of a missing user error = %v; want ErrNotFound", err)
+		}
+
+		userId := addUser(t, store, uniqueName("trent"))
+		subject := uniqueName("subject")
+		stateHash := uniqueName("state")
+		if err := store.CreateExternalIdentity(model.ExternalIdentity{Provider: "corp", Subject: subject,
+			UserID: userId}); err != nil {
+			t.Fatalf("CreateExternalIdentity: %v", err)
+		}
+		if err := store.CreateFederatedLoginState(model.FederatedLoginState{StateHash: stateHash, Provider: "corp",
+			UserID: userId, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatalf("CreateFederatedLoginState: %v", err)
+		}
+		if err := store.DeleteUser(userId); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := store.GetExternalIdentity("corp", subject); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetExternalIdentity of a deleted user error = %v; want ErrNotFound", err)
+		}
+		if _, err := store.ConsumeFederatedLoginState(stateHash, "corp"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ConsumeFederatedLoginState of a deleted user error = %v; want ErrNotFound", err)
+		}
+	})
+}
+
+// TestAPIKeyStore checks the APIKeyStore operations.
+func TestAPIKeyStore(t *testing.T, newStore NewStoreFunc) {
+	t.Run("Keys", func(t *testing.T) {
+		store := newStore(t)
+		userId := addUser(t, store, uniqueName("quentin"))
+		otherId := addUser(t, store, uniqueName("rupert"))
+		keyId := uniqueName("key")
+		expiringId := uniqueName("key")
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+		err := store.CreateAPIKey(model.APIKey{ID: keyId, UserID: userId, Name: "deploy", KeyHash: "key-hash",
+			Permissions: []string{"user:read", "role:read"}})
+		if err != nil {
+			t.Fatalf("CreateAPIKey: %v", err)
+		}
+		err = store.CreateAPIKey(model.APIKey{ID: keyId, UserID: otherId, KeyHash: "other-hash",
+			Permissions: []string{"user:read"}})
+		if !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("CreateAPIKey with a stored ID error = %v; want ErrConflict", err)
+		}
+		err = store.CreateAPIKey(model.APIKey{ID: expiringId, UserID: userId, Name: "backup", KeyHash: "backup-hash",
+			Permissions: []string{"user:read"}, ExpiresAt: &expiresAt})
+		if err != nil {
+			t.Fatalf("CreateAPIKey with an expiration: %v", err)
+		}
+
+		key, err := store.GetAPIKey(keyId)
+		if err != nil || key.UserID != userId || key.Name != "deploy" || key.KeyHash != "key-hash" ||
+			len(key.Permissions) != 2 || !containsString(key.Permissions, "role:read") || key.ExpiresAt != nil ||
+			key.CreatedAt.IsZero() || key.LastUsedAt != nil {
+			t.Fatalf("GetAPIKey = %+v, %v; want the created key", key, err)
+		}
+		key, err = store.GetAPIKey(expiringId)
+		if err != nil || key.ExpiresAt == nil || !key.ExpiresAt.Equal(expiresAt) {
+			t.Fatalf("GetAPIKey = %+v, %v; want the key expiring at %v", key, err, expiresAt)
+		}
+
+		usedAt := time.Now().Truncate(time.Second)
+		if err := store.TouchAPIKey(keyId, usedAt); err != nil {
+			t.Fatalf("TouchAPIKey: %v", err)
+		}
+		keys, err := store.ListAPIKeys(userId)
+		if err != nil || len(keys) != 2 || keys[0].ID != keyId || keys[0].LastUsedAt == nil ||
+			!keys[0].LastUsedAt.Equal(usedAt) || keys[1].ID != expiringId || keys[1].LastUsedAt != nil {
+			t.Errorf("ListAPIKeys = %+v, %v; want the used key, then the expiring one", keys, err)
+		}
+		if keys, err := store.ListAPIKeys(otherId); err != nil || len(keys) != 0 {
+			t.Errorf("ListAPIKeys of another user = %+v, %v; want none", keys, err)
+		}
+
+		if err := store.DeleteAPIKey(otherId, keyId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("DeleteAPIKey by another user error = %v; want ErrNotFound", err)
+		}
+		if err := store.DeleteAPIKey(userId, keyId); err != nil {
+			t.Fatalf("DeleteAPIKey: %v", err)
+		}
+		if _, err := store.GetAPIKey(keyId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetAPIKey after delete error = %v; want ErrNotFound", err)
+		}
+		if err := store.TouchAPIKey(keyId, time.Now()); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("TouchAPIKey after delete error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("UnknownAndDeletedUser", func(t *testing.T) {
+		store := newStore(t)
+		err := store.CreateAPIKey(model.APIKey{ID: uniqueName("key"), UserID: -1, KeyHash: "key-hash",
+			Permissions: []string{"user:read"}})
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("CreateAPIKey for a missing user error = %v; want ErrNotFound", err)
+		}
+		if _, err := store.ListAPIKeys(-1); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ListAPIKeys of a missing user error = %v; want ErrNotFound", err)
+		}
+
+		userId := addUser(t, store, uniqueName("sybil"))
+		keyId := uniqueName("key")
+		if err := store.CreateAPIKey(model.APIKey{ID: keyId, UserID: userId, KeyHash: "key-hash",
+			Permissions: []string{"user:read"}}); err != nil {
+			t.Fatalf("CreateAPIKey: %v", err)
+		}
+		if err := store.DeleteUser(userId); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := store.GetAPIKey(keyId); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetAPIKey of a deleted user error = %v; want ErrNotFound", err)
+		}
+	})
+}
+
+// TestRBACStore checks the RBACStore operations.
+func TestRBACStore(t *testing.T, newStore NewStoreFunc) {
+	t.Run("Roles", func(t *testing.T) {
+		store := newStore(t)
+		username := uniqueName("grace")
+		userId := addUser(t, store, username)
+
+		roles, err := store.GetUserRolesByUserId(userId)
+		if err != nil || len(roles) != 1 || roles[0] != "user" {
+			t.Fatalf("GetUserRolesByUserId = %v, %v; want [user]", roles, err)
+		}
+
+		if err := store.SetUserRole(username, "admin"); err != nil {
+			t.Fatalf("SetUserRole: %v", err)
+		}
+		roles, err = store.GetUserRolesByUserId(userId)
+		if err != nil || len(roles) != 2 || roles[0] != "admin" || roles[1] != "user" {
+			t.Errorf("GetUserRolesByUserId = %v, %v; want [admin user]", roles, err)
+		}
+
+		if err := store.SetUserRole(username, "admin"); err == nil {
+			t.Errorf("assigning the same role twice succeeded")
+		}
+		if err := store.SetUserRole(username, "no-such-role"); err == nil {
+			t.Errorf("SetUserRole with an unknown role succeeded")
+		}
+	})
+
+	t.Run("RoleLifecycle", func(t *testing.T) {
+		store := newStore(t)
+		name := uniqueName("editor")
+		role, err := store.CreateRole(name)
+		if err != nil || role.Name != name {
+			t.Fatalf("CreateRole = %+v, %v", role, err)
+		}
+		if _, err := store.CreateRole(name); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("CreateRole duplicate error = %v; want ErrConflict", err)
+		}
+
+		roles, err := store.ListRoles()
+		if err != nil || !containsRole(roles, role.ID, name) {
+			t.Errorf("ListRoles = %v, %v; want it to contain %q", roles, err, name)
+		}
+
+		renamed := uniqueName("writer")
+		if err := store.RenameRole(role.ID, renamed); err != nil {
+			t.Errorf("RenameRole: %v", err)
+		}
+		if err := store.RenameRole(role.ID, "admin"); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("RenameRole to an existing name error = %v; want ErrConflict", err)
+		}
+		if err := store.RenameRole(-1, uniqueName("x")); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RenameRole of a missing role error = %v; want ErrNotFound", err)
+		}
+
+		userId := addUser(t, store, uniqueName("heidi"))
+		if err := store.AssignRole(userId, role.ID); err != nil {
+			t.Fatalf("AssignRole: %v", err)
+		}
+		if err := store.AssignRole(userId, role.ID); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("AssignRole twice error = %v; want ErrConflict", err)
+		}
+		if err := store.DeleteRole(role.ID); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("DeleteRole of an assigned role error = %v; want ErrConflict", err)
+		}
+		if err := store.UnassignRole(userId, role.ID); err != nil {
+			t.Errorf("UnassignRole: %v", err)
+		}
+		if err := store.UnassignRole(userId, role.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UnassignRole twice error = %v; want ErrNotFound", err)
+		}
+		if err := store.AssignRole(-1, role.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("AssignRole to a missing user error = %v; want ErrNotFound", err)
+		}
+
+		if err := store.DeleteRole(role.ID); err != nil {
+			t.Errorf("DeleteRole: %v", err)
+		}
+		if err := store.DeleteRole(role.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("DeleteRole twice error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("PermissionLifecycle", func(t *testing.T) {
+		store := newStore(t)
+		role, err := store.CreateRole(uniqueName("auditor"))
+		if err != nil {
+			t.Fatalf("CreateRole: %v", err)
+		}
+		name := uniqueName("report:read")
+		permission, err := store.CreatePermission(name)
+		if err != nil || permission.Name != name {
+			t.Fatalf("CreatePermission = %+v, %v", permission, err)
+		}
+		if _, err := store.CreatePermission(name); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("CreatePermission duplicate error = %v; want ErrConflict", err)
+		}
+
+		permissions, err := store.ListPermissions()
+		if err != nil || !containsPermission(permissions, permission.ID, name) {
+			t.Errorf("ListPermissions = %v, %v; want it to contain %q", permissions, err, name)
+		}
+
+		renamed := uniqueName("report:view")
+		if err := store.RenamePermission(permission.ID, renamed); err != nil {
+			t.Errorf("RenamePermission: %v", err)
+		}
+		if err := store.RenamePermission(-1, uniqueName("x")); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RenamePermission of a missing permission error = %v; want ErrNotFound", err)
+		}
+
+		if err := store.GrantPermission(role.ID, permission.ID); err != nil {
+			t.Fatalf("GrantPermission: %v", err)
+		}
+		if err := store.GrantPermission(role.ID, permission.ID); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("GrantPermission twice error = %v; want ErrConflict", err)
+		}
+		if err := store.GrantPermission(role.ID, -1); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GrantPermission of a missing permission error = %v; want ErrNotFound", err)
+		}
+
+		granted, err := store.GetPermissionsByRoleId(role.ID)
+		if err != nil || len(granted) != 1 || granted[0] != renamed {
+			t.Errorf("GetPermissionsByRoleId = %v, %v; want [%s]", granted, err, renamed)
+		}
+
+		userId := addUser(t, store, uniqueName("ivan"))
+		if err := store.AssignRole(userId, role.ID); err != nil {
+			t.Fatalf("AssignRole: %v", err)
+		}
+		effective, err := store.GetUserPermissionsByUserId(userId)
+		if err != nil || !containsString(effective, renamed) {
+			t.Errorf("GetUserPermissionsByUserId = %v, %v; want it to contain %q", effective, err, renamed)
+		}
+		if err := store.UnassignRole(userId, role.ID); err != nil {
+			t.Fatalf("UnassignRole: %v", err)
+		}
+		effective, err = store.GetUserPermissionsByUserId(userId)
+		if err != nil || containsString(effective, renamed) {
+			t.Errorf("GetUserPermissionsByUserId after unassign = %v, %v; want no %q", effective, err, renamed)
+		}
+		if _, err := store.GetPermissionsByRoleId(-1); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetPermissionsByRoleId of a missing role error = %v; want ErrNotFound", err)
+		}
+
+		if err := store.DeletePermission(permission.ID); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("DeletePermission of a granted permission error = %v; want ErrConflict", err)
+		}
+		if err := store.RevokePermission(role.ID, permission.ID); err != nil {
+			t.Errorf("RevokePermission: %v", err)
+		}
+		if err := store.RevokePermission(role.ID, permission.ID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RevokePermission twice error = %v; want ErrNotFound", err)
+		}
+		if err := store.DeletePermission(permission.ID); err != nil {
+			t.Errorf("DeletePermission: %v", err)
+		}
+		if err := store.DeleteRole(role.ID); err != nil {
+			t.Errorf("DeleteRole: %v", err)
+		}
+	})
+}
+
+// containsString reports whether values contains value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsRole reports whether roles contains a role with the given ID and name.
+func containsRole(roles []model.Role, id int, name string) bool {
+	for _, role := range roles {
+		if role.ID == id && role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// containsPermission reports whether permissions contains a permission with the given ID and name.
+func containsPermission(permissions []model.Permission, id int, name string) bool {
+	for _, permission := range permissions {
+		if permission.ID == id && permission.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TestConcurrency checks that the store can be used from several goroutines at once.
+func TestConcurrency(t *testing.T, newStore NewStoreFunc) {
+	store := newStore(t)
+	prefix := uniqueName("worker")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			username := fmt.Sprintf("%s_%d", prefix, i)
+			user := model.NewUser(username, "secret", "hashed", username+"@example.com", "", "")
+			if err := store.AddUser(*user, "user"); err != nil {
+				errs <- err
+				return
+			}
+			stored, err := store.GetUserByUserName(username)
+			if err != nil {
+				errs <- err
+				return
+			}
+			err = store.CreateSession(model.Session{ID: username, UserID: stored.ID, ExpiresAt: time.Now().Add(time.Hour)})
+			if err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent access: %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	// APIKeyPrefix starts every API key, so a leaked key is easy to recognise, e.g. by secret scanners.
+	APIKeyPrefix = "grak_"
+
+	// apiKeySecretLength is the number of random bytes of the secret of an API key
+	apiKeySecretLength = 32
+
+	// apiKeyIdLength is the length of the hex-encoded ID of an API key
+	apiKeyIdLength = 32
+
+	// maxAPIKeyNameLength is the maximum length of the name of an API key
+	maxAPIKeyNameLength = 255
+
+	// APIKeyTouchInterval is how often the last use of an API key is recorded, so a busy client does not update
+	// its key on every request.
+	APIKeyTouchInterval = time.Minute
+)
+
+// ErrMalformedAPIKey is returned by ParseAPIKey for a value that was not issued by NewAPIKey.
+var ErrMalformedAPIKey = errors.New("malformed API key")
+
+// NewAPIKey generates an API key. The key is APIKeyPrefix, a random public ID, a dot and 32 random bytes encoded
+// in base64url, so the key can be found by its ID without storing it. Only the hash returned with it may be
+// stored.
+//
+// Returns the key, its ID, its hash as computed by HashAPIKey, and an error, if any.
+func NewAPIKey() (string, string, string, error) {
+	id, err := newTokenId()
+	if err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, apiKeySecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	key := APIKeyPrefix + id + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return key, id, HashAPIKey(key), nil
+}
+
+// ParseAPIKey extracts the ID from an API key. It only checks the format of the key: whether the key is valid is
+// decided by comparing its hash with the stored one.
+//
+// key: The API key sent by the client.
+//
+// Returns the ID, or ErrMalformedAPIKey if the key does not have the format of NewAPIKey.
+func ParseAPIKey(key string) (string, error) {
+	rest, prefixed := strings.CutPrefix(key, APIKeyPrefix)
+	id, secret, found := strings.Cut(rest, ".")
+	if !prefixed || !found || len(id) != apiKeyIdLength {
+		return "", ErrMalformedAPIKey
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", ErrMalformedAPIKey
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(secret)
+	if err != nil || len(decoded) != apiKeySecretLength {
+		return "", ErrMalformedAPIKey
+	}
+	return id, nil
+}
+
+// HashAPIKey computes the value stored for an API key, its SHA-256. Like for the OAuth client secrets the hash is
+// not keyed: the 256 random bits of the key cannot be guessed from a leaked hash.
+//
+// key: The API key.
+//
+// Returns the hash, hex encoded.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyMatches compares the hash of an API key with the stored one in constant time.
+//
+// key: The API key sent by the client.
+// storedHash: The hash stored for the key.
+//
+// Returns true if the stored hash is not empty and is the hash of the key.
+func APIKeyMatches(key string, storedHash string) bool {
+	if storedHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(storedHash)) == 1
+}
+
+// APIKeyPermissions computes the permissions an API key grants: those it was created with that its owner still
+// holds, so revoking a permission of the owner also revokes it from the keys.
+//
+// keyPermissions: The permissions the key was created with.
+// ownerPermissions: The current permissions of the owner of the key.
+//
+// Returns the granted permissions, in the order of keyPermissions.
+func APIKeyPermissions(keyPermissions []string, ownerPermissions []string) []string {
+	held := make(map[string]bool, len(ownerPermissions))
+	for _, permission := range ownerPermissions {
+		held[permission] = true
+	}
+	granted := []string{}
+	for _, permission := range keyPermissions {
+		if held[permission] {
+			granted = append(granted, permission)
+		}
+	}
+	return granted
+}
+
+// ValidateAPIKeyRequest checks the request creating an API key: the name must be between 1 and 255 characters,
+// the key must grant at least one permission, all of them held by its owner, and an expiration must be in the
+// future.
+//
+// request: The request creating the key.
+// ownerPermissions: The current permissions of the owner of the key.
+// now: The current time.
+//
+// Returns the invalid fields, mapped to the reason they are rejected, or an empty map if the request is valid.
+func ValidateAPIKeyRequest(request model.APIKeyRequest, ownerPermissions []string, now time.Time) map[string]string {
+	fields := make(map[string]string)
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		fields["name"] = "must be between 1 and 255 characters"
+	}
+
+	if len(request.Permissions) == 0 {
+		fields["permissions"] = "must contain at least one permission"
+	} else if granted := APIKeyPermissions(request.Permissions, ownerPermissions); len(granted) != len(request.Permissions) {
+		fields["permissions"] = "must be permissions held by the owner of the key"
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(now) {
+		fields["expires_at"] = "must be in the future"
+	}
+	return fields
+}
+
+// IssueAPIKey generates an API key for a user and stores it. The request must have been checked with
+// ValidateAPIKeyRequest.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// apiKeys: The APIKeyStore in which the key is stored.
+// userId: The ID of the owner of the key.
+// request: The request creating the key.
+//
+// Returns the stored key, the key itself, to show once to the client, and an error, if any. A NotFoundError is
+// returned if the user does not exist.
+func IssueAPIKey(logger *logrus.Logger,
+	apiKeys repository.APIKeyStore,
+	userId int,
+	request model.APIKeyRequest) (*model.APIKey, string, error) {
+	key, keyId, keyHash, err := NewAPIKey()
+	if err != nil {
+		logger.WithError(err).WithField("userId", userId).Error("Error generating the API key")
+		return nil, "", err
+	}
+
+	permissions := []string{}
+	seen := make(map[string]bool)
+	for _, permission := range request.Permissions {
+		if !seen[permission] {
+			seen[permission] = true
+			permissions = append(permissions, permission)
+		}
+	}
+	err = apiKeys.CreateAPIKey(model.APIKey{
+		ID:          keyId,
+		UserID:      userId,
+		Name:        strings.TrimSpace(request.Name),
+		KeyHash:     keyHash,
+		Permissions: permissions,
+		ExpiresAt:   request.ExpiresAt,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	stored, err := apiKeys.GetAPIKey(keyId)
+	if err != nil {
+		return nil, "", err
+	}
+	return stored, key, nil
+}
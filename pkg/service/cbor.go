@@ -0,0 +1,127 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxCBORDepth is the maximum nesting of the CBOR items decoded by decodeCBOR.
+const maxCBORDepth = 16
+
+// errCBORTruncated is returned when a CBOR item is cut short.
+var errCBORTruncated = errors.New("truncated CBOR item")
+
+// decodeCBOR decodes the first CBOR item (RFC 8949) of data, as used by WebAuthn: definite-length unsigned and
+// negative integers, byte and text strings, arrays, maps and the simple values false, true and null. Integers are
+// returned as int64, byte strings as []byte, text strings as string, arrays as []interface{} and maps as
+// map[interface{}]interface{}, keyed by int64 or string. Floats, tags and indefinite lengths are not supported.
+//
+// data: The encoded item, possibly followed by other data.
+//
+// Returns the decoded item, the data following it, and an error if the item is malformed or unsupported.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+// decodeCBORItem decodes the first CBOR item of data, nested at the given depth.
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("CBOR item nested too deeply")
+	}
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major, info := data[0]>>5, data[0]&0x1f
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data[1:], nil
+		case 21:
+			return true, data[1:], nil
+		case 22:
+			return nil, data[1:], nil
+		}
+		return nil, nil, fmt.Errorf("unsupported CBOR simple value %d", info)
+	}
+
+	argument, rest, err := decodeCBORArgument(info, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0, 1:
+		if argument > 1<<63-1 {
+			return nil, nil, errors.New("CBOR integer out of range")
+		}
+		if major == 1 {
+			return -1 - int64(argument), rest, nil
+		}
+		return int64(argument), rest, nil
+	case 2, 3:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		value := rest[:argument]
+		if major == 3 {
+			return string(value), rest[argument:], nil
+		}
+		return append([]byte(nil), value...), rest[argument:], nil
+	case 4:
+		// Every item takes at least a byte, which bounds the allocation of a forged length
+		if argument > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			var item interface{}
+			if item, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case 5:
+		if argument > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		entries := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			var key, value interface{}
+			if key, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("unsupported CBOR map key")
+			}
+			if value, rest, err = decodeCBORItem(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			entries[key] = value
+		}
+		return entries, rest, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported CBOR major type %d", major)
+}
+
+// decodeCBORArgument decodes the argument of a CBOR item head, the value or length following its initial byte.
+func decodeCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	case info > 27:
+		return 0, nil, errors.New("unsupported CBOR indefinite length")
+	}
+	return 0, nil, errCBORTruncated
+}
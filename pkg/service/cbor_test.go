@@ -0,0 +1,115 @@
+package service
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want interface{}
+		rest []byte
+	}{
+		{name: "small integer", data: []byte{0x17}, want: int64(23)},
+		{name: "one byte integer", data: []byte{0x18, 0xff}, want: int64(255)},
+		{name: "two byte integer", data: []byte{0x19, 0x01, 0x00}, want: int64(256)},
+		{name: "four byte integer", data: []byte{0x1a, 0x00, 0x01, 0x00, 0x00}, want: int64(65536)},
+		{name: "eight byte integer", data: []byte{0x1b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			want: int64(1<<63 - 1)},
+		{name: "negative integer", data: []byte{0x26}, want: int64(-7)},
+		{name: "two byte negative integer", data: []byte{0x39, 0x01, 0x00}, want: int64(-257)},
+		{name: "byte string", data: []byte{0x43, 1, 2, 3}, want: []byte{1, 2, 3}},
+		{name: "text string", data: []byte{0x64, 'n', 'o', 'n', 'e'}, want: "none"},
+		{name: "array", data: []byte{0x82, 0x01, 0x20}, want: []interface{}{int64(1), int64(-1)}},
+		{name: "map", data: []byte{0xa2, 0x01, 0x02, 0x63, 'f', 'm', 't', 0xf6},
+			want: map[interface{}]interface{}{int64(1): int64(2), "fmt": nil}},
+		{name: "simple values", data: []byte{0x83, 0xf4, 0xf5, 0xf6}, want: []interface{}{false, true, nil}},
+		{name: "trailing data", data: []byte{0x01, 0xaa, 0xbb}, want: int64(1), rest: []byte{0xaa, 0xbb}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, rest, err := decodeCBOR(test.data)
+			if err != nil {
+				t.Fatalf("decodeCBOR(%x): %v", test.data, err)
+			}
+			if !reflect.DeepEqual(got, test.want) || !bytes.Equal(rest, test.rest) {
+				t.Errorf("decodeCBOR(%x) = %#v, %x; want %#v, %x", test.data, got, rest, test.want, test.rest)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRejectsMalformedItems(t *testing.T) {
+	nested := bytes.Repeat([]byte{0x81}, maxCBORDepth+2)
+	nested = append(nested, 0x01)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "truncated argument", data: []byte{0x19, 0x01}},
+		{name: "truncated eight byte argument", data: []byte{0x1b, 0x00, 0x00}},
+		{name: "integer out of range", data: []byte{0x1b, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{name: "negative integer out of range", data: []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "truncated byte string", data: []byte{0x45, 1, 2}},
+		{name: "byte string of a forged length", data: []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "truncated text string", data: []byte{0x63, 'a'}},
+		{name: "truncated array", data: []byte{0x82, 0x01}},
+		{name: "array of a forged length", data: []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{name: "truncated map", data: []byte{0xa1, 0x01}},
+		{name: "map of a forged length", data: []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{name: "byte string map key", data: []byte{0xa1, 0x41, 0x00, 0x01}},
+		{name: "array map key", data: []byte{0xa1, 0x80, 0x01}},
+		{name: "indefinite length", data: []byte{0x5f, 0x41, 0x00, 0xff}},
+		{name: "reserved argument", data: []byte{0x1c}},
+		{name: "tag", data: []byte{0xc0, 0x01}},
+		{name: "float", data: []byte{0xf9, 0x3c, 0x00}},
+		{name: "undefined", data: []byte{0xf7}},
+		{name: "nested too deeply", data: nested},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, _, err := decodeCBOR(test.data); err == nil {
+				t.Errorf("decodeCBOR(%x) = %#v, want an error", test.data, got)
+			}
+		})
+	}
+}
+
+// FuzzDecodeCBOR checks that decoding arbitrary data never panics, and that a decoded item is followed by the rest
+// of the data.
+func FuzzDecodeCBOR(f *testing.F) {
+	for _, seed := range [][]byte{
+		{0xa2, 0x01, 0x02, 0x63, 'f', 'm', 't', 0xf6},
+		{0x83, 0xf4, 0xf5, 0xf6},
+		{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		{0x5b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x40, 0x22, 0x40},
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, rest, err := decodeCBOR(data)
+		if err == nil && (len(rest) >= len(data) || !bytes.HasSuffix(data, rest)) {
+			t.Errorf("decodeCBOR(%x) rest = %x, want a suffix of the data", data, rest)
+		}
+	})
+}
+
+// FuzzParseCOSEKey checks that parsing an arbitrary credential public key never panics.
+func FuzzParseCOSEKey(f *testing.F) {
+	f.Add([]byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x40, 0x22, 0x40})
+	f.Add([]byte{0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21, 0x40})
+	f.Add([]byte{0xa4, 0x01, 0x03, 0x03, 0x39, 0x01, 0x00, 0x20, 0x40, 0x21, 0x40})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if key, _, err := parseCOSEKey(data); err == nil && key == nil {
+			t.Errorf("parseCOSEKey(%x) returned no key and no error", data)
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package service
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP returns the IP address of the client that sent the request, taken from the remote address of the
+// connection. Forwarding headers such as X-Forwarded-For are ignored, since any client can set them.
+//
+// r: The HTTP request.
+//
+// Returns the IP address, or the raw remote address if it cannot be split into host and port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
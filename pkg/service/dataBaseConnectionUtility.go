@@ -19,7 +19,8 @@ import (
 //
 // Returns a pointer to a sql.DB object and an error.
 func NewDBConnection(logger *logrus.Logger, cfg *config.Config) (*sql.DB, error) {
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
+	// parseTime scans DATETIME and TIMESTAMP columns into time.Time values
+	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
 		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
 
 	db, err := sql.Open("mysql", connectionString)
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
+	"fmt"
+	"github.com/sirupsen/logrus"
+)
+
+// NewEmailVerificationToken generates the token verifying the email address of a user: a JWT of type
+// utils.EmailVerificationToken signed with the keyring, whose email claim is the current address of the user.
+// It is valid for EMAIL_VERIFICATION_TOKEN_VALIDITY and nothing is stored for it, so it can be used as long as
+// the address does not change.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the JWT and email verification configuration.
+// keys: The Keyring holding the signing key.
+// user: The user whose email address is verified; its ID, Username and Email must be set.
+//
+// Returns the token and an error, if any.
+func NewEmailVerificationToken(logger *logrus.Logger,
+	cfg *config.Config,
+	keys *Keyring,
+	user *model.User) (string, error) {
+	claims := &Claims{
+		TokenType: utils.EmailVerificationToken,
+		Email:     user.Email,
+	}
+	return createToken(logger, cfg, keys, user, claims, cfg.EmailVerificationTokenValidity)
+}
+
+// SendEmailVerification sends a user the token verifying its email address. If EMAIL_VERIFICATION_URL is set,
+// the token is appended to it to form a link, e.g. to GET /user/verify or to a page of the client.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the JWT and email verification configuration.
+// keys: The Keyring holding the signing key.
+// notifier: The Notifier delivering the token.
+// user: The user whose email address is verified; its ID, Username and Email must be set.
+//
+// Returns an error if the token cannot be generated or delivered.
+func SendEmailVerification(logger *logrus.Logger,
+	cfg *config.Config,
+	keys *Keyring,
+	notifier Notifier,
+	user *model.User) error {
+	token, err := NewEmailVerificationToken(logger, cfg, keys, user)
+	if err != nil {
+		return err
+	}
+
+	action := "Use this token to verify it:\n\n" + token
+	if cfg.EmailVerificationURL != "" {
+		action = "Follow this link to verify it:\n\n" + cfg.EmailVerificationURL + token
+	}
+	notification := Notification{
+		To:       user.Email,
+		Username: user.Username,
+		Subject:  "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nThis email address was given for your account. %s\n\n"+
+			"The verification expires after %s. If you did not create an account, ignore this message.",
+			user.Username, action, cfg.EmailVerificationTokenValidity),
+	}
+	if err = notifier.Notify(notification); err != nil {
+		logger.WithError(err).WithField("username", user.Username).Error("Error sending the email verification")
+		return err
+	}
+
+	logger.WithField("username", user.Username).Info("Email verification sent")
+	return nil
+}
@@ -0,0 +1,490 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/utils"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// federationSecretLength is the number of random bytes of a state, a nonce and a PKCE code verifier
+	federationSecretLength = 32
+
+	// upstreamRequestTimeout bounds every request sent to an upstream identity provider
+	upstreamRequestTimeout = 10 * time.Second
+
+	// maxUpstreamResponseSize bounds the documents read from an upstream identity provider
+	maxUpstreamResponseSize = 1 << 20
+
+	// upstreamKeysRefreshInterval is the minimum time between two downloads of the keys of a provider, so tokens
+	// signed with unknown keys cannot make the API flood the provider
+	upstreamKeysRefreshInterval = time.Minute
+)
+
+// upstreamProviderNamePattern matches the name of an upstream provider, used as a path segment of the routes.
+var upstreamProviderNamePattern = regexp.MustCompile(`^[a-z0-9-]{1,64}$`)
+
+// upstreamSigningAlgorithms lists the algorithms accepted for the ID tokens of the upstream providers. HMAC is
+// excluded: the client secret is not a signing key the API should trust.
+var upstreamSigningAlgorithms = []string{
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodRS384.Alg(),
+	jwt.SigningMethodRS512.Alg(),
+	jwt.SigningMethodPS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodES384.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// ErrUpstreamLogin is returned by IdentityProvider.Exchange when the provider refuses the code or returns an
+// invalid ID token.
+var ErrUpstreamLogin = errors.New("upstream login failed")
+
+// UpstreamIdentity is the identity of a user at an upstream identity provider, read from its verified ID token.
+type UpstreamIdentity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// IdentityProvider is an upstream identity provider the users can log in with. The login is an authorization code
+// flow with PKCE: the user is redirected to AuthorizationURL, and the code the provider sends back to the callback
+// is exchanged for the identity of the user.
+type IdentityProvider interface {
+	// Name returns the name of the provider in the routes.
+	Name() string
+
+	// AuthorizationURL returns the URL the user is redirected to, carrying the state and the nonce of the login
+	// and the S256 PKCE code challenge.
+	AuthorizationURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error)
+
+	// Exchange redeems an authorization code with its PKCE code verifier and returns the identity of the user,
+	// after checking the nonce of the login. It returns an error wrapping ErrUpstreamLogin if the provider
+	// refuses the code or its response is invalid.
+	Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*UpstreamIdentity, error)
+}
+
+// IdentityProviders is the registry of the upstream identity providers, by name. Providers are registered when
+// the server starts and only looked up afterwards.
+type IdentityProviders struct {
+	providers map[string]IdentityProvider
+}
+
+// NewIdentityProviders builds the registry of the upstream providers listed in FEDERATION_PROVIDERS, all of them
+// OpenID Connect providers. Their discovery documents are fetched on first use, so the API starts even when a
+// provider is unreachable.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the federation configuration.
+//
+// Returns the registry, and an error if a provider is misconfigured.
+func NewIdentityProviders(logger *logrus.Logger, cfg *config.Config) (*IdentityProviders, error) {
+	registry := &IdentityProviders{providers: make(map[string]IdentityProvider)}
+	client := &http.Client{Timeout: upstreamRequestTimeout}
+	for _, upstream := range cfg.FederationProviders {
+		if upstream.IssuerURL == "" || upstream.ClientID == "" || upstream.RedirectURL == "" {
+			return nil, fmt.Errorf("federation provider %q needs an issuer URL, a client ID and a redirect URL",
+				upstream.Name)
+		}
+		if err := registry.Register(NewOIDCProvider(upstream, client)); err != nil {
+			return nil, err
+		}
+		logger.WithFields(logrus.Fields{
+			"provider": upstream.Name,
+			"issuer":   upstream.IssuerURL,
+		}).Info("Federation provider registered")
+	}
+	return registry, nil
+}
+
+// Register adds a provider to the registry. Its name must be 1 to 64 lowercase letters, digits or dashes, and
+// cannot be mfa, which is the path of the second step of the password logins.
+//
+// provider: The provider.
+//
+// Returns an error if the name is invalid or already registered.
+func (p *IdentityProviders) Register(provider IdentityProvider) error {
+	name := provider.Name()
+	if !upstreamProviderNamePattern.MatchString(name) || name == "mfa" {
+		return fmt.Errorf("invalid federation provider name %q", name)
+	}
+	if _, ok := p.providers[name]; ok {
+		return fmt.Errorf("federation provider %q is already registered", name)
+	}
+	p.providers[name] = provider
+	return nil
+}
+
+// Lookup returns the provider registered under a name.
+//
+// name: The name of the provider.
+//
+// Returns the provider and true, or nil and false if no such provider is registered.
+func (p *IdentityProviders) Lookup(name string) (IdentityProvider, bool) {
+	provider, ok := p.providers[name]
+	return provider, ok
+}
+
+// Names returns the names of the registered providers, sorted.
+func (p *IdentityProviders) Names() []string {
+	names := make([]string, 0, len(p.providers))
+	for name := range p.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// upstreamDiscovery holds the members of the discovery document of an upstream provider the API uses.
+type upstreamDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// upstreamIDTokenClaims are the claims read from the ID tokens of the upstream providers.
+type upstreamIDTokenClaims struct {
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// OIDCProvider is an upstream OpenID Connect provider. It is configured from its issuer URL: the endpoints come
+// from its discovery document, and its signing keys from its JWKS, downloaded again when an ID token is signed
+// with an unknown key.
+type OIDCProvider struct {
+	config config.UpstreamProvider
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *upstreamDiscovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// NewOIDCProvider creates an upstream OpenID Connect provider.
+//
+// upstream: The configuration of the provider.
+// client: The HTTP client used to reach the provider.
+//
+// Returns a pointer to the OIDCProvider.
+func NewOIDCProvider(upstream config.UpstreamProvider, client *http.Client) *OIDCProvider {
+	return &OIDCProvider{config: upstream, client: client}
+}
+
+// Name returns the name of the provider in the routes.
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// AuthorizationURL returns the URL of the authorization endpoint of the provider, requesting a code for the
+// configured scopes, redirect URL and client, with the state, the nonce and the S256 PKCE code challenge.
+//
+// ctx: The context of the request, bounding the download of the discovery document.
+// state: The state of the login, sent back to the callback.
+// nonce: The nonce the ID token must carry.
+// codeChallenge: The S256 PKCE code challenge.
+//
+// Returns the URL, or an error if the discovery document cannot be retrieved.
+func (p *OIDCProvider) AuthorizationURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", p.config.Scopes)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", PKCEMethodS256)
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint of the provider and verifies the ID token of the
+// response: its signature with the keys of the provider, its issuer, its audience, which must be the client, its
+// expiry and its nonce. A confidential client authenticates with client_secret_basic, a public client sends its
+// client_id only.
+//
+// ctx: The context of the request, bounding the requests to the provider.
+// code: The authorization code sent to the callback.
+// codeVerifier: The PKCE code verifier of the login.
+// nonce: The nonce of the login.
+//
+// Returns the identity of the user, an error wrapping ErrUpstreamLogin if the code is refused or the ID token is
+// invalid, or another error if the provider cannot be reached.
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*UpstreamIdentity, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", utils.OAuthGrantAuthorizationCode)
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		// RFC 6749, section 2.3.1: the credentials are form-encoded before the basic authentication
+		request.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.fetchJSON(request, &tokens)
+	if err != nil && status == 0 {
+		return nil, err
+	}
+	if status != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: the token endpoint of %s answered %d %s %s", ErrUpstreamLogin, p.config.Name,
+			status, tokens.Error, tokens.ErrorDescription)
+	}
+
+	claims := &upstreamIDTokenClaims{}
+	_, err = jwt.ParseWithClaims(tokens.IDToken, claims,
+		func(token *jwt.Token) (interface{}, error) { return p.verificationKey(ctx, discovery, token) },
+		jwt.WithValidMethods(upstreamSigningAlgorithms),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(30*time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID token from %s: %v", ErrUpstreamLogin, p.config.Name, err)
+	}
+	switch {
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: the ID token from %s has no subject", ErrUpstreamLogin, p.config.Name)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: the ID token from %s does not carry the nonce of the login", ErrUpstreamLogin,
+			p.config.Name)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, fmt.Errorf("%w: the ID token from %s was issued to %s", ErrUpstreamLogin, p.config.Name,
+			claims.AuthorizedParty)
+	}
+
+	return &UpstreamIdentity{
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+		Name:              claims.Name,
+	}, nil
+}
+
+// getDiscovery returns the discovery document of the provider, downloading it on first use. Its issuer must be
+// the configured issuer URL.
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*upstreamDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	location := strings.TrimSuffix(p.config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	var discovery upstreamDiscovery
+	status, err := p.fetchJSON(request, &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving the discovery document of %s: %w", p.config.Name, err)
+	} else if status != http.StatusOK {
+		return nil, fmt.Errorf("retrieving the discovery document of %s: status %d", p.config.Name, status)
+	}
+	if discovery.Issuer != p.config.IssuerURL {
+		return nil, fmt.Errorf("the discovery document of %s is for issuer %q", p.config.Name, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("the discovery document of %s lacks an endpoint", p.config.Name)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// verificationKey is the jwt.Keyfunc of the ID tokens of the provider, selecting the key by the kid header, or the
+// only key of the provider when the token has none. The keys are downloaded again, at most once per
+// upstreamKeysRefreshInterval, when the kid is unknown, so the provider can rotate them.
+func (p *OIDCProvider) verificationKey(ctx context.Context, discovery *upstreamDiscovery, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.lookupKey(kid)
+	if !ok && time.Since(p.keysFetchedAt) >= upstreamKeysRefreshInterval {
+		if err := p.fetchKeys(ctx, discovery); err != nil {
+			return nil, err
+		}
+		key, ok = p.lookupKey(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+// lookupKey returns the cached key with a kid, or the only cached key if kid is empty. The caller holds p.mu.
+func (p *OIDCProvider) lookupKey(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// fetchKeys downloads the JWKS of the provider and caches its signature keys. Keys that cannot be decoded, e.g.
+// of an unsupported type, are skipped. The caller holds p.mu.
+func (p *OIDCProvider) fetchKeys(ctx context.Context, discovery *upstreamDiscovery) error {
+	p.keysFetchedAt = time.Now()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, discovery.JWKSURI, nil)
+	if err != nil {
+		return err
+	}
+	var jwks JWKS
+	status, err := p.fetchJSON(request, &jwks)
+	if err != nil {
+		return fmt.Errorf("retrieving the keys of %s: %w", p.config.Name, err)
+	} else if status != http.StatusOK {
+		return fmt.Errorf("retrieving the keys of %s: status %d", p.config.Name, status)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+	p.keys = keys
+	return nil
+}
+
+// fetchJSON sends a request to the provider and decodes its JSON response, whatever its status.
+//
+// Returns the status of the response, 0 if it was not received, and an error if the request failed or the
+// response is not JSON.
+func (p *OIDCProvider) fetchJSON(request *http.Request, value interface{}) (int, error) {
+	response, err := p.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	err = json.NewDecoder(io.LimitReader(response.Body, maxUpstreamResponseSize)).Decode(value)
+	return response.StatusCode, err
+}
+
+// NewFederationState generates the state of a login through an upstream provider, 256 random bits.
+//
+// Returns the base64url-encoded state, sent to the provider, its hash as computed by HashFederationState, which is
+// stored, and an error, if any.
+func NewFederationState() (string, string, error) {
+	state, err := newFederationSecret()
+	if err != nil {
+		return "", "", err
+	}
+	return state, HashFederationState(state), nil
+}
+
+// HashFederationState computes the value stored for the state of a login through an upstream provider.
+//
+// state: The base64url-encoded state.
+//
+// Returns the SHA-256 of the state, hex encoded.
+func HashFederationState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewFederationNonce generates the nonce of a login through an upstream provider, 256 random bits encoded in
+// base64url, which the ID token must carry so it cannot be replayed into another login.
+//
+// Returns the nonce and an error, if any.
+func NewFederationNonce() (string, error) {
+	return newFederationSecret()
+}
+
+// NewPKCEVerifier generates a PKCE code verifier, 256 random bits encoded in base64url, with its S256 challenge.
+//
+// Returns the verifier, its challenge and an error, if any.
+func NewPKCEVerifier() (string, string, error) {
+	verifier, err := newFederationSecret()
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// newFederationSecret generates federationSecretLength random bytes encoded in base64url.
+func newFederationSecret() (string, error) {
+	random := make([]byte, federationSecretLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// FederationStateExpiry returns when the state of a login started now expires, after FEDERATION_STATE_VALIDITY.
+//
+// cfg: A pointer to the config.Config struct which contains the federation configuration.
+//
+// Returns the expiry time, and an error if FEDERATION_STATE_VALIDITY is invalid.
+func FederationStateExpiry(cfg *config.Config) (time.Time, error) {
+	validity, err := utils.ParseDuration(cfg.FederationStateValidity)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(validity), nil
+}
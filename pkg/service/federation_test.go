@@ -0,0 +1,173 @@
+package service_test
+
+import (
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/service/oidctest"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newIssuer starts a mock issuer for a confidential client, closed at the end of the test.
+func newIssuer(t *testing.T) *oidctest.Issuer {
+	t.Helper()
+	issuer, err := oidctest.New("api", "client secret")
+	if err != nil {
+		t.Fatalf("oidctest.New: %v", err)
+	}
+	t.Cleanup(issuer.Close)
+	issuer.Subject = "248289761001"
+	issuer.Email = "jane@example.com"
+	issuer.EmailVerified = true
+	issuer.PreferredUsername = "jane"
+	issuer.Name = "Jane Doe"
+	return issuer
+}
+
+// upstreamLogin runs a login through the provider, and returns the result of the exchange of the code.
+func upstreamLogin(t *testing.T, issuer *oidctest.Issuer, provider *service.OIDCProvider) (*service.UpstreamIdentity, error) {
+	t.Helper()
+	state, _, err := service.NewFederationState()
+	if err != nil {
+		t.Fatalf("NewFederationState: %v", err)
+	}
+	nonce, err := service.NewFederationNonce()
+	if err != nil {
+		t.Fatalf("NewFederationNonce: %v", err)
+	}
+	verifier, challenge, err := service.NewPKCEVerifier()
+	if err != nil {
+		t.Fatalf("NewPKCEVerifier: %v", err)
+	}
+
+	authorizationURL, err := provider.AuthorizationURL(context.Background(), state, nonce, challenge)
+	if err != nil {
+		t.Fatalf("AuthorizationURL: %v", err)
+	}
+	code, returnedState, err := issuer.Authorize(authorizationURL)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if returnedState != state {
+		t.Fatalf("state sent back = %q, want %q", returnedState, state)
+	}
+	return provider.Exchange(context.Background(), code, verifier, nonce)
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	for name, secret := range map[string]string{"confidential": "client secret", "public": ""} {
+		t.Run(name, func(t *testing.T) {
+			issuer := newIssuer(t)
+			issuer.ClientSecret = secret
+			provider := service.NewOIDCProvider(issuer.Provider("corp", "http://localhost/callback"), http.DefaultClient)
+
+			identity, err := upstreamLogin(t, issuer, provider)
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			want := service.UpstreamIdentity{
+				Subject:           "248289761001",
+				Email:             "jane@example.com",
+				EmailVerified:     true,
+				PreferredUsername: "jane",
+				Name:              "Jane Doe",
+			}
+			if *identity != want {
+				t.Errorf("identity = %+v, want %+v", *identity, want)
+			}
+		})
+	}
+}
+
+func TestOIDCProviderRejectsInvalidIDTokens(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		nonce  string
+		kid    string
+	}{
+		{name: "other issuer", claims: map[string]interface{}{"iss": "https://evil.example"}},
+		{name: "no issuer", claims: map[string]interface{}{"iss": nil}},
+		{name: "other audience", claims: map[string]interface{}{"aud": "other"}},
+		{name: "no audience", claims: map[string]interface{}{"aud": nil}},
+		{name: "several audiences without authorized party", claims: map[string]interface{}{"aud": []string{"api", "other"}}},
+		{name: "several audiences authorized to another party",
+			claims: map[string]interface{}{"aud": []string{"api", "other"}, "azp": "other"}},
+		{name: "expired", claims: map[string]interface{}{"exp": now.Add(-time.Minute).Unix()}},
+		{name: "no expiry", claims: map[string]interface{}{"exp": nil}},
+		{name: "issued in the future", claims: map[string]interface{}{"iat": now.Add(time.Hour).Unix()}},
+		{name: "no subject", claims: map[string]interface{}{"sub": nil}},
+		{name: "other nonce", nonce: "a nonce of another login"},
+		{name: "no nonce", claims: map[string]interface{}{"nonce": nil}},
+		{name: "unknown key", kid: "rotated"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issuer := newIssuer(t)
+			issuer.Claims = test.claims
+			issuer.Nonce = test.nonce
+			issuer.KeyID = test.kid
+			provider := service.NewOIDCProvider(issuer.Provider("corp", "http://localhost/callback"), http.DefaultClient)
+
+			if identity, err := upstreamLogin(t, issuer, provider); !errors.Is(err, service.ErrUpstreamLogin) {
+				t.Errorf("Exchange = %+v, %v; want ErrUpstreamLogin", identity, err)
+			}
+		})
+	}
+}
+
+func TestOIDCProviderAcceptsAuthorizedParty(t *testing.T) {
+	issuer := newIssuer(t)
+	issuer.Claims = map[string]interface{}{"aud": []string{"api", "other"}, "azp": "api"}
+	provider := service.NewOIDCProvider(issuer.Provider("corp", "http://localhost/callback"), http.DefaultClient)
+
+	if _, err := upstreamLogin(t, issuer, provider); err != nil {
+		t.Errorf("Exchange of a token with several audiences, authorized to the client: %v", err)
+	}
+}
+
+func TestOIDCProviderRejectsCodes(t *testing.T) {
+	issuer := newIssuer(t)
+	provider := service.NewOIDCProvider(issuer.Provider("corp", "http://localhost/callback"), http.DefaultClient)
+	_, challenge, _ := service.NewPKCEVerifier()
+	authorizationURL, err := provider.AuthorizationURL(context.Background(), "state", "nonce", challenge)
+	if err != nil {
+		t.Fatalf("AuthorizationURL: %v", err)
+	}
+	code, _, err := issuer.Authorize(authorizationURL)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	// The code is used up by the first exchange, even with the wrong verifier
+	otherVerifier, _, _ := service.NewPKCEVerifier()
+	if _, err = provider.Exchange(context.Background(), code, otherVerifier, "nonce"); !errors.Is(err, service.ErrUpstreamLogin) {
+		t.Errorf("Exchange with another code verifier: %v, want ErrUpstreamLogin", err)
+	}
+	if _, err = provider.Exchange(context.Background(), "unknown", otherVerifier, "nonce"); !errors.Is(err, service.ErrUpstreamLogin) {
+		t.Errorf("Exchange of an unknown code: %v, want ErrUpstreamLogin", err)
+	}
+
+	wrongSecret := issuer.Provider("corp", "http://localhost/callback")
+	wrongSecret.ClientSecret = "wrong secret"
+	identity, err := upstreamLogin(t, issuer, service.NewOIDCProvider(wrongSecret, http.DefaultClient))
+	if !errors.Is(err, service.ErrUpstreamLogin) {
+		t.Errorf("Exchange with a wrong client secret = %+v, %v; want ErrUpstreamLogin", identity, err)
+	}
+}
+
+func TestOIDCProviderRejectsOtherIssuerDiscovery(t *testing.T) {
+	issuer := newIssuer(t)
+	upstream := issuer.Provider("corp", "http://localhost/callback")
+	upstream.IssuerURL += "/"
+	provider := service.NewOIDCProvider(upstream, http.DefaultClient)
+
+	_, challenge, _ := service.NewPKCEVerifier()
+	if _, err := provider.AuthorizationURL(context.Background(), "state", "nonce", challenge); err == nil {
+		t.Error("AuthorizationURL trusted the discovery document of another issuer")
+	}
+}
@@ -50,3 +50,33 @@ func HttpErrorResponse(logger *logrus.Logger,
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
+
+// HttpValidationErrorResponse sends a 400 (Bad Request) response for a request whose fields are invalid. The
+// JSON body holds the error message and the reason every field is rejected, e.g.
+// {"error": "Invalid profile", "fields": {"email": "must be a valid email address"}}.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// w: The http.ResponseWriter to write the error response to.
+// endpoint: The endpoint or URL path where the error occurred.
+// message: The error message to include in the response.
+// fields: The invalid fields, mapped to the reason they are rejected.
+// username: The username associated with the request (used for logging purposes).
+func HttpValidationErrorResponse(logger *logrus.Logger,
+	w http.ResponseWriter,
+	endpoint,
+	message string,
+	fields map[string]string,
+	username string) {
+
+	logger.WithFields(logrus.Fields{
+		"endpoint": endpoint,
+		"username": username,
+		"fields":   fields,
+	}).Info(message)
+
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}{Error: message, Fields: fields})
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/utils"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// HttpJSONResponse sends a successful HTTP response with the given payload encoded as JSON.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// w: The http.ResponseWriter to write the response to.
+// statusCode: The HTTP status code to set in the response.
+// endpoint: The endpoint or URL path that produced the response (used for logging purposes).
+// payload: The value to encode as the JSON body of the response.
+// username: The username associated with the request (used for logging purposes).
+//
+// If the payload cannot be encoded, the error is logged; the status code has already been sent at that point.
+func HttpJSONResponse(logger *logrus.Logger,
+	w http.ResponseWriter,
+	statusCode int,
+	endpoint string,
+	payload interface{},
+	username string) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(payload)
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"endpoint": endpoint,
+			"username": username,
+		}).Error("Error writing the response")
+	}
+}
+
+// HttpMessageResponse sends a successful HTTP response with a JSON body of the form {"message": message}.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// w: The http.ResponseWriter to write the response to.
+// statusCode: The HTTP status code to set in the response.
+// endpoint: The endpoint or URL path that produced the response.
+// message: The message to include in the response, also logged at info level.
+// username: The username associated with the request (used for logging purposes).
+func HttpMessageResponse(logger *logrus.Logger,
+	w http.ResponseWriter,
+	statusCode int,
+	endpoint,
+	message,
+	username string) {
+
+	HttpJSONResponse(logger, w, statusCode, endpoint, map[string]string{"message": message}, username)
+	logger.WithFields(logrus.Fields{
+		"endpoint": endpoint,
+		"username": username,
+	}).Info(message)
+}
+
+// HttpRepositoryErrorResponse sends an HTTP error response for an error returned by the repository layer.
+// Errors matching repository.ErrNotFound are answered with 404, errors matching repository.ErrConflict with 409,
+// and any other error with 500.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// w: The http.ResponseWriter to write the error response to.
+// endpoint: The endpoint or URL path where the error occurred.
+// message: The error message to include in the response when the error is not a not-found or conflict error.
+// err: The error returned by the repository.
+// username: The username associated with the request (used for logging purposes).
+func HttpRepositoryErrorResponse(logger *logrus.Logger,
+	w http.ResponseWriter,
+	endpoint,
+	message string,
+	err error,
+	username string) {
+
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		HttpErrorResponse(logger, w, http.StatusNotFound, endpoint, err.Error(), err, utils.LogTypeWarn, username)
+	case errors.Is(err, repository.ErrConflict):
+		HttpErrorResponse(logger, w, http.StatusConflict, endpoint, err.Error(), err, utils.LogTypeWarn, username)
+	default:
+		HttpErrorResponse(logger, w, http.StatusInternalServerError, endpoint, message, err, utils.LogTypeError, username)
+	}
+}
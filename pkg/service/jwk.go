@@ -0,0 +1,161 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is the JSON Web Key (RFC 7517) representation of a public verification key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, the document published at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// newJWK builds the JWK of a public key. The kid, alg and use members are left to the caller.
+//
+// publicKey: An *rsa.PublicKey, an *ecdsa.PublicKey on P-256 or an ed25519.PublicKey.
+//
+// Returns the JWK, or an error if the key type is not supported.
+func newJWK(publicKey interface{}) (JWK, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType: "RSA",
+			N:       encode(key.N.Bytes()),
+			E:       encode(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().Name != "P-256" {
+			return JWK{}, fmt.Errorf("unsupported EC curve %s", key.Curve.Params().Name)
+		}
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		key.X.FillBytes(x)
+		key.Y.FillBytes(y)
+		return JWK{
+			KeyType: "EC",
+			Curve:   "P-256",
+			X:       encode(x),
+			Y:       encode(y),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       encode(key),
+		}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported public key type %T", publicKey)
+}
+
+// PublicKey decodes the public key of a JWK, e.g. one published by an upstream identity provider.
+//
+// Returns an *rsa.PublicKey, an *ecdsa.PublicKey on P-256, P-384 or P-521 or an ed25519.PublicKey, or an error if
+// the key type or curve is not supported or the key is malformed.
+func (j JWK) PublicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch j.KeyType {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil || len(n) == 0 {
+			return nil, fmt.Errorf("invalid RSA modulus of key %q", j.KeyID)
+		}
+		e, err := decode(j.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent of key %q", j.KeyID)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if exponent.Int64() < 3 {
+			return nil, fmt.Errorf("invalid RSA exponent of key %q", j.KeyID)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q of key %q", j.Curve, j.KeyID)
+		}
+		x, errX := decode(j.X)
+		y, errY := decode(j.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid EC point of key %q", j.KeyID)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if _, err := key.ECDH(); err != nil {
+			return nil, fmt.Errorf("EC point of key %q is not on the curve", j.KeyID)
+		}
+		return key, nil
+	case "OKP":
+		x, err := decode(j.X)
+		if j.Curve != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid or unsupported OKP key %q", j.KeyID)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q of key %q", j.KeyType, j.KeyID)
+}
+
+// thumbprint computes the RFC 7638 thumbprint of a JWK, used as the default kid of asymmetric keys.
+//
+// jwk: The JWK, as returned by newJWK.
+//
+// Returns the base64url encoded SHA-256 thumbprint.
+func thumbprint(jwk JWK) string {
+	// RFC 7638 hashes the required members only, in lexicographic order and without whitespace.
+	var members interface{}
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+
+	canonical, _ := json.Marshal(members)
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,407 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/utils"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownKey is returned when a keyring operation references a kid the keyring does not hold.
+	ErrUnknownKey = errors.New("unknown signing key")
+
+	// ErrActiveKey is returned when trying to retire the active signing key.
+	ErrActiveKey = errors.New("the active signing key cannot be retired")
+
+	// ErrMissingSecret is returned when the HS256 algorithm is configured without a secret of its own.
+	ErrMissingSecret = errors.New("JWT_SECRET_KEY or JWT_SECRET_KEY_FILE must be set to a secret of your own, " +
+		"or JWT_SIGNING_ALGORITHM to an asymmetric algorithm")
+)
+
+// publicSecrets are HS256 secrets published with the source code, the former default of JWT_SECRET_KEY among
+// them. Anyone could forge tokens signed with them, so they are refused like a missing secret.
+var publicSecrets = map[string]bool{
+	"defaultSecret": true,
+}
+
+// KeyStatus describes a key of the keyring, as listed by the admin API.
+type KeyStatus struct {
+	ID        string     `json:"kid"`
+	Algorithm string     `json:"alg"`
+	Active    bool       `json:"active"`
+	RetiresAt *time.Time `json:"retiresAt,omitempty"`
+}
+
+// Keyring holds the key used to sign new tokens and the keys accepted to verify tokens, indexed by kid.
+//
+// Next to the active key, it holds verification-only keys: keys configured to be accepted (e.g. the next key,
+// published in the JWKS before it is promoted) and retiring keys. A key retires when it stops being the active
+// key or a configured key; it keeps verifying tokens until every token it may have signed has expired, and is
+// then dropped. It is safe for concurrent use.
+type Keyring struct {
+	mu          sync.RWMutex
+	active      *SigningKey
+	keys        map[string]*SigningKey
+	retiresAt   map[string]time.Time
+	retireAfter time.Duration
+}
+
+// NewKeyring creates a Keyring whose only key is the given active key.
+//
+// active: The key used to sign and verify tokens.
+// retireAfter: How long a key keeps verifying tokens after it retires; the lifetime of the longest-lived token.
+//
+// Returns a pointer to the Keyring.
+func NewKeyring(active *SigningKey, retireAfter time.Duration) *Keyring {
+	return &Keyring{
+		active:      active,
+		keys:        map[string]*SigningKey{active.ID: active},
+		retiresAt:   make(map[string]time.Time),
+		retireAfter: retireAfter,
+	}
+}
+
+// LoadKeyring creates the Keyring described by the JWT configuration. See loadConfiguredKeys for the keys it
+// holds.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the JWT configuration.
+//
+// Returns a pointer to the Keyring, or an error if a key cannot be loaded.
+func LoadKeyring(logger *logrus.Logger, cfg *config.Config) (*Keyring, error) {
+	retireAfter, err := tokenLifetime(cfg)
+	if err != nil {
+		logger.WithError(err).Error("Invalid JWT token lifetime")
+		return nil, err
+	}
+
+	active, verification, err := loadConfiguredKeys(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := NewKeyring(active, retireAfter)
+	if err = keys.Update(active, verification); err != nil {
+		logger.WithError(err).Error("Error loading the JWT verification keys")
+		return nil, err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"algorithm":    active.Algorithm,
+		"kid":          active.ID,
+		"verification": len(verification),
+	}).Info("JWT signing key loaded")
+	return keys, nil
+}
+
+// Reload reads the key files of the JWT configuration again and applies them with Update. Rotating the signing
+// key is done by replacing the key file (or secret file) and reloading, either with SIGHUP or through the admin
+// API: the new key becomes active and the previous one retires.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the JWT configuration.
+//
+// Returns an error if a key cannot be loaded, in which case the keyring is left unchanged.
+func (k *Keyring) Reload(logger *logrus.Logger, cfg *config.Config) error {
+	active, verification, err := loadConfiguredKeys(logger, cfg)
+	if err != nil {
+		return err
+	}
+
+	previous := k.Active()
+	if err = k.Update(active, verification); err != nil {
+		logger.WithError(err).Error("Error reloading the JWT keys")
+		return err
+	}
+
+	if previous.ID != active.ID {
+		logger.WithFields(logrus.Fields{
+			"kid":         active.ID,
+			"previousKid": previous.ID,
+			"retiresAt":   time.Now().Add(k.retireAfter),
+		}).Info("JWT signing key rotated")
+	} else {
+		logger.WithField("kid", active.ID).Info("JWT keys reloaded, the signing key is unchanged")
+	}
+	return nil
+}
+
+// Update replaces the configured keys of the keyring. The given active key becomes the signing key and the
+// verification keys are accepted until they are no longer configured. Every other key retires: it is dropped
+// once every token it may have signed has expired. A kid cannot be reused for a different key.
+//
+// active: The key used to sign new tokens; it must hold a private key.
+// verification: The additional keys accepted to verify tokens.
+//
+// Returns an error if the active key cannot sign or a kid is already in use by a different key, in which case
+// the keyring is left unchanged.
+func (k *Keyring) Update(active *SigningKey, verification []*SigningKey) error {
+	if !active.CanSign() {
+		return fmt.Errorf("signing key %q has no private key", active.ID)
+	}
+
+	configured := map[string]*SigningKey{active.ID: active}
+	for _, key := range verification {
+		if other, ok := configured[key.ID]; ok && other.fingerprint != key.fingerprint {
+			return fmt.Errorf("kid %q is configured for two different keys", key.ID)
+		}
+		if _, ok := configured[key.ID]; !ok {
+			configured[key.ID] = key
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	k.prune(now)
+	for id, key := range configured {
+		if existing, ok := k.keys[id]; ok && existing.fingerprint != key.fingerprint {
+			return fmt.Errorf("kid %q is already in use by a different key", id)
+		}
+	}
+
+	for id := range k.keys {
+		if _, ok := configured[id]; !ok {
+			if _, retiring := k.retiresAt[id]; !retiring {
+				k.retiresAt[id] = now.Add(k.retireAfter)
+			}
+		}
+	}
+	for id, key := range configured {
+		k.keys[id] = key
+		delete(k.retiresAt, id)
+	}
+	k.active = active
+	return nil
+}
+
+// Retire drops a verification key immediately, without waiting for the tokens it signed to expire. It is meant
+// for compromised keys; tokens signed by the key are rejected from then on.
+//
+// kid: The kid of the key.
+//
+// Returns ErrUnknownKey if the keyring does not hold the key and ErrActiveKey if it is the active key.
+func (k *Keyring) Retire(kid string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.prune(time.Now())
+	if _, ok := k.keys[kid]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	if k.active.ID == kid {
+		return ErrActiveKey
+	}
+	delete(k.keys, kid)
+	delete(k.retiresAt, kid)
+	return nil
+}
+
+// Active returns the key used to sign new tokens.
+func (k *Keyring) Active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Lookup returns the verification key with the given kid. Retired keys whose tokens have all expired are
+// never returned.
+//
+// kid: The kid header of the token being verified.
+//
+// Returns the key and true, or nil and false if the keyring holds no such key.
+func (k *Keyring) Lookup(kid string) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	if !ok || k.expired(kid, time.Now()) {
+		return nil, false
+	}
+	return key, true
+}
+
+// Keys returns the status of every key of the keyring, sorted by kid.
+func (k *Keyring) Keys() []KeyStatus {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	statuses := []KeyStatus{}
+	for id, key := range k.keys {
+		if k.expired(id, now) {
+			continue
+		}
+		status := KeyStatus{ID: id, Algorithm: key.Algorithm, Active: id == k.active.ID}
+		if retiresAt, ok := k.retiresAt[id]; ok {
+			status.RetiresAt = &retiresAt
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// JWKS returns the public part of every asymmetric key of the keyring, sorted by kid.
+func (k *Keyring) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	set := JWKS{Keys: []JWK{}}
+	for id, key := range k.keys {
+		if !key.Public() || k.expired(id, now) {
+			continue
+		}
+		if jwk, err := key.JWK(); err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+// expired reports whether a retiring key has outlived every token it may have signed. The caller must hold
+// the lock.
+func (k *Keyring) expired(kid string, now time.Time) bool {
+	retiresAt, ok := k.retiresAt[kid]
+	return ok && !now.Before(retiresAt)
+}
+
+// prune drops the expired keys. The caller must hold the write lock.
+func (k *Keyring) prune(now time.Time) {
+	for id := range k.retiresAt {
+		if k.expired(id, now) {
+			delete(k.keys, id)
+			delete(k.retiresAt, id)
+		}
+	}
+}
+
+// signToken signs a token with the active key and sets its kid header.
+//
+// claims: The claims of the token.
+//
+// Returns the signed token and an error, if any.
+func (k *Keyring) signToken(claims jwt.Claims) (string, error) {
+	key := k.Active()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// keyFunc is the jwt.Keyfunc selecting the verification key of a token by its kid header. The algorithm of
+// the token must be the one of the key, so a public key can never be used as an HMAC secret.
+func (k *Keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("signing algorithm %s does not match key %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+// supportedAlgorithms lists the algorithms accepted when parsing tokens.
+var supportedAlgorithms = []string{
+	jwt.SigningMethodHS256.Alg(),
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// loadConfiguredKeys reads the keys of the JWT configuration. With the HS256 algorithm, tokens are signed with
+// the secret read from JWT_SECRET_KEY_FILE, or with JWT_SECRET_KEY if no file is set, which must be neither empty
+// nor a published secret; with RS256, ES256 or EdDSA they are signed with the private key read from
+// JWT_PRIVATE_KEY_FILE. The files listed in JWT_VERIFICATION_KEY_FILES are loaded as verification keys, see
+// ParseVerificationKey.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the JWT configuration.
+//
+// Returns the active key, the verification keys and an error, if any.
+func loadConfiguredKeys(logger *logrus.Logger, cfg *config.Config) (*SigningKey, []*SigningKey, error) {
+	var active *SigningKey
+	var err error
+
+	if cfg.JWTSigningAlgorithm == jwt.SigningMethodHS256.Alg() {
+		secret := cfg.JWTSecretKey
+		if cfg.JWTSecretKeyFile != "" {
+			var secretBytes []byte
+			secretBytes, err = os.ReadFile(cfg.JWTSecretKeyFile)
+			if err != nil {
+				logger.WithError(err).WithField("file", cfg.JWTSecretKeyFile).Error("Error reading the JWT secret key")
+				return nil, nil, err
+			}
+			secret = strings.TrimSpace(string(secretBytes))
+		}
+		if secret == "" || publicSecrets[secret] {
+			logger.Error("No JWT secret key configured")
+			return nil, nil, ErrMissingSecret
+		}
+		active, err = NewHMACSigningKey(cfg.JWTKeyID, secret)
+	} else {
+		var pemBytes []byte
+		pemBytes, err = os.ReadFile(cfg.JWTPrivateKeyFile)
+		if err != nil {
+			logger.WithError(err).WithField("file", cfg.JWTPrivateKeyFile).Error("Error reading the JWT private key")
+			return nil, nil, err
+		}
+		active, err = ParseSigningKeyPEM(cfg.JWTKeyID, cfg.JWTSigningAlgorithm, pemBytes)
+	}
+	if err != nil {
+		logger.WithError(err).WithField("algorithm", cfg.JWTSigningAlgorithm).Error("Error loading the JWT signing key")
+		return nil, nil, err
+	}
+
+	var verification []*SigningKey
+	for _, file := range strings.Split(cfg.JWTVerificationKeyFiles, ",") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			logger.WithError(err).WithField("file", file).Error("Error reading a JWT verification key")
+			return nil, nil, err
+		}
+		key, err := ParseVerificationKey("", data)
+		if err != nil {
+			logger.WithError(err).WithField("file", file).Error("Error loading a JWT verification key")
+			return nil, nil, err
+		}
+		verification = append(verification, key)
+	}
+	return active, verification, nil
+}
+
+// tokenLifetime returns the lifetime of the tokens signed by the API, i.e. how long a key must keep verifying
+// tokens after it stops signing them. Access tokens, email verification tokens and MFA challenge tokens are
+// signed, the longest-lived of them sets the lifetime; refresh tokens are opaque.
+//
+// cfg: A pointer to the config.Config struct which contains the JWT configuration.
+//
+// Returns the lifetime, or an error if a configured lifetime is invalid.
+func tokenLifetime(cfg *config.Config) (time.Duration, error) {
+	var lifetime time.Duration
+	for _, value := range []string{cfg.JWTExpirationTime, cfg.EmailVerificationTokenValidity, cfg.MFATokenValidity} {
+		duration, err := utils.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+		if duration > lifetime {
+			lifetime = duration
+		}
+	}
+	return lifetime, nil
+}
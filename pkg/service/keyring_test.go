@@ -0,0 +1,161 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// keyringConfig returns a configuration signing with the HS256 secret of the given file.
+func keyringConfig(secretFile string) *config.Config {
+	return &config.Config{
+		JWTSigningAlgorithm:            "HS256",
+		JWTSecretKeyFile:               secretFile,
+		JWTIssuer:                      "test",
+		JWTAudience:                    "test",
+		JWTExpirationTime:              "15m",
+		EmailVerificationTokenValidity: "24h",
+		MFATokenValidity:               "5m",
+	}
+}
+
+// signAccessToken signs an access token of the admin user with the active key of the keyring.
+func signAccessToken(t *testing.T, cfg *config.Config, keys *Keyring) string {
+	t.Helper()
+	user := &model.User{ID: 1, Username: "admin"}
+	token, err := createToken(quietLogger(), cfg, keys, user, &Claims{TokenType: utils.AccessToken}, cfg.JWTExpirationTime)
+	if err != nil {
+		t.Fatalf("createToken: %v", err)
+	}
+	return token
+}
+
+// tokenKid returns the kid header of a token.
+func tokenKid(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func writeSecret(t *testing.T, path string, secret string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		t.Fatalf("writing the secret file: %v", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "jwt.secret")
+	writeSecret(t, secretFile, "the first secret of at least thirty-two characters")
+	cfg := keyringConfig(secretFile)
+	keys, err := LoadKeyring(quietLogger(), cfg)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	before := signAccessToken(t, cfg, keys)
+	previousKid := keys.Active().ID
+
+	writeSecret(t, secretFile, "the second secret of at least thirty-two characters")
+	if err = keys.Reload(quietLogger(), cfg); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	after := signAccessToken(t, cfg, keys)
+
+	if _, err = VerifyToken(quietLogger(), cfg, keys, before, utils.AccessToken); err != nil {
+		t.Errorf("token signed before the rotation: %v, want it verified", err)
+	}
+	if kid := tokenKid(t, before); kid != previousKid {
+		t.Errorf("kid of the token signed before the rotation = %q, want %q", kid, previousKid)
+	}
+	if kid := tokenKid(t, after); kid == previousKid || kid != keys.Active().ID {
+		t.Errorf("kid of the token signed after the rotation = %q, want the new key %q", kid, keys.Active().ID)
+	}
+	if _, err = VerifyToken(quietLogger(), cfg, keys, after, utils.AccessToken); err != nil {
+		t.Errorf("token signed after the rotation: %v, want it verified", err)
+	}
+
+	statuses := keys.Keys()
+	if len(statuses) != 2 {
+		t.Fatalf("Keys = %+v, want the active key and the retiring one", statuses)
+	}
+	for _, status := range statuses {
+		if retiring := status.ID == previousKid; status.Active == retiring || (status.RetiresAt != nil) != retiring {
+			t.Errorf("status of key %q = %+v", status.ID, status)
+		}
+	}
+}
+
+func TestKeyringRejectsKeysPastRetirement(t *testing.T) {
+	cfg := keyringConfig("")
+	first, err := NewHMACSigningKey("first", "the first secret of at least thirty-two characters")
+	if err != nil {
+		t.Fatalf("NewHMACSigningKey: %v", err)
+	}
+	second, err := NewHMACSigningKey("second", "the second secret of at least thirty-two characters")
+	if err != nil {
+		t.Fatalf("NewHMACSigningKey: %v", err)
+	}
+
+	retireAfter := 100 * time.Millisecond
+	keys := NewKeyring(first, retireAfter)
+	token := signAccessToken(t, cfg, keys)
+	if err = keys.Update(second, nil); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, err = VerifyToken(quietLogger(), cfg, keys, token, utils.AccessToken); err != nil {
+		t.Fatalf("token of the retiring key: %v, want it verified until the key retires", err)
+	}
+
+	time.Sleep(2 * retireAfter)
+	if _, err = VerifyToken(quietLogger(), cfg, keys, token, utils.AccessToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of the retired key: %v, want ErrUnknownKey", err)
+	}
+	if _, ok := keys.Lookup("first"); ok {
+		t.Error("Lookup of the retired key succeeded")
+	}
+	if statuses := keys.Keys(); len(statuses) != 1 || statuses[0].ID != "second" {
+		t.Errorf("Keys = %+v, want only the active key", statuses)
+	}
+}
+
+func TestKeyringRetire(t *testing.T) {
+	cfg := keyringConfig("")
+	first, _ := NewHMACSigningKey("first", "the first secret of at least thirty-two characters")
+	second, _ := NewHMACSigningKey("second", "the second secret of at least thirty-two characters")
+	keys := NewKeyring(first, time.Hour)
+	token := signAccessToken(t, cfg, keys)
+	if err := keys.Update(second, nil); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if err := keys.Retire("second"); !errors.Is(err, ErrActiveKey) {
+		t.Errorf("Retire of the active key = %v, want ErrActiveKey", err)
+	}
+	if err := keys.Retire("first"); err != nil {
+		t.Fatalf("Retire: %v", err)
+	}
+	if _, err := VerifyToken(quietLogger(), cfg, keys, token, utils.AccessToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of the retired key: %v, want ErrUnknownKey", err)
+	}
+	if err := keys.Retire("first"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Retire of a retired key = %v, want ErrUnknownKey", err)
+	}
+}
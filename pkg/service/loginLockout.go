@@ -0,0 +1,127 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/utils"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// LoginLockoutPolicy decides when failed logins lock an account or a client IP address, and for how long. Once
+// the failures of an account or an address reach its threshold, it is locked for Duration; every further failure
+// doubles the window, up to MaxDuration. The failures are forgotten after ResetAfter without a new one.
+type LoginLockoutPolicy struct {
+	AccountThreshold int
+	IPThreshold      int
+	Duration         time.Duration
+	MaxDuration      time.Duration
+	ResetAfter       time.Duration
+}
+
+// NewLoginLockoutPolicy creates the LoginLockoutPolicy configured by the LOGIN_LOCKOUT_* and LOGIN_FAILURE_RESET
+// environment variables. A threshold of 0 disables the lockout of the accounts or of the addresses.
+//
+// cfg: A pointer to the config.Config struct which contains the lockout configuration.
+//
+// Returns the policy, or an error if a threshold is negative or a duration is invalid.
+func NewLoginLockoutPolicy(cfg *config.Config) (*LoginLockoutPolicy, error) {
+	if cfg.LoginLockoutAccountThreshold < 0 || cfg.LoginLockoutIPThreshold < 0 {
+		return nil, fmt.Errorf("the login lockout thresholds cannot be negative")
+	}
+
+	policy := &LoginLockoutPolicy{
+		AccountThreshold: cfg.LoginLockoutAccountThreshold,
+		IPThreshold:      cfg.LoginLockoutIPThreshold,
+	}
+	var err error
+	if policy.Duration, err = utils.ParseDuration(cfg.LoginLockoutDuration); err != nil {
+		return nil, fmt.Errorf("invalid LOGIN_LOCKOUT_DURATION: %w", err)
+	}
+	if policy.MaxDuration, err = utils.ParseDuration(cfg.LoginLockoutMaxDuration); err != nil {
+		return nil, fmt.Errorf("invalid LOGIN_LOCKOUT_MAX_DURATION: %w", err)
+	}
+	if policy.ResetAfter, err = utils.ParseDuration(cfg.LoginFailureReset); err != nil {
+		return nil, fmt.Errorf("invalid LOGIN_FAILURE_RESET: %w", err)
+	}
+	if policy.MaxDuration < policy.Duration {
+		return nil, fmt.Errorf("LOGIN_LOCKOUT_MAX_DURATION (%s) is shorter than LOGIN_LOCKOUT_DURATION (%s)",
+			policy.MaxDuration, policy.Duration)
+	}
+	return policy, nil
+}
+
+// LockDuration computes how long an account or an address is locked after a failed login.
+//
+// failures: The number of failures of the account or the address, including the last one.
+// threshold: The threshold of the account or the address.
+//
+// Returns the lockout window, or 0 if the threshold is not reached or is 0.
+func (p *LoginLockoutPolicy) LockDuration(failures int, threshold int) time.Duration {
+	if threshold == 0 || failures < threshold {
+		return 0
+	}
+	duration := p.Duration
+	for i := threshold; i < failures && duration < p.MaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > p.MaxDuration {
+		return p.MaxDuration
+	}
+	return duration
+}
+
+// RecordLoginFailure counts a failed login against the client IP address and, if the username exists, against
+// the account, and locks them once their threshold is reached.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// lockouts: The LockoutStore holding the failed login counters.
+// userId: The ID of the account, or 0 if the username does not exist or the account must not be counted.
+// username: The username of the login (used for logging purposes).
+// ip: The client IP address.
+//
+// Returns an error if a counter cannot be updated.
+func (p *LoginLockoutPolicy) RecordLoginFailure(logger *logrus.Logger,
+	lockouts repository.LockoutStore,
+	userId int,
+	username string,
+	ip string) error {
+	now := time.Now()
+	since := now.Add(-p.ResetAfter)
+
+	if p.IPThreshold > 0 {
+		failures, err := lockouts.RecordIPLoginFailure(ip, since)
+		if err != nil {
+			return err
+		}
+		if duration := p.LockDuration(failures, p.IPThreshold); duration > 0 {
+			if err = lockouts.LockIP(ip, now.Add(duration)); err != nil {
+				return err
+			}
+			logger.WithFields(logrus.Fields{
+				"ip":       ip,
+				"failures": failures,
+				"duration": duration,
+			}).Warn("Client address locked out after failed logins")
+		}
+	}
+
+	if p.AccountThreshold > 0 && userId != 0 {
+		failures, err := lockouts.RecordUserLoginFailure(userId, since)
+		if err != nil {
+			return err
+		}
+		if duration := p.LockDuration(failures, p.AccountThreshold); duration > 0 {
+			if err = lockouts.LockUser(userId, now.Add(duration)); err != nil {
+				return err
+			}
+			logger.WithFields(logrus.Fields{
+				"username": username,
+				"failures": failures,
+				"duration": duration,
+			}).Warn("Account locked out after failed logins")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The notifiers selected with the NOTIFIER environment variable.
+const (
+	NotifierLog  = "log"
+	NotifierFile = "file"
+	NotifierSMTP = "smtp"
+)
+
+// Notification is a message sent to a user outside of the API, e.g. the link to reset a forgotten password or
+// to verify an email address.
+type Notification struct {
+	To       string
+	Username string
+	Subject  string
+	Body     string
+}
+
+// Notifier delivers notifications to users. Implementations must be safe for concurrent use.
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// NewNotifier creates the Notifier selected by the NOTIFIER environment variable.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the notifier configuration.
+//
+// Returns the Notifier, or an error if the notifier is unknown.
+func NewNotifier(logger *logrus.Logger, cfg *config.Config) (Notifier, error) {
+	switch cfg.Notifier {
+	case NotifierLog:
+		return &LogNotifier{logger: logger}, nil
+	case NotifierFile:
+		return &FileNotifier{path: cfg.NotifierFile}, nil
+	case NotifierSMTP:
+		return NewSMTPNotifier(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom)
+	default:
+		return nil, fmt.Errorf("unknown notifier %q, expected %s, %s or %s", cfg.Notifier, NotifierLog,
+			NotifierFile, NotifierSMTP)
+	}
+}
+
+// LogNotifier writes the notifications to the application log instead of delivering them. It is meant for
+// local use only: the notifications, including their secrets, end up in the log.
+type LogNotifier struct {
+	logger *logrus.Logger
+}
+
+// Notify logs the notification at info level.
+//
+// notification: The notification to deliver.
+//
+// Returns nil.
+func (n *LogNotifier) Notify(notification Notification) error {
+	n.logger.WithFields(logrus.Fields{
+		"to":       notification.To,
+		"username": notification.Username,
+		"subject":  notification.Subject,
+		"body":     notification.Body,
+	}).Info("Notification")
+	return nil
+}
+
+// FileNotifier appends the notifications to a file instead of delivering them, e.g. to read them in local
+// development or in end-to-end tests. The file is readable only by its owner.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+// Notify appends the notification to the file, separated from the previous one by a blank line.
+//
+// notification: The notification to deliver.
+//
+// Returns an error if the file cannot be written.
+func (n *FileNotifier) Notify(notification Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s <%s>\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z),
+		notification.Username, notification.To, notification.Subject, notification.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// SMTPNotifier delivers the notifications by email through an SMTP server. The connection is upgraded with
+// STARTTLS when the server offers it; credentials, if any, are only sent over TLS or to localhost.
+type SMTPNotifier struct {
+	addr string
+	from *mail.Address
+	auth smtp.Auth
+}
+
+// NewSMTPNotifier creates an SMTPNotifier.
+//
+// host: The host name of the SMTP server.
+// port: The port of the SMTP server, e.g. 25 or 587.
+// username: The user authenticating with the server, or an empty string to send without authentication.
+// password: The password of the user.
+// from: The sender of the emails, e.g. "GolandRestApi <no-reply@example.com>".
+//
+// Returns the SMTPNotifier, or an error if the sender is not a valid address.
+func NewSMTPNotifier(host string, port int, username string, password string, from string) (*SMTPNotifier, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP sender %q: %w", from, err)
+	}
+
+	notifier := &SMTPNotifier{addr: net.JoinHostPort(host, strconv.Itoa(port)), from: sender}
+	if username != "" {
+		notifier.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return notifier, nil
+}
+
+// Notify sends the notification as a plain text email to its recipient.
+//
+// notification: The notification to deliver.
+//
+// Returns an error if the recipient is invalid or the server does not accept the email.
+func (n *SMTPNotifier) Notify(notification Notification) error {
+	to, err := mail.ParseAddress(notification.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", notification.To, err)
+	}
+	to.Name = notification.Username
+
+	messageId := make([]byte, 16)
+	if _, err = rand.Read(messageId); err != nil {
+		return err
+	}
+	domain := n.from.Address[strings.LastIndex(n.from.Address, "@")+1:]
+
+	// The addresses are encoded by net/mail and the subject as a MIME word, so none of the values given by
+	// users can add a header
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", n.from)
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", notification.Subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(messageId), domain)
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(strings.ReplaceAll(notification.Body, "\r\n", "\n"), "\n", "\r\n"))
+	message.WriteString("\r\n")
+
+	return smtp.SendMail(n.addr, n.auth, n.from.Address, []string{to.Address}, message.Bytes())
+}
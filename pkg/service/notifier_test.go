@@ -0,0 +1,208 @@
+package service
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
+	"bufio"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpMessage is an email received by fakeSMTPServer.
+type smtpMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+// fakeSMTPServer is an in-process SMTP server accepting every email, without STARTTLS or authentication.
+type fakeSMTPServer struct {
+	listener net.Listener
+	messages chan smtpMessage
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	s := &fakeSMTPServer{listener: listener, messages: make(chan smtpMessage, 10)}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// hostPort returns the host and port the server listens on.
+func (s *fakeSMTPServer) hostPort(t *testing.T) (string, int) {
+	t.Helper()
+	host, portString, _ := net.SplitHostPort(s.listener.Addr().String())
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		t.Fatalf("port of %s: %v", s.listener.Addr(), err)
+	}
+	return host, port
+}
+
+// serve handles one SMTP session.
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("220 localhost ESMTP")
+
+	var message smtpMessage
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			_ = text.PrintfLine("250 localhost")
+		case "MAIL":
+			message = smtpMessage{from: smtpPath(line)}
+			_ = text.PrintfLine("250 OK")
+		case "RCPT":
+			message.to = append(message.to, smtpPath(line))
+			_ = text.PrintfLine("250 OK")
+		case "DATA":
+			_ = text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			message.data, err = io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			s.messages <- message
+			_ = text.PrintfLine("250 OK")
+		case "QUIT":
+			_ = text.PrintfLine("221 Bye")
+			return
+		default:
+			_ = text.PrintfLine("250 OK")
+		}
+	}
+}
+
+// smtpPath returns the address between angle brackets of a MAIL or RCPT command.
+func smtpPath(line string) string {
+	start, end := strings.IndexByte(line, '<'), strings.LastIndexByte(line, '>')
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+// receive waits for the next email received by the server.
+func (s *fakeSMTPServer) receive(t *testing.T) (smtpMessage, *mail.Message) {
+	t.Helper()
+	select {
+	case message := <-s.messages:
+		parsed, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(message.data))))
+		if err != nil {
+			t.Fatalf("parsing the email: %v", err)
+		}
+		return message, parsed
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return smtpMessage{}, nil
+	}
+}
+
+func TestSMTPNotifierSendsEmailVerification(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	host, port := server.hostPort(t)
+	notifier, err := NewSMTPNotifier(host, port, "", "", "GolandRestApi <no-reply@example.com>")
+	if err != nil {
+		t.Fatalf("NewSMTPNotifier: %v", err)
+	}
+
+	cfg := keyringConfig("")
+	cfg.EmailVerificationURL = "https://app.example.com/verify?token="
+	key, _ := NewHMACSigningKey("test", "a test secret of at least thirty-two characters")
+	keys := NewKeyring(key, time.Hour)
+	user := &model.User{ID: 7, Username: "alice", Email: "alice@example.com"}
+	if err = SendEmailVerification(quietLogger(), cfg, keys, notifier, user); err != nil {
+		t.Fatalf("SendEmailVerification: %v", err)
+	}
+
+	envelope, message := server.receive(t)
+	if envelope.from != "no-reply@example.com" || len(envelope.to) != 1 || envelope.to[0] != "alice@example.com" {
+		t.Errorf("envelope = %s -> %v, want no-reply@example.com -> [alice@example.com]", envelope.from, envelope.to)
+	}
+	to, err := mail.ParseAddress(message.Header.Get("To"))
+	if err != nil || to.Address != "alice@example.com" || to.Name != "alice" {
+		t.Errorf("To = %q, want alice <alice@example.com>", message.Header.Get("To"))
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if err != nil || subject != "Verify your email address" {
+		t.Errorf("Subject = %q, %v; want Verify your email address", subject, err)
+	}
+
+	body, err := io.ReadAll(message.Body)
+	if err != nil {
+		t.Fatalf("reading the body: %v", err)
+	}
+	start := strings.Index(string(body), cfg.EmailVerificationURL)
+	if start < 0 {
+		t.Fatalf("body %q has no verification link", body)
+	}
+	token := strings.Fields(string(body)[start+len(cfg.EmailVerificationURL):])[0]
+	claims, err := VerifyToken(quietLogger(), cfg, keys, token, utils.EmailVerificationToken)
+	if err != nil {
+		t.Fatalf("token of the verification link: %v", err)
+	}
+	if claims.Email != user.Email || claims.Subject != "7" {
+		t.Errorf("claims of the verification link = %+v, want the address of user 7", claims)
+	}
+}
+
+func TestSMTPNotifierEncodesHeaders(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	host, port := server.hostPort(t)
+	notifier, err := NewSMTPNotifier(host, port, "", "", "no-reply@example.com")
+	if err != nil {
+		t.Fatalf("NewSMTPNotifier: %v", err)
+	}
+
+	err = notifier.Notify(Notification{
+		To:       "bob@example.com",
+		Username: "bob\r\nBcc: eve@example.com",
+		Subject:  "Hello\r\nBcc: eve@example.com",
+		Body:     "Line one\n.\nLine three",
+	})
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	envelope, message := server.receive(t)
+	if len(envelope.to) != 1 || envelope.to[0] != "bob@example.com" {
+		t.Errorf("recipients = %v, want only bob@example.com", envelope.to)
+	}
+	if bcc := message.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("Bcc = %q, want no header added by the values of the notification", bcc)
+	}
+	// The fake server reads the data with a DotReader, which turns the line endings into LF
+	body, _ := io.ReadAll(message.Body)
+	if string(body) != "Line one\n.\nLine three\n" {
+		t.Errorf("body = %q, want the lines of the notification", body)
+	}
+
+	if err = notifier.Notify(Notification{To: "not an address"}); err == nil {
+		t.Error("Notify to an invalid address succeeded")
+	}
+}
@@ -0,0 +1,406 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// oauthSecretLength is the number of random bytes of a client secret and of an authorization code
+	oauthSecretLength = 32
+
+	// PKCEMethodS256 is the only PKCE code challenge method accepted: plain challenges would reveal the verifier.
+	PKCEMethodS256 = "S256"
+)
+
+var (
+	// pkceVerifierPattern matches a PKCE code verifier: 43 to 128 unreserved characters (RFC 7636).
+	pkceVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+	// pkceChallengePattern matches an S256 code challenge: a SHA-256 encoded in base64url without padding.
+	pkceChallengePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+)
+
+// IDTokenClaims are the claims of the OpenID Connect ID tokens issued to the OAuth clients. The sub claim is the
+// user ID and the audience the client. preferred_username is only set with the profile scope, and email and
+// email_verified with the email scope.
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	AuthorizedParty   string `json:"azp"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// OAuthTokens holds the tokens issued by HandleOAuthTokensCreation: the access token and the refresh token of the
+// session, as in a TokenPair, the ID token if the openid scope was granted, and the granted scope.
+type OAuthTokens struct {
+	TokenPair
+	IDToken string
+	Scope   string
+}
+
+// ExpiresIn returns the number of seconds until the access token expires, the expires_in of the token response.
+func (t *OAuthTokens) ExpiresIn() int {
+	return int(time.Until(t.AccessExpiresAt).Round(time.Second).Seconds())
+}
+
+// OIDCDiscovery is the OpenID Connect discovery document published at /.well-known/openid-configuration.
+type OIDCDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// NewOIDCDiscovery builds the discovery document of the OAuth provider. The endpoints are published under
+// OAUTH_ISSUER_URL, which must be the public URL of the API, and the ID token signing algorithm is the one of the
+// active key.
+//
+// cfg: A pointer to the config.Config struct which contains the OAuth issuer.
+// keys: The Keyring holding the signing key.
+//
+// Returns the discovery document.
+func NewOIDCDiscovery(cfg *config.Config, keys *Keyring) OIDCDiscovery {
+	base := strings.TrimSuffix(cfg.OAuthIssuerURL, "/")
+	return OIDCDiscovery{
+		Issuer:                 cfg.OAuthIssuerURL,
+		AuthorizationEndpoint:  base + "/oauth/authorize",
+		TokenEndpoint:          base + "/oauth/token",
+		IntrospectionEndpoint:  base + "/oauth/introspect",
+		RevocationEndpoint:     base + "/oauth/revoke",
+		JWKSURI:                base + "/.well-known/jwks.json",
+		ResponseTypesSupported: []string{"code"},
+		GrantTypesSupported: []string{utils.OAuthGrantAuthorizationCode, utils.OAuthGrantRefreshToken,
+			utils.OAuthGrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{keys.Active().Algorithm},
+		ScopesSupported:                   []string{utils.OAuthScopeOpenID, utils.OAuthScopeProfile, utils.OAuthScopeEmail},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{PKCEMethodS256},
+		ClaimsSupported: []string{"iss", "sub", "aud", "exp", "iat", "nonce", "azp", "preferred_username", "email",
+			"email_verified"},
+	}
+}
+
+// NewOAuthClientId generates the ID of a new OAuth client, 128 random bits encoded in hex.
+//
+// Returns the client ID and an error, if any.
+func NewOAuthClientId() (string, error) {
+	return newTokenId()
+}
+
+// NewOAuthClientSecret generates the secret of a confidential OAuth client: 32 random bytes encoded in base64url.
+// Only the hash returned with it may be stored, the secret is shown once to the administrator.
+//
+// Returns the secret, its hash as computed by HashOAuthSecret, and an error, if any.
+func NewOAuthClientSecret() (string, string, error) {
+	return newOAuthSecret()
+}
+
+// NewOAuthAuthorizationCode generates an authorization code: 32 random bytes encoded in base64url. Only the hash
+// returned with it may be stored.
+//
+// Returns the code, its hash as computed by HashOAuthSecret, and an error, if any.
+func NewOAuthAuthorizationCode() (string, string, error) {
+	return newOAuthSecret()
+}
+
+// newOAuthSecret generates a random value of oauthSecretLength bytes encoded in base64url, with its hash.
+func newOAuthSecret() (string, string, error) {
+	random := make([]byte, oauthSecretLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(random)
+	return secret, HashOAuthSecret(secret), nil
+}
+
+// HashOAuthSecret computes the value stored for a client secret or an authorization code, its SHA-256. Like for the
+// password reset tokens the hash is not keyed: the 256 random bits of the values cannot be guessed from a leaked
+// hash.
+//
+// secret: The client secret or the authorization code.
+//
+// Returns the hash, hex encoded.
+func HashOAuthSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// OAuthClientSecretMatches compares the hash of a client secret with the stored one in constant time.
+//
+// secret: The client secret sent by the client.
+// storedHash: The hash stored for the client, empty for a public client.
+//
+// Returns true if the stored hash is not empty and is the hash of the secret.
+func OAuthClientSecretMatches(secret string, storedHash string) bool {
+	if storedHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashOAuthSecret(secret)), []byte(storedHash)) == 1
+}
+
+// OAuthCodeExpiry returns when an authorization code issued now expires, after OAUTH_CODE_VALIDITY.
+//
+// cfg: A pointer to the config.Config struct which contains the OAuth configuration.
+//
+// Returns the expiry time, and an error if OAUTH_CODE_VALIDITY is invalid.
+func OAuthCodeExpiry(cfg *config.Config) (time.Time, error) {
+	validity, err := utils.ParseDuration(cfg.OAuthCodeValidity)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(validity), nil
+}
+
+// ValidPKCEChallenge reports whether a value has the format of an S256 PKCE code challenge.
+//
+// challenge: The code_challenge sent to the authorization endpoint.
+func ValidPKCEChallenge(challenge string) bool {
+	return pkceChallengePattern.MatchString(challenge)
+}
+
+// VerifyPKCE checks a PKCE code verifier against the S256 code challenge of an authorization code: the challenge
+// must be the base64url-encoded SHA-256 of the verifier. The comparison takes constant time.
+//
+// verifier: The code_verifier sent to the token endpoint.
+// challenge: The code_challenge stored with the authorization code.
+//
+// Returns true if the verifier is well-formed and answers the challenge.
+func VerifyPKCE(verifier string, challenge string) bool {
+	if !pkceVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// NormalizeOAuthScope removes the duplicate and extra spaces of a space-separated scope list, keeping the order of
+// the scopes.
+//
+// scope: The scope list.
+//
+// Returns the normalized list.
+func NormalizeOAuthScope(scope string) string {
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, value := range strings.Fields(scope) {
+		if !seen[value] {
+			seen[value] = true
+			scopes = append(scopes, value)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// HasOAuthScope reports whether a space-separated scope list contains a scope.
+//
+// scope: The scope list.
+// value: The scope looked for, e.g. utils.OAuthScopeOpenID.
+func HasOAuthScope(scope string, value string) bool {
+	for _, granted := range strings.Fields(scope) {
+		if granted == value {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthScopeIncludes reports whether every scope of a space-separated scope list is in another one.
+//
+// granted: The scope list that was granted.
+// requested: The scope list requested.
+func OAuthScopeIncludes(granted string, requested string) bool {
+	for _, value := range strings.Fields(requested) {
+		if !HasOAuthScope(granted, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleOAuthTokensCreation generates the tokens of a user who authorized an OAuth client. The access token is a
+// JWT of type utils.OAuthAccessToken issued by OAUTH_ISSUER_URL for the client, carrying the granted scope and the
+// session, and expiring after JWT_EXPIRATION_TIME like the access tokens of the API. The refresh token is an
+// opaque value generated by NewRefreshToken, whose session expires after JWT_REFRESH_TOKEN_VALIDITY. With the
+// openid scope, an ID token is issued for the client, with the given nonce. Storing the session, with the hash of
+// the refresh token, and recording the access token are left to the caller.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the JWT and OAuth configuration.
+// keys: The Keyring holding the signing key.
+// client: The OAuth client the tokens are issued to.
+// user: The user who authorized the client; its ID, Username, Email and EmailVerified must be set.
+// scope: The granted scope.
+// nonce: The nonce of the authorization request, copied in the ID token, possibly empty.
+// sessionId: The session of the tokens, or an empty string to start a new session.
+//
+// Returns the generated tokens, or an error if token creation fails.
+func HandleOAuthTokensCreation(logger *logrus.Logger,
+	cfg *config.Config,
+	keys *Keyring,
+	client *model.OAuthClient,
+	user *model.User,
+	scope string,
+	nonce string,
+	sessionId string) (*OAuthTokens, error) {
+
+	var err error
+	if sessionId == "" {
+		sessionId, err = newTokenId()
+		if err != nil {
+			logger.WithError(err).WithField("username", user.Username).Error("Error generating the session id")
+			return nil, err
+		}
+	}
+
+	tokens := &OAuthTokens{TokenPair: TokenPair{SessionId: sessionId}, Scope: scope}
+	accessClaims := &Claims{
+		Username:  user.Username,
+		TokenType: utils.OAuthAccessToken,
+		SessionId: sessionId,
+		ClientId:  client.ID,
+		Scope:     scope,
+	}
+	accessClaims.RegisteredClaims, err = newRegisteredClaims(strconv.Itoa(user.ID), cfg.OAuthIssuerURL, client.ID,
+		cfg.JWTExpirationTime)
+	if err != nil {
+		logger.WithError(err).WithField("username", user.Username).Error("Error generating the registered claims")
+		return nil, err
+	}
+	tokens.AccessToken, err = signClaims(logger, keys, accessClaims, user.Username, accessClaims.TokenType,
+		accessClaims.ID)
+	if err != nil {
+		return nil, err
+	}
+	tokens.AccessTokenId = accessClaims.ID
+	tokens.AccessExpiresAt = accessClaims.ExpiresAt.Time
+
+	if HasOAuthScope(scope, utils.OAuthScopeOpenID) {
+		tokens.IDToken, err = newIDToken(logger, cfg, keys, client, user, scope, nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refreshValidity, err := utils.ParseDuration(cfg.JWTRefreshTokenValidity)
+	if err != nil {
+		logger.WithError(err).WithField("username", user.Username).Error("Invalid refresh token validity format")
+		return nil, err
+	}
+
+	tokens.RefreshToken, tokens.RefreshTokenHash, err = NewRefreshToken(cfg, sessionId)
+	if err != nil {
+		logger.WithError(err).WithField("username", user.Username).Error("Error generating the refresh token")
+		return nil, err
+	}
+	tokens.RefreshExpiresAt = time.Now().Add(refreshValidity)
+
+	return tokens, nil
+}
+
+// NewOAuthClientCredentialsToken generates the access token of a client authenticated with the client credentials
+// grant: a JWT of type utils.OAuthAccessToken whose subject and audience are the client, without user nor session,
+// expiring after JWT_EXPIRATION_TIME.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the JWT and OAuth configuration.
+// keys: The Keyring holding the signing key.
+// client: The OAuth client the token is issued to.
+// scope: The granted scope.
+//
+// Returns the token, its expiration time and an error, if any.
+func NewOAuthClientCredentialsToken(logger *logrus.Logger,
+	cfg *config.Config,
+	keys *Keyring,
+	client *model.OAuthClient,
+	scope string) (string, time.Time, error) {
+
+	claims := &Claims{
+		TokenType: utils.OAuthAccessToken,
+		ClientId:  client.ID,
+		Scope:     scope,
+	}
+	var err error
+	claims.RegisteredClaims, err = newRegisteredClaims(client.ID, cfg.OAuthIssuerURL, client.ID, cfg.JWTExpirationTime)
+	if err != nil {
+		logger.WithError(err).WithField("clientId", client.ID).Error("Error generating the registered claims")
+		return "", time.Time{}, err
+	}
+	token, err := signClaims(logger, keys, claims, client.ID, claims.TokenType, claims.ID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, claims.ExpiresAt.Time, nil
+}
+
+// newIDToken generates the OpenID Connect ID token of a user for a client, valid for JWT_EXPIRATION_TIME.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the JWT and OAuth configuration.
+// keys: The Keyring holding the signing key.
+// client: The OAuth client, the audience of the token.
+// user: The user authenticated by the token.
+// scope: The granted scope, deciding which claims of the user are included.
+// nonce: The nonce of the authorization request, possibly empty.
+//
+// Returns the token and an error, if any.
+func newIDToken(logger *logrus.Logger,
+	cfg *config.Config,
+	keys *Keyring,
+	client *model.OAuthClient,
+	user *model.User,
+	scope string,
+	nonce string) (string, error) {
+
+	registered, err := newRegisteredClaims(strconv.Itoa(user.ID), cfg.OAuthIssuerURL, client.ID, cfg.JWTExpirationTime)
+	if err != nil {
+		logger.WithError(err).WithField("username", user.Username).Error("Error generating the registered claims")
+		return "", err
+	}
+
+	claims := &IDTokenClaims{
+		Nonce:            nonce,
+		AuthorizedParty:  client.ID,
+		RegisteredClaims: registered,
+	}
+	if HasOAuthScope(scope, utils.OAuthScopeProfile) {
+		claims.PreferredUsername = user.Username
+	}
+	if HasOAuthScope(scope, utils.OAuthScopeEmail) && user.Email != "" {
+		emailVerified := user.EmailVerified
+		claims.Email = user.Email
+		claims.EmailVerified = &emailVerified
+	}
+	token, err := signClaims(logger, keys, claims, user.Username, "id", registered.ID)
+	if err != nil {
+		return "", fmt.Errorf("signing the ID token: %w", err)
+	}
+	return token, nil
+}
@@ -0,0 +1,320 @@
+// Package oidctest provides a local mock OpenID Connect issuer, to exercise the logins through upstream identity
+// providers without a real provider.
+package oidctest
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/service"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Issuer is a mock OpenID Connect issuer serving its discovery document, its JWKS, an authorization endpoint that
+// approves every request at once and a token endpoint for the authorization code grant with S256 PKCE. Its ID
+// tokens are signed with an ES256 key and describe the identity set in its fields.
+type Issuer struct {
+	// URL is the issuer URL, to configure as FEDERATION_<NAME>_ISSUER_URL
+	URL string
+
+	// ClientID and ClientSecret are the credentials of the only client; an empty secret makes it public
+	ClientID     string
+	ClientSecret string
+
+	// Subject, Email, EmailVerified, PreferredUsername and Name are the claims of the next ID tokens
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+
+	// Nonce, when set, replaces the nonce of the next ID tokens, to emulate a replayed token
+	Nonce string
+
+	// Claims are set in the next ID tokens over the other claims, a nil value removing the claim, to emulate an
+	// invalid token, e.g. expired or issued to another client
+	Claims map[string]interface{}
+
+	// KeyID, when set, replaces the kid header of the next ID tokens, to emulate a token signed with an unknown key
+	KeyID string
+
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+	kid    string
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// authorization is a pending authorization code of the issuer.
+type authorization struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// encoding is the encoding of the codes and of the members of the JWK.
+var encoding = base64.RawURLEncoding
+
+// New starts an issuer on a local port with a new P-256 key.
+//
+// clientID: The client ID of the only client.
+// clientSecret: The secret of the client, or empty for a public client.
+//
+// Returns the issuer, to close once done, and an error, if any.
+func New(clientID string, clientSecret string) (*Issuer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	issuer := &Issuer{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		kid:          "oidctest",
+		codes:        make(map[string]authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/jwks", issuer.jwks)
+	mux.HandleFunc("/authorize", issuer.authorize)
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	issuer.URL = issuer.server.URL
+	return issuer, nil
+}
+
+// Close shuts the issuer down.
+func (i *Issuer) Close() {
+	i.server.Close()
+}
+
+// Provider returns the configuration of an upstream provider using the issuer.
+//
+// name: The name of the provider.
+// redirectURL: The callback of the API, e.g. http://localhost:8080/api/v1/user/login/corp/callback.
+//
+// Returns the configuration.
+func (i *Issuer) Provider(name string, redirectURL string) config.UpstreamProvider {
+	return config.UpstreamProvider{
+		Name:         name,
+		IssuerURL:    i.URL,
+		ClientID:     i.ClientID,
+		ClientSecret: i.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       "openid profile email",
+	}
+}
+
+// Authorize follows the authorization URL returned by the API, like the browser of the user, and stops at the
+// redirection to the callback.
+//
+// authorizationURL: The redirect_to of the API.
+//
+// Returns the code and the state to send to the callback, and an error if the issuer refused the request.
+func (i *Issuer) Authorize(authorizationURL string) (string, string, error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := client.Get(authorizationURL)
+	if err != nil {
+		return "", "", err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("authorization refused with status %d", response.StatusCode)
+	}
+
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+// discovery serves the discovery document of the issuer.
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"ES256"},
+		"code_challenge_methods_supported":      []string{service.PKCEMethodS256},
+	})
+}
+
+// jwks serves the public key of the issuer.
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	i.key.X.FillBytes(x)
+	i.key.Y.FillBytes(y)
+	writeJSON(w, http.StatusOK, service.JWKS{Keys: []service.JWK{{
+		KeyType:   "EC",
+		Use:       "sig",
+		Algorithm: "ES256",
+		KeyID:     i.kid,
+		Curve:     "P-256",
+		X:         encoding.EncodeToString(x),
+		Y:         encoding.EncodeToString(y),
+	}}})
+}
+
+// authorize approves an authorization request of the client, redirecting to its redirect URI with a new code.
+func (i *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if query.Get("response_type") != "code" || query.Get("client_id") != i.ClientID || redirectURI == "" ||
+		query.Get("code_challenge_method") != service.PKCEMethodS256 ||
+		!service.ValidPKCEChallenge(query.Get("code_challenge")) {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code := encoding.EncodeToString(random)
+	i.mu.Lock()
+	i.codes[code] = authorization{
+		redirectURI:   redirectURI,
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+	}
+	i.mu.Unlock()
+
+	location, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	values := location.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	location.RawQuery = values.Encode()
+	http.Redirect(w, r, location.String(), http.StatusFound)
+}
+
+// token redeems an authorization code for an ID token, after authenticating the client and checking the PKCE
+// code verifier.
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	if err := i.authenticateClient(r); err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client",
+			"error_description": err.Error()})
+		return
+	}
+
+	i.mu.Lock()
+	code, ok := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code"))
+	i.mu.Unlock()
+	if r.PostForm.Get("grant_type") != "authorization_code" || !ok ||
+		code.redirectURI != r.PostForm.Get("redirect_uri") ||
+		!service.VerifyPKCE(r.PostForm.Get("code_verifier"), code.codeChallenge) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := i.idToken(code.nonce)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "oidctest",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// authenticateClient checks the credentials of the client: client_secret_basic for a confidential client, the
+// client_id form parameter for a public one.
+func (i *Issuer) authenticateClient(r *http.Request) error {
+	if i.ClientSecret == "" {
+		if r.PostForm.Get("client_id") != i.ClientID {
+			return errors.New("unknown client")
+		}
+		return nil
+	}
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return errors.New("client_secret_basic required")
+	}
+	id, errId := url.QueryUnescape(id)
+	secret, errSecret := url.QueryUnescape(secret)
+	if errId != nil || errSecret != nil || id != i.ClientID || secret != i.ClientSecret {
+		return errors.New("invalid client credentials")
+	}
+	return nil
+}
+
+// idToken signs an ID token for the identity of the issuer, valid for 5 minutes.
+func (i *Issuer) idToken(nonce string) (string, error) {
+	if i.Nonce != "" {
+		nonce = i.Nonce
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   i.URL,
+		"sub":   i.Subject,
+		"aud":   i.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+	}
+	for name, value := range map[string]string{
+		"email":              i.Email,
+		"preferred_username": i.PreferredUsername,
+		"name":               i.Name,
+	} {
+		if value != "" {
+			claims[name] = value
+		}
+	}
+	if i.Email != "" {
+		claims["email_verified"] = i.EmailVerified
+	}
+	for name, value := range i.Claims {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = i.kid
+	if i.KeyID != "" {
+		token.Header["kid"] = i.KeyID
+	}
+	return token.SignedString(i.key)
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// passwordResetTokenLength is the number of random bytes of a password reset token.
+const passwordResetTokenLength = 32
+
+// NewPasswordResetToken generates a password reset token: 32 random bytes encoded in base64url. Only the hash
+// returned with it may be stored.
+//
+// Returns the token, its hash as computed by HashPasswordResetToken, and an error, if any.
+func NewPasswordResetToken() (string, string, error) {
+	secret := make([]byte, passwordResetTokenLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	return token, HashPasswordResetToken(token), nil
+}
+
+// HashPasswordResetToken computes the value stored for a password reset token, its SHA-256. Unlike refresh
+// tokens the hash is not keyed: reset tokens are only valid for minutes, and their 256 random bits cannot be
+// guessed from a leaked hash in that time.
+//
+// token: The reset token.
+//
+// Returns the hash, hex encoded.
+func HashPasswordResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssuePasswordResetToken generates a password reset token for a user and stores its hash.
+//
+// resets: The PasswordResetStore in which the hash of the token is stored.
+// userId: The ID of the user whose password is reset.
+// validity: How long the token can be used.
+//
+// Returns the token, to send to the user, and an error, if any.
+func IssuePasswordResetToken(resets repository.PasswordResetStore, userId int, validity time.Duration) (string, error) {
+	token, tokenHash, err := NewPasswordResetToken()
+	if err != nil {
+		return "", err
+	}
+	err = resets.CreatePasswordResetToken(model.PasswordResetToken{
+		TokenHash: tokenHash,
+		UserID:    userId,
+		ExpiresAt: time.Now().Add(validity),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// PasswordResetNotification builds the notification delivering a password reset token. If PASSWORD_RESET_URL is
+// set, the token is appended to it to form a link to the page of the client resetting the password.
+//
+// cfg: A pointer to the config.Config struct which contains the password reset configuration.
+// user: The user whose password is reset.
+// token: The reset token.
+// validity: How long the token can be used.
+// required: Whether an administrator requires the reset, rather than the user asking for it.
+//
+// Returns the notification.
+func PasswordResetNotification(cfg *config.Config,
+	user *model.User,
+	token string,
+	validity time.Duration,
+	required bool) Notification {
+	action := "Use this token to choose a new password:\n\n" + token
+	if cfg.PasswordResetURL != "" {
+		action = "Follow this link to choose a new password:\n\n" + cfg.PasswordResetURL + token
+	}
+	body := fmt.Sprintf("Hello %s,\n\nA password reset was requested for your account. %s\n\n"+
+		"It can be used once, within %s. If you did not ask for it, ignore this message: your password "+
+		"is unchanged.", user.Username, action, validity)
+	if required {
+		body = fmt.Sprintf("Hello %s,\n\nAn administrator requires you to choose a new password before logging "+
+			"in with a password again. %s\n\nIt can be used once, within %s. Once it has expired, ask for "+
+			"another one with the forgotten password form.", user.Username, action, validity)
+	}
+	return Notification{
+		To:       user.Email,
+		Username: user.Username,
+		Subject:  "Reset your password",
+		Body:     body,
+	}
+}
@@ -4,6 +4,19 @@ import (
 	"GolandRestApi/pkg/model"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
+	"unicode/utf8"
+)
+
+const (
+	// minPasswordLength is the minimum number of characters of a new password.
+	minPasswordLength = 8
+
+	// maxPasswordLength is the maximum number of bytes of a password hashed by bcrypt.
+	maxPasswordLength = 72
+
+	// dummyPasswordHash is the bcrypt hash, at the default cost, of a random password nobody knows. Passwords
+	// given for unknown users are compared with it, so they take as long to reject as a wrong password.
+	dummyPasswordHash = "$2a$10$Dpnz0WkLUsu5MC654uGCN.eINxt18V7/oko9CY0WFtKbKqAVxzQZe"
 )
 
 // HashPassword generates a bcrypt hashed password for a given user's plaintext password.
@@ -43,3 +56,28 @@ func CheckPasswordHash(logger *logrus.Logger, user *model.User, password string)
 	}
 	return nil
 }
+
+// CheckDummyPasswordHash compares a password with a hash that no password matches, for a user that does not
+// exist. It takes as long as CheckPasswordHash, so the response time of a login does not tell whether the
+// username exists.
+//
+// password: The plaintext password given for the unknown user.
+func CheckDummyPasswordHash(password string) {
+	_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+}
+
+// ValidatePassword checks a new password. Passwords must be at least 8 characters long, and at most 72 bytes
+// long since bcrypt ignores the following bytes.
+//
+// password: The new plaintext password.
+//
+// Returns the reason the password is rejected, or an empty string if it is valid.
+func ValidatePassword(password string) string {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return "must be at least 8 characters long"
+	}
+	if len(password) > maxPasswordLength {
+		return "must be at most 72 bytes long"
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxEmailLength is the longest email address accepted, as per RFC 5321.
+	maxEmailLength = 254
+
+	// maxCountryLength is the longest country name accepted.
+	maxCountryLength = 64
+)
+
+var (
+	// countryPattern matches country names: letters, spaces, dots, apostrophes and hyphens.
+	countryPattern = regexp.MustCompile(`^\p{L}[\p{L} .'-]*$`)
+
+	// phonePattern matches phone numbers: an optional leading +, then digits, spaces, hyphens and parentheses.
+	phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()-]*$`)
+)
+
+// ValidateProfileUpdate checks the fields of a profile update.
+//
+// update: The profile update; nil fields are not checked.
+//
+// Returns the invalid fields, mapped to the reason they are rejected, or an empty map if the update is valid.
+func ValidateProfileUpdate(update model.ProfileUpdate) map[string]string {
+	invalid := make(map[string]string)
+
+	if update.Email != nil {
+		if reason := validateEmail(*update.Email); reason != "" {
+			invalid["email"] = reason
+		}
+	}
+	if update.Country != nil && *update.Country != "" {
+		country := *update.Country
+		if utf8.RuneCountInString(country) > maxCountryLength {
+			invalid["country"] = "must be at most 64 characters long"
+		} else if !countryPattern.MatchString(country) || strings.TrimSpace(country) != country {
+			invalid["country"] = "must be a country name"
+		}
+	}
+	if update.Phone != nil && *update.Phone != "" {
+		phone := *update.Phone
+		digits := 0
+		for _, c := range phone {
+			if c >= '0' && c <= '9' {
+				digits++
+			}
+		}
+		if !phonePattern.MatchString(phone) || digits < 6 || digits > 15 {
+			invalid["phone"] = "must be a phone number of 6 to 15 digits"
+		}
+	}
+	return invalid
+}
+
+// ValidateAdminUserUpdate checks the fields of an update of a user by an administrator: the profile fields as
+// ValidateProfileUpdate does, the status, which must be active or disabled, and the roles, which must exist.
+//
+// update: The update; nil fields are not checked.
+// roles: The existing roles.
+//
+// Returns the invalid fields, mapped to the reason they are rejected, or an empty map if the update is valid.
+func ValidateAdminUserUpdate(update model.AdminUserUpdate, roles []model.Role) map[string]string {
+	invalid := ValidateProfileUpdate(update.ProfileUpdate)
+
+	if update.Status != nil && *update.Status != utils.UserStatusActive && *update.Status != utils.UserStatusDisabled {
+		invalid["status"] = "must be active or disabled"
+	}
+	if update.Roles != nil {
+		existing := make(map[string]bool, len(roles))
+		for _, role := range roles {
+			existing[role.Name] = true
+		}
+		for _, name := range *update.Roles {
+			if !existing[name] {
+				invalid["roles"] = "must be names of existing roles, " + name + " is not"
+				break
+			}
+		}
+	}
+	return invalid
+}
+
+// validateEmail checks an email address. Display names ("Alice <alice@example.com>") are rejected: only the
+// address itself is stored.
+//
+// email: The email address.
+//
+// Returns the reason the address is rejected, or an empty string if it is valid.
+func validateEmail(email string) string {
+	if email == "" {
+		return "is required"
+	}
+	if len(email) > maxEmailLength {
+		return "must be at most 254 characters long"
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return "must be a valid email address"
+	}
+	return ""
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// refreshTokenSecretLength is the number of random bytes of a refresh token.
+const refreshTokenSecretLength = 32
+
+// minSecretKeyLength is the minimum length of the secret keys set in the configuration.
+const minSecretKeyLength = 32
+
+// ErrMalformedRefreshToken is returned by ParseRefreshToken for a value that was not issued by NewRefreshToken.
+var ErrMalformedRefreshToken = errors.New("malformed refresh token")
+
+// CheckRefreshTokenHashKey checks that REFRESH_TOKEN_HASH_KEY is set to a key of at least 32 characters other
+// than its former public default, with which anyone could check guessed refresh tokens against a leaked
+// database.
+//
+// cfg: A pointer to the config.Config struct which contains the refresh token hash key.
+//
+// Returns an error describing the problem, or nil if the key can be used.
+func CheckRefreshTokenHashKey(cfg *config.Config) error {
+	if cfg.RefreshTokenHashKey == "" || cfg.RefreshTokenHashKey == "defaultRefreshTokenHashKey" {
+		return errors.New("REFRESH_TOKEN_HASH_KEY must be set to a secret of your own")
+	}
+	if len(cfg.RefreshTokenHashKey) < minSecretKeyLength {
+		return fmt.Errorf("REFRESH_TOKEN_HASH_KEY must be at least %d characters long", minSecretKeyLength)
+	}
+	return nil
+}
+
+// NewRefreshToken generates an opaque refresh token for a session. The token is the session ID followed by a
+// dot and 32 random bytes encoded in base64url, so the session can be found without storing the token. Only
+// the hash returned with it may be stored.
+//
+// cfg: A pointer to the config.Config struct which contains the refresh token hash key.
+// sessionId: The ID of the session the token belongs to.
+//
+// Returns the token, its hash as computed by HashRefreshToken, and an error, if any.
+func NewRefreshToken(cfg *config.Config, sessionId string) (string, string, error) {
+	secret := make([]byte, refreshTokenSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token := sessionId + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return token, HashRefreshToken(cfg, token), nil
+}
+
+// ParseRefreshToken extracts the session ID from a refresh token. It only checks the format of the token:
+// whether the token is the current one of the session is decided by comparing its hash with the stored one.
+//
+// token: The refresh token sent by the client.
+//
+// Returns the session ID, or ErrMalformedRefreshToken if the token does not have the format of NewRefreshToken.
+func ParseRefreshToken(token string) (string, error) {
+	sessionId, secret, found := strings.Cut(token, ".")
+	if !found || sessionId == "" {
+		return "", ErrMalformedRefreshToken
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(secret)
+	if err != nil || len(decoded) != refreshTokenSecretLength {
+		return "", ErrMalformedRefreshToken
+	}
+	return sessionId, nil
+}
+
+// HashRefreshToken computes the value stored for a refresh token: an HMAC-SHA256 keyed with
+// REFRESH_TOKEN_HASH_KEY, so a leaked database neither contains usable tokens nor allows checking guesses
+// without the key.
+//
+// cfg: A pointer to the config.Config struct which contains the refresh token hash key.
+// token: The refresh token.
+//
+// Returns the hash as a hex string of 64 characters.
+func HashRefreshToken(cfg *config.Config, token string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.RefreshTokenHashKey))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RefreshTokenMatches compares two refresh token hashes in constant time, so the time taken does not reveal
+// how much of a guessed hash is right.
+//
+// tokenHash: The hash of the token sent by the client.
+// storedHash: The hash stored in the session.
+//
+// Returns true if the hashes are equal and not empty.
+func RefreshTokenMatches(tokenHash string, storedHash string) bool {
+	if storedHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(tokenHash), []byte(storedHash)) == 1
+}
+
+// RevokeSession revokes a session and denies its outstanding access tokens, so neither its refresh token nor
+// its access tokens can be used anymore.
+//
+// tokens: The TokenStore holding the session.
+// denylist: The DenylistStore to which the access tokens of the session are added.
+// sessionId: The ID of the session.
+//
+// Returns a NotFoundError if the session does not exist, or any other error returned by the stores.
+func RevokeSession(tokens repository.TokenStore, denylist repository.DenylistStore, sessionId string) error {
+	if err := tokens.RevokeSession(sessionId); err != nil {
+		return err
+	}
+	_, err := denylist.DenySessionAccessTokens(sessionId)
+	return err
+}
+
+// RevokeUserSessions revokes every session of a user except the given one and denies their outstanding access
+// tokens, logging the user out of all the other devices.
+//
+// tokens: The TokenStore holding the sessions.
+// denylist: The DenylistStore to which the access tokens of the sessions are added.
+// userId: The ID of the user.
+// exceptSessionId: The ID of the session to keep, or an empty string to revoke every session.
+//
+// Returns the number of revoked sessions, or an error returned by the stores.
+func RevokeUserSessions(tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	userId int,
+	exceptSessionId string) (int, error) {
+
+	if exceptSessionId == "" {
+		revoked, err := tokens.RevokeUserSessions(userId, "")
+		if err != nil {
+			return 0, err
+		}
+		_, err = denylist.DenyUserAccessTokens(userId)
+		return revoked, err
+	}
+
+	// The access tokens of the kept session must stay valid, so the tokens are denied session by session
+	sessions, err := tokens.ListSessions(userId)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := tokens.RevokeUserSessions(userId, exceptSessionId)
+	if err != nil {
+		return 0, err
+	}
+	for _, session := range sessions {
+		if session.ID == exceptSessionId {
+			continue
+		}
+		if _, err = denylist.DenySessionAccessTokens(session.ID); err != nil {
+			return 0, err
+		}
+	}
+	return revoked, nil
+}
+
+// RevokeReusedSession revokes the session of a refresh token that was presented after being rotated, denies its
+// access tokens, and logs the reuse as a security event.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// tokens: The TokenStore holding the session.
+// denylist: The DenylistStore to which the access tokens of the session are added.
+// session: The session of the reused refresh token.
+// userName: The username of the owner of the session.
+// r: The HTTP request that presented the token.
+func RevokeReusedSession(logger *logrus.Logger,
+	tokens repository.TokenStore,
+	denylist repository.DenylistStore,
+	session *model.Session,
+	userName string,
+	r *http.Request) {
+
+	fields := logrus.Fields{
+		"event":     "refresh_token_reuse",
+		"username":  userName,
+		"userId":    session.UserID,
+		"sessionId": session.ID,
+		"clientId":  session.ClientID,
+		"ip":        ClientIP(r),
+		"userAgent": r.UserAgent(),
+	}
+
+	err := RevokeSession(tokens, denylist, session.ID)
+	if err != nil {
+		logger.WithError(err).WithFields(fields).Error("Refresh token reuse detected, error revoking the session")
+		return
+	}
+	logger.WithFields(fields).Warn("Refresh token reuse detected, session revoked")
+}
@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/service"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+	"time"
+)
+
+// newSessionStore returns a memory store in which the admin user, ID 1, has the sessions laptop, phone and tablet,
+// each with one recorded access token named after it.
+func newSessionStore(t *testing.T) *repository.MemoryStore {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	store := repository.NewMemoryStore(logger)
+
+	expiresAt := time.Now().Add(time.Hour)
+	for _, id := range []string{"laptop", "phone", "tablet"} {
+		err := store.CreateSession(model.Session{ID: id, UserID: 1, ExpiresAt: expiresAt, TokenHash: id + "-hash"})
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		err = store.RecordAccessToken(model.AccessToken{ID: id, SessionID: id, UserID: 1, ExpiresAt: expiresAt})
+		if err != nil {
+			t.Fatalf("RecordAccessToken: %v", err)
+		}
+	}
+	return store
+}
+
+// checkDenied fails the test unless exactly the access tokens of the denied sessions are denied.
+func checkDenied(t *testing.T, store *repository.MemoryStore, denied ...string) {
+	t.Helper()
+	for _, id := range []string{"laptop", "phone", "tablet"} {
+		want := false
+		for _, deniedId := range denied {
+			want = want || id == deniedId
+		}
+		if got, err := store.IsAccessTokenDenied(id); err != nil || got != want {
+			t.Errorf("IsAccessTokenDenied(%s) = %t, %v; want %t", id, got, err, want)
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	store := newSessionStore(t)
+	if err := service.RevokeSession(store, store, "phone"); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	checkDenied(t, store, "phone")
+
+	var notFound *repository.NotFoundError
+	if err := service.RevokeSession(store, store, "unknown"); !errors.As(err, &notFound) {
+		t.Errorf("RevokeSession(unknown) = %v, want a NotFoundError", err)
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	store := newSessionStore(t)
+	revoked, err := service.RevokeUserSessions(store, store, 1, "laptop")
+	if err != nil || revoked != 2 {
+		t.Fatalf("RevokeUserSessions except laptop = %d, %v; want 2", revoked, err)
+	}
+	checkDenied(t, store, "phone", "tablet")
+
+	if revoked, err = service.RevokeUserSessions(store, store, 1, ""); err != nil || revoked != 1 {
+		t.Fatalf("RevokeUserSessions = %d, %v; want 1", revoked, err)
+	}
+	checkDenied(t, store, "laptop", "phone", "tablet")
+	if sessions, err := store.ListSessions(1); err != nil || len(sessions) != 0 {
+		t.Errorf("ListSessions = %v, %v; want no session left", sessions, err)
+	}
+}
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is a key used to sign and verify tokens with one algorithm. HS256 keys are shared secrets;
+// RS256, ES256 and EdDSA keys are asymmetric, and only their public part is ever published. A key parsed
+// from a public key can only verify tokens.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+
+	method      jwt.SigningMethod
+	signKey     interface{}
+	verifyKey   interface{}
+	fingerprint string
+}
+
+// Public reports whether the key is asymmetric, i.e. whether it can be published in the JWKS.
+func (k *SigningKey) Public() bool {
+	return k.Algorithm != jwt.SigningMethodHS256.Alg()
+}
+
+// CanSign reports whether the key holds the private part needed to sign tokens.
+func (k *SigningKey) CanSign() bool {
+	return k.signKey != nil
+}
+
+// JWK returns the public verification key in JWK format.
+//
+// Returns the JWK, or an error for HS256 keys, which have no public part.
+func (k *SigningKey) JWK() (JWK, error) {
+	if !k.Public() {
+		return JWK{}, errors.New("HS256 keys cannot be published")
+	}
+	jwk, err := newJWK(k.verifyKey)
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk.KeyID = k.ID
+	jwk.Algorithm = k.Algorithm
+	jwk.Use = "sig"
+	return jwk, nil
+}
+
+// NewHMACSigningKey creates an HS256 key from a shared secret.
+//
+// id: The kid of the key. If empty, it is derived from a SHA-256 hash of the secret, so rotating the
+// secret also changes the kid.
+// secret: The shared secret.
+//
+// Returns the SigningKey, or an error if the secret is empty.
+func NewHMACSigningKey(id string, secret string) (*SigningKey, error) {
+	if secret == "" {
+		return nil, errors.New("the HS256 secret is empty")
+	}
+	sum := sha256.Sum256([]byte(secret))
+	fingerprint := hex.EncodeToString(sum[:])
+	if id == "" {
+		id = "hs256-" + fingerprint[:16]
+	}
+	return &SigningKey{
+		ID:          id,
+		Algorithm:   jwt.SigningMethodHS256.Alg(),
+		method:      jwt.SigningMethodHS256,
+		signKey:     []byte(secret),
+		verifyKey:   []byte(secret),
+		fingerprint: fingerprint,
+	}, nil
+}
+
+// ParseSigningKeyPEM creates an asymmetric key from a PEM encoded private key.
+//
+// id: The kid of the key. If empty, the RFC 7638 thumbprint of the public key is used.
+// algorithm: RS256 (RSA key of at least 2048 bits), ES256 (P-256 key) or EdDSA (Ed25519 key).
+// pemBytes: The PEM encoded private key (PKCS#1, SEC 1 or PKCS#8).
+//
+// Returns the SigningKey, or an error if the algorithm is unknown or the key does not match it.
+func ParseSigningKeyPEM(id string, algorithm string, pemBytes []byte) (*SigningKey, error) {
+	var privateKey crypto.Signer
+	var err error
+
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err = jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+	case jwt.SigningMethodES256.Alg():
+		privateKey, err = jwt.ParseECPrivateKeyFromPEM(pemBytes)
+	case jwt.SigningMethodEdDSA.Alg():
+		var key crypto.PrivateKey
+		key, err = jwt.ParseEdPrivateKeyFromPEM(pemBytes)
+		if err == nil {
+			var ok bool
+			if privateKey, ok = key.(crypto.Signer); !ok {
+				err = errors.New("invalid EdDSA key")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := newAsymmetricKey(id, privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != algorithm {
+		return nil, fmt.Errorf("the key is a %s key, not a %s key", key.Algorithm, algorithm)
+	}
+	key.signKey = privateKey
+	return key, nil
+}
+
+// GenerateSigningKey generates a new key for an algorithm, in the format of the key files of the JWT
+// configuration: a random secret for HS256 (JWT_SECRET_KEY_FILE) and a PKCS#8 PEM encoded private key for
+// RS256, ES256 and EdDSA (JWT_PRIVATE_KEY_FILE).
+//
+// algorithm: HS256, RS256 (2048-bit RSA key), ES256 (P-256 key) or EdDSA (Ed25519 key).
+//
+// Returns the content of the key file, or an error if the algorithm is unknown or the key cannot be generated.
+func GenerateSigningKey(algorithm string) ([]byte, error) {
+	var privateKey crypto.Signer
+	var err error
+
+	switch algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		secret := make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return []byte(base64.RawURLEncoding.EncodeToString(secret) + "\n"), nil
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParseVerificationKey creates a key from the content of a key file, inferring its algorithm. The file holds
+// either a PEM encoded private or public key (RS256, ES256 or EdDSA), or a raw HS256 secret. Public keys give
+// verification-only keys.
+//
+// id: The kid of the key. If empty, it is derived from the key as for NewHMACSigningKey and ParseSigningKeyPEM.
+// data: The content of the key file.
+//
+// Returns the SigningKey, or an error if the key cannot be parsed.
+func ParseVerificationKey(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return NewHMACSigningKey(id, string(bytes.TrimSpace(data)))
+	}
+
+	if block.Type == "PUBLIC KEY" {
+		publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newAsymmetricKey(id, publicKey)
+	}
+
+	var privateKey interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+
+	key, err := newAsymmetricKey(id, signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.signKey = signer
+	return key, nil
+}
+
+// newAsymmetricKey creates a verification-only key from a public key, inferring the algorithm from its type.
+//
+// id: The kid of the key. If empty, the RFC 7638 thumbprint of the public key is used.
+// publicKey: An *rsa.PublicKey of at least 2048 bits, an *ecdsa.PublicKey on P-256 or an ed25519.PublicKey.
+//
+// Returns the SigningKey, or an error if the key type or size is not supported.
+func newAsymmetricKey(id string, publicKey interface{}) (*SigningKey, error) {
+	key := &SigningKey{ID: id, verifyKey: publicKey}
+
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		if publicKey.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RS256 keys must have at least 2048 bits, got %d", publicKey.N.BitLen())
+		}
+		key.method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if publicKey.Curve.Params().Name != "P-256" {
+			return nil, fmt.Errorf("ES256 keys must use the P-256 curve, got %s", publicKey.Curve.Params().Name)
+		}
+		key.method = jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	key.Algorithm = key.method.Alg()
+
+	jwk, err := newJWK(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	key.fingerprint = thumbprint(jwk)
+	if key.ID == "" {
+		key.ID = key.fingerprint
+	}
+	return key, nil
+}
@@ -2,43 +2,155 @@ package service
 
 import (
 	"GolandRestApi/pkg/config"
-	"database/sql"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/repository"
+	"GolandRestApi/pkg/utils"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/sirupsen/logrus"
+	"strconv"
 	"time"
 )
 
-// createToken generates a JSON Web Token (JWT) with the provided username and expiration time.
-// It uses the secret key from the application configuration to sign the token. The expiration time
-// is specified as a duration string (e.g., "15m" for 15 minutes). Note: This function is used to
-// create the access and refresh token, just use the env variables for them.
+// Claims are the claims carried by the tokens issued by the API. Next to the registered claims (sub, exp,
+// iat, nbf, jti, iss and aud), access tokens carry the username and a snapshot of the roles and effective
+// permissions of the user, so requests can be authorized without querying the database. The snapshot is
+// refreshed every time a new access token is issued. Access tokens carry the ID of the session they belong to,
+// email verification tokens the email address they verify. The access tokens issued to OAuth clients carry the ID
+// of the client and the granted scope instead of the roles and permissions.
+type Claims struct {
+	Username    string   `json:"username"`
+	TokenType   string   `json:"typ"`
+	SessionId   string   `json:"sid,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	ClientId    string   `json:"client_id,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// UserId returns the user ID held in the sub claim.
+//
+// Returns the user ID, or an error if the sub claim is not a number.
+func (c *Claims) UserId() (int, error) {
+	return strconv.Atoi(c.Subject)
+}
+
+// HasPermission reports whether the claims grant the given permission.
+//
+// permission: The name of the permission, e.g. "user:delete".
+func (c *Claims) HasPermission(permission string) bool {
+	for _, granted := range c.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// newTokenId generates a random identifier for the jti claim.
+//
+// Returns the identifier as a hex string and an error, if any.
+func newTokenId() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// createToken generates a signed JSON Web Token (JWT) for the given claims. It fills in the registered
+// claims (sub, exp, iat, nbf, jti, iss and aud) and signs the token with the active key of the keyring,
+// whose ID is set as the kid header. The expiration time is specified as a duration string (e.g., "15m" for 15
+// minutes or "7d" for 7 days).
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
-// cfg: A pointer to the config.Config struct which contains the JWT secret key.
-// username: The username to be included in the JWT claims.
+// cfg: A pointer to the config.Config struct which contains the JWT issuer and audience.
+// keys: The Keyring holding the signing key.
+// user: The user the token is issued to.
+// claims: The claims specific to the token type; the registered claims are overwritten.
 // expirationTime: The duration for which the JWT will be valid.
 //
 // Returns the JWT token as a string and an error, if any. If there is an error during token creation,
 // it returns an empty string and the error.
-func createToken(logger *logrus.Logger, cfg *config.Config, username string, expirationTime string) (string, error) {
-	var secretKey = []byte(cfg.JWTSecretKey)
-	expirationDuration, err := time.ParseDuration(expirationTime)
+func createToken(logger *logrus.Logger,
+	cfg *config.Config,
+	keys *Keyring,
+	user *model.User,
+	claims *Claims,
+	expirationTime string) (string, error) {
+
+	registered, err := newRegisteredClaims(strconv.Itoa(user.ID), cfg.JWTIssuer, cfg.JWTAudience, expirationTime)
 	if err != nil {
-		logger.WithField("username", username).
+		logger.WithField("username", user.Username).
 			WithError(err).
-			Error("Invalid JWT expiration time format")
+			Error("Error generating the registered claims")
 		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		jwt.MapClaims{
-			"username": username,
-			"exp":      time.Now().Add(expirationDuration).Unix(),
-		})
+	claims.Username = user.Username
+	claims.RegisteredClaims = registered
+	return signClaims(logger, keys, claims, user.Username, claims.TokenType, registered.ID)
+}
+
+// newRegisteredClaims fills in the registered claims of a token issued now: sub, exp, iat, nbf, a random jti, iss
+// and aud.
+//
+// subject: The value of the sub claim.
+// issuer: The value of the iss claim.
+// audience: The value of the aud claim.
+// expirationTime: The duration for which the token will be valid, e.g. "15m".
+//
+// Returns the registered claims, or an error if the duration is invalid or the jti cannot be generated.
+func newRegisteredClaims(subject string,
+	issuer string,
+	audience string,
+	expirationTime string) (jwt.RegisteredClaims, error) {
 
-	tokenString, err := token.SignedString(secretKey)
+	expirationDuration, err := utils.ParseDuration(expirationTime)
+	if err != nil {
+		return jwt.RegisteredClaims{}, fmt.Errorf("invalid JWT expiration time format: %w", err)
+	}
+
+	tokenId, err := newTokenId()
+	if err != nil {
+		return jwt.RegisteredClaims{}, fmt.Errorf("generating the token id: %w", err)
+	}
+
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Subject:   subject,
+		ExpiresAt: jwt.NewNumericDate(now.Add(expirationDuration)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ID:        tokenId,
+		Issuer:    issuer,
+		Audience:  jwt.ClaimStrings{audience},
+	}, nil
+}
+
+// signClaims signs a token with the active key of the keyring and logs its issuance.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// keys: The Keyring holding the signing key.
+// claims: The complete claims of the token.
+// username: The username of the user the token is issued to, or the client ID for a token without user.
+// tokenType: The type of the token, logged.
+// tokenId: The jti of the token, logged.
+//
+// Returns the signed token and an error, if any.
+func signClaims(logger *logrus.Logger,
+	keys *Keyring,
+	claims jwt.Claims,
+	username string,
+	tokenType string,
+	tokenId string) (string, error) {
+
+	tokenString, err := keys.signToken(claims)
 	if err != nil {
 		logger.WithError(err).
 			WithField("username", username).
@@ -46,108 +158,189 @@ func createToken(logger *logrus.Logger, cfg *config.Config, username string, exp
 		return "", err
 	}
 
-	logger.WithField("username", username).
-		Infof("JWT token generated")
+	logger.WithFields(logrus.Fields{
+		"username":  username,
+		"tokenType": tokenType,
+		"jti":       tokenId,
+	}).Info("JWT token generated")
 	return tokenString, nil
 }
 
-// VerifyToken verifies the authenticity and validity of a JSON Web Token (JWT) using the provided secret key.
-// It checks if the token is correctly signed and has not expired.
+// VerifyToken verifies the authenticity and validity of a JSON Web Token (JWT) and returns its claims. The
+// verification key is selected from the keyring by the kid header of the token. It checks the signature, the
+// exp, nbf and iat claims, the issuer, the audience and the token type, so a token issued for another purpose
+// cannot be used as an access token.
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
-// cfg: A pointer to the config.Config struct which contains the JWT secret key.
-// username: The username associated with the token (used for logging purposes).
+// cfg: A pointer to the config.Config struct which contains the JWT issuer and audience.
+// keys: The Keyring holding the verification keys.
 // tokenString: The JWT token to be verified.
+// tokenType: The expected token type, e.g. utils.AccessToken.
 //
-// Returns an error if the token is invalid, expired, or if there's any error during verification.
-// Returns nil if the token is valid.
-func VerifyToken(logger *logrus.Logger, cfg *config.Config, username string, tokenString string) error {
-	var secretKey = []byte(cfg.JWTSecretKey)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
-	})
-	if err != nil {
-		logger.WithError(err).WithField("username", username).Error("Error parsing the token")
-		return err
-	}
-
-	if !token.Valid {
-		logger.WithField("username", username).
-			Warnf("Invalid token: %s", tokenString)
-		return errors.New("invalid token")
-	}
+// Returns the verified claims, or an error if the token is invalid, expired, of the wrong type or if there's
+// any error during verification.
+func VerifyToken(logger *logrus.Logger,
+	cfg *config.Config,
+	keys *Keyring,
+	tokenString string,
+	tokenType string) (*Claims, error) {
+	return verifyToken(logger, keys, tokenString, tokenType, jwt.WithIssuer(cfg.JWTIssuer), jwt.WithAudience(cfg.JWTAudience))
+}
 
-	return nil
+// VerifyOAuthAccessToken verifies an access token issued to an OAuth client and returns its claims. It checks the
+// signature, the exp, nbf and iat claims, the issuer, which must be OAUTH_ISSUER_URL, and the token type. The
+// audience is the client the token was issued to and is left to the caller.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the OAuth issuer.
+// keys: The Keyring holding the verification keys.
+// tokenString: The JWT token to be verified.
+//
+// Returns the verified claims, or an error if the token is invalid, expired or not an OAuth access token.
+func VerifyOAuthAccessToken(logger *logrus.Logger,
+	cfg *config.Config,
+	keys *Keyring,
+	tokenString string) (*Claims, error) {
+	return verifyToken(logger, keys, tokenString, utils.OAuthAccessToken, jwt.WithIssuer(cfg.OAuthIssuerURL))
 }
 
-// extractMapClaimsFromToken extracts the MapClaims from a JWT token without verifying its signature.
-// It parses the provided token string and retrieves the claims as a map.
+// verifyToken parses a JWT signed by the keyring, checks its signature, its exp, nbf and iat claims, the given
+// parser options and its token type, and returns its claims.
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
-// tokenString: The JWT token to be parsed.
+// keys: The Keyring holding the verification keys.
+// tokenString: The JWT token to be verified.
+// tokenType: The expected token type.
+// options: The additional checks, e.g. jwt.WithIssuer.
 //
-// Returns a jwt.MapClaims containing the claims from the token, or an error if parsing fails.
-func extractMapClaimsFromToken(logger *logrus.Logger, tokenString string) (jwt.MapClaims, error) {
-	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+// Returns the verified claims, or an error if the token is invalid, expired or of the wrong type.
+func verifyToken(logger *logrus.Logger,
+	keys *Keyring,
+	tokenString string,
+	tokenType string,
+	options ...jwt.ParserOption) (*Claims, error) {
+
+	claims := &Claims{}
+	options = append(options, jwt.WithValidMethods(supportedAlgorithms), jwt.WithIssuedAt())
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc, options...)
 	if err != nil {
-		logger.WithError(err).WithField("token", tokenString).Error("Error parsing unverified token")
+		logger.WithError(err).WithField("username", claims.Username).Warn("Error parsing the token")
 		return nil, err
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		logger.WithField("token", tokenString).Warn("Unable to retrieve claims from token")
-		return nil, errors.New("invalid token claims")
+	if !token.Valid {
+		logger.WithField("username", claims.Username).Warn("Invalid token")
+		return nil, errors.New("invalid token")
+	}
+
+	if claims.TokenType != tokenType {
+		logger.WithField("username", claims.Username).
+			Warnf("Invalid token type %q, expected %q", claims.TokenType, tokenType)
+		return nil, fmt.Errorf("invalid token type %q", claims.TokenType)
 	}
 
-	logger.WithField("token", tokenString).Info("Token mapClaims retrieved successfully")
 	return claims, nil
 }
 
-// ExtractClaimsFromToken extracts the username and expiration (exp) claims from a JWT token.
-// It parses the provided token string, retrieves the claims, and returns them as strings.
+// TokenPair holds the tokens issued by HandleTokensCreation, with what the caller must store in the session to
+// rotate the refresh token. The refresh token itself is only sent to the client.
+type TokenPair struct {
+	AccessToken      string
+	AccessTokenId    string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	SessionId        string
+	RefreshTokenHash string
+	RefreshExpiresAt time.Time
+}
+
+// AccessTokenRecord returns the record of the access token of the pair, to be stored in the denylist store so
+// the token can be denied before it expires.
 //
-// logger: A logrus.Logger instance for logging information, warnings, and errors.
-// tokenString: The JWT token to be parsed.
+// userId: The ID of the user the token was issued to.
 //
-// Returns the extracted username, expiration, and an error if extraction fails.
-func ExtractClaimsFromToken(logger *logrus.Logger, tokenString string) (string, string, error) {
-	mapClaims, err := extractMapClaimsFromToken(logger, tokenString)
-	if err != nil {
-		logger.WithError(err).WithField("token", tokenString).Error("Error extracting map claims from token")
-		return "", "", err
+// Returns the record of the access token.
+func (p *TokenPair) AccessTokenRecord(userId int) model.AccessToken {
+	return model.AccessToken{
+		ID:        p.AccessTokenId,
+		SessionID: p.SessionId,
+		UserID:    userId,
+		ExpiresAt: p.AccessExpiresAt,
 	}
-
-	username := fmt.Sprint(mapClaims["username"])
-	exp := fmt.Sprint(mapClaims["exp"])
-	logger.WithFields(logrus.Fields{
-		"token":    tokenString,
-		"username": username,
-		"exp":      exp,
-	}).Info("Token claims retrieved successfully")
-	return username, exp, nil
 }
 
 // HandleTokensCreation generates and handles the creation of access and refresh tokens for a user.
-// It creates a new access token and refresh token for the specified username and stores the refresh token in the database.
+// The access token carries the roles and effective permissions of the user, resolved from the RBACStore, and
+// expires after JWT_EXPIRATION_TIME. The refresh token is an opaque value generated by NewRefreshToken, whose
+// session expires after JWT_REFRESH_TOKEN_VALIDITY. Both tokens belong to the given session. Storing the
+// session, with the hash of the refresh token, and recording the access token are left to the caller.
+// With EMAIL_VERIFICATION_MODE set to restrict, the access token of a user whose email address is not verified
+// carries no roles and no permissions, so it only grants the routes open to every authenticated user.
 //
 // logger: A logrus.Logger instance for logging information, warnings, and errors.
-// db: A pointer to the SQL database connection.
 // cfg: A pointer to the config.Config struct which contains JWT configuration.
-// userName: The username for which tokens are being generated.
+// keys: The Keyring holding the signing key.
+// rbac: The RBACStore used to resolve the roles and permissions of the user.
+// user: The user for which tokens are being generated; its ID and Username must be set.
+// sessionId: The session of the tokens, or an empty string to start a new session at login.
 //
-// Returns the generated access token, refresh token, and an error if token creation or storage fails.
-func HandleTokensCreation(logger *logrus.Logger, db *sql.DB, cfg *config.Config, userName string) (string, string, error) {
-	var accessToken, refreshToken string
-	accessToken, err := createToken(logger, cfg, userName, cfg.JWTExpirationTime)
+// Returns the generated tokens, or an error if token creation fails.
+func HandleTokensCreation(logger *logrus.Logger,
+	cfg *config.Config,
+	keys *Keyring,
+	rbac repository.RBACStore,
+	user *model.User,
+	sessionId string) (*TokenPair, error) {
+
+	roles, err := rbac.GetUserRolesByUserId(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := rbac.GetUserPermissionsByUserId(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.EmailVerificationMode == utils.EmailVerificationRestrict && !user.EmailVerified {
+		logger.WithField("username", user.Username).Info("Email not verified, issuing an access token without permissions")
+		roles, permissions = nil, nil
+	}
+
+	if sessionId == "" {
+		sessionId, err = newTokenId()
+		if err != nil {
+			logger.WithError(err).WithField("username", user.Username).Error("Error generating the session id")
+			return nil, err
+		}
+	}
+
+	pair := &TokenPair{SessionId: sessionId}
+	accessClaims := &Claims{
+		TokenType:   utils.AccessToken,
+		SessionId:   sessionId,
+		Roles:       roles,
+		Permissions: permissions,
+	}
+	pair.AccessToken, err = createToken(logger, cfg, keys, user, accessClaims, cfg.JWTExpirationTime)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
+	pair.AccessTokenId = accessClaims.ID
+	pair.AccessExpiresAt = accessClaims.ExpiresAt.Time
 
-	refreshToken, err = createToken(logger, cfg, userName, cfg.JWTExpirationTime)
+	refreshValidity, err := utils.ParseDuration(cfg.JWTRefreshTokenValidity)
 	if err != nil {
-		return "", "", err
+		logger.WithError(err).WithField("username", user.Username).Error("Invalid refresh token validity format")
+		return nil, err
+	}
+
+	pair.RefreshToken, pair.RefreshTokenHash, err = NewRefreshToken(cfg, sessionId)
+	if err != nil {
+		logger.WithError(err).WithField("username", user.Username).Error("Error generating the refresh token")
+		return nil, err
 	}
+	pair.RefreshExpiresAt = time.Now().Add(refreshValidity)
 
-	return accessToken, refreshToken, nil
+	return pair, nil
 }
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// totpPeriod is the number of seconds of a TOTP time step
+	totpPeriod = 30
+
+	// totpDigits is the number of digits of a TOTP code
+	totpDigits = 6
+
+	// totpSkew is the number of time steps before and after the current one whose codes are accepted, to
+	// tolerate the clock drift of the authenticator app
+	totpSkew = 1
+
+	// totpSecretLength is the number of random bytes of a TOTP secret, the length of a SHA-1 HMAC key
+	totpSecretLength = 20
+
+	// recoveryCodeCount is the number of recovery codes generated when TOTP is enabled
+	recoveryCodeCount = 10
+
+	// recoveryCodeLength is the number of base32 characters of a recovery code, 50 random bits
+	recoveryCodeLength = 10
+)
+
+// totpEncoding is the encoding of the TOTP secrets expected by the authenticator apps.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// CheckTOTPEncryptionKey checks that TOTP_ENCRYPTION_KEY is set to a key of at least 32 characters other than
+// its former public default, with which anyone could decrypt the TOTP secrets of a leaked database.
+//
+// cfg: A pointer to the config.Config struct which contains the TOTP encryption key.
+//
+// Returns an error describing the problem, or nil if the key can be used.
+func CheckTOTPEncryptionKey(cfg *config.Config) error {
+	if cfg.TOTPEncryptionKey == "" || cfg.TOTPEncryptionKey == "defaultTOTPEncryptionKey" {
+		return errors.New("TOTP_ENCRYPTION_KEY must be set to a secret of your own")
+	}
+	if len(cfg.TOTPEncryptionKey) < minSecretKeyLength {
+		return fmt.Errorf("TOTP_ENCRYPTION_KEY must be at least %d characters long", minSecretKeyLength)
+	}
+	return nil
+}
+
+// NewTOTPSecret generates a TOTP secret of 160 random bits.
+//
+// Returns the secret encoded in base32, as entered in an authenticator app, and an error, if any.
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI builds the otpauth:// URI of a TOTP secret, rendered as a QR code for the authenticator apps. The
+// account is labelled with TOTP_ISSUER and the username.
+//
+// cfg: A pointer to the config.Config struct which contains the TOTP issuer.
+// username: The name of the user.
+// secret: The secret, encoded in base32.
+//
+// Returns the URI.
+func TOTPURI(cfg *config.Config, username string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", cfg.TOTPIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpPeriod))
+	label := url.PathEscape(cfg.TOTPIssuer) + ":" + url.PathEscape(username)
+	// Spaces are encoded as %20, some authenticator apps display a + literally
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// ValidateTOTPCode checks a TOTP code against a secret, accepting the codes of the current time step and of the
+// adjacent ones. The caller must record the returned step, so the code cannot be replayed.
+//
+// secret: The secret, encoded in base32.
+// code: The code given by the user.
+// now: The current time.
+//
+// Returns the time step of the code and true if it is valid, or 0 and false otherwise.
+func ValidateTOTPCode(secret string, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the HOTP code (RFC 4226) of a key for a counter, here the TOTP time step.
+func totpCode(key []byte, counter int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// EncryptTOTPSecret encrypts a TOTP secret for storage with AES-256-GCM, keyed with TOTP_ENCRYPTION_KEY. The ID
+// of the user is authenticated with it, so a secret copied to another user cannot be decrypted.
+//
+// cfg: A pointer to the config.Config struct which contains the TOTP encryption key.
+// userId: The ID of the user owning the secret.
+// secret: The secret, encoded in base32.
+//
+// Returns the nonce followed by the ciphertext, encoded in base64, and an error, if any.
+func EncryptTOTPSecret(cfg *config.Config, userId int, secret string) (string, error) {
+	aead, err := totpCipher(cfg)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(strconv.Itoa(userId)))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptTOTPSecret decrypts a TOTP secret encrypted by EncryptTOTPSecret.
+//
+// cfg: A pointer to the config.Config struct which contains the TOTP encryption key.
+// userId: The ID of the user owning the secret.
+// encrypted: The encrypted secret.
+//
+// Returns the secret, encoded in base32, or an error if it cannot be decrypted, e.g. after TOTP_ENCRYPTION_KEY
+// changed.
+func DecryptTOTPSecret(cfg *config.Config, userId int, encrypted string) (string, error) {
+	aead, err := totpCipher(cfg)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted TOTP secret too short")
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(strconv.Itoa(userId)))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// totpCipher returns the AES-256-GCM cipher of the TOTP secrets.
+func totpCipher(cfg *config.Config) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(totpKey(cfg, "totp secret encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// totpKey derives a 256-bit key for the given purpose from TOTP_ENCRYPTION_KEY, so the secrets and the recovery
+// codes are not protected by the same key.
+func totpKey(cfg *config.Config, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(cfg.TOTPEncryptionKey))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// NewRecoveryCodes generates the one-time recovery codes of a user, of the form xxxxx-xxxxx. Only the hashes
+// returned with them may be stored.
+//
+// cfg: A pointer to the config.Config struct which contains the TOTP encryption key.
+//
+// Returns the codes, their hashes as computed by HashRecoveryCode, and an error, if any.
+func NewRecoveryCodes(cfg *config.Config) ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for len(codes) < recoveryCodeCount {
+		random := make([]byte, totpEncoding.DecodedLen(recoveryCodeLength)+1)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(random))[:recoveryCodeLength]
+		code = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(cfg, code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode computes the value stored for a recovery code: its HMAC-SHA256 keyed with a key derived from
+// TOTP_ENCRYPTION_KEY, since the 50 random bits of a code could be guessed from an unkeyed hash. The code is
+// normalized first, so it can be typed in upper case, with or without the dash.
+//
+// cfg: A pointer to the config.Config struct which contains the TOTP encryption key.
+// code: The recovery code.
+//
+// Returns the hash, hex encoded.
+func HashRecoveryCode(cfg *config.Config, code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	mac := hmac.New(sha256.New, totpKey(cfg, "totp recovery code"))
+	mac.Write([]byte(normalized))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewMFAToken generates the challenge returned by a login that needs a second factor: a JWT of type
+// utils.MFAToken signed with the keyring, valid for MFA_TOKEN_VALIDITY. It only proves that the password was
+// checked, and is exchanged with a TOTP or recovery code for the access and refresh tokens.
+//
+// logger: A logrus.Logger instance for logging information, warnings, and errors.
+// cfg: A pointer to the config.Config struct which contains the JWT and MFA configuration.
+// keys: The Keyring holding the signing key.
+// user: The user who logged in; its ID and Username must be set.
+//
+// Returns the token and an error, if any.
+func NewMFAToken(logger *logrus.Logger,
+	cfg *config.Config,
+	keys *Keyring,
+	user *model.User) (string, error) {
+	return createToken(logger, cfg, keys, user, &Claims{TokenType: utils.MFAToken}, cfg.MFATokenValidity)
+}
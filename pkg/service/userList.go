@@ -0,0 +1,152 @@
+package service
+
+import (
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultUserPageSize is the number of users listed per page when the limit parameter is not set.
+	DefaultUserPageSize = 50
+
+	// MaxUserPageSize is the largest number of users listed per page.
+	MaxUserPageSize = 200
+)
+
+// userCursor is the content of the opaque cursors of the user listing. It records the sort order it was issued
+// for, so it cannot be used with another one.
+type userCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+	Value      string `json:"v,omitempty"`
+	ID         int    `json:"i"`
+}
+
+// ParseUserQuery reads the query of the user listing from the query parameters of a request: role, country,
+// status (active, disabled or deleted; the deleted users are only listed when it is deleted), q (a substring of
+// the username or email), created_from and created_to (RFC 3339 times), sort (id, username, email, country or
+// date_created), order (asc or desc), limit (1 to MaxUserPageSize) and cursor (the next_cursor of the previous
+// page).
+//
+// values: The query parameters.
+//
+// Returns the query, with the page size as Limit, and the invalid parameters mapped to the reason they are
+// rejected, or an empty map if every parameter is valid.
+func ParseUserQuery(values url.Values) (model.UserQuery, map[string]string) {
+	fields := make(map[string]string)
+	query := model.UserQuery{
+		Role:    values.Get("role"),
+		Country: values.Get("country"),
+		Search:  values.Get("q"),
+		Sort:    utils.UserSortID,
+		Limit:   DefaultUserPageSize,
+	}
+
+	switch status := values.Get("status"); status {
+	case "":
+	case utils.UserStatusActive, utils.UserStatusDisabled, utils.UserStatusDeleted:
+		query.Status = status
+	default:
+		fields["status"] = "must be active, disabled or deleted"
+	}
+
+	for _, name := range []string{"created_from", "created_to"} {
+		if values.Get(name) == "" {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339, values.Get(name))
+		if err != nil {
+			fields[name] = "must be an RFC 3339 time, e.g. 2024-01-01T00:00:00Z"
+		} else if name == "created_from" {
+			query.CreatedFrom = created
+		} else {
+			query.CreatedTo = created
+		}
+	}
+
+	switch sort := values.Get("sort"); sort {
+	case "":
+	case utils.UserSortID, utils.UserSortUsername, utils.UserSortEmail, utils.UserSortCountry,
+		utils.UserSortDateCreated:
+		query.Sort = sort
+	default:
+		fields["sort"] = "must be id, username, email, country or date_created"
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		fields["order"] = "must be asc or desc"
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > MaxUserPageSize {
+			fields["limit"] = "must be a number between 1 and " + strconv.Itoa(MaxUserPageSize)
+		} else {
+			query.Limit = parsed
+		}
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := decodeUserCursor(cursor, query.Sort, query.Descending)
+		if err != nil {
+			fields["cursor"] = "must be the next_cursor of a listing with the same sort and order"
+		} else {
+			query.After = after
+		}
+	}
+	return query, fields
+}
+
+// NextUserCursor returns the cursor of the page following a user, to send back as the cursor parameter.
+//
+// query: The query of the listing.
+// last: The last user of the current page.
+//
+// Returns the opaque cursor.
+func NextUserCursor(query model.UserQuery, last model.UserSummary) string {
+	cursor := userCursor{Sort: query.Sort, Descending: query.Descending, ID: last.ID}
+	switch query.Sort {
+	case utils.UserSortUsername:
+		cursor.Value = last.Username
+	case utils.UserSortEmail:
+		cursor.Value = last.Email
+	case utils.UserSortCountry:
+		cursor.Value = last.Country
+	case utils.UserSortDateCreated:
+		cursor.Value = last.DateCreated.UTC().Format(time.RFC3339Nano)
+	}
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeUserCursor reads a cursor returned by NextUserCursor and checks that it was issued for the given sort
+// order.
+func decodeUserCursor(value string, sort string, descending bool) (*model.UserCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor userCursor
+	if err := json.Unmarshal(decoded, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Sort != sort || cursor.Descending != descending {
+		return nil, errors.New("cursor issued for another sort order")
+	}
+	if sort == utils.UserSortDateCreated {
+		if _, err := time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+			return nil, err
+		}
+	}
+	return &model.UserCursor{Value: cursor.Value, ID: cursor.ID}, nil
+}
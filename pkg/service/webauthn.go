@@ -0,0 +1,484 @@
+package service
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/utils"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// webAuthnChallengeLength is the number of random bytes of a WebAuthn challenge
+	webAuthnChallengeLength = 32
+
+	// maxWebAuthnCredentialIdLength is the maximum length of a credential ID allowed by the WebAuthn specification
+	maxWebAuthnCredentialIdLength = 1023
+
+	// minWebAuthnRSAKeyBits is the minimum size of an RSA credential public key
+	minWebAuthnRSAKeyBits = 2048
+
+	// Flags of the authenticator data: user present, user verified, attested credential data and extension data
+	webAuthnFlagUserPresent  = 0x01
+	webAuthnFlagUserVerified = 0x04
+	webAuthnFlagAttested     = 0x40
+	webAuthnFlagExtensions   = 0x80
+
+	// COSE algorithms of the credential public keys accepted: ES256, EdDSA (Ed25519) and RS256
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// webAuthnEncoding is the encoding of the binary fields of the WebAuthn JSON messages.
+var webAuthnEncoding = base64.RawURLEncoding
+
+// ErrWebAuthnSignCount is returned by VerifyWebAuthnLogin when the signature counter of the authenticator did not
+// increase, a sign that the credential may have been cloned.
+var ErrWebAuthnSignCount = errors.New("WebAuthn signature counter did not increase, the authenticator may be cloned")
+
+// WebAuthnCredentialDescriptor identifies a credential in the options of a ceremony.
+type WebAuthnCredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// WebAuthnCreationOptions are the options of a registration ceremony, in the JSON form of the
+// PublicKeyCredentialCreationOptions passed to navigator.credentials.create().
+type WebAuthnCreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64  `json:"timeout"`
+	Attestation            string `json:"attestation"`
+	AuthenticatorSelection struct {
+		ResidentKey        string `json:"residentKey"`
+		RequireResidentKey bool   `json:"requireResidentKey"`
+		UserVerification   string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	ExcludeCredentials []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+}
+
+// WebAuthnRequestOptions are the options of a login ceremony, in the JSON form of the
+// PublicKeyCredentialRequestOptions passed to navigator.credentials.get().
+type WebAuthnRequestOptions struct {
+	Challenge        string                         `json:"challenge"`
+	Timeout          int64                          `json:"timeout"`
+	RPID             string                         `json:"rpId"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+// WebAuthnRegistrationResponse is the credential created by an authenticator, in the JSON form of the
+// PublicKeyCredential returned by navigator.credentials.create(). The binary fields are base64url-encoded.
+type WebAuthnRegistrationResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// WebAuthnLoginResponse is the assertion signed by an authenticator, in the JSON form of the PublicKeyCredential
+// returned by navigator.credentials.get(). The binary fields are base64url-encoded.
+type WebAuthnLoginResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// NewWebAuthnChallenge generates the challenge of a WebAuthn ceremony, 256 random bits.
+//
+// Returns the base64url-encoded challenge, sent to the client, its hash as computed by HashWebAuthnChallenge,
+// which is stored, and an error, if any.
+func NewWebAuthnChallenge() (string, string, error) {
+	random := make([]byte, webAuthnChallengeLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	challenge := webAuthnEncoding.EncodeToString(random)
+	return challenge, HashWebAuthnChallenge(challenge), nil
+}
+
+// HashWebAuthnChallenge computes the value stored for a WebAuthn challenge, so a leaked challenge table cannot be
+// used to answer pending ceremonies.
+//
+// challenge: The base64url-encoded challenge.
+//
+// Returns the SHA-256 of the challenge, hex encoded.
+func HashWebAuthnChallenge(challenge string) string {
+	sum := sha256.Sum256([]byte(challenge))
+	return hex.EncodeToString(sum[:])
+}
+
+// WebAuthnUserHandle returns the user handle of a user, the opaque ID under which its credentials are stored by
+// the authenticators and returned by discoverable logins: the base64url-encoded decimal ID of the user.
+//
+// userId: The ID of the user.
+//
+// Returns the base64url-encoded user handle.
+func WebAuthnUserHandle(userId int) string {
+	return webAuthnEncoding.EncodeToString([]byte(strconv.Itoa(userId)))
+}
+
+// WebAuthnChallengeExpiry returns when a challenge issued now expires, after WEBAUTHN_CHALLENGE_VALIDITY.
+//
+// cfg: A pointer to the config.Config struct which contains the WebAuthn configuration.
+//
+// Returns the expiry time, and an error if WEBAUTHN_CHALLENGE_VALIDITY is invalid.
+func WebAuthnChallengeExpiry(cfg *config.Config) (time.Time, error) {
+	validity, err := utils.ParseDuration(cfg.WebAuthnChallengeValidity)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(validity), nil
+}
+
+// NewWebAuthnCreationOptions builds the options of the registration of a passkey. A discoverable credential and
+// user verification are required, so the passkey alone is enough to log in, and no attestation is requested.
+//
+// cfg: A pointer to the config.Config struct which contains the WebAuthn configuration.
+// user: The user registering the passkey; its ID and Username must be set.
+// challenge: The base64url-encoded challenge of the ceremony.
+// existing: The credentials the user already registered, which the authenticator must not register again.
+//
+// Returns the options, and an error if WEBAUTHN_CHALLENGE_VALIDITY is invalid.
+func NewWebAuthnCreationOptions(cfg *config.Config,
+	user *model.User,
+	challenge string,
+	existing []model.WebAuthnCredential) (*WebAuthnCreationOptions, error) {
+	validity, err := utils.ParseDuration(cfg.WebAuthnChallengeValidity)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &WebAuthnCreationOptions{Challenge: challenge, Timeout: validity.Milliseconds(), Attestation: "none"}
+	options.RP.ID = cfg.WebAuthnRPID
+	options.RP.Name = cfg.WebAuthnRPName
+	options.User.ID = WebAuthnUserHandle(user.ID)
+	options.User.Name = user.Username
+	options.User.DisplayName = user.Username
+	for _, alg := range []int{coseAlgES256, coseAlgEdDSA, coseAlgRS256} {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{"public-key", alg})
+	}
+	options.AuthenticatorSelection.ResidentKey = "required"
+	options.AuthenticatorSelection.RequireResidentKey = true
+	options.AuthenticatorSelection.UserVerification = "required"
+	options.ExcludeCredentials = []WebAuthnCredentialDescriptor{}
+	for _, credential := range existing {
+		options.ExcludeCredentials = append(options.ExcludeCredentials,
+			WebAuthnCredentialDescriptor{Type: "public-key", ID: credential.ID})
+	}
+	return options, nil
+}
+
+// NewWebAuthnRequestOptions builds the options of a login with a passkey. No credential is listed: the user
+// picks a discoverable credential, which tells who logs in.
+//
+// cfg: A pointer to the config.Config struct which contains the WebAuthn configuration.
+// challenge: The base64url-encoded challenge of the ceremony.
+//
+// Returns the options, and an error if WEBAUTHN_CHALLENGE_VALIDITY is invalid.
+func NewWebAuthnRequestOptions(cfg *config.Config, challenge string) (*WebAuthnRequestOptions, error) {
+	validity, err := utils.ParseDuration(cfg.WebAuthnChallengeValidity)
+	if err != nil {
+		return nil, err
+	}
+	return &WebAuthnRequestOptions{
+		Challenge:        challenge,
+		Timeout:          validity.Milliseconds(),
+		RPID:             cfg.WebAuthnRPID,
+		AllowCredentials: []WebAuthnCredentialDescriptor{},
+		UserVerification: "required",
+	}, nil
+}
+
+// VerifyWebAuthnRegistration verifies a credential created by an authenticator: the client data must be of a
+// registration made from one of WEBAUTHN_ORIGINS, and the authenticator data must be scoped to WEBAUTHN_RP_ID,
+// with the user present and verified, and hold a public key of a supported algorithm. The attestation statement is
+// not verified, as none is requested. The caller must consume the returned challenge, so it is only used once.
+//
+// cfg: A pointer to the config.Config struct which contains the WebAuthn configuration.
+// response: The credential created by the authenticator.
+//
+// Returns the credential to store, with its ID, PublicKey and SignCount set, the hash of the challenge it
+// answers, and an error if it is invalid.
+func VerifyWebAuthnRegistration(cfg *config.Config,
+	response *WebAuthnRegistrationResponse) (*model.WebAuthnCredential, string, error) {
+	if response.Type != "public-key" {
+		return nil, "", errors.New("not a public key credential")
+	}
+	challengeHash, _, err := verifyWebAuthnClientData(cfg, response.Response.ClientDataJSON, "webauthn.create")
+	if err != nil {
+		return nil, "", err
+	}
+
+	attestationObject, err := webAuthnEncoding.DecodeString(response.Response.AttestationObject)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid attestation object encoding: %w", err)
+	}
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid attestation object: %w", err)
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, "", errors.New("attestation object is not a map")
+	}
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, "", errors.New("attestation object without authenticator data")
+	}
+
+	signCount, flags, rest, err := parseWebAuthnAuthenticatorData(cfg, authData)
+	if err != nil {
+		return nil, "", err
+	}
+	if flags&webAuthnFlagAttested == 0 {
+		return nil, "", errors.New("authenticator data without attested credential data")
+	}
+
+	// Attested credential data: AAGUID, length of the credential ID, credential ID and COSE public key
+	if len(rest) < 18 {
+		return nil, "", errors.New("truncated attested credential data")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if idLength == 0 || idLength > maxWebAuthnCredentialIdLength || idLength > len(rest) {
+		return nil, "", errors.New("invalid credential ID length")
+	}
+	credentialId := rest[:idLength]
+	rest = rest[idLength:]
+	if response.ID != webAuthnEncoding.EncodeToString(credentialId) {
+		return nil, "", errors.New("credential ID does not match the authenticator data")
+	}
+
+	_, keyRest, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid credential public key: %w", err)
+	}
+	publicKey := rest[:len(rest)-len(keyRest)]
+	if len(keyRest) > 0 && flags&webAuthnFlagExtensions == 0 {
+		return nil, "", errors.New("unexpected data after the credential public key")
+	}
+	if _, _, err = parseCOSEKey(publicKey); err != nil {
+		return nil, "", err
+	}
+
+	return &model.WebAuthnCredential{
+		ID:        response.ID,
+		PublicKey: append([]byte(nil), publicKey...),
+		SignCount: signCount,
+	}, challengeHash, nil
+}
+
+// VerifyWebAuthnLogin verifies an assertion signed by an authenticator with a registered credential: the client
+// data must be of a login made from one of WEBAUTHN_ORIGINS, the authenticator data must be scoped to
+// WEBAUTHN_RP_ID, with the user present and verified, the signature must be valid for the public key of the
+// credential, and the signature counter must have increased, unless the authenticator does not implement one.
+// The caller must consume the returned challenge, so it is only used once, and store the new signature counter.
+//
+// cfg: A pointer to the config.Config struct which contains the WebAuthn configuration.
+// response: The assertion signed by the authenticator.
+// credential: The registered credential whose ID is the ID of the assertion.
+//
+// Returns the new signature counter, the hash of the challenge the assertion answers, and an error if it is
+// invalid, ErrWebAuthnSignCount if only the signature counter is wrong.
+func VerifyWebAuthnLogin(cfg *config.Config,
+	response *WebAuthnLoginResponse,
+	credential *model.WebAuthnCredential) (uint32, string, error) {
+	if response.Type != "public-key" || response.ID != credential.ID {
+		return 0, "", errors.New("assertion not made with the credential")
+	}
+	challengeHash, clientDataHash, err := verifyWebAuthnClientData(cfg, response.Response.ClientDataJSON, "webauthn.get")
+	if err != nil {
+		return 0, "", err
+	}
+
+	authData, err := webAuthnEncoding.DecodeString(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid authenticator data encoding: %w", err)
+	}
+	signature, err := webAuthnEncoding.DecodeString(response.Response.Signature)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	signCount, _, _, err := parseWebAuthnAuthenticatorData(cfg, authData)
+	if err != nil {
+		return 0, "", err
+	}
+
+	publicKey, alg, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return 0, "", err
+	}
+	signed := append(append([]byte(nil), authData...), clientDataHash...)
+	if !verifyCOSESignature(publicKey, alg, signed, signature) {
+		return 0, "", errors.New("invalid assertion signature")
+	}
+
+	if (signCount != 0 || credential.SignCount != 0) && signCount <= credential.SignCount {
+		return 0, "", ErrWebAuthnSignCount
+	}
+	return signCount, challengeHash, nil
+}
+
+// verifyWebAuthnClientData checks the client data of a ceremony: its type and its origin, one of
+// WEBAUTHN_ORIGINS.
+//
+// cfg: A pointer to the config.Config struct which contains the WebAuthn configuration.
+// encoded: The base64url-encoded client data JSON.
+// ceremonyType: The expected type, webauthn.create or webauthn.get.
+//
+// Returns the hash of the challenge, the SHA-256 of the client data signed by the authenticator, and an error if
+// the client data is invalid.
+func verifyWebAuthnClientData(cfg *config.Config, encoded string, ceremonyType string) (string, []byte, error) {
+	clientDataJSON, err := webAuthnEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid client data encoding: %w", err)
+	}
+	var clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err = json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return "", nil, fmt.Errorf("invalid client data: %w", err)
+	}
+	if clientData.Type != ceremonyType {
+		return "", nil, fmt.Errorf("unexpected client data type %q", clientData.Type)
+	}
+	if clientData.CrossOrigin || !webAuthnOriginAllowed(cfg, clientData.Origin) {
+		return "", nil, fmt.Errorf("origin %q not allowed", clientData.Origin)
+	}
+	if clientData.Challenge == "" {
+		return "", nil, errors.New("client data without challenge")
+	}
+
+	sum := sha256.Sum256(clientDataJSON)
+	return HashWebAuthnChallenge(clientData.Challenge), sum[:], nil
+}
+
+// webAuthnOriginAllowed reports whether an origin is one of the comma-separated WEBAUTHN_ORIGINS.
+func webAuthnOriginAllowed(cfg *config.Config, origin string) bool {
+	for _, allowed := range strings.Split(cfg.WebAuthnOrigins, ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWebAuthnAuthenticatorData checks the fixed part of authenticator data: the hash of the relying party ID
+// must be the one of WEBAUTHN_RP_ID and the user must be present and verified.
+//
+// Returns the signature counter, the flags, the data following the fixed part, and an error if the data is
+// invalid.
+func parseWebAuthnAuthenticatorData(cfg *config.Config, authData []byte) (uint32, byte, []byte, error) {
+	if len(authData) < 37 {
+		return 0, 0, nil, errors.New("truncated authenticator data")
+	}
+	rpIdHash := sha256.Sum256([]byte(cfg.WebAuthnRPID))
+	if !bytes.Equal(authData[:32], rpIdHash[:]) {
+		return 0, 0, nil, errors.New("authenticator data of another relying party")
+	}
+	flags := authData[32]
+	if flags&webAuthnFlagUserPresent == 0 || flags&webAuthnFlagUserVerified == 0 {
+		return 0, 0, nil, errors.New("user not present or not verified")
+	}
+	return binary.BigEndian.Uint32(authData[33:37]), flags, authData[37:], nil
+}
+
+// parseCOSEKey parses a COSE-encoded credential public key (RFC 9053): an EC2 P-256 key for ES256, an OKP Ed25519
+// key for EdDSA or an RSA key of at least 2048 bits for RS256.
+//
+// Returns the public key, its COSE algorithm, and an error if the key is invalid or unsupported.
+func parseCOSEKey(data []byte) (interface{}, int64, error) {
+	decoded, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid credential public key: %w", err)
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok || len(rest) > 0 {
+		return nil, 0, errors.New("credential public key is not a COSE key")
+	}
+	keyType, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+	curve, _ := key[int64(-1)].(int64)
+	x, _ := key[int64(-2)].([]byte)
+	y, _ := key[int64(-3)].([]byte)
+
+	switch {
+	case alg == coseAlgES256 && keyType == 2 && curve == 1 && len(x) == 32 && len(y) == 32:
+		publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, 0, errors.New("credential public key not on the P-256 curve")
+		}
+		return publicKey, alg, nil
+	case alg == coseAlgEdDSA && keyType == 1 && curve == 6 && len(x) == ed25519.PublicKeySize:
+		return ed25519.PublicKey(x), alg, nil
+	case alg == coseAlgRS256 && keyType == 3:
+		// The modulus and the exponent of an RSA key are labelled -1 and -2
+		modulus, _ := key[int64(-1)].([]byte)
+		exponent, _ := key[int64(-2)].([]byte)
+		n := new(big.Int).SetBytes(modulus)
+		e := new(big.Int).SetBytes(exponent)
+		if n.BitLen() < minWebAuthnRSAKeyBits || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, 0, errors.New("invalid RSA credential public key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported credential public key, algorithm %d", alg)
+}
+
+// verifyCOSESignature verifies the signature of data with a public key parsed by parseCOSEKey.
+func verifyCOSESignature(publicKey interface{}, alg int64, data []byte, signature []byte) bool {
+	switch alg {
+	case coseAlgES256:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), digest[:], signature)
+	case coseAlgEdDSA:
+		return ed25519.Verify(publicKey.(ed25519.PublicKey), data, signature)
+	case coseAlgRS256:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
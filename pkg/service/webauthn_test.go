@@ -0,0 +1,232 @@
+package service_test
+
+import (
+	"GolandRestApi/pkg/config"
+	"GolandRestApi/pkg/model"
+	"GolandRestApi/pkg/service"
+	"GolandRestApi/pkg/service/webauthntest"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const webAuthnOrigin = "https://example.com"
+
+func webAuthnConfig() *config.Config {
+	return &config.Config{
+		WebAuthnRPID:              "example.com",
+		WebAuthnRPName:            "Example",
+		WebAuthnOrigins:           "https://app.example.com, " + webAuthnOrigin,
+		WebAuthnChallengeValidity: "5m",
+	}
+}
+
+// register runs a registration ceremony with the authenticator and returns the verified credential.
+func register(t *testing.T, cfg *config.Config, authenticator *webauthntest.Authenticator) *model.WebAuthnCredential {
+	t.Helper()
+	challenge, challengeHash, err := service.NewWebAuthnChallenge()
+	if err != nil {
+		t.Fatalf("NewWebAuthnChallenge: %v", err)
+	}
+	options, err := service.NewWebAuthnCreationOptions(cfg, &model.User{ID: 7, Username: "alice"}, challenge, nil)
+	if err != nil {
+		t.Fatalf("NewWebAuthnCreationOptions: %v", err)
+	}
+	response, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	credential, answered, err := service.VerifyWebAuthnRegistration(cfg, response)
+	if err != nil {
+		t.Fatalf("VerifyWebAuthnRegistration: %v", err)
+	}
+	if answered != challengeHash {
+		t.Fatalf("challenge of the registration = %s, want %s", answered, challengeHash)
+	}
+	return credential
+}
+
+// assertion signs an assertion with the authenticator for a new login challenge and returns it with the hash of
+// the challenge.
+func assertion(t *testing.T, cfg *config.Config, authenticator *webauthntest.Authenticator) (*service.WebAuthnLoginResponse, string) {
+	t.Helper()
+	challenge, challengeHash, err := service.NewWebAuthnChallenge()
+	if err != nil {
+		t.Fatalf("NewWebAuthnChallenge: %v", err)
+	}
+	options, err := service.NewWebAuthnRequestOptions(cfg, challenge)
+	if err != nil {
+		t.Fatalf("NewWebAuthnRequestOptions: %v", err)
+	}
+	response, err := authenticator.Get(options)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return response, challengeHash
+}
+
+func newAuthenticator(t *testing.T, signCount uint32) *webauthntest.Authenticator {
+	t.Helper()
+	authenticator, err := webauthntest.New(webAuthnOrigin)
+	if err != nil {
+		t.Fatalf("webauthntest.New: %v", err)
+	}
+	authenticator.SignCount = signCount
+	return authenticator
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	cfg := webAuthnConfig()
+	authenticator := newAuthenticator(t, 10)
+	credential := register(t, cfg, authenticator)
+	if credential.ID != base64.RawURLEncoding.EncodeToString(authenticator.CredentialID) || credential.SignCount != 10 ||
+		len(credential.PublicKey) == 0 {
+		t.Fatalf("registered credential = %+v, want the credential of the authenticator", credential)
+	}
+
+	for want := uint32(11); want <= 12; want++ {
+		response, challengeHash := assertion(t, cfg, authenticator)
+		signCount, answered, err := service.VerifyWebAuthnLogin(cfg, response, credential)
+		if err != nil {
+			t.Fatalf("VerifyWebAuthnLogin: %v", err)
+		}
+		if signCount != want || answered != challengeHash {
+			t.Errorf("VerifyWebAuthnLogin = %d, %s; want %d, %s", signCount, answered, want, challengeHash)
+		}
+		credential.SignCount = signCount
+	}
+}
+
+func TestWebAuthnLoginWithoutSignCount(t *testing.T) {
+	cfg := webAuthnConfig()
+	authenticator := newAuthenticator(t, 0)
+	credential := register(t, cfg, authenticator)
+
+	for i := 0; i < 2; i++ {
+		response, _ := assertion(t, cfg, authenticator)
+		if signCount, _, err := service.VerifyWebAuthnLogin(cfg, response, credential); err != nil || signCount != 0 {
+			t.Errorf("VerifyWebAuthnLogin = %d, %v; want 0 for an authenticator without a counter", signCount, err)
+		}
+	}
+}
+
+func TestWebAuthnRejectsOrigin(t *testing.T) {
+	cfg := webAuthnConfig()
+	authenticator := newAuthenticator(t, 1)
+	authenticator.Origin = "https://evil.example"
+	challenge, _, _ := service.NewWebAuthnChallenge()
+	options, _ := service.NewWebAuthnCreationOptions(cfg, &model.User{ID: 7, Username: "alice"}, challenge, nil)
+	response, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, _, err = service.VerifyWebAuthnRegistration(cfg, response); err == nil {
+		t.Error("registration from another origin accepted")
+	}
+
+	authenticator.Origin = webAuthnOrigin
+	credential := register(t, cfg, authenticator)
+	authenticator.Origin = "https://example.com.evil.example"
+	login, _ := assertion(t, cfg, authenticator)
+	if _, _, err = service.VerifyWebAuthnLogin(cfg, login, credential); err == nil {
+		t.Error("login from another origin accepted")
+	}
+}
+
+func TestWebAuthnRejectsRelyingParty(t *testing.T) {
+	cfg := webAuthnConfig()
+	authenticator := newAuthenticator(t, 1)
+	challenge, _, _ := service.NewWebAuthnChallenge()
+	options, _ := service.NewWebAuthnCreationOptions(cfg, &model.User{ID: 7, Username: "alice"}, challenge, nil)
+	options.RP.ID = "evil.example"
+	response, err := authenticator.Create(options)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, _, err = service.VerifyWebAuthnRegistration(cfg, response); err == nil {
+		t.Error("registration scoped to another relying party accepted")
+	}
+}
+
+func TestWebAuthnRejectsChangedChallenge(t *testing.T) {
+	cfg := webAuthnConfig()
+	authenticator := newAuthenticator(t, 1)
+	credential := register(t, cfg, authenticator)
+	response, challengeHash := assertion(t, cfg, authenticator)
+
+	// The client data is signed with the authenticator data, so its challenge cannot be swapped for another one
+	var clientData map[string]interface{}
+	clientDataJSON, _ := base64.RawURLEncoding.DecodeString(response.Response.ClientDataJSON)
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		t.Fatalf("decoding the client data: %v", err)
+	}
+	other, otherHash, _ := service.NewWebAuthnChallenge()
+	for _, challenge := range []string{other, ""} {
+		clientData["challenge"] = challenge
+		changed, _ := json.Marshal(clientData)
+		forged := *response
+		forged.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(changed)
+		if _, answered, err := service.VerifyWebAuthnLogin(cfg, &forged, credential); err == nil {
+			t.Errorf("assertion with the challenge changed to %q accepted, answering %s instead of %s (%s)",
+				challenge, answered, challengeHash, otherHash)
+		}
+	}
+}
+
+func TestWebAuthnRejectsSignCountRollback(t *testing.T) {
+	cfg := webAuthnConfig()
+	authenticator := newAuthenticator(t, 10)
+	credential := register(t, cfg, authenticator)
+
+	response, _ := assertion(t, cfg, authenticator)
+	signCount, _, err := service.VerifyWebAuthnLogin(cfg, response, credential)
+	if err != nil {
+		t.Fatalf("VerifyWebAuthnLogin: %v", err)
+	}
+	credential.SignCount = signCount
+
+	// A replayed assertion, and one of a clone whose counter is behind, do not increase the counter
+	if _, _, err = service.VerifyWebAuthnLogin(cfg, response, credential); !errors.Is(err, service.ErrWebAuthnSignCount) {
+		t.Errorf("replayed assertion: %v, want ErrWebAuthnSignCount", err)
+	}
+	authenticator.SignCount = 4
+	rolledBack, _ := assertion(t, cfg, authenticator)
+	if _, _, err = service.VerifyWebAuthnLogin(cfg, rolledBack, credential); !errors.Is(err, service.ErrWebAuthnSignCount) {
+		t.Errorf("assertion with a lower counter: %v, want ErrWebAuthnSignCount", err)
+	}
+}
+
+func TestWebAuthnRejectsTamperedAssertion(t *testing.T) {
+	cfg := webAuthnConfig()
+	authenticator := newAuthenticator(t, 1)
+	credential := register(t, cfg, authenticator)
+	response, _ := assertion(t, cfg, authenticator)
+
+	tamper := func(encoded string, index int) string {
+		data, _ := base64.RawURLEncoding.DecodeString(encoded)
+		data[index] ^= 0x01
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signature := *response
+	signature.Response.Signature = tamper(response.Response.Signature, 10)
+	authData := *response
+	authData.Response.AuthenticatorData = tamper(response.Response.AuthenticatorData, 36)
+	otherCredential := *credential
+	otherCredential.PublicKey = register(t, cfg, newAuthenticator(t, 1)).PublicKey
+
+	for name, test := range map[string]struct {
+		response   *service.WebAuthnLoginResponse
+		credential *model.WebAuthnCredential
+	}{
+		"signature":          {&signature, credential},
+		"authenticator data": {&authData, credential},
+		"public key":         {response, &otherCredential},
+	} {
+		_, _, err := service.VerifyWebAuthnLogin(cfg, test.response, test.credential)
+		if err == nil || errors.Is(err, service.ErrWebAuthnSignCount) {
+			t.Errorf("assertion with a tampered %s: %v, want an invalid signature", name, err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Package webauthntest provides a software WebAuthn authenticator, to exercise the passkey registration and login
+// endpoints without a browser or a security key.
+package webauthntest
+
+import (
+	"GolandRestApi/pkg/service"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// Authenticator is a software authenticator holding a single discoverable ES256 credential. Its user presence
+// and verification flags are always set; its signature counter is incremented by every assertion.
+type Authenticator struct {
+	// Origin is the origin reported in the client data, which must be one of WEBAUTHN_ORIGINS
+	Origin string
+
+	// SignCount is the signature counter of the credential; set it to 0 to emulate an authenticator without one
+	SignCount uint32
+
+	// CredentialID and UserHandle are set by Create
+	CredentialID []byte
+	UserHandle   []byte
+
+	rpId string
+	key  *ecdsa.PrivateKey
+}
+
+// encoding is the encoding of the binary fields of the WebAuthn JSON messages.
+var encoding = base64.RawURLEncoding
+
+// New creates an authenticator with a new P-256 key.
+//
+// origin: The origin reported in the client data.
+//
+// Returns the authenticator and an error, if any.
+func New(origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Authenticator{Origin: origin, key: key}, nil
+}
+
+// Create registers the credential of the authenticator with the options of a registration ceremony, like
+// navigator.credentials.create().
+//
+// options: The options returned by the server.
+//
+// Returns the created credential, to send back to the server, and an error, if any.
+func (a *Authenticator) Create(options *service.WebAuthnCreationOptions) (*service.WebAuthnRegistrationResponse, error) {
+	userHandle, err := encoding.DecodeString(options.User.ID)
+	if err != nil {
+		return nil, err
+	}
+	supported := false
+	for _, param := range options.PubKeyCredParams {
+		supported = supported || param.Alg == -7
+	}
+	if !supported {
+		return nil, errors.New("ES256 not offered by the relying party")
+	}
+
+	credentialId := make([]byte, 32)
+	if _, err = rand.Read(credentialId); err != nil {
+		return nil, err
+	}
+	a.rpId, a.CredentialID, a.UserHandle = options.RP.ID, credentialId, userHandle
+
+	// Attested credential data: a zero AAGUID, the credential ID and its COSE public key
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(credentialId)))
+	attested = append(attested, credentialId...)
+	attested = append(attested, a.publicKey()...)
+	authData := append(a.authenticatorData(0x01|0x04|0x40), attested...)
+
+	attestationObject := encodeMap(map[interface{}][]byte{
+		"fmt":      encodeText("none"),
+		"attStmt":  encodeMap(map[interface{}][]byte{}),
+		"authData": encodeBytes(authData),
+	})
+	clientData, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &service.WebAuthnRegistrationResponse{ID: encoding.EncodeToString(credentialId), Type: "public-key"}
+	response.Response.ClientDataJSON = encoding.EncodeToString(clientData)
+	response.Response.AttestationObject = encoding.EncodeToString(attestationObject)
+	return response, nil
+}
+
+// Get signs an assertion with the credential of the authenticator for the options of a login ceremony, like
+// navigator.credentials.get().
+//
+// options: The options returned by the server.
+//
+// Returns the assertion, to send back to the server, and an error, if any.
+func (a *Authenticator) Get(options *service.WebAuthnRequestOptions) (*service.WebAuthnLoginResponse, error) {
+	if a.CredentialID == nil {
+		return nil, errors.New("no credential created")
+	}
+	if options.RPID != a.rpId {
+		return nil, errors.New("credential of another relying party")
+	}
+
+	if a.SignCount != 0 {
+		a.SignCount++
+	}
+	authData := a.authenticatorData(0x01 | 0x04)
+	clientData, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	response := &service.WebAuthnLoginResponse{ID: encoding.EncodeToString(a.CredentialID), Type: "public-key"}
+	response.Response.ClientDataJSON = encoding.EncodeToString(clientData)
+	response.Response.AuthenticatorData = encoding.EncodeToString(authData)
+	response.Response.Signature = encoding.EncodeToString(signature)
+	response.Response.UserHandle = encoding.EncodeToString(a.UserHandle)
+	return response, nil
+}
+
+// authenticatorData builds the fixed part of the authenticator data with the given flags.
+func (a *Authenticator) authenticatorData(flags byte) []byte {
+	rpIdHash := sha256.Sum256([]byte(a.rpId))
+	data := append(rpIdHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.SignCount)
+	return data
+}
+
+// clientData builds the client data JSON of a ceremony.
+func (a *Authenticator) clientData(ceremonyType string, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":        ceremonyType,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+// publicKey encodes the public key of the authenticator as a COSE EC2 key.
+func (a *Authenticator) publicKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	return encodeMap(map[interface{}][]byte{
+		int64(1):  encodeInt(2),  // kty: EC2
+		int64(3):  encodeInt(-7), // alg: ES256
+		int64(-1): encodeInt(1),  // crv: P-256
+		int64(-2): encodeBytes(x),
+		int64(-3): encodeBytes(y),
+	})
+}
+
+// encodeHead encodes the head of a CBOR item of a major type.
+func encodeHead(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, argument)
+}
+
+// encodeInt encodes a CBOR integer.
+func encodeInt(value int64) []byte {
+	if value < 0 {
+		return encodeHead(1, uint64(-1-value))
+	}
+	return encodeHead(0, uint64(value))
+}
+
+// encodeBytes encodes a CBOR byte string.
+func encodeBytes(value []byte) []byte {
+	return append(encodeHead(2, uint64(len(value))), value...)
+}
+
+// encodeText encodes a CBOR text string.
+func encodeText(value string) []byte {
+	return append(encodeHead(3, uint64(len(value))), value...)
+}
+
+// encodeMap encodes a CBOR map of encoded values, keyed by int64 or string, in the canonical order of the keys.
+func encodeMap(entries map[interface{}][]byte) []byte {
+	keys := make([][]byte, 0, len(entries))
+	values := make(map[string][]byte, len(entries))
+	for key, value := range entries {
+		var encoded []byte
+		switch key := key.(type) {
+		case int64:
+			encoded = encodeInt(key)
+		case string:
+			encoded = encodeText(key)
+		}
+		keys = append(keys, encoded)
+		values[string(encoded)] = value
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return string(keys[i]) < string(keys[j])
+	})
+
+	encoded := encodeHead(5, uint64(len(entries)))
+	for _, key := range keys {
+		encoded = append(encoded, key...)
+		encoded = append(encoded, values[string(key)]...)
+	}
+	return encoded
+}
@@ -7,4 +7,88 @@ const (
 
 	AdminRole = "admin"
 	UserRole  = "user"
+
+	// Permissions required by the routes of the API
+	PermissionUserCreate = "user:create"
+	PermissionUserRead   = "user:read"
+	PermissionUserDelete = "user:delete"
+	PermissionRoleRead   = "role:read"
+	PermissionRoleWrite  = "role:write"
+	PermissionRoleAssign = "role:assign"
+	PermissionKeyManage  = "key:manage"
+	PermissionUserUnlock = "user:unlock"
+	PermissionUserUpdate = "user:update"
+
+	PermissionSessionRevoke = "session:revoke"
+
+	PermissionOAuthClientManage = "oauth:client"
+
+	PermissionAPIKeyManage = "apikey:manage"
+
+	// Value of the typ claim of the access tokens. Refresh tokens are opaque and the refresh JWTs issued by
+	// earlier versions carry another type, so they are never accepted as access tokens.
+	AccessToken = "access"
+
+	// Value of the typ claim of the tokens sent to verify an email address.
+	EmailVerificationToken = "email_verification"
+
+	// Value of the typ claim of the challenge tokens returned by a login that needs a second factor.
+	MFAToken = "mfa"
+
+	// Value of the typ claim of the access tokens issued to OAuth clients. They are meant for the services of the
+	// clients, so the API itself does not accept them as access tokens.
+	OAuthAccessToken = "oauth_access"
+
+	// Value of the typ claim of the claims built for a request authenticated with an API key. No token carries it.
+	APIKeyToken = "api_key"
+
+	// Header carrying the API keys of the machine-to-machine clients, sent instead of an Authorization header.
+	APIKeyHeader = "X-API-Key"
+
+	StorageBackendMySQL  = "mysql"
+	StorageBackendMemory = "memory"
+
+	// Values of EMAIL_VERIFICATION_MODE: what an unverified user can do.
+	EmailVerificationOff      = "off"
+	EmailVerificationRestrict = "restrict"
+	EmailVerificationBlock    = "block"
+
+	// Grant types of the OAuth token endpoint, which OAuth clients are registered with.
+	OAuthGrantAuthorizationCode = "authorization_code"
+	OAuthGrantRefreshToken      = "refresh_token"
+	OAuthGrantClientCredentials = "client_credentials"
+
+	// OpenID Connect scopes: openid asks for an ID token, profile and email for the matching claims in it.
+	OAuthScopeOpenID  = "openid"
+	OAuthScopeProfile = "profile"
+	OAuthScopeEmail   = "email"
+
+	// Ceremonies of the WebAuthn challenges.
+	WebAuthnRegistration = "registration"
+	WebAuthnLogin        = "login"
+
+	// Fields the users listed to the administrators can be sorted by.
+	UserSortID          = "id"
+	UserSortUsername    = "username"
+	UserSortEmail       = "email"
+	UserSortCountry     = "country"
+	UserSortDateCreated = "date_created"
+
+	// Statuses of the user accounts. A disabled account cannot log in, refresh its sessions or use its API keys. A
+	// deleted account is not found by the lookups until it is restored, and is purged after DELETED_USER_RETENTION.
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+	UserStatusDeleted  = "deleted"
+
+	// Fields of a user recorded in the changes made by the administrators.
+	UserFieldEmail                 = "email"
+	UserFieldCountry               = "country"
+	UserFieldPhone                 = "phone"
+	UserFieldRoles                 = "roles"
+	UserFieldStatus                = "status"
+	UserFieldPasswordResetRequired = "password_reset_required"
+
+	// Values of REGISTRATION_MODE: whether a registration tells that the username or the email is already used.
+	RegistrationModeStandard       = "standard"
+	RegistrationModeNonEnumerating = "non-enumerating"
 )
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration parses a duration string like time.ParseDuration, but also accepts a leading number of days
+// with the "d" unit, e.g. "7d" or "1d12h".
+//
+// s: The duration string.
+//
+// Returns the duration, or an error if the string is not a valid duration.
+func ParseDuration(s string) (time.Duration, error) {
+	i := strings.IndexByte(s, 'd')
+	if i < 0 {
+		return time.ParseDuration(s)
+	}
+
+	days, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || i == 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	duration := time.Duration(days * float64(24*time.Hour))
+
+	if rest := s[i+1:]; rest != "" {
+		if rest[0] == '-' || rest[0] == '+' {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		remainder, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		if strings.HasPrefix(s, "-") {
+			remainder = -remainder
+		}
+		duration += remainder
+	}
+	return duration, nil
+}